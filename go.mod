@@ -6,6 +6,7 @@ toolchain go1.24.4
 
 require (
 	github.com/aws/aws-sdk-go v1.47.3
+	github.com/blang/semver v3.5.1+incompatible
 	github.com/braintree/manners v0.0.0-20160418043613-82a8879fc5fd
 	github.com/google/go-github/v32 v32.1.0
 	github.com/gorilla/mux v1.8.1
@@ -24,7 +25,6 @@ require (
 
 require (
 	github.com/beorn7/perks v1.0.1 // indirect
-	github.com/blang/semver v3.5.1+incompatible // indirect
 	github.com/cespare/xxhash/v2 v2.3.0 // indirect
 	github.com/davecgh/go-spew v1.1.2-0.20180830191138-d8f796af33cc // indirect
 	github.com/distribution/reference v0.5.0 // indirect
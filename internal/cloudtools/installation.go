@@ -1,6 +1,8 @@
 package cloudtools
 
 import (
+	"strings"
+
 	cloud "github.com/mattermost/mattermost-cloud/model"
 	"github.com/pkg/errors"
 )
@@ -54,3 +56,30 @@ func GetInstallationDNSFromDNSRecords(installation *cloud.InstallationDTO) strin
 	}
 	return ""
 }
+
+// GetInstallationsWithOwnerIDPrefix returns the installations that aren't deleted
+// and whose OwnerID starts with ownerIDPrefix. The search is narrowed to the
+// installations of groupID unless it is empty.
+func GetInstallationsWithOwnerIDPrefix(client *cloud.Client, groupID, ownerIDPrefix string) ([]*cloud.InstallationDTO, error) {
+	installations, err := client.GetInstallations(&cloud.GetInstallationsRequest{
+		GroupID:                     groupID,
+		Paging:                      cloud.AllPagesNotDeleted(),
+		IncludeGroupConfig:          false,
+		IncludeGroupConfigOverrides: false,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to retrieve installations from provisioner")
+	}
+
+	var matches []*cloud.InstallationDTO
+	for _, installation := range installations {
+		if installation.Installation == nil || !strings.HasPrefix(installation.OwnerID, ownerIDPrefix) {
+			continue
+		}
+		if isNotDeletedState(installation.State) {
+			matches = append(matches, installation)
+		}
+	}
+
+	return matches, nil
+}
@@ -3,9 +3,14 @@ package spinwick
 // Request tracks information about a given SpinWick request.
 type Request struct {
 	InstallationID string
-	Error          error
-	ReportError    bool
-	Aborted        bool
+	// URL is the address of the SpinWick once it is ready.
+	URL         string
+	Error       error
+	ReportError bool
+	Aborted     bool
+	// PRNotified is set when the error was already explained on the PR, so the
+	// generic failure message is not posted.
+	PRNotified bool
 }
 
 // WithInstallationID updates the installation ID of a Request.
@@ -14,6 +19,12 @@ func (r *Request) WithInstallationID(id string) *Request {
 	return r
 }
 
+// WithURL updates the SpinWick URL of a Request.
+func (r *Request) WithURL(url string) *Request {
+	r.URL = url
+	return r
+}
+
 // WithError updates the error of a Request.
 func (r *Request) WithError(err error) *Request {
 	r.Error = err
@@ -31,3 +42,9 @@ func (r *Request) IntentionalAbort() *Request {
 	r.Aborted = true
 	return r
 }
+
+// AlreadyNotifiedPR marks the request's error as already explained on the PR.
+func (r *Request) AlreadyNotifiedPR() *Request {
+	r.PRNotified = true
+	return r
+}
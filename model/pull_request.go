@@ -21,6 +21,7 @@ type PullRequest struct {
 	Labels    []string
 	State     string
 	URL       string
+	Body      string
 	CreatedAt time.Time
 }
 
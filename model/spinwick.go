@@ -13,13 +13,22 @@ type Spinwick struct {
 	PRNumber     int    `json:"pr_number"`
 	RepeatableID string `json:"repeatable_id"`
 	UniqueID     string `json:"unique_id"`
+	// Variant is the name of an additional SpinWick for the same PR, empty for the default one.
+	Variant string `json:"variant,omitempty"`
 }
 
 // NewSpinwick creates a new Spinwick instance, automatically calling the repeatableID and uniqueID methods
 func NewSpinwick(repoName string, prNumber int, baseDomain string) *Spinwick {
+	return NewSpinwickVariant(repoName, prNumber, "", baseDomain)
+}
+
+// NewSpinwickVariant creates a new Spinwick instance for a named variant of the PR's SpinWick.
+// An empty variant is the default SpinWick returned by NewSpinwick.
+func NewSpinwickVariant(repoName string, prNumber int, variant, baseDomain string) *Spinwick {
 	spinwick := &Spinwick{
 		RepoName: repoName,
 		PRNumber: prNumber,
+		Variant:  variant,
 	}
 
 	spinwick.RepeatableID = spinwick.repeatableID()
@@ -31,7 +40,7 @@ func NewSpinwick(repoName string, prNumber int, baseDomain string) *Spinwick {
 // Generates an ID based on the PR number and repo name that's repeatable so it can be used for identifying and looking up installations
 func (s *Spinwick) uniqueID(baseDomain string) string {
 	randomID := cloudModel.NewID()[0:5]
-	spinWickID := strings.ToLower(fmt.Sprintf("%s-pr-%d-%s", s.RepoName, s.PRNumber, randomID))
+	spinWickID := strings.ToLower(fmt.Sprintf("%s-%s", s.repeatableID(), randomID))
 	// DNS names in MM cloud have a character limit. The number of characters in the domain - 64 will be how many we need to trim
 	numCharactersToTrim := len(spinWickID+baseDomain) - 64
 	if numCharactersToTrim > 0 {
@@ -48,6 +57,9 @@ func (s *Spinwick) uniqueID(baseDomain string) string {
 
 // Generates an ID based on the PR number and repo name, and appends a random string to make it unique
 func (s *Spinwick) repeatableID() string {
+	if s.Variant != "" {
+		return strings.ToLower(fmt.Sprintf("%s-pr-%d-%s", s.RepoName, s.PRNumber, s.Variant))
+	}
 	return strings.ToLower(fmt.Sprintf("%s-pr-%d", s.RepoName, s.PRNumber))
 }
 
@@ -20,6 +20,15 @@ func TestNewSpinwick(t *testing.T) {
 	assert.Equal(t, 22, len(spinwick.UniqueID)) //  5 char random ID + 16 chars for the rest
 }
 
+func TestNewSpinwickVariant(t *testing.T) {
+	spinwick := NewSpinwickVariant("test-repo", 123, "ha", "example.com")
+
+	assert.Equal(t, "ha", spinwick.Variant)
+	assert.Equal(t, "test-repo-pr-123-ha", spinwick.RepeatableID)
+	assert.True(t, strings.HasPrefix(spinwick.UniqueID, "test-repo-pr-123-ha-"))
+	assert.Equal(t, 25, len(spinwick.UniqueID))
+}
+
 func TestSpinwick_repeatableID(t *testing.T) {
 	spinwick := &Spinwick{
 		RepoName: "Test-Repo",
@@ -0,0 +1,143 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mattermost/matterwick/internal/spinwick"
+	"github.com/mattermost/matterwick/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	buildWorkflowConclusionSuccess   = "success"
+	buildWorkflowConclusionCancelled = "cancelled"
+)
+
+// buildWorkflowRetention is how long completed build workflow runs are kept,
+// so updates that start waiting after the build completed don't miss it.
+var buildWorkflowRetention = time.Hour
+
+// buildWorkflowRun is a completed run of a SpinWick build workflow.
+type buildWorkflowRun struct {
+	ID         int64
+	Conclusion string
+	HTMLURL    string
+
+	completedAt time.Time
+}
+
+// buildWorkflows wakes the SpinWick updates waiting for the build workflow of
+// their head commit.
+type buildWorkflows struct {
+	lock      sync.Mutex
+	completed map[string]buildWorkflowRun
+	waiters   map[string]map[chan buildWorkflowRun]bool
+}
+
+func newBuildWorkflows() *buildWorkflows {
+	return &buildWorkflows{
+		completed: make(map[string]buildWorkflowRun),
+		waiters:   make(map[string]map[chan buildWorkflowRun]bool),
+	}
+}
+
+func buildWorkflowKey(repoName, sha string) string {
+	return repoName + ":" + sha
+}
+
+// complete records a completed build of the commit and wakes its waiters.
+func (b *buildWorkflows) complete(repoName, sha string, run buildWorkflowRun) {
+	key := buildWorkflowKey(repoName, sha)
+	run.completedAt = time.Now()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for k, completed := range b.completed {
+		if time.Since(completed.completedAt) > buildWorkflowRetention {
+			delete(b.completed, k)
+		}
+	}
+	b.completed[key] = run
+
+	for ch := range b.waiters[key] {
+		select {
+		case ch <- run:
+		default:
+		}
+	}
+}
+
+// wait returns the completed build workflow run of the commit, waiting for it
+// if it has not completed yet.
+func (b *buildWorkflows) wait(ctx context.Context, repoName, sha string) (buildWorkflowRun, error) {
+	key := buildWorkflowKey(repoName, sha)
+	ch := make(chan buildWorkflowRun, 1)
+
+	b.lock.Lock()
+	if run, ok := b.completed[key]; ok {
+		b.lock.Unlock()
+		return run, nil
+	}
+	if b.waiters[key] == nil {
+		b.waiters[key] = make(map[chan buildWorkflowRun]bool)
+	}
+	b.waiters[key][ch] = true
+	b.lock.Unlock()
+
+	defer func() {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		delete(b.waiters[key], ch)
+		if len(b.waiters[key]) == 0 {
+			delete(b.waiters, key)
+		}
+	}()
+
+	select {
+	case run := <-ch:
+		return run, nil
+	case <-ctx.Done():
+		return buildWorkflowRun{}, errors.New("timed out waiting for the build workflow")
+	}
+}
+
+// isSpinWickBuildWorkflow reports whether name is the SpinWick build workflow of the repository.
+func (s *Server) isSpinWickBuildWorkflow(repoName, name string) bool {
+	workflow, ok := s.Config.SpinWickBuildWorkflows[repoName]
+	return ok && workflow == name
+}
+
+// waitForSpinWickBuild waits for the build workflow of the PR head commit to
+// succeed. Failed builds are reported on the PR right away instead of after
+// the image wait times out.
+func (s *Server) waitForSpinWickBuild(ctx context.Context, pr *model.PullRequest, variant string, request *spinwick.Request, logger logrus.FieldLogger) *spinwick.Request {
+	ctx, cancel := context.WithTimeout(ctx, 45*time.Minute)
+	defer cancel()
+
+	logger.Info("Waiting for the build workflow to update SpinWick")
+	run, err := s.buildWorkflows.wait(ctx, pr.RepoName, pr.Sha)
+	if err != nil {
+		return request.WithError(err).IntentionalAbort()
+	}
+	logger = logger.WithFields(logrus.Fields{"run_id": run.ID, "conclusion": run.Conclusion})
+
+	switch run.Conclusion {
+	case buildWorkflowConclusionSuccess:
+		logger.Info("Build workflow succeeded")
+		return request
+	case buildWorkflowConclusionCancelled:
+		// Builds are usually cancelled by a newer commit, which updates the SpinWick itself.
+		return request.WithError(errors.New("the build workflow was cancelled")).IntentionalAbort()
+	}
+
+	s.setSpinWickStatus(pr, variant, fmt.Sprintf(":x: The build of %s failed, so the SpinWick was not updated. See the [failed run](%s).%s", pr.Sha, run.HTMLURL, variantSuffix(variant)))
+	return request.WithError(errors.Errorf("the build workflow concluded with %s", run.Conclusion)).IntentionalAbort().AlreadyNotifiedPR()
+}
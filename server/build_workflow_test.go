@@ -0,0 +1,157 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mattermost/matterwick/internal/spinwick"
+	"github.com/mattermost/matterwick/model"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildWorkflows(t *testing.T) {
+	t.Run("completed before waiting", func(t *testing.T) {
+		builds := newBuildWorkflows()
+		builds.complete("mattermost-server", "abc123", buildWorkflowRun{ID: 1, Conclusion: "success"})
+
+		run, err := builds.wait(context.Background(), "mattermost-server", "abc123")
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), run.ID)
+	})
+
+	t.Run("completed while waiting", func(t *testing.T) {
+		builds := newBuildWorkflows()
+		go func() {
+			// Complete until the waiter has registered.
+			for {
+				builds.lock.Lock()
+				waiting := len(builds.waiters) > 0
+				builds.lock.Unlock()
+				if waiting {
+					builds.complete("mattermost-server", "other", buildWorkflowRun{ID: 1, Conclusion: "success"})
+					builds.complete("mattermost-server", "abc123", buildWorkflowRun{ID: 2, Conclusion: "failure"})
+					return
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		run, err := builds.wait(ctx, "mattermost-server", "abc123")
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), run.ID)
+		assert.Equal(t, "failure", run.Conclusion)
+		assert.Empty(t, builds.waiters)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		builds := newBuildWorkflows()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := builds.wait(ctx, "mattermost-server", "abc123")
+		assert.EqualError(t, err, "timed out waiting for the build workflow")
+		assert.Empty(t, builds.waiters)
+	})
+
+	t.Run("expired runs are dropped", func(t *testing.T) {
+		builds := newBuildWorkflows()
+		builds.complete("mattermost-server", "old", buildWorkflowRun{ID: 1})
+		builds.completed[buildWorkflowKey("mattermost-server", "old")] = buildWorkflowRun{ID: 1, completedAt: time.Now().Add(-2 * buildWorkflowRetention)}
+		builds.complete("mattermost-server", "new", buildWorkflowRun{ID: 2})
+		assert.Len(t, builds.completed, 1)
+	})
+}
+
+func TestHandleWorkflowRunEventSpinWickBuild(t *testing.T) {
+	s := &Server{
+		Logger:         logrus.New(),
+		Config:         &MatterwickConfig{SpinWickBuildWorkflows: map[string]string{"mattermost-server": "Server CI"}},
+		buildWorkflows: newBuildWorkflows(),
+	}
+	payload := func(name, action string) *WorkflowRunWebhookPayload {
+		return &WorkflowRunWebhookPayload{
+			Action: action,
+			WorkflowRun: WorkflowRunWithInputs{
+				ID:         7,
+				Name:       name,
+				HeadSHA:    "abc123",
+				Conclusion: "failure",
+				HTMLURL:    "https://github.com/mattermost/mattermost-server/actions/runs/7",
+			},
+			Repository: map[string]interface{}{
+				"name":  "mattermost-server",
+				"owner": map[string]interface{}{"login": "mattermost"},
+			},
+		}
+	}
+
+	s.handleWorkflowRunEventWithInputs(payload("Server CI", "requested"))
+	s.handleWorkflowRunEventWithInputs(payload("Other CI", "completed"))
+	assert.Empty(t, s.buildWorkflows.completed)
+
+	s.handleWorkflowRunEventWithInputs(payload("Server CI", "completed"))
+	run, ok := s.buildWorkflows.completed[buildWorkflowKey("mattermost-server", "abc123")]
+	require.True(t, ok)
+	assert.Equal(t, int64(7), run.ID)
+	assert.Equal(t, "failure", run.Conclusion)
+	assert.Equal(t, "https://github.com/mattermost/mattermost-server/actions/runs/7", run.HTMLURL)
+}
+
+func TestWaitForSpinWickBuild(t *testing.T) {
+	pr := &model.PullRequest{RepoOwner: "mattermost", RepoName: "mattermost-server", Number: 1, Sha: "abc123"}
+
+	for name, tc := range map[string]struct {
+		conclusion string
+		err        string
+		comment    bool
+	}{
+		"success":   {conclusion: "success"},
+		"cancelled": {conclusion: "cancelled", err: "the build workflow was cancelled"},
+		"failure":   {conclusion: "failure", err: "the build workflow concluded with failure", comment: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			mock := &statusCommentGitHubMock{comments: make(map[int64]string)}
+			ts := httptest.NewServer(mock)
+			defer ts.Close()
+
+			s := &Server{
+				Logger:         logrus.New(),
+				Config:         &MatterwickConfig{Username: "matterwick"},
+				githubAPIBase:  ts.URL + "/",
+				buildWorkflows: newBuildWorkflows(),
+			}
+			s.buildWorkflows.complete("mattermost-server", "abc123", buildWorkflowRun{
+				ID:         7,
+				Conclusion: tc.conclusion,
+				HTMLURL:    "https://github.com/mattermost/mattermost-server/actions/runs/7",
+			})
+
+			request := s.waitForSpinWickBuild(context.Background(), pr, "", &spinwick.Request{}, s.Logger)
+			if tc.err == "" {
+				assert.NoError(t, request.Error)
+			} else {
+				assert.EqualError(t, request.Error, tc.err)
+				assert.True(t, request.Aborted)
+				assert.Equal(t, tc.comment, request.PRNotified)
+			}
+
+			if !tc.comment {
+				assert.Empty(t, mock.comments)
+				return
+			}
+			require.Len(t, mock.comments, 1)
+			for _, body := range mock.comments {
+				assert.Contains(t, body, "The build of abc123 failed")
+				assert.Contains(t, body, "[failed run](https://github.com/mattermost/mattermost-server/actions/runs/7)")
+			}
+		})
+	}
+}
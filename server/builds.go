@@ -2,10 +2,14 @@ package server
 
 import (
 	"context"
+	"encoding/base64"
 	"net/url"
 	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ecr"
 	"github.com/mattermost/matterwick/model"
 	"github.com/sirupsen/logrus"
 
@@ -13,12 +17,27 @@ import (
 	"github.com/pkg/errors"
 )
 
+// dockerRegistryAuthECR is the DockerRegistry auth fetching credentials from ECR.
+const dockerRegistryAuthECR = "ecr"
+
+// Intervals between checks for a docker image. Registry push notifications
+// wake the waiters, so polling is only a fallback when they are enabled. Vars
+// so tests can shorten them.
+var (
+	imagePollInterval         = 30 * time.Second
+	imagePollFallbackInterval = 5 * time.Minute
+)
+
 // Builds implements buildsInterface for working with external CI/CD systems.
-type Builds struct{}
+type Builds struct {
+	// pushes wakes image waiters on registry push notifications, nil when the
+	// registry webhook is not configured.
+	pushes *imagePushes
+}
 
 type buildsInterface interface {
 	getInstallationVersion(pr *model.PullRequest) string
-	dockerRegistryClient(s *Server) (*registry.Registry, error)
+	dockerRegistryClient(s *Server, image string) (*registry.Registry, error)
 	waitForImage(ctx context.Context, reg *registry.Registry, desiredTag, imageToCheck string, logger logrus.FieldLogger) error
 }
 
@@ -26,12 +45,27 @@ func (b *Builds) getInstallationVersion(pr *model.PullRequest) string {
 	return pr.Sha[0:7]
 }
 
-func (b *Builds) dockerRegistryClient(s *Server) (reg *registry.Registry, err error) {
-	if _, err = url.ParseRequestURI(s.Config.DockerRegistryURL); err != nil {
+// dockerRegistryClient returns a client of the registry hosting the image.
+func (b *Builds) dockerRegistryClient(s *Server, image string) (reg *registry.Registry, err error) {
+	registryURL, username, password := s.Config.DockerRegistryURL, s.Config.DockerUsername, s.Config.DockerPassword
+	if config := dockerRegistryForImage(s.Config.DockerRegistries, image); config != nil {
+		registryURL, username, password = config.URL, config.Username, config.Password
+		if registryURL == "" {
+			registryURL = "https://" + imageRegistryHost(config.ImagePrefix)
+		}
+		if config.Auth == dockerRegistryAuthECR {
+			username, password, err = ecrCredentials(config.AWSRegion)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to get ECR credentials")
+			}
+		}
+	}
+
+	if _, err = url.ParseRequestURI(registryURL); err != nil {
 		return nil, errors.Wrap(err, "invalid url for docker registry")
 	}
 
-	reg, err = registry.New(s.Config.DockerRegistryURL, s.Config.DockerUsername, s.Config.DockerPassword)
+	reg, err = registry.New(registryURL, username, password)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to connect to docker registry")
 	}
@@ -39,11 +73,82 @@ func (b *Builds) dockerRegistryClient(s *Server) (reg *registry.Registry, err er
 	return reg, nil
 }
 
+// dockerRegistryForImage returns the registry with the longest prefix of the
+// image, or nil for images of the default registry.
+func dockerRegistryForImage(registries []DockerRegistry, image string) *DockerRegistry {
+	var match *DockerRegistry
+	for i := range registries {
+		if !strings.HasPrefix(image, registries[i].ImagePrefix) {
+			continue
+		}
+		if match == nil || len(registries[i].ImagePrefix) > len(match.ImagePrefix) {
+			match = &registries[i]
+		}
+	}
+	return match
+}
+
+// imageRegistryHost returns the registry host of an image reference, or an
+// empty string for Docker Hub images given without one.
+func imageRegistryHost(image string) string {
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		return parts[0]
+	}
+	return ""
+}
+
+// imageRepository returns the repository of an image reference within its
+// registry, e.g. "mattermost/focalboard" for "ghcr.io/mattermost/focalboard".
+func imageRepository(image string) string {
+	if host := imageRegistryHost(image); host != "" {
+		return strings.TrimPrefix(image, host+"/")
+	}
+	return image
+}
+
+// ecrCredentials returns registry credentials from an ECR authorization token.
+func ecrCredentials(region string) (string, string, error) {
+	sess, err := session.NewSession(&aws.Config{
+		Region: aws.String(region),
+	})
+	if err != nil {
+		return "", "", errors.Wrap(err, "failed to create AWS session")
+	}
+	output, err := ecr.New(sess).GetAuthorizationToken(&ecr.GetAuthorizationTokenInput{})
+	if err != nil {
+		return "", "", errors.Wrap(err, "failed to get authorization token")
+	}
+	if len(output.AuthorizationData) == 0 {
+		return "", "", errors.New("no authorization data returned")
+	}
+
+	token, err := base64.StdEncoding.DecodeString(aws.StringValue(output.AuthorizationData[0].AuthorizationToken))
+	if err != nil {
+		return "", "", errors.Wrap(err, "failed to decode authorization token")
+	}
+	credentials := strings.SplitN(string(token), ":", 2)
+	if len(credentials) != 2 {
+		return "", "", errors.New("malformed authorization token")
+	}
+	return credentials[0], credentials[1], nil
+}
+
 func (b *Builds) waitForImage(ctx context.Context, reg *registry.Registry, desiredTag, imageToCheck string, logger logrus.FieldLogger) error {
 	logger = logger.WithFields(logrus.Fields{"image": imageToCheck, "tag": desiredTag})
 
+	interval := imagePollInterval
+	var pushed <-chan struct{}
+	if b.pushes != nil {
+		var stop func()
+		pushed, stop = b.pushes.wait(imageToCheck, desiredTag)
+		defer stop()
+		interval = imagePollFallbackInterval
+	}
+
+	repository := imageRepository(imageToCheck)
 	for {
-		_, err := reg.ManifestDigest(imageToCheck, desiredTag)
+		_, err := reg.ManifestDigest(repository, desiredTag)
 		if err != nil && !strings.Contains(err.Error(), "status=404") {
 			return errors.Wrap(err, "unable to fetch tag from docker registry")
 		}
@@ -58,8 +163,9 @@ func (b *Builds) waitForImage(ctx context.Context, reg *registry.Registry, desir
 		select {
 		case <-ctx.Done():
 			return errors.New("timed out waiting for image to publish")
-		case <-time.After(30 * time.Second):
-			continue
+		case <-pushed:
+			logger.Debug("Docker tag push notification received")
+		case <-time.After(interval):
 		}
 	}
 }
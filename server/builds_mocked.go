@@ -19,7 +19,7 @@ func (b *MockedBuilds) getInstallationVersion(pr *model.PullRequest) string {
 	return b.Version
 }
 
-func (b *MockedBuilds) dockerRegistryClient(s *Server) (*registry.Registry, error) {
+func (b *MockedBuilds) dockerRegistryClient(s *Server, image string) (*registry.Registry, error) {
 	return nil, nil
 }
 
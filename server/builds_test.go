@@ -0,0 +1,80 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageRepository(t *testing.T) {
+	for image, repository := range map[string]string{
+		"mattermostdevelopment/mattermost-enterprise-edition": "mattermostdevelopment/mattermost-enterprise-edition",
+		"docker.io/mattermost/mattermost-team-edition":        "mattermost/mattermost-team-edition",
+		"ghcr.io/mattermost/focalboard":                       "mattermost/focalboard",
+		"localhost:5000/mattermost/cws-test":                  "mattermost/cws-test",
+		"localhost/cws-test":                                  "cws-test",
+		"123456789012.dkr.ecr.us-east-1.amazonaws.com/cws":    "cws",
+	} {
+		assert.Equal(t, repository, imageRepository(image), image)
+	}
+}
+
+func TestDockerRegistryForImage(t *testing.T) {
+	registries := []DockerRegistry{
+		{ImagePrefix: "ghcr.io/"},
+		{ImagePrefix: "ghcr.io/mattermost/"},
+		{ImagePrefix: "mattermost/cws"},
+	}
+
+	assert.Nil(t, dockerRegistryForImage(registries, "mattermostdevelopment/mattermost-enterprise-edition"))
+	assert.Nil(t, dockerRegistryForImage(nil, "ghcr.io/mattermost/focalboard"))
+	assert.Equal(t, "ghcr.io/mattermost/", dockerRegistryForImage(registries, "ghcr.io/mattermost/focalboard").ImagePrefix)
+	assert.Equal(t, "ghcr.io/", dockerRegistryForImage(registries, "ghcr.io/other/image").ImagePrefix)
+	assert.Equal(t, "mattermost/cws", dockerRegistryForImage(registries, "mattermost/cws-test").ImagePrefix)
+}
+
+func TestDockerRegistryClient(t *testing.T) {
+	newRegistry := func(username string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if user, _, ok := r.BasicAuth(); !ok || user != username {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}))
+	}
+	defaultRegistry := newRegistry("default")
+	defer defaultRegistry.Close()
+	ghcr := newRegistry("ghcr")
+	defer ghcr.Close()
+
+	s := &Server{Config: &MatterwickConfig{
+		DockerRegistryURL: defaultRegistry.URL,
+		DockerUsername:    "default",
+		DockerRegistries: []DockerRegistry{
+			{ImagePrefix: "ghcr.io/mattermost/", URL: ghcr.URL, Username: "ghcr", Password: "token"},
+		},
+	}}
+	builds := &Builds{}
+
+	reg, err := builds.dockerRegistryClient(s, "mattermostdevelopment/mattermost-enterprise-edition")
+	require.NoError(t, err)
+	assert.Equal(t, defaultRegistry.URL, reg.URL)
+
+	reg, err = builds.dockerRegistryClient(s, "ghcr.io/mattermost/focalboard")
+	require.NoError(t, err)
+	assert.Equal(t, ghcr.URL, reg.URL)
+
+	s.Config.DockerRegistries[0].Username = "wrong"
+	_, err = builds.dockerRegistryClient(s, "ghcr.io/mattermost/focalboard")
+	assert.Error(t, err)
+
+	s.Config.DockerRegistryURL = ""
+	_, err = builds.dockerRegistryClient(s, "mattermostdevelopment/mattermost-enterprise-edition")
+	assert.EqualError(t, err, `invalid url for docker registry: parse "": empty url`)
+}
@@ -289,13 +289,32 @@ func (s *Server) provisionCMTVersions(repoName, instanceType string, serverVersi
 			}
 		}
 
+		expectedInstances := 1
+		if instanceType == "mobile" && fullSuite {
+			expectedInstances = len(mobileE2EPlatforms)
+		}
+
+		claim := provisioningClaim{repo: repoName, kind: installationKindCMT, installations: expectedInstances}
+		lease, err := s.acquireProvisioningCapacity(provisionCtx, claim, func(position int, limit string) {
+			logger.WithFields(logrus.Fields{
+				"version":  version,
+				"position": position,
+				"limit":    limit,
+			}).Info("CMT instances queued for provisioning capacity")
+		})
+		if err != nil {
+			logger.WithError(err).Errorf("Failed to get provisioning capacity for version %s; dropping this version from the CMT matrix", version)
+			droppedVersions = append(droppedVersions, version)
+			continue
+		}
+
 		logger.WithField("version", version).Info("Creating CMT instances for server version")
 
 		var versionInstances []*E2EInstance
 		if instanceType == "mobile" {
-			var err error
 			versionInstances, err = s.createMobileCMTInstances(provisionCtx, repoName, version, fullSuite, logger)
 			if err != nil {
+				lease.done()
 				logger.WithError(err).Errorf("Failed to create topology for version %s; dropping this version from the CMT matrix", version)
 				droppedVersions = append(droppedVersions, version)
 				continue
@@ -303,23 +322,25 @@ func (s *Server) provisionCMTVersions(repoName, instanceType string, serverVersi
 		} else {
 			instance, err := s.createSingleCMTInstance(provisionCtx, repoName, instanceType, version, "", logger)
 			if err != nil {
+				lease.done()
 				logger.WithError(err).Errorf("Failed to create instance for version %s; dropping this version from the CMT matrix", version)
 				droppedVersions = append(droppedVersions, version)
 				continue
 			}
 			versionInstances = []*E2EInstance{instance}
 		}
-		expectedInstances := 1
-		if instanceType == "mobile" && fullSuite {
-			expectedInstances = len(mobileE2EPlatforms)
-		}
 		if len(versionInstances) != expectedInstances {
+			lease.done()
 			logger.Errorf("Incomplete CMT topology for version %s (got %d, want %d); dropping this version", version, len(versionInstances), expectedInstances)
 			s.destroyE2EInstances(versionInstances, logger)
 			droppedVersions = append(droppedVersions, version)
 			continue
 		}
 
+		for _, instance := range versionInstances {
+			lease.keep(instance.InstallationID)
+		}
+		lease.done()
 		allInstances = append(allInstances, versionInstances...)
 		validVersions = append(validVersions, version)
 	}
@@ -384,6 +405,7 @@ func (s *Server) dispatchAndTrackCMT(repoOwner, repoName, instanceType, branch s
 	if buildErr != nil {
 		logger.WithError(buildErr).Error("Failed to build CMT_MATRIX JSON")
 		s.destroyE2EInstances(instances, logger)
+		s.updateBranchStatusComment(repoOwner, repoName, branch, statusSectionCMT, "❌ Failed to build the compatibility matrix.", logger)
 		return
 	}
 
@@ -395,9 +417,16 @@ func (s *Server) dispatchAndTrackCMT(repoOwner, repoName, instanceType, branch s
 	if err != nil {
 		logger.WithError(err).Error("Failed to dispatch compatibility-matrix-testing.yml")
 		s.destroyE2EInstances(instances, logger)
+		s.updateBranchStatusComment(repoOwner, repoName, branch, statusSectionCMT, "❌ Failed to dispatch the compatibility matrix tests.", logger)
 		return
 	}
 
+	status := fmt.Sprintf("Compatibility matrix tests dispatched against server versions %s.", strings.Join(versions, ", "))
+	if testRunID != 0 {
+		status += fmt.Sprintf(" Follow the [workflow run](https://github.com/%s/%s/actions/runs/%d).", repoOwner, repoName, testRunID)
+	}
+	s.updateBranchStatusComment(repoOwner, repoName, branch, statusSectionCMT, status, logger)
+
 	if testRunID == 0 {
 		// Dispatch succeeded but run id unresolved — leave instances for the periodic stale-scan.
 		// Do not tear down: GH may already be running tests against these URLs.
@@ -49,6 +49,89 @@ type CloudAuth struct {
 	TokenEndpoint string
 }
 
+// SpinWickRepoDefaults contains the SpinWick defaults for a single repository.
+// Options passed to /spinwick create take precedence over these.
+type SpinWickRepoDefaults struct {
+	// Database is the provisioner database type, e.g. "aws-multitenant-rds".
+	Database string
+	// Filestore is the provisioner filestore type, e.g. "aws-s3".
+	Filestore string
+	// Plugins are additional plugins installed on every SpinWick of the repository,
+	// in the /spinwick create --plugins format, e.g. "focalboard@7.10.0".
+	Plugins []string
+	// PluginSettings are applied to the repository's plugin on plugin SpinWicks,
+	// under PluginSettings.Plugins.<plugin id>. A spinwick-plugin-settings block
+	// in the PR description overrides individual settings.
+	PluginSettings map[string]interface{}
+	// PluginArtifactSource is where plugin SpinWicks get the PR's plugin bundle:
+	// "s3" (default) or "github-actions" for the artifacts of the PR's workflow runs.
+	PluginArtifactSource string
+	// PluginArtifactName is the GitHub Actions artifact holding the bundle. An
+	// empty name means the first artifact of the run.
+	PluginArtifactName string
+}
+
+// SampleDataProfile describes the data seeded into a SpinWick created with
+// /spinwick create --sample-data. Channel and file post counts are per team.
+type SampleDataProfile struct {
+	// Teams is the number of teams created in addition to the PR team.
+	Teams           int
+	PublicChannels  int
+	PrivateChannels int
+	// PostsPerChannel is the number of root posts created in every seeded channel.
+	PostsPerChannel int
+	// RepliesPerThread is the number of replies added to the first post of every channel.
+	RepliesPerThread int
+	// Reactions adds a reaction from user-1 to every seeded root post.
+	Reactions bool
+	// DirectMessages is the number of messages posted in the sysadmin/user-1 DM.
+	DirectMessages int
+	FilePosts      int
+}
+
+// DockerRegistry maps the images under a prefix to the registry hosting them.
+type DockerRegistry struct {
+	// ImagePrefix selects the images of the registry, e.g. "ghcr.io/mattermost/"
+	// or "mattermostdevelopment/". The longest matching prefix wins.
+	ImagePrefix string
+	// URL of the registry API. It defaults to https:// and the registry host of
+	// ImagePrefix, e.g. "https://ghcr.io".
+	URL string
+	// Auth is "ecr" to fetch credentials for AWSRegion from ECR. Otherwise
+	// Username and Password are used, for basic auth or for the bearer token
+	// flow of registries like Docker Hub and GHCR. Anonymous if both are empty.
+	Auth      string
+	Username  string
+	Password  string
+	AWSRegion string
+}
+
+// ProvisioningQuotas limits the test servers provisioned at once. Requests above
+// a limit wait in a queue until enough servers are destroyed. Zero limits are
+// unlimited.
+type ProvisioningQuotas struct {
+	// Global caps all test servers.
+	Global int
+	// PerUser caps the test servers of the PRs of one author, unless Users
+	// sets a limit for the author.
+	PerUser int
+	Users   map[string]int
+	// PerRepo caps the test servers of one repository, unless Repos sets a
+	// limit for the repository.
+	PerRepo int
+	Repos   map[string]int
+	// PerKind caps the test servers of a kind: "spinwick", "e2e" or "cmt".
+	PerKind map[string]int
+}
+
+// SpinWickUser declares an account created on a SpinWick in addition to the
+// default sysadmin and user-1 accounts.
+type SpinWickUser struct {
+	Username string
+	// Role is one of "system_admin", "team_admin", "member", "guest", "bot" or "deactivated".
+	Role string
+}
+
 // MatterwickConfig defines all config for to run the server
 type MatterwickConfig struct {
 	ListenAddress       string
@@ -76,6 +159,11 @@ type MatterwickConfig struct {
 	DockerRegistryURL string
 	DockerUsername    string
 	DockerPassword    string
+	// DockerRegistries are the registries of images not hosted in DockerRegistryURL.
+	DockerRegistries []DockerRegistry
+	// RegistryWebhookSecret enables the /registry_webhook endpoint receiving
+	// image push notifications, which must carry this secret.
+	RegistryWebhookSecret string
 
 	MattermostWebhookURL            string
 	MattermostWebhookFooter         string
@@ -105,6 +193,48 @@ type MatterwickConfig struct {
 	// Value: plugin ID to use for mmctl enable command
 	PluginRepoToIDMapping map[string]string
 
+	// SpinWickRepoDefaults maps repository names to the SpinWick defaults used for that
+	// repository. Repositories without an entry use the multi-tenant Postgres and Bifrost
+	// backends.
+	SpinWickRepoDefaults map[string]SpinWickRepoDefaults
+
+	// SampleDataProfiles maps profile names (e.g. "small", "medium") to the data seeded
+	// by /spinwick create --sample-data <profile>.
+	SampleDataProfiles map[string]SampleDataProfile
+
+	// LicenseProfiles maps license profile names (e.g. "professional", "enterprise") to
+	// the licenses applied by /spinwick create --license <profile>.
+	LicenseProfiles map[string]string
+
+	// SpinWickLabelLicenses maps SpinWick labels to the license profile, or "none", used
+	// when /spinwick create does not choose one. HA SpinWicks without an entry use
+	// SpinWickHALicense.
+	SpinWickLabelLicenses map[string]string
+
+	// FeatureFlagPresets maps preset names to feature flags and their values, applied
+	// with /spinwick flags set --preset <name> and to E2E installations.
+	FeatureFlagPresets map[string]map[string]string
+
+	// ProvisioningCheckRuns reports the stages of SpinWick and E2E provisioning as
+	// GitHub check runs on the PR head commit. The Checks API only accepts GitHub
+	// App tokens, so GithubAccessToken must be an App installation token.
+	ProvisioningCheckRuns bool
+
+	// SpinWickBuildWorkflows maps repository names to the name of the workflow
+	// building the SpinWick image of their PRs. SpinWick updates of those
+	// repositories wait for that workflow to succeed on the PR head commit instead
+	// of guessing when the image is ready, and report failed builds right away.
+	// The workflow must run on every PR commit.
+	SpinWickBuildWorkflows map[string]string
+
+	// ProvisioningQuotas limits the SpinWick, E2E and CMT servers provisioned at
+	// once, queueing the requests above the limits.
+	ProvisioningQuotas ProvisioningQuotas
+
+	// SpinWickUsers is the default roster of additional accounts created on every
+	// SpinWick. It is replaced by /spinwick create --users when that flag is given.
+	SpinWickUsers []SpinWickUser
+
 	E2ELabel                string
 	E2EMobileIOSLabel       string
 	E2EMobileAndroidLabel   string
@@ -115,6 +245,9 @@ type MatterwickConfig struct {
 	E2EAutoTriggerOnMaster  bool
 	E2EReleasePatternPrefix string
 	E2ETestWorkflowNames    []string // workflow names of the actual test workflows (for completion-based cleanup)
+	// E2EFeatureFlagPresets maps E2E instance types ("desktop", "mobile") to the
+	// FeatureFlagPresets applied to their installations.
+	E2EFeatureFlagPresets map[string][]string
 	// E2EInstanceMaxAge is the minimum age (in hours) a non-PR E2E instance must reach
 	// before the periodic orphan-cleanup scan will delete it. This prevents the scan
 	// from destroying instances that are still being used by a currently-running test.
@@ -17,6 +17,7 @@ import (
 	cloudModel "github.com/mattermost/mattermost-cloud/model"
 	mattermostModel "github.com/mattermost/mattermost-server/v6/model"
 	"github.com/mattermost/matterwick/internal/cloudtools"
+	"github.com/mattermost/matterwick/internal/spinwick"
 	"github.com/mattermost/matterwick/model"
 	"github.com/sirupsen/logrus"
 )
@@ -199,19 +200,57 @@ func (s *Server) handleE2ETestRequest(pr *model.PullRequest, label string) {
 	}
 
 	// 3. No existing instances — create fresh ones.
+	checkKey := "e2e-" + inProgressKey
+	request := &spinwick.Request{InstallationID: "n/a"}
+	s.startProvisioningCheck(pr, checkKey, fmt.Sprintf("E2E servers (%s)", testPlatform), logger)
+	defer s.finishProvisioningCheck(checkKey, request)
+
+	// A cleanup cancels the wait for provisioning capacity, the PR was closed or the servers reset.
+	provisionCtx, cancelProvisioning := s.startE2EProvisioning(key, inProgressKey, startGeneration)
+	defer cancelProvisioning()
+
+	claim := provisioningClaim{user: pr.Username, repo: pr.RepoName, kind: installationKindE2E, installations: len(platforms)}
+	queued := false
+	lease, err := s.acquireProvisioningCapacity(provisionCtx, claim, func(position int, limit string) {
+		queued = true
+		logger.WithField("position", position).Info("E2E instances queued for provisioning capacity")
+		s.setProvisioningStage(checkKey, provisioningStageQueued)
+		s.updateStatusComment(pr, statusSectionE2E, fmt.Sprintf("The E2E test servers are number %d in the provisioning queue, waiting for %s.", position, limit))
+	})
+	if err != nil {
+		logger.WithError(err).Info("E2E cleanup requested while waiting for provisioning capacity; not creating instances")
+		if queued {
+			s.updateStatusComment(pr, statusSectionE2E, "The E2E test servers left the provisioning queue, the PR was closed or the E2E servers were reset.")
+		}
+		request.WithError(err).IntentionalAbort()
+		return
+	}
+	defer lease.done()
+	if queued {
+		s.updateStatusComment(pr, statusSectionE2E, "The E2E test servers left the provisioning queue, provisioning has started.")
+	}
+	s.setProvisioningStage(checkKey, provisioningStageCreating)
+
 	instances, err := s.createMultipleE2EInstances(pr, instanceType, platforms)
 	if err != nil {
 		logger.WithError(err).Error("Failed to create E2E instances")
 		s.postE2EErrorComment(pr, fmt.Sprintf("Failed to create E2E test instances: %v", err))
+		request.WithError(fmt.Errorf("failed to create E2E test instances: %w", err))
 		return
 	}
 
 	if len(instances) == 0 {
 		logger.Error("No instances were created")
 		s.postE2EErrorComment(pr, "Failed to create any E2E test instances")
+		request.WithError(fmt.Errorf("no E2E test instances were created"))
 		return
 	}
 
+	// The instances stay accounted until they are destroyed.
+	for _, instance := range instances {
+		lease.keep(instance.InstallationID)
+	}
+
 	// Check if PR closed during provisioning (~30 min) — cleanup events don't fire for closed PRs.
 	prInfo, _, prErr := newGithubClient(s.Config.GithubAccessToken).PullRequests.Get(
 		context.Background(), pr.RepoOwner, pr.RepoName, pr.Number)
@@ -220,12 +259,14 @@ func (s *Server) handleE2ETestRequest(pr *model.PullRequest, label string) {
 	} else if prInfo.GetState() == "closed" {
 		logger.Warn("PR was closed during E2E instance creation; destroying instances without tracking")
 		s.destroyE2EInstances(instances, logger)
+		request.WithError(fmt.Errorf("the PR was closed during provisioning")).IntentionalAbort()
 		return
 	}
 
 	if !storeIfCurrent(instances) {
 		logger.Warn("E2E reset was requested during provisioning; discarding freshly created instances")
 		s.destroyE2EInstances(instances, logger)
+		request.WithError(fmt.Errorf("the E2E servers were reset during provisioning")).IntentionalAbort()
 		return
 	}
 
@@ -234,6 +275,7 @@ func (s *Server) handleE2ETestRequest(pr *model.PullRequest, label string) {
 	if err = s.triggerE2EWorkflow(pr, instances, instanceType, testPlatform); err != nil {
 		logger.WithError(err).Error("Failed to trigger E2E workflow")
 		s.postE2EErrorComment(pr, fmt.Sprintf("Failed to trigger E2E workflow: %v", err))
+		request.WithError(fmt.Errorf("failed to trigger the E2E workflow: %w", err))
 		// Remove from tracking before cleanup to avoid double-destroy on later cleanup.
 		s.e2eInstancesLock.Lock()
 		delete(s.e2eInstances, key)
@@ -440,14 +482,25 @@ func (s *Server) createCloudInstallation(ctx context.Context, name, version, use
 		envVars["MM_EXPERIMENTALSETTINGS_RESTRICTSYSTEMADMIN"] = cloudModel.EnvVar{Value: "false"}
 	}
 
+	for _, preset := range s.Config.E2EFeatureFlagPresets[instanceType] {
+		presetEnv, err := s.featureFlagPresetEnv(preset)
+		if err != nil {
+			logger.WithError(err).Warn("Ignoring invalid E2E feature flag preset")
+			continue
+		}
+		for k, v := range presetEnv {
+			envVars[k] = v
+		}
+	}
+
 	installationRequest := &cloudModel.CreateInstallationRequest{
 		OwnerID:     name,
 		Version:     version,
 		DNS:         fmt.Sprintf("%s.%s", name, s.Config.DNSNameTestServer),
 		Size:        "miniSingleton",
 		Affinity:    cloudModel.InstallationAffinityMultiTenant,
-		Database:    cloudModel.InstallationDatabaseMultiTenantRDSPostgresPGBouncer,
-		Filestore:   cloudModel.InstallationFilestoreBifrost,
+		Database:    defaultSpinWickDatabase,
+		Filestore:   defaultSpinWickFilestore,
 		Annotations: []string{defaultMultiTenantAnnotation},
 		PriorityEnv: envVars,
 	}
@@ -744,6 +797,9 @@ func (s *Server) handleE2ECleanup(pr *model.PullRequest) {
 	// tracking map and dispatching a workflow against already-deleted servers.
 	s.e2ePRCleanupGenerationLock.Lock()
 	s.e2ePRCleanupGeneration[key]++
+	for _, cancel := range s.e2eProvisioningCancels[key] {
+		cancel()
+	}
 	s.e2ePRCleanupGenerationLock.Unlock()
 
 	// Fast path: in-memory map
@@ -755,12 +811,40 @@ func (s *Server) handleE2ECleanup(pr *model.PullRequest) {
 	if len(instances) > 0 {
 		logger.WithField("instances", len(instances)).Info("Destroying tracked E2E instances")
 		s.destroyE2EInstances(instances, logger)
+		s.updateStatusComment(pr, statusSectionE2E, "E2E test servers have been destroyed.")
 	}
 
 	// Fallback: catch orphans from restarts, map overwrites, or failed goroutines
 	s.cleanupOrphanedE2EInstances(pr, logger)
 }
 
+// startE2EProvisioning returns a context cancelled by the next cleanup of the PR, or already cancelled if a
+// cleanup ran since startGeneration. The returned function must be called once provisioning is over.
+func (s *Server) startE2EProvisioning(key, inProgressKey string, startGeneration int64) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s.e2ePRCleanupGenerationLock.Lock()
+	defer s.e2ePRCleanupGenerationLock.Unlock()
+	if s.e2ePRCleanupGeneration[key] != startGeneration {
+		cancel()
+		return ctx, cancel
+	}
+	if s.e2eProvisioningCancels[key] == nil {
+		s.e2eProvisioningCancels[key] = make(map[string]context.CancelFunc)
+	}
+	s.e2eProvisioningCancels[key][inProgressKey] = cancel
+
+	return ctx, func() {
+		s.e2ePRCleanupGenerationLock.Lock()
+		defer s.e2ePRCleanupGenerationLock.Unlock()
+		delete(s.e2eProvisioningCancels[key], inProgressKey)
+		if len(s.e2eProvisioningCancels[key]) == 0 {
+			delete(s.e2eProvisioningCancels, key)
+		}
+		cancel()
+	}
+}
+
 // cleanupOrphanedE2EInstances queries the cloud API by DNS LIKE pattern and destroys any matches.
 func (s *Server) cleanupOrphanedE2EInstances(pr *model.PullRequest, logger logrus.FieldLogger) {
 	var instanceType string
@@ -805,7 +889,9 @@ func (s *Server) cleanupOrphanedE2EInstances(pr *model.PullRequest, logger logru
 		instLogger.Info("Destroying orphaned E2E instance")
 		if err := s.CloudClient.DeleteInstallation(inst.ID); err != nil {
 			instLogger.WithError(err).Error("Failed to destroy orphaned E2E instance")
+			continue
 		}
+		s.releaseProvisioningCapacity(inst.ID)
 	}
 }
 
@@ -905,6 +991,7 @@ func (s *Server) cleanupStaleE2EInstances() {
 				instLogger.WithError(err).Error("Failed to destroy stale E2E instance")
 				continue
 			}
+			s.releaseProvisioningCapacity(inst.ID)
 			if isPR {
 				reapedPRInstallationIDs = append(reapedPRInstallationIDs, inst.ID)
 			}
@@ -984,6 +1071,7 @@ func (s *Server) destroyE2EInstances(instances []*E2EInstance, logger logrus.Fie
 			logger.WithError(err).Error("Failed to destroy E2E instance")
 			continue
 		}
+		s.releaseProvisioningCapacity(instance.InstallationID)
 
 		logger.Info("Successfully destroyed E2E instance")
 	}
@@ -1102,47 +1190,24 @@ func (s *Server) wakeUpHibernatingInstances(instances []*E2EInstance, logger log
 	}
 }
 
-// postE2EStartedComment posts a comment when E2E tests start
+// postE2EStartedComment shows the E2E test servers in the status comment.
 func (s *Server) postE2EStartedComment(pr *model.PullRequest, instances []*E2EInstance) {
-	ctx := context.Background()
-	client := newGithubClient(s.Config.GithubAccessToken)
-
 	var platformsList string
 	for _, inst := range instances {
 		platformsList += fmt.Sprintf("- %s: %s\n", inst.Platform, inst.URL)
 	}
 
-	comment := fmt.Sprintf(`## E2E Test Servers Created
-
-The following test servers have been created and are ready for E2E testing:
+	status := fmt.Sprintf(`The following test servers have been created and are ready for E2E testing:
 
 %s
-
 Tests will run against these servers. Please monitor the workflow run for progress.`, platformsList)
 
-	_, _, err := client.Issues.CreateComment(ctx, pr.RepoOwner, pr.RepoName, pr.Number, &github.IssueComment{
-		Body: &comment,
-	})
-
-	if err != nil {
-		s.Logger.WithError(err).Error("Failed to post E2E started comment")
-	}
+	s.updateStatusComment(pr, statusSectionE2E, status)
 }
 
-// postE2EErrorComment posts an error comment
+// postE2EErrorComment shows an E2E setup failure in the status comment.
 func (s *Server) postE2EErrorComment(pr *model.PullRequest, errorMsg string) {
-	ctx := context.Background()
-	client := newGithubClient(s.Config.GithubAccessToken)
-
-	comment := fmt.Sprintf("❌ E2E Test Setup Failed\n\n%s", errorMsg)
-
-	_, _, err := client.Issues.CreateComment(ctx, pr.RepoOwner, pr.RepoName, pr.Number, &github.IssueComment{
-		Body: &comment,
-	})
-
-	if err != nil {
-		s.Logger.WithError(err).Error("Failed to post E2E error comment")
-	}
+	s.updateStatusComment(pr, statusSectionE2E, fmt.Sprintf("❌ E2E Test Setup Failed\n\n%s", errorMsg))
 }
 
 // buildInstanceDetailsJSON builds the instance details JSON for desktop workflows
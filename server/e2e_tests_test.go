@@ -1562,3 +1562,28 @@ func TestDispatchAndTrackCMTFollowUpMatrix(t *testing.T) {
 	assert.Equal(t, "https://retry.example.com", tracked[0].URL)
 }
 
+
+func TestE2ECleanupCancelsProvisioning(t *testing.T) {
+	s := &Server{
+		Logger:                 logrus.New(),
+		Config:                 &MatterwickConfig{},
+		e2eInstances:           make(map[string][]*E2EInstance),
+		e2ePRCleanupGeneration: make(map[string]int64),
+		e2eProvisioningCancels: make(map[string]map[string]context.CancelFunc),
+	}
+	// The repo has no E2E servers, so the cleanup does not query the provisioner.
+	pr := &model.PullRequest{RepoOwner: "mattermost", RepoName: "matterwick", Number: 1}
+	key := "matterwick-pr-1"
+
+	ctx, done := s.startE2EProvisioning(key, key+"-all", 0)
+	require.NoError(t, ctx.Err())
+
+	s.handleE2ECleanup(pr)
+	assert.ErrorIs(t, ctx.Err(), context.Canceled, "the cleanup cancels the provisioning of the PR")
+	done()
+	assert.Empty(t, s.e2eProvisioningCancels)
+
+	stale, done := s.startE2EProvisioning(key, key+"-all", 0)
+	defer done()
+	assert.ErrorIs(t, stale.Err(), context.Canceled, "provisioning started before a cleanup is cancelled right away")
+}
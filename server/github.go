@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/url"
 
 	"github.com/mattermost/matterwick/model"
 	"github.com/pkg/errors"
@@ -80,6 +81,7 @@ func (s *Server) GetPullRequestFromGithub(pullRequest *github.PullRequest) (*mod
 		Sha:       *pullRequest.Head.SHA,
 		State:     *pullRequest.State,
 		URL:       *pullRequest.URL,
+		Body:      pullRequest.GetBody(),
 		CreatedAt: pullRequest.GetCreatedAt(),
 	}
 
@@ -115,6 +117,17 @@ func (s *Server) getPullRequestFromIssue(issue *github.Issue, repo *github.Repos
 	return pr, nil
 }
 
+// githubClient returns a GitHub client, pointed to the mock API in tests.
+func (s *Server) githubClient() *github.Client {
+	client := newGithubClient(s.Config.GithubAccessToken)
+	if s.githubAPIBase != "" {
+		if baseURL, err := url.Parse(s.githubAPIBase); err == nil {
+			client.BaseURL = baseURL
+		}
+	}
+	return client
+}
+
 func labelsToStringArray(labels []*github.Label) []string {
 	out := make([]string, len(labels))
 
@@ -155,15 +168,6 @@ func (s *Server) addLabel(repoOwner, repoName string, number int, label string)
 	}
 }
 
-func (s *Server) getComments(repoOwner, repoName string, number int) ([]*github.IssueComment, error) {
-	client := newGithubClient(s.Config.GithubAccessToken)
-	comments, _, err := client.Issues.ListComments(context.Background(), repoOwner, repoName, number, nil)
-	if err != nil {
-		return nil, err
-	}
-	return comments, nil
-}
-
 // GetUpdateChecks retrieve updated status checks from GH
 func (s *Server) GetUpdateChecks(owner, repoName string, prNumber int) (*model.PullRequest, error) {
 	client := newGithubClient(s.Config.GithubAccessToken)
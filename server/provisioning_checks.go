@@ -0,0 +1,140 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+	"github.com/mattermost/matterwick/internal/spinwick"
+	"github.com/mattermost/matterwick/model"
+	"github.com/sirupsen/logrus"
+)
+
+// Stages of a provisioning operation shown in its check run.
+const (
+	provisioningStageQueued          = "Queued for provisioning capacity"
+	provisioningStageWaitingForBuild = "Waiting for the build workflow"
+	provisioningStageWaitingForImage = "Waiting for the docker image"
+	provisioningStageCreating        = "Creating the installation"
+	provisioningStageUpdating        = "Updating the installation"
+	provisioningStageDNS             = "Waiting for DNS to propagate"
+	provisioningStageInit            = "Initializing the server"
+	provisioningStagePlugins         = "Installing plugins"
+)
+
+// provisioningCheck is the check run of a running provisioning operation.
+type provisioningCheck struct {
+	repoOwner string
+	repoName  string
+	name      string
+	id        int64
+}
+
+// startProvisioningCheck creates an in-progress check run on the PR head commit
+// for the provisioning operation identified by key. Nothing is done unless
+// ProvisioningCheckRuns is enabled.
+func (s *Server) startProvisioningCheck(pr *model.PullRequest, key, name string, logger logrus.FieldLogger) {
+	if !s.Config.ProvisioningCheckRuns {
+		return
+	}
+
+	checkRun, _, err := s.githubClient().Checks.CreateCheckRun(context.Background(), pr.RepoOwner, pr.RepoName, github.CreateCheckRunOptions{
+		Name:      name,
+		HeadSHA:   pr.Sha,
+		Status:    github.String("in_progress"),
+		StartedAt: &github.Timestamp{Time: time.Now()},
+		Output: &github.CheckRunOutput{
+			Title:   github.String("Starting"),
+			Summary: github.String("Matterwick is starting to provision the test servers."),
+		},
+	})
+	if err != nil {
+		logger.WithError(err).WithField("check", name).Warn("Failed to create the provisioning check run")
+		return
+	}
+
+	s.provisioningChecksLock.Lock()
+	defer s.provisioningChecksLock.Unlock()
+	s.provisioningChecks[key] = provisioningCheck{
+		repoOwner: pr.RepoOwner,
+		repoName:  pr.RepoName,
+		name:      name,
+		id:        checkRun.GetID(),
+	}
+}
+
+func (s *Server) getProvisioningCheck(key string) (provisioningCheck, bool) {
+	s.provisioningChecksLock.Lock()
+	defer s.provisioningChecksLock.Unlock()
+	check, ok := s.provisioningChecks[key]
+	return check, ok
+}
+
+// setProvisioningStage shows the current stage in the check run of the
+// provisioning operation, if it has one.
+func (s *Server) setProvisioningStage(key, stage string) {
+	check, ok := s.getProvisioningCheck(key)
+	if !ok {
+		return
+	}
+
+	_, _, err := s.githubClient().Checks.UpdateCheckRun(context.Background(), check.repoOwner, check.repoName, check.id, github.UpdateCheckRunOptions{
+		Name: check.name,
+		Output: &github.CheckRunOutput{
+			Title:   github.String(stage),
+			Summary: github.String(stage + "."),
+		},
+	})
+	if err != nil {
+		s.Logger.WithError(err).WithFields(logrus.Fields{"check": check.name, "stage": stage}).Warn("Failed to update the provisioning check run")
+	}
+}
+
+// finishProvisioningCheck completes the check run of the provisioning operation
+// with the result of the request. The request error is the summary of failed
+// and aborted operations.
+func (s *Server) finishProvisioningCheck(key string, request *spinwick.Request) {
+	check, ok := s.getProvisioningCheck(key)
+	if !ok {
+		return
+	}
+	s.provisioningChecksLock.Lock()
+	delete(s.provisioningChecks, key)
+	s.provisioningChecksLock.Unlock()
+
+	conclusion, title, summary := "success", "Ready", "The test servers are ready."
+	if request.URL != "" {
+		summary = "The test server is ready: " + request.URL
+	}
+	if request.Error != nil {
+		conclusion, title, summary = "failure", "Failed", request.Error.Error()
+		if request.Aborted {
+			conclusion, title = "neutral", "Aborted"
+		}
+	}
+
+	_, _, err := s.githubClient().Checks.UpdateCheckRun(context.Background(), check.repoOwner, check.repoName, check.id, github.UpdateCheckRunOptions{
+		Name:        check.name,
+		Status:      github.String("completed"),
+		Conclusion:  github.String(conclusion),
+		CompletedAt: &github.Timestamp{Time: time.Now()},
+		Output: &github.CheckRunOutput{
+			Title:   github.String(title),
+			Summary: github.String(summary),
+		},
+	})
+	if err != nil {
+		s.Logger.WithError(err).WithField("check", check.name).Warn("Failed to complete the provisioning check run")
+	}
+}
+
+// spinWickCheckName returns the name of the check run of a SpinWick.
+func spinWickCheckName(variant string) string {
+	if variant != "" {
+		return "SpinWick (" + variant + ")"
+	}
+	return "SpinWick"
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mattermost/matterwick/internal/spinwick"
+	"github.com/mattermost/matterwick/model"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// checkRunsGitHubMock is a minimal GitHub API recording check run requests.
+type checkRunsGitHubMock struct {
+	created []map[string]interface{}
+	updates []map[string]interface{}
+}
+
+func (m *checkRunsGitHubMock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&body)
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/repos/mattermost/mattermost-server/check-runs":
+		m.created = append(m.created, body)
+		w.Write([]byte(`{"id": 7}`))
+	case r.Method == http.MethodPatch && r.URL.Path == "/repos/mattermost/mattermost-server/check-runs/7":
+		m.updates = append(m.updates, body)
+		w.Write([]byte(`{"id": 7}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newCheckRunsTestServer(url string, enabled bool) *Server {
+	return &Server{
+		Logger:             logrus.New(),
+		Config:             &MatterwickConfig{ProvisioningCheckRuns: enabled},
+		githubAPIBase:      url + "/",
+		provisioningChecks: make(map[string]provisioningCheck),
+	}
+}
+
+func TestProvisioningChecks(t *testing.T) {
+	pr := &model.PullRequest{RepoOwner: "mattermost", RepoName: "mattermost-server", Number: 42, Sha: "abc123"}
+
+	t.Run("disabled", func(t *testing.T) {
+		mock := &checkRunsGitHubMock{}
+		ts := httptest.NewServer(mock)
+		defer ts.Close()
+		s := newCheckRunsTestServer(ts.URL, false)
+
+		s.startProvisioningCheck(pr, "mattermost-server-pr-42", spinWickCheckName(""), s.Logger)
+		s.setProvisioningStage("mattermost-server-pr-42", provisioningStageDNS)
+		s.finishProvisioningCheck("mattermost-server-pr-42", &spinwick.Request{})
+		assert.Empty(t, mock.created)
+		assert.Empty(t, mock.updates)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		mock := &checkRunsGitHubMock{}
+		ts := httptest.NewServer(mock)
+		defer ts.Close()
+		s := newCheckRunsTestServer(ts.URL, true)
+
+		s.startProvisioningCheck(pr, "mattermost-server-pr-42-ha", spinWickCheckName("ha"), s.Logger)
+		require.Len(t, mock.created, 1)
+		assert.Equal(t, "SpinWick (ha)", mock.created[0]["name"])
+		assert.Equal(t, "abc123", mock.created[0]["head_sha"])
+		assert.Equal(t, "in_progress", mock.created[0]["status"])
+
+		s.setProvisioningStage("mattermost-server-pr-42-ha", provisioningStageDNS)
+		s.setProvisioningStage("unknown", provisioningStageInit)
+		require.Len(t, mock.updates, 1)
+		assert.Equal(t, "SpinWick (ha)", mock.updates[0]["name"])
+		assert.Equal(t, provisioningStageDNS, mock.updates[0]["output"].(map[string]interface{})["title"])
+
+		s.finishProvisioningCheck("mattermost-server-pr-42-ha", (&spinwick.Request{}).WithURL("https://spinwick.example.com"))
+		require.Len(t, mock.updates, 2)
+		assert.Equal(t, "completed", mock.updates[1]["status"])
+		assert.Equal(t, "success", mock.updates[1]["conclusion"])
+		assert.Contains(t, mock.updates[1]["output"].(map[string]interface{})["summary"], "https://spinwick.example.com")
+
+		s.setProvisioningStage("mattermost-server-pr-42-ha", provisioningStageInit)
+		assert.Len(t, mock.updates, 2, "finished checks are not updated")
+	})
+
+	for name, tc := range map[string]struct {
+		request    *spinwick.Request
+		conclusion string
+	}{
+		"failure": {(&spinwick.Request{}).WithError(errors.New("timed out waiting for DNS")), "failure"},
+		"aborted": {(&spinwick.Request{}).WithError(errors.New("timed out waiting for DNS")).IntentionalAbort(), "neutral"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			mock := &checkRunsGitHubMock{}
+			ts := httptest.NewServer(mock)
+			defer ts.Close()
+			s := newCheckRunsTestServer(ts.URL, true)
+
+			s.startProvisioningCheck(pr, "mattermost-server-pr-42", spinWickCheckName(""), s.Logger)
+			s.finishProvisioningCheck("mattermost-server-pr-42", tc.request)
+			require.Len(t, mock.updates, 1)
+			assert.Equal(t, tc.conclusion, mock.updates[0]["conclusion"])
+			assert.Equal(t, "timed out waiting for DNS", mock.updates[0]["output"].(map[string]interface{})["summary"])
+		})
+	}
+}
@@ -0,0 +1,457 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	cloudModel "github.com/mattermost/mattermost-cloud/model"
+	"github.com/mattermost/matterwick/internal/cloudtools"
+	"github.com/mattermost/matterwick/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Installation kinds limited by ProvisioningQuotas.PerKind.
+const (
+	installationKindSpinWick = "spinwick"
+	installationKindE2E      = "e2e"
+	installationKindCMT      = "cmt"
+)
+
+// Scopes of the usage counters limited by ProvisioningQuotas.
+const (
+	quotaScopeGlobal = "global"
+	quotaScopeKind   = "kind"
+	quotaScopeRepo   = "repo"
+	quotaScopeUser   = "user"
+)
+
+// quotaCounter counts the installations of a scope, e.g. of one repository.
+type quotaCounter struct {
+	scope string
+	name  string
+}
+
+// limit returns the limit of the counter, 0 meaning unlimited.
+func (q ProvisioningQuotas) limit(counter quotaCounter) int {
+	switch counter.scope {
+	case quotaScopeGlobal:
+		return q.Global
+	case quotaScopeKind:
+		return q.PerKind[counter.name]
+	case quotaScopeRepo:
+		if limit, ok := q.Repos[counter.name]; ok {
+			return limit
+		}
+		return q.PerRepo
+	case quotaScopeUser:
+		if limit, ok := q.Users[counter.name]; ok {
+			return limit
+		}
+		return q.PerUser
+	}
+	return 0
+}
+
+// describe returns the limit of the counter as shown to the PR.
+func (q ProvisioningQuotas) describe(counter quotaCounter) string {
+	limit := q.limit(counter)
+	switch counter.scope {
+	case quotaScopeGlobal:
+		return fmt.Sprintf("the global limit of %d test servers", limit)
+	case quotaScopeKind:
+		return fmt.Sprintf("the limit of %d %s test servers", limit, counter.name)
+	case quotaScopeRepo:
+		return fmt.Sprintf("the limit of %d test servers for %s", limit, counter.name)
+	default:
+		return fmt.Sprintf("the limit of %d test servers for @%s", limit, counter.name)
+	}
+}
+
+// provisioningClaim describes the installations a request wants to provision.
+type provisioningClaim struct {
+	// user is the PR author, empty for requests without a PR like CMT runs.
+	user string
+	// repo is empty for installations found on the provisioner whose repository
+	// is unknown.
+	repo          string
+	kind          string
+	installations int
+}
+
+// counters returns the usage counters the claim is accounted to.
+func (c provisioningClaim) counters() []quotaCounter {
+	counters := []quotaCounter{
+		{scope: quotaScopeGlobal},
+		{scope: quotaScopeKind, name: c.kind},
+	}
+	if c.repo != "" {
+		counters = append(counters, quotaCounter{scope: quotaScopeRepo, name: c.repo})
+	}
+	if c.user != "" {
+		counters = append(counters, quotaCounter{scope: quotaScopeUser, name: c.user})
+	}
+	return counters
+}
+
+// provisioningWaiter is a claim waiting in the provisioning queue.
+type provisioningWaiter struct {
+	claim   provisioningClaim
+	granted chan struct{}
+}
+
+// provisioningQueue accounts the installations provisioned by matterwick
+// against ProvisioningQuotas, and queues the claims above the limits. The
+// accounting lives in memory and is loaded from the provisioner at startup.
+// The zero value is ready to use.
+type provisioningQueue struct {
+	lock   sync.Mutex
+	quotas ProvisioningQuotas
+	usage  map[quotaCounter]int
+	// held maps the holders of provisioned installations, SpinWick owner IDs or
+	// E2E installation IDs, to the claim they were accounted to.
+	held    map[string]provisioningClaim
+	waiting []*provisioningWaiter
+}
+
+// provisioningLease is the capacity granted to a claim. Installations kept by
+// the lease stay accounted until they are released, the others are given back
+// when the lease is done.
+type provisioningLease struct {
+	queue   *provisioningQueue
+	claim   provisioningClaim
+	unbound int
+}
+
+// acquire waits until the claim fits the quotas. Claims are granted in arrival
+// order, except that a claim held back by its user or repo limit does not hold
+// back the claims of other users and repos. A claim larger than a limit is
+// granted once nothing else uses that limit. queued is called with the queue
+// position and the blocking limit when the claim has to wait.
+func (q *provisioningQueue) acquire(ctx context.Context, quotas ProvisioningQuotas, claim provisioningClaim, queued func(position int, limit string)) (*provisioningLease, error) {
+	waiter := &provisioningWaiter{claim: claim, granted: make(chan struct{})}
+	lease := &provisioningLease{queue: q, claim: claim, unbound: claim.installations}
+
+	q.lock.Lock()
+	q.quotas = quotas
+	q.waiting = append(q.waiting, waiter)
+	q.grant()
+	position, limit := q.position(waiter)
+	q.lock.Unlock()
+
+	if position == 0 {
+		return lease, nil
+	}
+	queued(position, limit)
+
+	select {
+	case <-waiter.granted:
+		return lease, nil
+	case <-ctx.Done():
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	select {
+	case <-waiter.granted:
+		// Granted while giving up.
+		q.add(claim, -claim.installations)
+	default:
+		for i, w := range q.waiting {
+			if w == waiter {
+				q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+				break
+			}
+		}
+	}
+	q.grant()
+	return nil, ctx.Err()
+}
+
+// grant grants the queued claims that fit the quotas. A claim that does not
+// fit blocks the full counters for the claims queued after it, so they cannot
+// overtake it. The lock must be held.
+func (q *provisioningQueue) grant() {
+	blocked := make(map[quotaCounter]bool)
+	waiting := q.waiting[:0]
+	for _, waiter := range q.waiting {
+		fits := true
+		for _, counter := range waiter.claim.counters() {
+			if blocked[counter] {
+				fits = false
+			} else if !q.fits(counter, waiter.claim.installations) {
+				fits = false
+				blocked[counter] = true
+			}
+		}
+		if !fits {
+			waiting = append(waiting, waiter)
+			continue
+		}
+		q.add(waiter.claim, waiter.claim.installations)
+		close(waiter.granted)
+	}
+	for i := len(waiting); i < len(q.waiting); i++ {
+		q.waiting[i] = nil
+	}
+	q.waiting = waiting
+}
+
+// fits reports whether installations more fit the limit of the counter. The
+// lock must be held.
+func (q *provisioningQueue) fits(counter quotaCounter, installations int) bool {
+	limit := q.quotas.limit(counter)
+	used := q.usage[counter]
+	return limit <= 0 || used == 0 || used+installations <= limit
+}
+
+// add accounts installations more to the counters of the claim. The lock must
+// be held.
+func (q *provisioningQueue) add(claim provisioningClaim, installations int) {
+	if q.usage == nil {
+		q.usage = make(map[quotaCounter]int)
+	}
+	for _, counter := range claim.counters() {
+		q.usage[counter] += installations
+		if q.usage[counter] <= 0 {
+			delete(q.usage, counter)
+		}
+	}
+}
+
+// position returns the 1-based queue position of the waiter and the limit
+// blocking it, or 0 if it was granted. The lock must be held.
+func (q *provisioningQueue) position(waiter *provisioningWaiter) (int, string) {
+	for i, w := range q.waiting {
+		if w != waiter {
+			continue
+		}
+		for _, counter := range waiter.claim.counters() {
+			if !q.fits(counter, waiter.claim.installations) {
+				return i + 1, q.quotas.describe(counter)
+			}
+		}
+		return i + 1, "the requests queued before it"
+	}
+	return 0, ""
+}
+
+// release stops accounting the installations of the holders, waking the
+// queued claims that now fit.
+func (q *provisioningQueue) release(holders ...string) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for _, holder := range holders {
+		claim, ok := q.held[holder]
+		if !ok {
+			continue
+		}
+		delete(q.held, holder)
+		q.add(claim, -1)
+	}
+	q.grant()
+}
+
+// hold accounts one installation already provisioned for the claim to the
+// holder until it is released. Holders already held are ignored.
+func (q *provisioningQueue) hold(holder string, claim provisioningClaim) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if _, ok := q.held[holder]; ok {
+		return
+	}
+	if q.held == nil {
+		q.held = make(map[string]provisioningClaim)
+	}
+	q.held[holder] = claim
+	q.add(claim, 1)
+}
+
+// keep keeps one installation of the lease accounted to each holder until it
+// is released. Holders beyond the claim or already held are ignored.
+func (l *provisioningLease) keep(holders ...string) {
+	l.queue.lock.Lock()
+	defer l.queue.lock.Unlock()
+
+	if l.queue.held == nil {
+		l.queue.held = make(map[string]provisioningClaim)
+	}
+	for _, holder := range holders {
+		if l.unbound == 0 {
+			return
+		}
+		if _, ok := l.queue.held[holder]; ok {
+			continue
+		}
+		l.queue.held[holder] = l.claim
+		l.unbound--
+	}
+}
+
+// done gives back the installations of the lease that were not kept.
+func (l *provisioningLease) done() {
+	l.queue.lock.Lock()
+	defer l.queue.lock.Unlock()
+
+	l.queue.add(l.claim, -l.unbound)
+	l.unbound = 0
+	l.queue.grant()
+}
+
+// acquireProvisioningCapacity waits in the provisioning queue until the claim
+// fits the configured quotas.
+func (s *Server) acquireProvisioningCapacity(ctx context.Context, claim provisioningClaim, queued func(position int, limit string)) (*provisioningLease, error) {
+	lease, err := s.provisioningQueue.acquire(ctx, s.Config.ProvisioningQuotas, claim, queued)
+	if err != nil {
+		return nil, errors.Wrap(err, "stopped waiting for provisioning capacity")
+	}
+	return lease, nil
+}
+
+// releaseProvisioningCapacity stops accounting the installations of the
+// holders against the quotas.
+func (s *Server) releaseProvisioningCapacity(holders ...string) {
+	s.provisioningQueue.release(holders...)
+}
+
+var (
+	// e2eOwnerIDRegex matches the owner IDs of PR E2E installations, e.g.
+	// mobile-pr-123-ios-site-1-0a1b2c3d.
+	e2eOwnerIDRegex = regexp.MustCompile(`^(desktop|mobile)-pr-(\d+)-.+$`)
+	// cmtOwnerIDRegex matches the owner IDs of the other E2E installations, e.g.
+	// the CMT installation desktop-11-6-4-0a1b2c3d. E2E installations of pushes
+	// share the format and are accounted as CMT installations.
+	cmtOwnerIDRegex = regexp.MustCompile(`^(desktop|mobile)-.+$`)
+	// spinWickOwnerIDRegex matches the owner IDs of SpinWicks and their named
+	// variants, e.g. mattermost-pr-123 or mattermost-pr-123-ha.
+	spinWickOwnerIDRegex = regexp.MustCompile(`^(.+)-pr-(\d+)(?:-([a-z0-9-]+))?$`)
+)
+
+// e2eInstanceTypeRepos maps the E2E instance types starting the owner IDs of
+// E2E and CMT installations to their repository.
+var e2eInstanceTypeRepos = map[string]string{
+	"desktop": "desktop",
+	"mobile":  "mattermost-mobile",
+}
+
+// provisionedClaim returns the holder and claim of an installation provisioned
+// by matterwick, along with the number of its PR or 0 for installations not
+// provisioned for a PR. E2E and CMT installations are held by installation ID.
+func provisionedClaim(ownerID, installationID string) (string, provisioningClaim, int, bool) {
+	if match := e2eOwnerIDRegex.FindStringSubmatch(ownerID); match != nil {
+		number, err := strconv.Atoi(match[2])
+		if err != nil {
+			return "", provisioningClaim{}, 0, false
+		}
+		return installationID, provisioningClaim{repo: e2eInstanceTypeRepos[match[1]], kind: installationKindE2E, installations: 1}, number, true
+	}
+	if match := cmtOwnerIDRegex.FindStringSubmatch(ownerID); match != nil {
+		return installationID, provisioningClaim{repo: e2eInstanceTypeRepos[match[1]], kind: installationKindCMT, installations: 1}, 0, true
+	}
+	match := spinWickOwnerIDRegex.FindStringSubmatch(ownerID)
+	if match == nil || validateVariantName(match[3]) != nil {
+		return "", provisioningClaim{}, 0, false
+	}
+	number, err := strconv.Atoi(match[2])
+	if err != nil {
+		return "", provisioningClaim{}, 0, false
+	}
+	return ownerID, provisioningClaim{repo: match[1], kind: installationKindSpinWick, installations: 1}, number, true
+}
+
+// getProvisionedInstallations returns the installations of the provisioner that
+// matterwick may have provisioned. Without a CloudGroupID the provisioner may
+// also hold installations of others, so only the ones named in the test server
+// domain are returned.
+func (s *Server) getProvisionedInstallations() ([]*cloudModel.InstallationDTO, error) {
+	installations, err := cloudtools.GetInstallationsWithOwnerIDPrefix(s.CloudClient, s.Config.CloudGroupID, "")
+	if err != nil || s.Config.CloudGroupID != "" {
+		return installations, err
+	}
+
+	var provisioned []*cloudModel.InstallationDTO
+	for _, installation := range installations {
+		if strings.HasSuffix(cloudtools.GetInstallationDNSFromDNSRecords(installation), "."+s.Config.DNSNameTestServer) {
+			provisioned = append(provisioned, installation)
+		}
+	}
+	return provisioned, nil
+}
+
+// loadProvisioningUsage accounts the SpinWick, E2E and CMT installations found
+// on the provisioner against the quotas, so restarts don't undercount them.
+// Installations of PRs are accounted to the author of their PR when it can be
+// looked up.
+func (s *Server) loadProvisioningUsage() {
+	logger := s.Logger.WithField("type", "provisioning_usage")
+
+	installations, err := s.getProvisionedInstallations()
+	if err != nil {
+		logger.WithError(err).Error("Failed to load the provisioning usage, installations provisioned before the restart are not counted")
+		return
+	}
+
+	authors := make(map[string]string)
+	var loaded int
+	for _, installation := range installations {
+		holder, claim, number, ok := provisionedClaim(installation.OwnerID, installation.ID)
+		if !ok {
+			continue
+		}
+		if number != 0 && claim.repo != "" {
+			pr := fmt.Sprintf("%s#%d", claim.repo, number)
+			author, ok := authors[pr]
+			if !ok {
+				author, err = s.prAuthor(claim.repo, number)
+				if err != nil {
+					logger.WithError(err).WithField("pr", pr).Warn("Failed to look up the PR author, the installation is not counted for the user")
+				}
+				authors[pr] = author
+			}
+			claim.user = author
+		}
+		s.provisioningQueue.hold(holder, claim)
+		loaded++
+	}
+	logger.WithField("installations", loaded).Info("Loaded the provisioning usage")
+}
+
+// prAuthor returns the login of the author of a PR of the organization.
+func (s *Server) prAuthor(repoName string, number int) (string, error) {
+	pr, _, err := s.githubClient().PullRequests.Get(context.Background(), s.Config.Org, repoName, number)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get the PR")
+	}
+	return pr.GetUser().GetLogin(), nil
+}
+
+// waitForSpinWickCapacity waits for the capacity of a SpinWick installation,
+// telling the PR its queue position while it waits and when provisioning
+// starts.
+func (s *Server) waitForSpinWickCapacity(ctx context.Context, pr *model.PullRequest, variant, ownerID string, logger logrus.FieldLogger) (*provisioningLease, error) {
+	claim := provisioningClaim{user: pr.Username, repo: pr.RepoName, kind: installationKindSpinWick, installations: 1}
+	queued := false
+	lease, err := s.acquireProvisioningCapacity(ctx, claim, func(position int, limit string) {
+		queued = true
+		logger.WithField("position", position).Info("SpinWick queued for provisioning capacity")
+		s.setProvisioningStage(ownerID, provisioningStageQueued)
+		s.setSpinWickStatus(pr, variant, fmt.Sprintf("The SpinWick%s is number %d in the provisioning queue, waiting for %s.", variantSuffix(variant), position, limit))
+	})
+	if err != nil {
+		return nil, err
+	}
+	if queued {
+		logger.Info("SpinWick left the provisioning queue")
+		s.setSpinWickStatus(pr, variant, fmt.Sprintf("The SpinWick%s left the provisioning queue, provisioning has started.", variantSuffix(variant)))
+	}
+	return lease, nil
+}
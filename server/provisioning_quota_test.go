@@ -0,0 +1,299 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/matterwick/model"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProvisioningQuotasLimit(t *testing.T) {
+	quotas := ProvisioningQuotas{
+		Global:  10,
+		PerUser: 2,
+		Users:   map[string]int{"release-bot": 5},
+		PerRepo: 4,
+		Repos:   map[string]int{"mattermost-mobile": 0},
+		PerKind: map[string]int{installationKindCMT: 6},
+	}
+
+	assert.Equal(t, 10, quotas.limit(quotaCounter{scope: quotaScopeGlobal}))
+	assert.Equal(t, 2, quotas.limit(quotaCounter{scope: quotaScopeUser, name: "someone"}))
+	assert.Equal(t, 5, quotas.limit(quotaCounter{scope: quotaScopeUser, name: "release-bot"}))
+	assert.Equal(t, 4, quotas.limit(quotaCounter{scope: quotaScopeRepo, name: "mattermost-server"}))
+	assert.Equal(t, 0, quotas.limit(quotaCounter{scope: quotaScopeRepo, name: "mattermost-mobile"}), "overrides can lift the limit")
+	assert.Equal(t, 6, quotas.limit(quotaCounter{scope: quotaScopeKind, name: installationKindCMT}))
+	assert.Equal(t, 0, quotas.limit(quotaCounter{scope: quotaScopeKind, name: installationKindE2E}))
+
+	assert.Equal(t, "the limit of 2 test servers for @someone", quotas.describe(quotaCounter{scope: quotaScopeUser, name: "someone"}))
+	assert.Equal(t, "the limit of 6 cmt test servers", quotas.describe(quotaCounter{scope: quotaScopeKind, name: installationKindCMT}))
+}
+
+// queuedClaim is the outcome of a provisioningQueue.acquire run in a goroutine.
+type queuedClaim struct {
+	lease *provisioningLease
+	err   error
+}
+
+// acquireInBackground acquires the claim in a goroutine, returning once the
+// claim is queued with its position and blocking limit.
+func acquireInBackground(t *testing.T, ctx context.Context, q *provisioningQueue, quotas ProvisioningQuotas, claim provisioningClaim) (<-chan queuedClaim, int, string) {
+	type queuedAt struct {
+		position int
+		limit    string
+	}
+	positions := make(chan queuedAt, 1)
+	results := make(chan queuedClaim, 1)
+	go func() {
+		lease, err := q.acquire(ctx, quotas, claim, func(position int, limit string) {
+			positions <- queuedAt{position, limit}
+		})
+		results <- queuedClaim{lease, err}
+	}()
+
+	select {
+	case queued := <-positions:
+		return results, queued.position, queued.limit
+	case result := <-results:
+		require.NoError(t, result.err)
+		t.Fatal("the claim was not queued")
+	case <-time.After(10 * time.Second):
+		t.Fatal("the claim was not queued")
+	}
+	return nil, 0, ""
+}
+
+func receiveClaim(t *testing.T, results <-chan queuedClaim) queuedClaim {
+	select {
+	case result := <-results:
+		return result
+	case <-time.After(10 * time.Second):
+		t.Fatal("the claim was not granted")
+		return queuedClaim{}
+	}
+}
+
+func TestProvisioningQueue(t *testing.T) {
+	claim := func(user string, installations int) provisioningClaim {
+		return provisioningClaim{user: user, repo: "mattermost-server", kind: installationKindSpinWick, installations: installations}
+	}
+	notQueued := func(int, string) { t.Fatal("the claim was queued") }
+
+	t.Run("kept installations count until released", func(t *testing.T) {
+		q := &provisioningQueue{}
+		quotas := ProvisioningQuotas{PerUser: 1}
+
+		lease, err := q.acquire(context.Background(), quotas, claim("alice", 1), notQueued)
+		require.NoError(t, err)
+		lease.keep("mattermost-server-pr-1")
+		lease.done()
+
+		results, position, limit := acquireInBackground(t, context.Background(), q, quotas, claim("alice", 1))
+		assert.Equal(t, 1, position)
+		assert.Equal(t, "the limit of 1 test servers for @alice", limit)
+
+		other, err := q.acquire(context.Background(), quotas, claim("bob", 1), notQueued)
+		require.NoError(t, err, "other users are not held back")
+		other.done()
+
+		q.release("mattermost-server-pr-1")
+		result := receiveClaim(t, results)
+		require.NoError(t, result.err)
+		result.lease.done()
+		assert.Empty(t, q.usage)
+		assert.Empty(t, q.held)
+	})
+
+	t.Run("shared limits are granted in arrival order", func(t *testing.T) {
+		q := &provisioningQueue{}
+		quotas := ProvisioningQuotas{Global: 3}
+
+		first, err := q.acquire(context.Background(), quotas, claim("alice", 2), notQueued)
+		require.NoError(t, err)
+
+		large, position, _ := acquireInBackground(t, context.Background(), q, quotas, claim("bob", 2))
+		assert.Equal(t, 1, position)
+		small, position, limit := acquireInBackground(t, context.Background(), q, quotas, claim("carol", 1))
+		assert.Equal(t, 2, position)
+		assert.Equal(t, "the requests queued before it", limit, "the small claim fits but must not overtake the large one")
+
+		first.done()
+		receiveClaim(t, large).lease.done()
+		receiveClaim(t, small).lease.done()
+		assert.Empty(t, q.waiting)
+	})
+
+	t.Run("claims larger than a limit run alone", func(t *testing.T) {
+		q := &provisioningQueue{}
+		lease, err := q.acquire(context.Background(), ProvisioningQuotas{PerRepo: 3}, claim("alice", 5), notQueued)
+		require.NoError(t, err)
+		lease.done()
+	})
+
+	t.Run("cancelled claims leave the queue", func(t *testing.T) {
+		q := &provisioningQueue{}
+		quotas := ProvisioningQuotas{Global: 1}
+
+		lease, err := q.acquire(context.Background(), quotas, claim("alice", 1), notQueued)
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancelled, _, _ := acquireInBackground(t, ctx, q, quotas, claim("bob", 1))
+		waiting, position, _ := acquireInBackground(t, context.Background(), q, quotas, claim("carol", 1))
+		assert.Equal(t, 2, position)
+
+		cancel()
+		assert.ErrorIs(t, receiveClaim(t, cancelled).err, context.Canceled)
+
+		lease.done()
+		receiveClaim(t, waiting).lease.done()
+		assert.Empty(t, q.usage)
+	})
+}
+
+func TestProvisionedClaim(t *testing.T) {
+	holder, claim, number, ok := provisionedClaim("mattermost-pr-12", "inst-1")
+	require.True(t, ok)
+	assert.Equal(t, "mattermost-pr-12", holder)
+	assert.Equal(t, provisioningClaim{repo: "mattermost", kind: installationKindSpinWick, installations: 1}, claim)
+	assert.Equal(t, 12, number)
+
+	holder, claim, number, ok = provisionedClaim("mattermost-plugin-jira-pr-3-ha", "inst-2")
+	require.True(t, ok)
+	assert.Equal(t, "mattermost-plugin-jira-pr-3-ha", holder)
+	assert.Equal(t, "mattermost-plugin-jira", claim.repo)
+	assert.Equal(t, 3, number)
+
+	holder, claim, number, ok = provisionedClaim("mobile-pr-7-ios-site-1-0a1b2c3d", "inst-3")
+	require.True(t, ok)
+	assert.Equal(t, "inst-3", holder, "E2E installations are held by installation ID")
+	assert.Equal(t, provisioningClaim{repo: "mattermost-mobile", kind: installationKindE2E, installations: 1}, claim)
+	assert.Equal(t, 7, number)
+
+	holder, claim, number, ok = provisionedClaim("desktop-11-6-4-0a1b2c3d", "inst-4")
+	require.True(t, ok)
+	assert.Equal(t, "inst-4", holder)
+	assert.Equal(t, provisioningClaim{repo: "desktop", kind: installationKindCMT, installations: 1}, claim)
+	assert.Equal(t, 0, number)
+
+	_, _, _, ok = provisionedClaim("mattermost-pr-12-not-a-valid-variant", "inst-5")
+	assert.False(t, ok)
+	_, _, _, ok = provisionedClaim("customer-workspace", "inst-6")
+	assert.False(t, ok)
+}
+
+func TestLoadProvisioningUsage(t *testing.T) {
+	cloud := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/installations" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`[
+			{"ID":"inst-1","OwnerID":"mattermost-pr-1","State":"stable","DNSRecords":[{"DomainName":"mattermost-pr-1-abcde.test.mattermost.cloud"}]},
+			{"ID":"inst-2","OwnerID":"mattermost-pr-1-ha","State":"stable","DNSRecords":[{"DomainName":"mattermost-pr-1-ha-abcde.test.mattermost.cloud"}]},
+			{"ID":"inst-3","OwnerID":"mattermost-pr-2","State":"stable","DNSRecords":[{"DomainName":"mattermost-pr-2-abcde.test.mattermost.cloud"}]},
+			{"ID":"inst-4","OwnerID":"mattermost-pr-3","State":"deletion-requested","DNSRecords":[{"DomainName":"mattermost-pr-3-abcde.test.mattermost.cloud"}]},
+			{"ID":"inst-5","OwnerID":"desktop-pr-9-linux-0a1b2c3d","State":"hibernating","DNSRecords":[{"DomainName":"desktop-pr-9-linux-0a1b2c3d.test.mattermost.cloud"}]},
+			{"ID":"inst-6","OwnerID":"desktop-11-6-4-0a1b2c3d","State":"stable","DNSRecords":[{"DomainName":"desktop-11-6-4-0a1b2c3d.test.mattermost.cloud"}]},
+			{"ID":"inst-7","OwnerID":"customer-pr-4","State":"stable","DNSRecords":[{"DomainName":"customer.cloud.mattermost.com"}]}
+		]`))
+	}))
+	defer cloud.Close()
+
+	var lookups int
+	github := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lookups++
+		switch r.URL.Path {
+		case "/repos/mattermost/mattermost/pulls/1":
+			w.Write([]byte(`{"number":1,"user":{"login":"alice"}}`))
+		case "/repos/mattermost/desktop/pulls/9":
+			w.Write([]byte(`{"number":9,"user":{"login":"bob"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer github.Close()
+
+	s := &Server{
+		Logger:        logrus.New(),
+		Config:        &MatterwickConfig{Org: "mattermost", DNSNameTestServer: "test.mattermost.cloud"},
+		CloudClient:   model.NewCloudClient(cloud.URL, "", "", "", ""),
+		githubAPIBase: github.URL + "/",
+	}
+	s.loadProvisioningUsage()
+
+	assert.Equal(t, 3, lookups, "the author of each PR is looked up once")
+	assert.Equal(t, map[quotaCounter]int{
+		{scope: quotaScopeGlobal}:                               5,
+		{scope: quotaScopeKind, name: installationKindSpinWick}: 3,
+		{scope: quotaScopeKind, name: installationKindE2E}:      1,
+		{scope: quotaScopeKind, name: installationKindCMT}:      1,
+		{scope: quotaScopeRepo, name: "mattermost"}:             3,
+		{scope: quotaScopeRepo, name: "desktop"}:                2,
+		{scope: quotaScopeUser, name: "alice"}:                  2,
+		{scope: quotaScopeUser, name: "bob"}:                    1,
+	}, s.provisioningQueue.usage, "installations outside of the test server domain are not counted without a group")
+
+	s.releaseProvisioningCapacity("mattermost-pr-1", "mattermost-pr-1-ha", "mattermost-pr-2", "inst-5", "inst-6")
+	assert.Empty(t, s.provisioningQueue.usage)
+	assert.Empty(t, s.provisioningQueue.held)
+}
+
+func TestWaitForSpinWickCapacity(t *testing.T) {
+	mock := &statusCommentGitHubMock{comments: make(map[int64]string)}
+	ts := httptest.NewServer(mock)
+	defer ts.Close()
+
+	s := &Server{
+		Logger:        logrus.New(),
+		Config:        &MatterwickConfig{Username: "matterwick", ProvisioningQuotas: ProvisioningQuotas{PerRepo: 1}},
+		githubAPIBase: ts.URL + "/",
+	}
+	pr := &model.PullRequest{RepoOwner: "mattermost", RepoName: "mattermost-server", Number: 1, Username: "alice"}
+
+	held, err := s.waitForSpinWickCapacity(context.Background(), pr, "", "mattermost-server-pr-2", s.Logger)
+	require.NoError(t, err)
+	held.keep("mattermost-server-pr-2")
+	held.done()
+	assert.Empty(t, mock.comments, "the PR is not told about capacity available right away")
+
+	results := make(chan error, 1)
+	go func() {
+		lease, err := s.waitForSpinWickCapacity(context.Background(), pr, "", "mattermost-server-pr-1", s.Logger)
+		if err == nil {
+			lease.done()
+		}
+		results <- err
+	}()
+
+	statusComment := func() string {
+		mock.lock.Lock()
+		defer mock.lock.Unlock()
+		for _, body := range mock.comments {
+			return body
+		}
+		return ""
+	}
+	require.Eventually(t, func() bool {
+		return strings.Contains(statusComment(), "The SpinWick is number 1 in the provisioning queue, waiting for the limit of 1 test servers for mattermost-server.")
+	}, 10*time.Second, time.Millisecond)
+
+	s.releaseProvisioningCapacity("mattermost-server-pr-2")
+	select {
+	case err := <-results:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("the SpinWick did not leave the queue")
+	}
+	assert.Contains(t, statusComment(), "The SpinWick left the provisioning queue, provisioning has started.")
+}
@@ -4,9 +4,6 @@
 package server
 
 import (
-	"context"
-	"strings"
-
 	"github.com/mattermost/matterwick/model"
 	"github.com/sirupsen/logrus"
 
@@ -90,7 +87,9 @@ func (s *Server) handlePullRequestEvent(event *github.PullRequestEvent) {
 	case "synchronize":
 		logger.Info("PR has a new commit")
 
+		go s.handleUpdateSpinWickVariants(pr, false)
 		s.handleSynchronizeSpinwick(pr, spinwick.RepeatableID, false)
+		go s.refreshCompanionHosts(pr)
 	case "closed":
 		logger.Info("PR was closed")
 		// Always attempt E2E cleanup on close — the label may not have been removed
@@ -103,6 +102,9 @@ func (s *Server) handlePullRequestEvent(event *github.PullRequestEvent) {
 				s.handleDestroySpinWick(pr, false)
 			}
 		}
+		s.handleDestroySpinWickVariants(pr)
+		// Snapshots are only useful while the PR is open.
+		s.cleanupSpinWickSnapshots(pr, "")
 	}
 }
 
@@ -124,48 +126,6 @@ func (s *Server) handleSynchronizeSpinwick(pr *model.PullRequest, spinwickID str
 	s.handleUpdateSpinWick(pr, withLicense, withCloudInfra, noBuildChanges, s.getEnvMap(spinwickID))
 }
 
-func (s *Server) removeOldComments(comments []*github.IssueComment, pr *model.PullRequest, logger logrus.FieldLogger) {
-	serverMessages := []string{
-		s.Config.SetupSpinmintFailedMessage,
-		"Spinmint test server created",
-		"Spinmint upgrade test server created",
-		"New commit detected",
-		"Error during the request to upgrade",
-		"Error doing the upgrade process",
-		"Timed out waiting",
-		"Mattermost test server created!",
-		"Mattermost test server updated!",
-		"Failed to create mattermost installation",
-		"Creating a new SpinWick test server using Mattermost Cloud.",
-		"Please wait while a new kubernetes cluster is created for your SpinWick",
-		"Mattermost test server updated with git commit",
-		"Enterprise Edition Image not available",
-		"CWS test server created!",
-		"Creating a SpinWick test customer web server",
-		"Spinwick CWS test server has been destroyed",
-		"CWS test server updated",
-		"Creating a new SpinWick test cloud server with CWS",
-		"Mattermost test server with CWS created",
-		"Creating a Plugin SpinWick test server",
-	}
-
-	logger.Info("Removing old Matterwick comments")
-	for _, comment := range comments {
-		if *comment.User.Login == s.Config.Username {
-			for _, message := range serverMessages {
-				if strings.Contains(*comment.Body, message) {
-					logger.Infof("Removing old comment with ID %d", *comment.ID)
-					_, err := newGithubClient(s.Config.GithubAccessToken).Issues.DeleteComment(context.Background(), pr.RepoOwner, pr.RepoName, *comment.ID)
-					if err != nil {
-						logger.WithError(err).Error("Unable to remove old MatterWick comment")
-					}
-					break
-				}
-			}
-		}
-	}
-}
-
 // isE2ELabel checks if a label is an E2E test label (desktop or mobile).
 func (s *Server) isE2ELabel(label string) bool {
 	return label == s.Config.E2ELabel ||
@@ -0,0 +1,163 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// registryWebhookSecretHeader carries the secret of registry push notifications.
+// Docker Hub cannot set headers, so the secret may also be given as the secret
+// query parameter of the webhook URL.
+const registryWebhookSecretHeader = "X-Registry-Webhook-Secret"
+
+// imagePush is an image tag pushed to the registry.
+type imagePush struct {
+	Image string
+	Tag   string
+}
+
+func (p imagePush) key() string {
+	return imageRepository(p.Image) + ":" + p.Tag
+}
+
+// imagePushes wakes the waiters of image tags when the registry reports a push.
+type imagePushes struct {
+	lock    sync.Mutex
+	waiters map[string]map[chan struct{}]bool
+}
+
+func newImagePushes() *imagePushes {
+	return &imagePushes{waiters: make(map[string]map[chan struct{}]bool)}
+}
+
+// wait registers a waiter for the image tag. The returned channel receives a
+// value for every push of the tag until the returned function is called.
+func (p *imagePushes) wait(image, tag string) (<-chan struct{}, func()) {
+	key := imagePush{Image: image, Tag: tag}.key()
+	ch := make(chan struct{}, 1)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.waiters[key] == nil {
+		p.waiters[key] = make(map[chan struct{}]bool)
+	}
+	p.waiters[key][ch] = true
+
+	return ch, func() {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		delete(p.waiters[key], ch)
+		if len(p.waiters[key]) == 0 {
+			delete(p.waiters, key)
+		}
+	}
+}
+
+// notify wakes the waiters of the pushed tag and returns how many there were.
+func (p *imagePushes) notify(push imagePush) int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	waiters := p.waiters[push.key()]
+	for ch := range waiters {
+		// A waiter that was not woken yet needs no second wake up.
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+	return len(waiters)
+}
+
+// registryPushEvent covers the push notifications of Docker Hub and of the
+// registry notification endpoint.
+type registryPushEvent struct {
+	// Docker Hub
+	PushData struct {
+		Tag string `json:"tag"`
+	} `json:"push_data"`
+	Repository struct {
+		RepoName string `json:"repo_name"`
+	} `json:"repository"`
+
+	// Registry notifications
+	Events []struct {
+		Action string `json:"action"`
+		Target struct {
+			Repository string `json:"repository"`
+			Tag        string `json:"tag"`
+		} `json:"target"`
+	} `json:"events"`
+}
+
+// parseRegistryPushes returns the image tags pushed according to a registry
+// webhook payload.
+func parseRegistryPushes(body []byte) ([]imagePush, error) {
+	var event registryPushEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, errors.Wrap(err, "failed to decode the registry event")
+	}
+
+	var pushes []imagePush
+	if event.Repository.RepoName != "" && event.PushData.Tag != "" {
+		pushes = append(pushes, imagePush{Image: event.Repository.RepoName, Tag: event.PushData.Tag})
+	}
+	for _, e := range event.Events {
+		// Pushes by digest have no tag and cannot complete a wait.
+		if e.Action != "push" || e.Target.Repository == "" || e.Target.Tag == "" {
+			continue
+		}
+		pushes = append(pushes, imagePush{Image: e.Target.Repository, Tag: e.Target.Tag})
+	}
+	return pushes, nil
+}
+
+// handleRegistryWebhook receives image push notifications and wakes the
+// SpinWicks waiting for the pushed images.
+func (s *Server) handleRegistryWebhook(w http.ResponseWriter, r *http.Request) {
+	if s.Config.RegistryWebhookSecret == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	secret := r.Header.Get(registryWebhookSecretHeader)
+	if secret == "" {
+		secret = r.URL.Query().Get("secret")
+	}
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(s.Config.RegistryWebhookSecret)) != 1 {
+		s.Logger.Error("Invalid registry webhook secret")
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		s.Logger.WithError(err).Error("Failed to read registry webhook")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	pushes, err := parseRegistryPushes(body)
+	if err != nil {
+		s.Logger.WithError(err).Error("Failed to parse registry webhook")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	for _, push := range pushes {
+		waiters := s.imagePushes.notify(push)
+		s.Logger.WithFields(logrus.Fields{
+			"image":   push.Image,
+			"tag":     push.Tag,
+			"waiters": waiters,
+		}).Debug("Received image push")
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/heroku/docker-registry-client/registry"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRegistryPushes(t *testing.T) {
+	t.Run("docker hub", func(t *testing.T) {
+		pushes, err := parseRegistryPushes([]byte(`{
+			"callback_url": "https://registry.hub.docker.com/u/mattermostdevelopment/mattermost-enterprise-edition/hook/1/",
+			"push_data": {"pusher": "mattermost", "tag": "abc1234"},
+			"repository": {"repo_name": "mattermostdevelopment/mattermost-enterprise-edition"}
+		}`))
+		require.NoError(t, err)
+		assert.Equal(t, []imagePush{{Image: "mattermostdevelopment/mattermost-enterprise-edition", Tag: "abc1234"}}, pushes)
+	})
+
+	t.Run("registry notifications", func(t *testing.T) {
+		pushes, err := parseRegistryPushes([]byte(`{"events": [
+			{"action": "push", "target": {"repository": "mattermostdevelopment/mattermost-team-edition", "tag": "abc1234"}},
+			{"action": "push", "target": {"repository": "mattermostdevelopment/mattermost-team-edition", "digest": "sha256:123"}},
+			{"action": "pull", "target": {"repository": "mattermostdevelopment/mattermost-team-edition", "tag": "def5678"}}
+		]}`))
+		require.NoError(t, err)
+		assert.Equal(t, []imagePush{{Image: "mattermostdevelopment/mattermost-team-edition", Tag: "abc1234"}}, pushes)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := parseRegistryPushes([]byte(`not json`))
+		assert.Error(t, err)
+	})
+}
+
+func TestImagePushes(t *testing.T) {
+	pushes := newImagePushes()
+
+	pushed, stop := pushes.wait("mattermostdevelopment/mattermost-enterprise-edition", "abc1234")
+	other, stopOther := pushes.wait("mattermostdevelopment/mattermost-enterprise-edition", "def5678")
+	defer stopOther()
+
+	assert.Equal(t, 1, pushes.notify(imagePush{Image: "docker.io/mattermostdevelopment/mattermost-enterprise-edition", Tag: "abc1234"}))
+	assert.Equal(t, 1, pushes.notify(imagePush{Image: "mattermostdevelopment/mattermost-enterprise-edition", Tag: "abc1234"}), "repeated pushes don't block")
+
+	select {
+	case <-pushed:
+	default:
+		t.Fatal("the waiter was not woken")
+	}
+	select {
+	case <-other:
+		t.Fatal("the waiter of another tag was woken")
+	default:
+	}
+
+	stop()
+	assert.Zero(t, pushes.notify(imagePush{Image: "mattermostdevelopment/mattermost-enterprise-edition", Tag: "abc1234"}))
+}
+
+func TestHandleRegistryWebhook(t *testing.T) {
+	body := `{"push_data": {"tag": "abc1234"}, "repository": {"repo_name": "mattermostdevelopment/mattermost-enterprise-edition"}}`
+	newServer := func(secret string) *Server {
+		return &Server{
+			Logger:      logrus.New(),
+			Config:      &MatterwickConfig{RegistryWebhookSecret: secret},
+			imagePushes: newImagePushes(),
+		}
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		s := newServer("")
+		w := httptest.NewRecorder()
+		s.handleRegistryWebhook(w, httptest.NewRequest(http.MethodPost, "/registry_webhook?secret=", strings.NewReader(body)))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("invalid secret", func(t *testing.T) {
+		s := newServer("secret")
+		pushed, stop := s.imagePushes.wait("mattermostdevelopment/mattermost-enterprise-edition", "abc1234")
+		defer stop()
+
+		w := httptest.NewRecorder()
+		s.handleRegistryWebhook(w, httptest.NewRequest(http.MethodPost, "/registry_webhook?secret=wrong", strings.NewReader(body)))
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Empty(t, pushed)
+	})
+
+	t.Run("invalid payload", func(t *testing.T) {
+		s := newServer("secret")
+		w := httptest.NewRecorder()
+		s.handleRegistryWebhook(w, httptest.NewRequest(http.MethodPost, "/registry_webhook?secret=secret", strings.NewReader("{")))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	for name, request := range map[string]*http.Request{
+		"secret query parameter": httptest.NewRequest(http.MethodPost, "/registry_webhook?secret=secret", strings.NewReader(body)),
+		"secret header": func() *http.Request {
+			r := httptest.NewRequest(http.MethodPost, "/registry_webhook", strings.NewReader(body))
+			r.Header.Set(registryWebhookSecretHeader, "secret")
+			return r
+		}(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := newServer("secret")
+			pushed, stop := s.imagePushes.wait("mattermostdevelopment/mattermost-enterprise-edition", "abc1234")
+			defer stop()
+
+			w := httptest.NewRecorder()
+			s.handleRegistryWebhook(w, request)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Len(t, pushed, 1)
+		})
+	}
+}
+
+func TestWaitForImageWakesOnPush(t *testing.T) {
+	defer func(interval time.Duration) { imagePollFallbackInterval = interval }(imagePollFallbackInterval)
+	imagePollFallbackInterval = time.Hour
+
+	var published int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v2/":
+		case r.URL.Path == "/v2/mattermostdevelopment/mattermost-enterprise-edition/manifests/abc1234" && atomic.LoadInt32(&published) == 1:
+			w.Header().Set("Docker-Content-Digest", "sha256:"+strings.Repeat("a", 64))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	reg, err := registry.New(ts.URL, "", "")
+	require.NoError(t, err)
+	reg.Logf = registry.Quiet
+
+	pushes := newImagePushes()
+	builds := &Builds{pushes: pushes}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	done := make(chan error)
+	go func() {
+		done <- builds.waitForImage(ctx, reg, "abc1234", "mattermostdevelopment/mattermost-enterprise-edition", logrus.New())
+	}()
+
+	// Notify until the waiter has registered and been woken.
+	atomic.StoreInt32(&published, 1)
+	for {
+		pushes.notify(imagePush{Image: "mattermostdevelopment/mattermost-enterprise-edition", Tag: "abc1234"})
+		select {
+		case err = <-done:
+			require.NoError(t, err)
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
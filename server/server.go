@@ -5,6 +5,7 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
@@ -30,7 +31,10 @@ type Server struct {
 
 	Builds buildsInterface
 
-	commentLock sync.Mutex
+	// statusCommentLocks serializes the updates of the status comment of each PR,
+	// keyed by owner/repo#number.
+	statusCommentLocks   map[string]*sync.Mutex
+	statusCommentLocksMu sync.Mutex
 
 	StartTime time.Time
 
@@ -42,6 +46,45 @@ type Server struct {
 	envMaps     map[string]cloudModel.EnvVarMap
 	envMapsLock sync.Mutex
 
+	// spinWickOptions holds the /spinwick create options for each SpinWick, keyed by RepeatableID.
+	spinWickOptions     map[string]spinWickOptions
+	spinWickOptionsLock sync.Mutex
+
+	// credentials holds the accounts created on each SpinWick, keyed by RepeatableID.
+	credentials     map[string]spinWickCredentials
+	credentialsLock sync.Mutex
+
+	// provisioningChecks holds the check runs of running provisioning operations,
+	// keyed by SpinWick RepeatableID or E2E key.
+	provisioningChecks     map[string]provisioningCheck
+	provisioningChecksLock sync.Mutex
+
+	// spinWickUpdates holds the in-flight updates of each SpinWick, keyed by RepeatableID.
+	spinWickUpdates     map[string][]*spinWickUpdate
+	spinWickUpdatesLock sync.Mutex
+
+	// spinWickLifecycles holds the lifecycle state and operation queue of each SpinWick, keyed by RepeatableID.
+	spinWickLifecycles     map[string]*spinWickLifecycle
+	spinWickLifecyclesLock sync.Mutex
+
+	// spinWickSnapshots holds the named snapshots of each SpinWick, keyed by RepeatableID and name.
+	// spinWickSnapshotsBusy marks SpinWicks with a snapshot or restore in progress.
+	spinWickSnapshots     map[string]map[string]spinWickSnapshot
+	spinWickSnapshotsBusy map[string]bool
+	spinWickSnapshotsLock sync.Mutex
+
+	// companionHosts maps companion PRs to the PRs whose SpinWicks include them, keyed by "org/repo#n".
+	companionHosts     map[string][]companionHost
+	companionHostsLock sync.Mutex
+
+	// spinWickVariants holds the named variants of each SpinWick, keyed by the default RepeatableID and name.
+	spinWickVariants     map[string]map[string]spinWickVariant
+	spinWickVariantsLock sync.Mutex
+
+	// pluginBundles holds the plugin bundles served to installations, keyed by a random token.
+	pluginBundles     map[string]pluginBundle
+	pluginBundlesLock sync.Mutex
+
 	// e2eInstances tracks E2E instances by key: "{repo}-pr-{n}" | "{repo}-push-{branch}-{sha}" | "{repo}-cmt-{runID}"
 	e2eInstances     map[string][]*E2EInstance
 	e2eInstancesLock sync.Mutex
@@ -55,13 +98,22 @@ type Server struct {
 	e2eInProgressLock sync.Mutex
 
 	// e2ePRCleanupGeneration is incremented on each cleanup; provisioning aborts if it advances during the ~30-min create window.
-	e2ePRCleanupGeneration     map[string]int64
+	e2ePRCleanupGeneration map[string]int64
+	// e2eProvisioningCancels cancels the provisioning of the PR+platform runs of a PR on cleanup, keyed by PR then PR+platform.
+	e2eProvisioningCancels     map[string]map[string]context.CancelFunc
 	e2ePRCleanupGenerationLock sync.Mutex
 
 	// stopCh is closed by Stop() to terminate background goroutines.
 	stopCh   chan struct{}
 	stopOnce sync.Once
 
+	// imagePushes wakes the waiters of docker images on registry push notifications.
+	imagePushes *imagePushes
+	// buildWorkflows wakes SpinWick updates waiting for their build workflow.
+	buildWorkflows *buildWorkflows
+	// provisioningQueue accounts test servers against ProvisioningQuotas.
+	provisioningQueue provisioningQueue
+
 	// githubAPIBase redirects GitHub API calls to a mock URL in tests (empty = use real GitHub).
 	githubAPIBase string
 
@@ -96,18 +148,36 @@ func New(config *MatterwickConfig) *Server {
 		Logger:                 logger.WithField("instance", cloudModel.NewID()),
 		CloudClient:            cloudClient,
 		envMaps:                make(map[string]cloudModel.EnvVarMap),
+		spinWickOptions:        make(map[string]spinWickOptions),
+		credentials:            make(map[string]spinWickCredentials),
+		provisioningChecks:     make(map[string]provisioningCheck),
+		spinWickUpdates:        make(map[string][]*spinWickUpdate),
+		spinWickLifecycles:     make(map[string]*spinWickLifecycle),
+		spinWickSnapshots:      make(map[string]map[string]spinWickSnapshot),
+		spinWickSnapshotsBusy:  make(map[string]bool),
+		companionHosts:         make(map[string][]companionHost),
+		spinWickVariants:       make(map[string]map[string]spinWickVariant),
+		pluginBundles:          make(map[string]pluginBundle),
 		e2eInstances:           make(map[string][]*E2EInstance),
 		e2eInProgress:          make(map[string]bool),
 		e2ePRCleanupGeneration: make(map[string]int64),
+		e2eProvisioningCancels: make(map[string]map[string]context.CancelFunc),
 		cmtDispatchLocks:       make(map[string]*sync.Mutex),
+		statusCommentLocks:     make(map[string]*sync.Mutex),
 		stopCh:                 make(chan struct{}),
+		imagePushes:            newImagePushes(),
+		buildWorkflows:         newBuildWorkflows(),
 	}
 
 	if !isAwsConfigDefined() {
 		s.Logger.Error("Missing environment credentials for AWS Access: AWS_SECRET_ACCESS_KEY, AWS_ACCESS_KEY_ID")
 	}
 
-	s.Builds = &Builds{}
+	builds := &Builds{}
+	if config.RegistryWebhookSecret != "" {
+		builds.pushes = s.imagePushes
+	}
+	s.Builds = builds
 	if os.Getenv(buildOverride) != "" {
 		s.Logger.Warn("Using mocked build tools")
 		s.Builds = &MockedBuilds{
@@ -124,6 +194,11 @@ func New(config *MatterwickConfig) *Server {
 func (s *Server) Start() {
 	s.Logger.Info("Starting MatterWick Server")
 
+	// The usage is loaded first so the cleanup below releases the installations it reaps.
+	s.loadProvisioningUsage()
+	s.loadSpinWickSnapshots()
+	s.loadCompanionHosts()
+
 	// Clean up stale instances from any previous run immediately, then scan periodically.
 	s.cleanupStaleE2EInstances()
 	go func() {
@@ -167,7 +242,9 @@ func (s *Server) initializeRouter() {
 	s.Router.HandleFunc("/", s.ping).Methods(http.MethodGet)
 	s.Router.HandleFunc("/github_event", s.githubEvent).Methods(http.MethodPost)
 	s.Router.HandleFunc("/cloud_webhooks", s.handleCloudWebhook).Methods(http.MethodPost)
+	s.Router.HandleFunc("/registry_webhook", s.handleRegistryWebhook).Methods(http.MethodPost)
 	s.Router.HandleFunc("/shrug_wick", s.serveShrugWick).Methods(http.MethodGet)
+	s.Router.HandleFunc("/plugin_bundles/{token}/{filename}", s.servePluginBundleHandler).Methods(http.MethodGet)
 }
 
 func (s *Server) ping(w http.ResponseWriter, r *http.Request) {
@@ -20,17 +20,39 @@ const (
 )
 
 type (
-	spinWickCreateHandlerFn       func(envMap cloudModel.EnvVarMap, size string)
-	spinWickUpdateHandlerFn       func(envMap cloudModel.EnvVarMap)
-	spinWickDeleteHandlerFn       func()
+	spinWickCreateHandlerFn       func(args spinWickSlashCommandArgs)
+	spinWickUpdateHandlerFn       func(args spinWickSlashCommandArgs)
+	spinWickDeleteHandlerFn       func(name string)
+	spinWickSnapshotHandlerFn     func(name, variant string)
+	spinWickFlagsListHandlerFn    func(name string)
+	spinWickConfigHandlerFn       func(args spinWickConfigArgs)
+	spinWickCredentialsHandlerFn  func(name string)
 	spinWickSlashCommandsHandlers struct {
-		createHandler spinWickCreateHandlerFn
-		updateHandler spinWickUpdateHandlerFn
-		deleteHandler spinWickDeleteHandlerFn
+		createHandler      spinWickCreateHandlerFn
+		updateHandler      spinWickUpdateHandlerFn
+		deleteHandler      spinWickDeleteHandlerFn
+		snapshotHandler    spinWickSnapshotHandlerFn
+		restoreHandler     spinWickSnapshotHandlerFn
+		flagsHandler       spinWickFlagsListHandlerFn
+		configHandler      spinWickConfigHandlerFn
+		credentialsHandler spinWickCredentialsHandlerFn
 	}
 	spinWickSlashCommandArgs struct {
-		envMap cloudModel.EnvVarMap
-		size   string
+		// name is the SpinWick variant, empty for the default SpinWick.
+		name        string
+		envMap      cloudModel.EnvVarMap
+		size        string
+		database    string
+		filestore   string
+		sampleData  string
+		users       []SpinWickUser
+		upgradeFrom string
+		companions  []companionPR
+		plugins     []extraPlugin
+		// serverVersion is the server version of plugin SpinWicks.
+		serverVersion string
+		// license is the license profile, "none", or empty for the default.
+		license string
 	}
 )
 
@@ -64,33 +86,76 @@ func (s *Server) handleSlashCommand(cmd string, ev *github.IssueCommentEvent) {
 	}
 
 	spinWickHandlers := spinWickSlashCommandsHandlers{
-		createHandler: func(envMap cloudModel.EnvVarMap, size string) {
-			spinwick := model.NewSpinwick(pr.RepoName, pr.Number, s.Config.DNSNameTestServer)
-			s.envMapsLock.Lock()
-			s.envMaps[spinwick.RepeatableID] = envMap
-			s.envMapsLock.Unlock()
+		createHandler: func(args spinWickSlashCommandArgs) {
+			spinwick := model.NewSpinwickVariant(pr.RepoName, pr.Number, args.name, s.Config.DNSNameTestServer)
+			s.setVariantEnvAndOptions(spinwick.RepeatableID, args.envMap, spinWickOptions{
+				database:      args.database,
+				filestore:     args.filestore,
+				sampleData:    args.sampleData,
+				users:         args.users,
+				upgradeFrom:   args.upgradeFrom,
+				companions:    args.companions,
+				plugins:       args.plugins,
+				serverVersion: args.serverVersion,
+				license:       args.license,
+			})
+
+			// Named variants are not backed by a label.
+			if args.name != "" {
+				s.handleCreateSpinWickVariant(pr, args.name, args.size, args.envMap)
+				return
+			}
 
 			label := s.Config.SetupSpinWick
-			if size == "miniHA" {
+			if args.size == "miniHA" {
 				label = s.Config.SetupSpinWickHA
 			}
 			s.addLabel(pr.RepoOwner, pr.RepoName, pr.Number, label)
 		},
-		updateHandler: func(envMap cloudModel.EnvVarMap) {
-			spinwick := model.NewSpinwick(pr.RepoName, pr.Number, s.Config.DNSNameTestServer)
+		updateHandler: func(args spinWickSlashCommandArgs) {
+			spinwick := model.NewSpinwickVariant(pr.RepoName, pr.Number, args.name, s.Config.DNSNameTestServer)
 			s.envMapsLock.Lock()
-			s.envMaps[spinwick.RepeatableID] = envMap
+			s.envMaps[spinwick.RepeatableID] = args.envMap
 			s.envMapsLock.Unlock()
+			if args.license != "" {
+				s.setSpinWickLicense(spinwick.RepeatableID, args.license)
+			}
 
+			if args.name != "" {
+				s.handleUpdateSpinWickVariant(pr, args.name, true)
+				return
+			}
 			s.handleSynchronizeSpinwick(pr, spinwick.RepeatableID, true)
 		},
-		deleteHandler: func() {
+		deleteHandler: func(name string) {
+			if name != "" {
+				s.handleDestroySpinWickVariant(pr, name)
+				return
+			}
 			for _, label := range pr.Labels {
 				if s.isSpinWickLabel(label) {
 					s.removeLabel(pr.RepoOwner, pr.RepoName, pr.Number, label)
 				}
 			}
 		},
+		snapshotHandler: func(name, variant string) {
+			s.handleSnapshotSpinWick(pr, name, variant)
+		},
+		restoreHandler: func(name, variant string) {
+			s.handleRestoreSpinWick(pr, name, variant)
+		},
+		flagsHandler: func(name string) {
+			s.handleListSpinWickFlags(pr, name)
+		},
+		credentialsHandler: func(name string) {
+			s.handleResetSpinWickCredentials(pr, name)
+		},
+	}
+	spinWickHandlers.configHandler = func(args spinWickConfigArgs) {
+		// Env-locked settings go through the regular update path.
+		s.handleSetSpinWickConfig(pr, args, func(envMap cloudModel.EnvVarMap) {
+			spinWickHandlers.updateHandler(spinWickSlashCommandArgs{name: args.name, envMap: envMap})
+		})
 	}
 
 	switch args[0] {
@@ -121,11 +186,31 @@ func (s *Server) parseSpinwickSlashCommandArgs(args []string, isUpdate bool) (sp
 	var env string
 	var clearEnv string
 	var size string
+	var database string
+	var filestore string
+	var sampleData string
+	var users string
+	var upgradeFrom string
+	var with string
+	var plugins string
+	var serverVersion string
+	var license string
+	var name string
+	flagset.StringVar(&name, "name", "", "An optional name of a SpinWick variant, to run several SpinWicks for the PR side by side e.g. 'ha'")
+	flagset.StringVar(&license, "license", "", "An optional license profile e.g. 'enterprise', or 'none' for an unlicensed server")
 	flagset.StringVar(&env, "env", "", "An optional comma-separated list of environment variables. Example: VAR1=VAl1,VAR2=VAL2")
 	if isUpdate {
 		flagset.StringVar(&clearEnv, "clear-env", "", "An optional comma-separated list of environment variables to clear. Example: VAR1,VAR2")
 	} else {
 		flagset.StringVar(&size, "size", "miniSingleton", "Size of the Mattermost installation e.g. 'miniSingleton' or 'miniHA'")
+		flagset.StringVar(&database, "database", "", "An optional provisioner database type e.g. 'aws-multitenant-rds' or 'aws-rds-postgres'")
+		flagset.StringVar(&filestore, "filestore", "", "An optional provisioner filestore type e.g. 'aws-s3' or 'bifrost'")
+		flagset.StringVar(&sampleData, "sample-data", "", "An optional sample data profile to seed after creation e.g. 'small' or 'medium'")
+		flagset.StringVar(&users, "users", "", "An optional comma-separated roster of additional users with roles (system_admin, team_admin, member, guest, bot, deactivated). Example: admin2:system_admin,guest1:guest")
+		flagset.StringVar(&upgradeFrom, "upgrade-from", "", "An optional release to create the installation with before upgrading it to the PR build e.g. '10.11.0' or 'release-10.11'")
+		flagset.StringVar(&with, "with", "", "An optional comma-separated list of companion PRs whose builds are added to the installation. Example: mattermost/mattermost-plugin-jira#123")
+		flagset.StringVar(&plugins, "plugins", "", "An optional comma-separated list of additional plugins from the marketplace or a release URL. Example: focalboard@7.10.0,com.mattermost.calls,playbooks@https://example.com/playbooks.tar.gz")
+		flagset.StringVar(&serverVersion, "server-version", "", "An optional server version for plugin SpinWicks instead of one matching the plugin's min_server_version e.g. '10.11.0', 'release-10.11' or 'master'")
 	}
 
 	err := flagset.Parse(args)
@@ -137,6 +222,23 @@ func (s *Server) parseSpinwickSlashCommandArgs(args []string, isUpdate bool) (sp
 
 	s.Logger.WithField("env", env).Info("parsed env vars")
 
+	if err = validateVariantName(name); err != nil {
+		return parsedArgs, err.Error(), fmt.Errorf("failed to parse name: %w", err)
+	}
+
+	if err = validateSpinWickDatabase(database); err != nil {
+		return parsedArgs, err.Error(), fmt.Errorf("failed to parse database: %w", err)
+	}
+	if err = validateSpinWickFilestore(filestore); err != nil {
+		return parsedArgs, err.Error(), fmt.Errorf("failed to parse filestore: %w", err)
+	}
+	if sampleData != "" {
+		if _, ok := s.Config.SampleDataProfiles[sampleData]; !ok {
+			err = fmt.Errorf("unknown sample data profile %q", sampleData)
+			return parsedArgs, err.Error(), fmt.Errorf("failed to parse sample data: %w", err)
+		}
+	}
+
 	envMap := make(cloudModel.EnvVarMap)
 	if env != "" {
 		envMap, err = parseEnvArg(env)
@@ -152,18 +254,84 @@ func (s *Server) parseSpinwickSlashCommandArgs(args []string, isUpdate bool) (sp
 		}
 	}
 
+	if err = s.validateLicenseProfile(license); err != nil {
+		return parsedArgs, err.Error(), fmt.Errorf("failed to parse license: %w", err)
+	}
+	if err = validatePluginServerVersion(serverVersion); err != nil {
+		return parsedArgs, err.Error(), fmt.Errorf("failed to parse server version: %w", err)
+	}
+	if err = validateUpgradeFrom(upgradeFrom); err != nil {
+		return parsedArgs, err.Error(), fmt.Errorf("failed to parse upgrade version: %w", err)
+	}
+	if users != "" {
+		parsedArgs.users, err = parseUsersArg(users)
+		if err != nil {
+			return parsedArgs, err.Error(), fmt.Errorf("failed to parse users: %w", err)
+		}
+	}
+
+	if with != "" {
+		parsedArgs.companions, err = parseCompanionsArg(with)
+		if err != nil {
+			return parsedArgs, err.Error(), fmt.Errorf("failed to parse companion PRs: %w", err)
+		}
+	}
+
+	if plugins != "" {
+		parsedArgs.plugins, err = parseExtraPluginsArg(plugins)
+		if err != nil {
+			return parsedArgs, err.Error(), fmt.Errorf("failed to parse plugins: %w", err)
+		}
+	}
+
+	parsedArgs.name = name
 	parsedArgs.envMap = envMap
 	parsedArgs.size = size
+	parsedArgs.database = database
+	parsedArgs.filestore = filestore
+	parsedArgs.sampleData = sampleData
+	parsedArgs.upgradeFrom = upgradeFrom
+	parsedArgs.serverVersion = serverVersion
+	parsedArgs.license = license
 
 	return parsedArgs, "", nil
 }
 
+// parseSpinwickDeleteArgs parses the arguments of /spinwick delete and returns
+// the variant to delete, empty for the default SpinWick.
+func parseSpinwickDeleteArgs(args []string) (string, string, error) {
+	var outBuf bytes.Buffer
+	flagset := flag.NewFlagSet("spinwick", flag.ContinueOnError)
+	flagset.SetOutput(&outBuf)
+
+	var name string
+	flagset.StringVar(&name, "name", "", "An optional name of the SpinWick variant to delete")
+
+	err := flagset.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return "", outBuf.String(), err
+	} else if err != nil {
+		return "", outBuf.String(), fmt.Errorf("failed to parse args: %w", err)
+	}
+
+	if err = validateVariantName(name); err != nil {
+		return "", err.Error(), fmt.Errorf("failed to parse name: %w", err)
+	}
+
+	return name, "", nil
+}
+
 var spinwickSlashCommandUsageString = `Usage: /spinwick <command> [args]
 
 Available commands:
   create  Create a new Mattermost spinwick installation
   update  Update the existing Mattermost spinwick installation
   delete  Delete the existing Mattermost spinwick installation
+  snapshot <name> [--name <variant>]  Snapshot the database of the existing spinwick installation
+  restore <name> [--name <variant>]   Restore the existing spinwick installation from a snapshot
+  flags <list|set|unset>  Manage the feature flags of the existing spinwick installation
+  config set       Change server config settings of the existing spinwick installation without a restart
+  credentials reset  Reset the passwords of the existing spinwick installation and post them again
 `
 
 func (s *Server) handleSpinWickSlashCommand(args []string, handlers spinWickSlashCommandsHandlers) (string, error) {
@@ -186,11 +354,21 @@ func (s *Server) handleSpinWickSlashCommand(args []string, handlers spinWickSlas
 		}
 
 		s.Logger.WithFields(logrus.Fields{
-			"envMap": parsedArgs.envMap,
-			"size":   parsedArgs.size,
+			"name":          parsedArgs.name,
+			"envMap":        parsedArgs.envMap,
+			"size":          parsedArgs.size,
+			"database":      parsedArgs.database,
+			"filestore":     parsedArgs.filestore,
+			"sampleData":    parsedArgs.sampleData,
+			"users":         parsedArgs.users,
+			"upgradeFrom":   parsedArgs.upgradeFrom,
+			"companions":    parsedArgs.companions,
+			"plugins":       parsedArgs.plugins,
+			"serverVersion": parsedArgs.serverVersion,
+			"license":       parsedArgs.license,
 		}).Info("going to create spinwick")
 
-		handlers.createHandler(parsedArgs.envMap, parsedArgs.size)
+		handlers.createHandler(parsedArgs)
 	case "update":
 		s.Logger.WithField("args", args).Info("handling spinwick update command")
 
@@ -204,20 +382,95 @@ func (s *Server) handleSpinWickSlashCommand(args []string, handlers spinWickSlas
 		}
 
 		s.Logger.WithFields(logrus.Fields{
-			"envMap": parsedArgs.envMap,
+			"name":    parsedArgs.name,
+			"envMap":  parsedArgs.envMap,
+			"license": parsedArgs.license,
 		}).Info("going to update spinwick")
 
-		handlers.updateHandler(parsedArgs.envMap)
+		handlers.updateHandler(parsedArgs)
 	case "delete":
 		s.Logger.WithField("args", args).Info("handling spinwick delete command")
 
+		name, output, err := parseSpinwickDeleteArgs(args[1:])
+		if err != nil {
+			return output, fmt.Errorf("failed to parse spinwick command args: %w", err)
+		}
+
 		if handlers.deleteHandler == nil {
 			return "", fmt.Errorf("nil handler")
 		}
 
-		s.Logger.Info("going to delete spinwick")
+		s.Logger.WithField("name", name).Info("going to delete spinwick")
 
-		handlers.deleteHandler()
+		handlers.deleteHandler(name)
+	case "snapshot", "restore":
+		s.Logger.WithField("args", args).Infof("handling spinwick %s command", args[0])
+
+		name, variant, err := parseSnapshotArgs(args[1:])
+		if err != nil {
+			return err.Error(), fmt.Errorf("failed to parse spinwick %s args: %w", args[0], err)
+		}
+
+		handler := handlers.snapshotHandler
+		if args[0] == "restore" {
+			handler = handlers.restoreHandler
+		}
+		if handler == nil {
+			return "", fmt.Errorf("nil handler")
+		}
+
+		s.Logger.WithFields(logrus.Fields{"snapshot": name, "name": variant}).Infof("going to %s spinwick", args[0])
+
+		handler(name, variant)
+	case "flags":
+		s.Logger.WithField("args", args).Info("handling spinwick flags command")
+
+		flagsArgs, output, err := s.parseSpinwickFlagsArgs(args[1:])
+		if err != nil {
+			return output, fmt.Errorf("failed to parse spinwick flags args: %w", err)
+		}
+
+		if flagsArgs.command == "list" {
+			if handlers.flagsHandler == nil {
+				return "", fmt.Errorf("nil handler")
+			}
+			handlers.flagsHandler(flagsArgs.name)
+			return "", nil
+		}
+
+		if handlers.updateHandler == nil {
+			return "", fmt.Errorf("nil handler")
+		}
+
+		s.Logger.WithFields(logrus.Fields{
+			"name":   flagsArgs.name,
+			"envMap": flagsArgs.envMap,
+		}).Infof("going to %s spinwick feature flags", flagsArgs.command)
+
+		// Feature flags are environment variables, applied like /spinwick update --env.
+		handlers.updateHandler(spinWickSlashCommandArgs{name: flagsArgs.name, envMap: flagsArgs.envMap})
+	case "config":
+		s.Logger.WithField("args", args).Info("handling spinwick config command")
+
+		configArgs, output, err := parseSpinwickConfigArgs(args[1:])
+		if err != nil {
+			return output, fmt.Errorf("failed to parse spinwick config args: %w", err)
+		}
+		if handlers.configHandler == nil {
+			return "", fmt.Errorf("nil handler")
+		}
+		handlers.configHandler(configArgs)
+	case "credentials":
+		s.Logger.WithField("args", args).Info("handling spinwick credentials command")
+
+		name, output, err := parseSpinwickCredentialsArgs(args[1:])
+		if err != nil {
+			return output, fmt.Errorf("failed to parse spinwick credentials args: %w", err)
+		}
+		if handlers.credentialsHandler == nil {
+			return "", fmt.Errorf("nil handler")
+		}
+		handlers.credentialsHandler(name)
 	default:
 		return spinwickSlashCommandUsageString, fmt.Errorf("invalid command %q", args[0])
 	}
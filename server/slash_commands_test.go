@@ -110,16 +110,16 @@ func TestHandleSpinWickSlashCommand(t *testing.T) {
 			var deleteCalled bool
 
 			handlers := spinWickSlashCommandsHandlers{
-				createHandler: func(envMap cloudModel.EnvVarMap, size string) {
+				createHandler: func(args spinWickSlashCommandArgs) {
 					createCalled = true
-					createEnv = envMap
-					createSize = size
+					createEnv = args.envMap
+					createSize = args.size
 				},
-				updateHandler: func(envMap cloudModel.EnvVarMap) {
+				updateHandler: func(args spinWickSlashCommandArgs) {
 					updateCalled = true
-					updateEnv = envMap
+					updateEnv = args.envMap
 				},
-				deleteHandler: func() {
+				deleteHandler: func(name string) {
 					deleteCalled = true
 				},
 			}
@@ -139,3 +139,277 @@ func TestHandleSpinWickSlashCommand(t *testing.T) {
 		})
 	}
 }
+
+func TestHandleSpinWickVariantSlashCommands(t *testing.T) {
+	s := &Server{Logger: logrus.New()}
+
+	var createName, updateName, deleteName string
+	handlers := spinWickSlashCommandsHandlers{
+		createHandler: func(args spinWickSlashCommandArgs) { createName = args.name },
+		updateHandler: func(args spinWickSlashCommandArgs) { updateName = args.name },
+		deleteHandler: func(name string) { deleteName = name },
+	}
+
+	_, err := s.handleSpinWickSlashCommand([]string{"create", "--name", "ha", "--size", "miniHA"}, handlers)
+	require.NoError(t, err)
+	assert.Equal(t, "ha", createName)
+
+	_, err = s.handleSpinWickSlashCommand([]string{"update", "--name", "ha", "--env", "A=1"}, handlers)
+	require.NoError(t, err)
+	assert.Equal(t, "ha", updateName)
+
+	_, err = s.handleSpinWickSlashCommand([]string{"delete", "--name", "ha"}, handlers)
+	require.NoError(t, err)
+	assert.Equal(t, "ha", deleteName)
+
+	output, err := s.handleSpinWickSlashCommand([]string{"delete", "--name", "Not_Valid"}, handlers)
+	require.Error(t, err)
+	assert.Equal(t, `invalid variant name "Not_Valid", use up to 12 lowercase letters, digits or '-'`, output)
+
+	_, err = s.handleSpinWickSlashCommand([]string{"create", "--name", "much-too-long-name"}, handlers)
+	require.Error(t, err)
+}
+
+func TestHandleSpinWickSnapshotSlashCommands(t *testing.T) {
+	s := &Server{Logger: logrus.New()}
+
+	var snapshotName, restoreName, restoreVariant string
+	handlers := spinWickSlashCommandsHandlers{
+		snapshotHandler: func(name, variant string) { snapshotName = name },
+		restoreHandler:  func(name, variant string) { restoreName, restoreVariant = name, variant },
+	}
+
+	output, err := s.handleSpinWickSlashCommand([]string{"snapshot", "before-upgrade"}, handlers)
+	require.NoError(t, err)
+	assert.Empty(t, output)
+	assert.Equal(t, "before-upgrade", snapshotName)
+	assert.Empty(t, restoreName)
+
+	output, err = s.handleSpinWickSlashCommand([]string{"restore", "before-upgrade"}, handlers)
+	require.NoError(t, err)
+	assert.Empty(t, output)
+	assert.Equal(t, "before-upgrade", restoreName)
+	assert.Empty(t, restoreVariant)
+
+	_, err = s.handleSpinWickSlashCommand([]string{"restore", "before-upgrade", "--name", "ha"}, handlers)
+	require.NoError(t, err)
+	assert.Equal(t, "ha", restoreVariant)
+
+	output, err = s.handleSpinWickSlashCommand([]string{"restore"}, handlers)
+	require.Error(t, err)
+	assert.Equal(t, "expected exactly one snapshot name", output)
+}
+
+func TestHandleSpinWickFlagsSlashCommands(t *testing.T) {
+	s := newFeatureFlagsTestServer()
+
+	var listName string
+	var updateArgs *spinWickSlashCommandArgs
+	handlers := spinWickSlashCommandsHandlers{
+		updateHandler: func(args spinWickSlashCommandArgs) { updateArgs = &args },
+		flagsHandler:  func(name string) { listName = name },
+	}
+
+	_, err := s.handleSpinWickSlashCommand([]string{"flags", "list", "--name", "ha"}, handlers)
+	require.NoError(t, err)
+	assert.Equal(t, "ha", listName)
+	assert.Nil(t, updateArgs)
+
+	_, err = s.handleSpinWickSlashCommand([]string{"flags", "set", "ChannelBookmarks"}, handlers)
+	require.NoError(t, err)
+	require.NotNil(t, updateArgs)
+	assert.Equal(t, cloudModel.EnvVarMap{"MM_FEATUREFLAGS_CHANNELBOOKMARKS": {Value: "true"}}, updateArgs.envMap)
+
+	_, err = s.handleSpinWickSlashCommand([]string{"flags", "unset", "--name", "ha", "ChannelBookmarks"}, handlers)
+	require.NoError(t, err)
+	assert.Equal(t, "ha", updateArgs.name)
+	assert.Equal(t, cloudModel.EnvVarMap{"MM_FEATUREFLAGS_CHANNELBOOKMARKS": {}}, updateArgs.envMap)
+
+	output, err := s.handleSpinWickSlashCommand([]string{"flags"}, handlers)
+	require.Error(t, err)
+	assert.Equal(t, spinwickFlagsUsageString, output)
+}
+
+func TestHandleSpinWickConfigSlashCommands(t *testing.T) {
+	s := &Server{Logger: logrus.New()}
+
+	var configArgs *spinWickConfigArgs
+	handlers := spinWickSlashCommandsHandlers{
+		configHandler: func(args spinWickConfigArgs) { configArgs = &args },
+	}
+
+	_, err := s.handleSpinWickSlashCommand([]string{"config", "set", "--name", "ha", "LogSettings.ConsoleLevel=DEBUG"}, handlers)
+	require.NoError(t, err)
+	require.NotNil(t, configArgs)
+	assert.Equal(t, "ha", configArgs.name)
+	assert.Equal(t, []configSetting{{Path: "LogSettings.ConsoleLevel", Value: "DEBUG"}}, configArgs.settings)
+
+	output, err := s.handleSpinWickSlashCommand([]string{"config"}, handlers)
+	require.Error(t, err)
+	assert.Equal(t, spinwickConfigUsageString, output)
+}
+
+func TestHandleSpinWickCredentialsSlashCommands(t *testing.T) {
+	s := &Server{Logger: logrus.New()}
+
+	resetName := "unset"
+	handlers := spinWickSlashCommandsHandlers{
+		credentialsHandler: func(name string) { resetName = name },
+	}
+
+	_, err := s.handleSpinWickSlashCommand([]string{"credentials", "reset", "--name", "ha"}, handlers)
+	require.NoError(t, err)
+	assert.Equal(t, "ha", resetName)
+
+	output, err := s.handleSpinWickSlashCommand([]string{"credentials"}, handlers)
+	require.Error(t, err)
+	assert.Equal(t, spinwickCredentialsUsageString, output)
+}
+
+func TestParseSpinwickSlashCommandArgsBackends(t *testing.T) {
+	s := &Server{
+		Logger: logrus.New(),
+	}
+
+	t.Run("database and filestore", func(t *testing.T) {
+		parsedArgs, _, err := s.parseSpinwickSlashCommandArgs([]string{"--database", "aws-multitenant-rds", "--filestore", "aws-s3"}, false)
+		require.NoError(t, err)
+		assert.Equal(t, cloudModel.InstallationDatabaseMultiTenantRDSMySQL, parsedArgs.database)
+		assert.Equal(t, cloudModel.InstallationFilestoreAwsS3, parsedArgs.filestore)
+	})
+
+	t.Run("defaults are empty", func(t *testing.T) {
+		parsedArgs, _, err := s.parseSpinwickSlashCommandArgs([]string{}, false)
+		require.NoError(t, err)
+		assert.Empty(t, parsedArgs.database)
+		assert.Empty(t, parsedArgs.filestore)
+	})
+
+	t.Run("unsupported database", func(t *testing.T) {
+		_, output, err := s.parseSpinwickSlashCommandArgs([]string{"--database", "sqlite"}, false)
+		require.Error(t, err)
+		assert.Equal(t, `unsupported database "sqlite"`, output)
+	})
+
+	t.Run("unsupported filestore", func(t *testing.T) {
+		_, output, err := s.parseSpinwickSlashCommandArgs([]string{"--filestore", "local"}, false)
+		require.Error(t, err)
+		assert.Equal(t, `unsupported filestore "local"`, output)
+	})
+
+	t.Run("not available on update", func(t *testing.T) {
+		_, _, err := s.parseSpinwickSlashCommandArgs([]string{"--database", "aws-rds"}, true)
+		require.Error(t, err)
+	})
+
+	t.Run("companion PRs", func(t *testing.T) {
+		parsedArgs, _, err := s.parseSpinwickSlashCommandArgs([]string{"--with", "mattermost/mattermost-plugin-jira#12"}, false)
+		require.NoError(t, err)
+		assert.Equal(t, []companionPR{{Owner: "mattermost", Repo: "mattermost-plugin-jira", Number: 12}}, parsedArgs.companions)
+
+		_, output, err := s.parseSpinwickSlashCommandArgs([]string{"--with", "mattermost-plugin-jira#12"}, false)
+		require.Error(t, err)
+		assert.Equal(t, `invalid companion PR "mattermost-plugin-jira#12", expected org/repo#N`, output)
+	})
+
+	t.Run("extra plugins", func(t *testing.T) {
+		parsedArgs, _, err := s.parseSpinwickSlashCommandArgs([]string{"--plugins", "focalboard@7.10.0"}, false)
+		require.NoError(t, err)
+		assert.Equal(t, []extraPlugin{{ID: "focalboard", Version: "7.10.0"}}, parsedArgs.plugins)
+
+		_, output, err := s.parseSpinwickSlashCommandArgs([]string{"--plugins", "focalboard@next"}, false)
+		require.Error(t, err)
+		assert.Equal(t, `invalid version "next" for plugin "focalboard"`, output)
+	})
+
+	t.Run("plugin server version", func(t *testing.T) {
+		parsedArgs, _, err := s.parseSpinwickSlashCommandArgs([]string{"--server-version", "release-10.11"}, false)
+		require.NoError(t, err)
+		assert.Equal(t, "release-10.11", parsedArgs.serverVersion)
+
+		_, output, err := s.parseSpinwickSlashCommandArgs([]string{"--server-version", "main"}, false)
+		require.Error(t, err)
+		assert.Equal(t, `invalid server version "main", expected e.g. 10.11.0, release-10.11 or master`, output)
+	})
+}
+
+func TestParseSpinwickSlashCommandArgsSampleData(t *testing.T) {
+	s := &Server{
+		Logger: logrus.New(),
+		Config: &MatterwickConfig{
+			SampleDataProfiles: map[string]SampleDataProfile{
+				"small": {Teams: 1},
+			},
+		},
+	}
+
+	parsedArgs, _, err := s.parseSpinwickSlashCommandArgs([]string{"--sample-data", "small"}, false)
+	require.NoError(t, err)
+	assert.Equal(t, "small", parsedArgs.sampleData)
+
+	_, output, err := s.parseSpinwickSlashCommandArgs([]string{"--sample-data", "huge"}, false)
+	require.Error(t, err)
+	assert.Equal(t, `unknown sample data profile "huge"`, output)
+}
+
+func TestParseSpinwickSlashCommandArgsLicense(t *testing.T) {
+	s := newLicenseTestServer()
+
+	for _, isUpdate := range []bool{false, true} {
+		parsedArgs, _, err := s.parseSpinwickSlashCommandArgs([]string{"--license", "enterprise"}, isUpdate)
+		require.NoError(t, err)
+		assert.Equal(t, "enterprise", parsedArgs.license)
+
+		parsedArgs, _, err = s.parseSpinwickSlashCommandArgs([]string{"--license", "none"}, isUpdate)
+		require.NoError(t, err)
+		assert.Equal(t, "none", parsedArgs.license)
+	}
+
+	_, output, err := s.parseSpinwickSlashCommandArgs([]string{"--license", "advanced"}, false)
+	require.Error(t, err)
+	assert.Equal(t, `unknown license profile "advanced", use one of enterprise, professional, none`, output)
+}
+
+func TestGetSpinWickOptions(t *testing.T) {
+	s := &Server{
+		Logger: logrus.New(),
+		Config: &MatterwickConfig{
+			SpinWickRepoDefaults: map[string]SpinWickRepoDefaults{
+				"mattermost": {Database: cloudModel.InstallationDatabaseMultiTenantRDSMySQL, Plugins: []string{"focalboard@7.10.0"}},
+				"broken":     {Database: "nope", Filestore: "nope", Plugins: []string{"not a plugin"}},
+			},
+		},
+		spinWickOptions: make(map[string]spinWickOptions),
+	}
+
+	t.Run("provisioner defaults", func(t *testing.T) {
+		opts := s.getSpinWickOptions("other", "other-pr-1")
+		assert.Equal(t, defaultSpinWickDatabase, opts.database)
+		assert.Equal(t, defaultSpinWickFilestore, opts.filestore)
+	})
+
+	t.Run("repo defaults", func(t *testing.T) {
+		opts := s.getSpinWickOptions("mattermost", "mattermost-pr-1")
+		assert.Equal(t, cloudModel.InstallationDatabaseMultiTenantRDSMySQL, opts.database)
+		assert.Equal(t, defaultSpinWickFilestore, opts.filestore)
+		assert.Equal(t, []extraPlugin{{ID: "focalboard", Version: "7.10.0"}}, opts.plugins)
+	})
+
+	t.Run("invalid repo defaults are ignored", func(t *testing.T) {
+		opts := s.getSpinWickOptions("broken", "broken-pr-1")
+		assert.Equal(t, defaultSpinWickDatabase, opts.database)
+		assert.Equal(t, defaultSpinWickFilestore, opts.filestore)
+		assert.Nil(t, opts.plugins)
+	})
+
+	t.Run("slash command options win", func(t *testing.T) {
+		s.setSpinWickOptions("mattermost-pr-2", spinWickOptions{filestore: cloudModel.InstallationFilestoreAwsS3})
+		opts := s.getSpinWickOptions("mattermost", "mattermost-pr-2")
+		assert.Equal(t, cloudModel.InstallationDatabaseMultiTenantRDSMySQL, opts.database)
+		assert.Equal(t, cloudModel.InstallationFilestoreAwsS3, opts.filestore)
+
+		s.deleteSpinWickOptions("mattermost-pr-2")
+		opts = s.getSpinWickOptions("mattermost", "mattermost-pr-2")
+		assert.Equal(t, defaultSpinWickFilestore, opts.filestore)
+	})
+}
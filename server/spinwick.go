@@ -19,7 +19,6 @@ import (
 	"text/template"
 	"time"
 
-	"github.com/google/go-github/v32/github"
 	cloudModel "github.com/mattermost/mattermost-cloud/model"
 	mattermostModel "github.com/mattermost/mattermost-server/v6/model"
 	"github.com/mattermost/matterwick/internal/cloudtools"
@@ -66,21 +65,22 @@ func (s *Server) checkExistingInstallation(ownerID string, logger logrus.FieldLo
 }
 
 // Helper function to create installation request with common settings
-func (s *Server) createInstallationRequest(ownerID, version, image, dns, size string, withLicense bool, envVars cloudModel.EnvVarMap) *cloudModel.CreateInstallationRequest {
+func (s *Server) createInstallationRequest(ownerID, version, image, dns, size, license string, envVars cloudModel.EnvVarMap, opts spinWickOptions) *cloudModel.CreateInstallationRequest {
+	affinity, annotations := opts.affinity()
 	installationRequest := &cloudModel.CreateInstallationRequest{
 		OwnerID:     ownerID,
 		Version:     version,
 		Image:       image,
 		DNS:         dns,
 		Size:        size,
-		Affinity:    cloudModel.InstallationAffinityMultiTenant,
-		Database:    cloudModel.InstallationDatabaseMultiTenantRDSPostgresPGBouncer,
-		Filestore:   cloudModel.InstallationFilestoreBifrost,
-		Annotations: []string{defaultMultiTenantAnnotation},
+		Affinity:    affinity,
+		Database:    opts.database,
+		Filestore:   opts.filestore,
+		Annotations: annotations,
 	}
 
-	if withLicense {
-		installationRequest.License = s.Config.SpinWickHALicense
+	if license != "" {
+		installationRequest.License = license
 	}
 	if len(envVars) > 0 {
 		installationRequest.PriorityEnv = envVars
@@ -93,7 +93,8 @@ func (s *Server) createInstallationRequest(ownerID, version, image, dns, size st
 }
 
 // Helper function to wait for installation and initialize it
-func (s *Server) waitAndInitializeInstallation(ctx context.Context, pr *model.PullRequest, request *spinwick.Request, installation *cloudModel.InstallationDTO, logger logrus.FieldLogger) (string, string, error) {
+func (s *Server) waitAndInitializeInstallation(ctx context.Context, pr *model.PullRequest, request *spinwick.Request, installation *cloudModel.InstallationDTO, opts spinWickOptions, logger logrus.FieldLogger) (spinWickCredentials, error) {
+	s.setProvisioningStage(installation.OwnerID, provisioningStageCreating)
 	if os.Getenv("MATTERWICK_LOCAL_TESTING") == "true" {
 		s.waitForInstallationStablePoll(ctx, pr, request, logger)
 	} else {
@@ -101,16 +102,38 @@ func (s *Server) waitAndInitializeInstallation(ctx context.Context, pr *model.Pu
 	}
 
 	if request.Error != nil {
-		return "", "", errors.Wrap(request.Error, "error waiting for installation to become stable")
+		return nil, errors.Wrap(request.Error, "error waiting for installation to become stable")
 	}
 
 	spinwickURL := fmt.Sprintf("https://%s", cloudtools.GetInstallationDNSFromDNSRecords(installation))
-	sysadminPassword, userPassword, err := s.initializeMattermostTestServer(spinwickURL, pr.Number, logger)
+
+	s.setProvisioningStage(installation.OwnerID, provisioningStageDNS)
+	wait := 600
+	logger.Infof("Waiting up to %d seconds for DNS to propagate", wait)
+	dnsCtx, cancel := context.WithTimeout(context.Background(), time.Duration(wait)*time.Second)
+	defer cancel()
+
+	mmHost, _ := url.Parse(spinwickURL)
+	if err := checkDNS(dnsCtx, fmt.Sprintf("%s:443", mmHost.Host)); err != nil {
+		return nil, errors.Wrap(err, "timed out waiting for DNS to propagate for installation")
+	}
+
+	s.setProvisioningStage(installation.OwnerID, provisioningStageInit)
+	credentials, err := s.initializeMattermostTestServer(spinwickURL, pr, opts.users, logger)
 	if err != nil {
-		return "", "", errors.Wrap(err, "failed to initialize the Installation")
+		return nil, errors.Wrap(err, "failed to initialize the Installation")
+	}
+
+	// Sample data is a convenience, so a failure is reported but doesn't fail the SpinWick.
+	if opts.sampleData != "" {
+		err = s.seedSampleDataForSpinWick(spinwickURL, credentials.password(spinWickSysadminUsername), pr.Number, opts.sampleData, logger)
+		if err != nil {
+			logger.WithError(err).Warn("Failed to seed sample data")
+			s.addSpinWickWarning(pr, "", fmt.Sprintf("Seeding the `%s` sample data failed: %s", opts.sampleData, err.Error()))
+		}
 	}
 
-	return sysadminPassword, userPassword, nil
+	return credentials, nil
 }
 
 // Helper function to generate a secure random password
@@ -123,7 +146,7 @@ func generateSecurePassword() (string, error) {
 }
 
 // Helper function to format and send success comment to Mattermost webhook
-func (s *Server) sendSpinwickSuccessToMattermost(pr *model.PullRequest, installation *cloudModel.InstallationDTO, sysadminPassword, userPassword, extraInfo string, logger logrus.FieldLogger) {
+func (s *Server) sendSpinwickSuccessToMattermost(pr *model.PullRequest, variant string, installation *cloudModel.InstallationDTO, credentials spinWickCredentials, extraInfo string, logger logrus.FieldLogger) {
 	// Send public message to GitHub (without credentials)
 	spinwickURL := fmt.Sprintf("https://%s", cloudtools.GetInstallationDNSFromDNSRecords(installation))
 	logLink := fmt.Sprintf("https://grafana.internal.mattermost.com/explore?orgId=1&left=%%7B%%22datasource%%22:%%22PFB2D5CACEC34D62E%%22,%%22queries%%22:%%5B%%7B%%22refId%%22:%%22A%%22,%%22expr%%22:%%22%%7Bnamespace%%3D%%5C%%22%s%%5C%%22%%7D%%22,%%22queryType%%22:%%22range%%22,%%22datasource%%22:%%7B%%22type%%22:%%22loki%%22,%%22uid%%22:%%22PFB2D5CACEC34D62E%%22%%7D,%%22editorMode%%22:%%22code%%22%%7D%%5D,%%22range%%22:%%7B%%22from%%22:%%22now-1h%%22,%%22to%%22:%%22now%%22%%7D%%7D", installation.ID)
@@ -149,7 +172,7 @@ func (s *Server) sendSpinwickSuccessToMattermost(pr *model.PullRequest, installa
 		githubMsg += "\n\n**Credentials:** Have been sent securely to the internal Mattermost channel."
 	}
 
-	s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, githubMsg)
+	s.setSpinWickStatus(pr, variant, githubMsg)
 
 	// Send credentials to Mattermost webhook
 	if s.Config.MattermostCredentialsWebhookURL == "" {
@@ -157,9 +180,8 @@ func (s *Server) sendSpinwickSuccessToMattermost(pr *model.PullRequest, installa
 		return
 	}
 
-	userTable := fmt.Sprintf("| Account Type | Username | Password |\n|---|---|---|\n| Admin | sysadmin | %s |\n| User | user-1 | %s |", sysadminPassword, userPassword)
 	mmMsg := fmt.Sprintf("## %sSpinwick for PR #%d\n---\n**Repository:** %s/%s\n**Pull Request:** [#%d](%s)\n\n**Test Server:** %s\n\n### Credentials\n%s",
-		prefix, pr.Number, pr.RepoOwner, pr.RepoName, pr.Number, pr.URL, spinwickURL, userTable)
+		prefix, pr.Number, pr.RepoOwner, pr.RepoName, pr.Number, pr.URL, spinwickURL, credentials.table())
 	if extraInfo != "" {
 		mmMsg += "\n\n### Additional Info\n" + extraInfo
 	}
@@ -168,24 +190,7 @@ func (s *Server) sendSpinwickSuccessToMattermost(pr *model.PullRequest, installa
 		mmMsg += "\n---\n" + s.Config.MattermostWebhookFooter
 	}
 
-	webhookRequest := &WebhookRequest{Username: "MatterWick", Text: mmMsg}
-
-	// Send to credentials webhook URL
-	b, err := json.Marshal(webhookRequest)
-	if err != nil {
-		logger.WithError(err).Error("Unable to marshal webhook request")
-		return
-	}
-
-	client := http.Client{Timeout: 10 * time.Second}
-	request, err := http.NewRequest(http.MethodPost, s.Config.MattermostCredentialsWebhookURL, bytes.NewReader(b))
-	if err != nil {
-		logger.WithError(err).Error("Unable to create webhook request")
-		return
-	}
-	request.Header.Add("Content-Type", "application/json")
-
-	if _, err = client.Do(request); err != nil {
+	if err := s.postToCredentialsWebhook(mmMsg); err != nil {
 		logger.WithError(err).Error("Unable to post credentials to Mattermost webhook")
 	}
 }
@@ -196,14 +201,24 @@ func (s *Server) sendSpinwickSuccessComment(pr *model.PullRequest, installation
 	// Generate secure passwords for backwards compatibility
 	sysadminPassword, _ := generateSecurePassword()
 	userPassword, _ := generateSecurePassword()
-	s.sendSpinwickSuccessToMattermost(pr, installation, sysadminPassword, userPassword, extraInfo, logger)
+	credentials := spinWickCredentials{
+		{AccountType: "Admin", Username: spinWickSysadminUsername, Password: sysadminPassword},
+		{AccountType: "User", Username: spinWickUserUsername, Password: userPassword},
+	}
+	s.sendSpinwickSuccessToMattermost(pr, "", installation, credentials, extraInfo, logger)
 }
 
 func (s *Server) handleCreateSpinWick(pr *model.PullRequest, size string, withLicense, withCloudInfra bool, envVars cloudModel.EnvVarMap) {
 	logger := s.Logger.WithFields(logrus.Fields{"repo_name": pr.RepoName, "pr": pr.Number})
 	if pr.State == "closed" {
 		logger.Info("PR is closed/merged, will not create a test server")
-		s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, "PR is closed/merged not creating a SpinWick Test server")
+		s.setSpinWickStatus(pr, "", "PR is closed/merged not creating a SpinWick Test server")
+		return
+	}
+
+	checkKey := model.NewSpinwick(pr.RepoName, pr.Number, s.Config.DNSNameTestServer).RepeatableID
+	ctx, finish, ok := s.beginSpinWickOperation(context.Background(), checkKey, spinWickOperationCreate, logger)
+	if !ok {
 		return
 	}
 
@@ -213,20 +228,23 @@ func (s *Server) handleCreateSpinWick(pr *model.PullRequest, size string, withLi
 		ReportError:    false,
 		Aborted:        false,
 	}
-	if pr.RepoName == cwsRepoName {
-		s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, "Creating a CWS SpinWick test server")
-		request = s.createCWSSpinWick(pr, logger)
+	defer func() { finish(request) }()
+
+	s.startSpinWickDeployment(pr, "", logger)
+	s.startProvisioningCheck(pr, checkKey, spinWickCheckName(""), logger)
+
+	lease, err := s.waitForSpinWickCapacity(ctx, pr, "", checkKey, logger)
+	if err != nil {
+		request.WithError(err)
+	} else if pr.RepoName == cwsRepoName {
+		s.updateStatusComment(pr, statusSectionSpinWick, "Creating a CWS SpinWick test server")
+		request = s.createCWSSpinWick(ctx, pr, logger)
 	} else if s.isPluginRepository(pr.RepoName) {
-		s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, "Creating a Plugin SpinWick test server")
-		request = s.createPluginSpinWick(pr, logger)
+		s.updateStatusComment(pr, statusSectionSpinWick, "Creating a Plugin SpinWick test server")
+		request = s.createPluginSpinWick(ctx, pr, logger)
 	} else if withCloudInfra {
-		s.sendGitHubComment(
-			pr.RepoOwner,
-			pr.RepoName,
-			pr.Number,
-			"Creating a new SpinWick test cloud server with CWS using Mattermost Cloud.",
-		)
-		request = s.createCloudSpinWickWithCWS(pr, size, logger)
+		s.updateStatusComment(pr, statusSectionSpinWick, "Creating a new SpinWick test cloud server with CWS using Mattermost Cloud.")
+		request = s.createCloudSpinWickWithCWS(ctx, pr, size, logger)
 	} else {
 		var commitMsg string
 		if withLicense {
@@ -234,11 +252,24 @@ func (s *Server) handleCreateSpinWick(pr *model.PullRequest, size string, withLi
 		} else {
 			commitMsg = "Creating a new SpinWick test server using Mattermost Cloud."
 		}
-		s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, commitMsg)
-		request = s.createSpinWick(pr, size, withLicense, envVars, logger)
+		s.updateStatusComment(pr, statusSectionSpinWick, commitMsg)
+		request = s.createSpinWick(ctx, pr, "", size, withLicense, envVars, logger)
+	}
+	if lease != nil {
+		// Failed creations may still have left an installation behind, which
+		// counts until the SpinWick is destroyed.
+		if request.InstallationID != "n/a" {
+			lease.keep(checkKey)
+		}
+		lease.done()
+	}
+	if destroyedSpinWickOperation(ctx, request) {
+		logger.Info("SpinWick creation cancelled by a destroy")
 	}
 
 	logger = logger.WithField("installation_id", request.InstallationID)
+	s.finishSpinWickDeployment(pr, "", request, logger)
+	s.finishProvisioningCheck(checkKey, request)
 
 	if request.Error != nil {
 		if request.Aborted {
@@ -246,18 +277,14 @@ func (s *Server) handleCreateSpinWick(pr *model.PullRequest, size string, withLi
 		} else {
 			logger.WithError(request.Error).Error("Failed to create SpinWick")
 		}
-		comments, err := s.getComments(pr.RepoOwner, pr.RepoName, pr.Number)
-		if err != nil {
-			logger.WithError(err).Error("Error getting comments")
-		} else {
-			s.removeOldComments(comments, pr, logger)
-		}
-		for _, label := range pr.Labels {
-			if s.isSpinWickLabel(label) {
-				s.removeLabel(pr.RepoOwner, pr.RepoName, pr.Number, label)
+		if !request.PRNotified {
+			for _, label := range pr.Labels {
+				if s.isSpinWickLabel(label) {
+					s.removeLabel(pr.RepoOwner, pr.RepoName, pr.Number, label)
+				}
 			}
+			s.updateStatusComment(pr, statusSectionSpinWick, s.Config.SetupSpinmintFailedMessage)
 		}
-		s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, s.Config.SetupSpinmintFailedMessage)
 
 		if request.ReportError {
 			additionalFields := map[string]string{
@@ -270,7 +297,7 @@ func (s *Server) handleCreateSpinWick(pr *model.PullRequest, size string, withLi
 
 // createCloudSpinwickWithCWS will use the defined CWSCloudInstance to create a new user/customer and
 // instantiate a new MM cloud installation
-func (s *Server) createCloudSpinWickWithCWS(pr *model.PullRequest, _ string, logger logrus.FieldLogger) *spinwick.Request {
+func (s *Server) createCloudSpinWickWithCWS(ctx context.Context, pr *model.PullRequest, _ string, logger logrus.FieldLogger) *spinwick.Request {
 	request := &spinwick.Request{
 		InstallationID: "n/a",
 		Error:          nil,
@@ -322,14 +349,14 @@ func (s *Server) createCloudSpinWickWithCWS(pr *model.PullRequest, _ string, log
 			WithError(fmt.Errorf("Already found a installation belonging to %s", customerID)).
 			IntentionalAbort()
 	}
-	reg, errDocker := s.Builds.dockerRegistryClient(s)
+	image := mattermostEEImage
+	version := s.Builds.getInstallationVersion(pr)
+	reg, errDocker := s.Builds.dockerRegistryClient(s, image)
 	if errDocker != nil {
 		return request.WithError(errors.Wrap(errDocker, "unable to get docker registry client")).ShouldReportError()
 	}
 
-	image := mattermostEEImage
-	version := s.Builds.getInstallationVersion(pr)
-	ctx, cancel := context.WithTimeout(context.Background(), 45*time.Minute)
+	ctx, cancel := context.WithTimeout(ctx, 45*time.Minute)
 	defer cancel()
 	err = s.Builds.waitForImage(ctx, reg, version, image, logger)
 	if err != nil {
@@ -365,7 +392,7 @@ func (s *Server) createCloudSpinWickWithCWS(pr *model.PullRequest, _ string, log
 	} else {
 		githubMsg += "\n\n**Credentials:** Have been sent securely to the internal Mattermost channel."
 	}
-	s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, githubMsg)
+	s.updateStatusComment(pr, statusSectionSpinWick, githubMsg)
 
 	// Send credentials to Mattermost webhook
 	if s.Config.MattermostCredentialsWebhookURL != "" {
@@ -398,10 +425,10 @@ func (s *Server) createCloudSpinWickWithCWS(pr *model.PullRequest, _ string, log
 		logger.Warn("No Mattermost credentials webhook URL set: unable to send credentials")
 	}
 
-	return request
+	return request.WithURL(spinwickURL)
 }
 
-func (s *Server) createCWSSpinWick(pr *model.PullRequest, logger logrus.FieldLogger) *spinwick.Request {
+func (s *Server) createCWSSpinWick(ctx context.Context, pr *model.PullRequest, logger logrus.FieldLogger) *spinwick.Request {
 	request := &spinwick.Request{
 		InstallationID: "n/a",
 		Error:          nil,
@@ -423,13 +450,13 @@ func (s *Server) createCWSSpinWick(pr *model.PullRequest, logger logrus.FieldLog
 		return request.WithError(errors.Wrap(err, "Error occurred whilst creating namespace")).ShouldReportError()
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 45*time.Minute)
+	ctx, cancel := context.WithTimeout(ctx, 45*time.Minute)
 	defer cancel()
 
 	version := s.Builds.getInstallationVersion(pr)
 	image := cwsImage
 
-	reg, errDocker := s.Builds.dockerRegistryClient(s)
+	reg, errDocker := s.Builds.dockerRegistryClient(s, image)
 	if errDocker != nil {
 		return request.WithError(errors.Wrap(errDocker, "unable to get docker registry client")).ShouldReportError()
 	}
@@ -524,18 +551,10 @@ func (s *Server) createCWSSpinWick(pr *model.PullRequest, logger logrus.FieldLog
 		}
 	}
 
-	comments, errComments := s.getComments(pr.RepoOwner, pr.RepoName, pr.Number)
-	commentsToDelete := []string{"Creating a SpinWick test CWS", "Spinwick Kubernetes namespace"}
-	if errComments != nil {
-		logger.WithError(err).Error("pr_error")
-	} else {
-		s.removeCommentsWithSpecificMessages(comments, commentsToDelete, pr, logger)
-	}
-
 	spinwickURL := fmt.Sprintf("http://%s", lbURL)
 	// Send public message to GitHub (no credentials for CWS-only deployments)
 	msg := fmt.Sprintf("**CWS SpinWick PR #%d** :tada:\n\n**Test server created!**\n\nAccess here: %s\n\n**Split individual target:** %s", pr.Number, spinwickURL, deployment.Environment.CWSSplitServerID)
-	s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, msg)
+	s.updateStatusComment(pr, statusSectionSpinWick, msg)
 
 	// Send to Mattermost webhook for consistency
 	if s.Config.MattermostCredentialsWebhookURL != "" {
@@ -566,14 +585,15 @@ func (s *Server) createCWSSpinWick(pr *model.PullRequest, logger logrus.FieldLog
 	}
 
 	request.InstallationID = deployment.Namespace
-	return request
+	return request.WithURL(spinwickURL)
 }
 
-// createSpinwick creates a SpinWick with the following behavior:
+// createSpinWick creates the PR's SpinWick, or the named variant of it when
+// variant is not empty, with the following behavior:
 // - no cloud installation found = installation is created
 // - cloud installation found = actual ID string and no error
 // - any errors = error is returned
-func (s *Server) createSpinWick(pr *model.PullRequest, size string, withLicense bool, envVars cloudModel.EnvVarMap, logger logrus.FieldLogger) *spinwick.Request {
+func (s *Server) createSpinWick(ctx context.Context, pr *model.PullRequest, variant, size string, withLicense bool, envVars cloudModel.EnvVarMap, logger logrus.FieldLogger) *spinwick.Request {
 	request := &spinwick.Request{
 		InstallationID: "n/a",
 		Error:          nil,
@@ -585,7 +605,7 @@ func (s *Server) createSpinWick(pr *model.PullRequest, size string, withLicense
 		return request.WithError(errors.Errorf("Repository %s is not supported", pr.RepoName))
 	}
 
-	spinwick := model.NewSpinwick(pr.RepoName, pr.Number, s.Config.DNSNameTestServer)
+	spinwick := model.NewSpinwickVariant(pr.RepoName, pr.Number, variant, s.Config.DNSNameTestServer)
 	ownerID := spinwick.RepeatableID
 
 	// Check for existing installation
@@ -599,46 +619,41 @@ func (s *Server) createSpinWick(pr *model.PullRequest, size string, withLicense
 			IntentionalAbort()
 	}
 
-	// Remove old message to reduce the amount of similar messages and avoid confusion
-	serverNewCommitMessages := []string{
-		"Test server destroyed",
-	}
-	comments, errComments := s.getComments(pr.RepoOwner, pr.RepoName, pr.Number)
-	if errComments != nil {
-		logger.WithError(err).Errorf("Failed to get comments on %s for PR %d", pr.RepoName, pr.Number)
-	} else {
-		s.removeCommentsWithSpecificMessages(comments, serverNewCommitMessages, pr, logger)
-	}
-
 	logger.Info("No SpinWick found for this PR. Creating a new one.")
 
 	image := mattermostEEImage
 	version := s.Builds.getInstallationVersion(pr)
 
-	reg, errDocker := s.Builds.dockerRegistryClient(s)
+	reg, errDocker := s.Builds.dockerRegistryClient(s, image)
 	if errDocker != nil {
 		return request.WithError(errors.Wrap(errDocker, "unable to get docker registry client")).ShouldReportError()
 	}
 
 	logger.Info("Waiting for docker image to set up SpinWick")
+	s.setProvisioningStage(ownerID, provisioningStageWaitingForImage)
 
-	ctxEnterprise, cancelEnterprise := context.WithTimeout(context.Background(), 30*time.Minute)
+	ctxEnterprise, cancelEnterprise := context.WithTimeout(ctx, 30*time.Minute)
 	defer cancelEnterprise()
 
 	err = s.Builds.waitForImage(ctxEnterprise, reg, version, image, logger)
 	if err != nil {
 		if withLicense {
-			s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, "Enterprise Edition Image not available in the 30 minutes timeframe.\nPlease check if the EE Pipeline was triggered and if not please trigger and re-add the `Setup HA Cloud Test Server` again.")
+			s.setSpinWickStatus(pr, variant, "Enterprise Edition Image not available in the 30 minutes timeframe.\nPlease check if the EE Pipeline was triggered and if not please trigger and re-add the `Setup HA Cloud Test Server` again.")
 			return request.WithError(
 				errors.Wrap(err, "error waiting for the docker image. Aborting. Check if EE pipeline ran")).
 				ShouldReportError()
 		}
 
 		logger.WithField("sha", pr.Sha).Warn("Did not find the EE image, falling back to TE")
-		s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, "Enterprise Edition Image not available in the 30 minutes timeframe, checking the Team Edition Image and if available will use that.")
+		s.setSpinWickStatus(pr, variant, "Enterprise Edition Image not available in the 30 minutes timeframe, checking the Team Edition Image and if available will use that.")
 
 		image = mattermostTeamImage
-		ctxTeam, cancelTeam := context.WithTimeout(context.Background(), 30*time.Minute)
+		reg, errDocker = s.Builds.dockerRegistryClient(s, image)
+		if errDocker != nil {
+			return request.WithError(errors.Wrap(errDocker, "unable to get docker registry client")).ShouldReportError()
+		}
+
+		ctxTeam, cancelTeam := context.WithTimeout(ctx, 30*time.Minute)
 		defer cancelTeam()
 
 		err = s.Builds.waitForImage(ctxTeam, reg, version, image, logger)
@@ -648,17 +663,36 @@ func (s *Server) createSpinWick(pr *model.PullRequest, size string, withLicense
 		}
 	}
 
-	logger.Info("Creating installation")
+	opts := s.getSpinWickOptions(pr.RepoName, ownerID)
+	license := s.spinWickLicense(opts, withLicense)
+
+	// Upgrade-path SpinWicks start on an older release and are patched to the PR
+	// build once they have been initialized and seeded.
+	installImage, installVersion := image, version
+	if opts.upgradeFrom != "" {
+		installImage, installVersion = upgradeFromImageAndTag(image, opts.upgradeFrom)
+		if _, ok := s.Config.SampleDataProfiles[defaultUpgradeSampleData]; ok && opts.sampleData == "" {
+			opts.sampleData = defaultUpgradeSampleData
+		}
+	}
+
+	logger.WithFields(logrus.Fields{
+		"database":  opts.database,
+		"filestore": opts.filestore,
+		"image":     installImage,
+		"version":   installVersion,
+	}).Info("Creating installation")
 
 	cloudClient := s.CloudClient
 	installationRequest := s.createInstallationRequest(
 		ownerID,
-		version,
-		image,
+		installVersion,
+		installImage,
 		spinwick.DNS(s.Config.DNSNameTestServer),
 		size,
-		withLicense,
+		license,
 		envVars,
+		opts,
 	)
 
 	installation, err = cloudClient.CreateInstallation(installationRequest)
@@ -672,17 +706,48 @@ func (s *Server) createSpinWick(pr *model.PullRequest, size string, withLicense
 
 	wait := 1200
 	logger.Infof("Waiting %d seconds for mattermost installation to become stable", wait)
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(wait)*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(wait)*time.Second)
 	defer cancel()
 
-	sysadminPassword, userPassword, err := s.waitAndInitializeInstallation(ctx, pr, request, installation, logger)
+	credentials, err := s.waitAndInitializeInstallation(ctx, pr, request, installation, opts, logger)
 	if err != nil {
 		return request.WithError(err).ShouldReportError()
 	}
+	s.setSpinWickCredentials(spinwick.RepeatableID, credentials)
+
+	var extraInfo string
+	if variant != "" {
+		extraInfo = fmt.Sprintf("**Variant:** `%s`", variant)
+	}
+	if opts.upgradeFrom != "" {
+		extraInfo += "\n\n" + s.upgradeSpinWickToPR(pr, installation.ID, installVersion, version, image, license, envVars, logger)
+	}
+
+	companions, companionReport := s.spinWickCompanions(pr, opts)
+	if len(companions) > 0 || len(opts.plugins) > 0 {
+		s.setProvisioningStage(ownerID, provisioningStagePlugins)
+	}
+	if companionInfo := s.installCompanionPlugins(installation.ID, companions, companionReport, logger); companionInfo != "" {
+		extraInfo = strings.TrimSpace(extraInfo + "\n\n" + companionInfo)
+	}
+	s.linkCompanions(pr, variant, companions)
+
+	if pluginTable := extraPluginsTable(s.installExtraPlugins(installation.ID, opts.plugins, logger)); pluginTable != "" {
+		extraInfo = strings.TrimSpace(extraInfo + "\n\n" + pluginTable)
+	}
+
+	mmURL := fmt.Sprintf("https://%s", cloudtools.GetInstallationDNSFromDNSRecords(installation))
+	smokeInfo, smokeErr := s.smokeTestSpinWick(pr, ownerID, mmURL, installation.ID, credentials, logger)
+	extraInfo = strings.TrimSpace(extraInfo + "\n\n" + smokeInfo)
 
 	// Send success message to Mattermost webhook
-	s.sendSpinwickSuccessToMattermost(pr, installation, sysadminPassword, userPassword, "", logger)
+	s.sendSpinwickSuccessToMattermost(pr, variant, installation, credentials, extraInfo, logger)
 
+	request.WithURL(mmURL)
+	if smokeErr != nil {
+		// The success comment already shows the failed checks.
+		return request.WithError(smokeErr).AlreadyNotifiedPR()
+	}
 	return request
 }
 
@@ -697,15 +762,34 @@ func (s *Server) handleUpdateSpinWick(pr *model.PullRequest, withLicense, withCl
 		Aborted:        false,
 	}
 
+	checkKey := model.NewSpinwick(pr.RepoName, pr.Number, s.Config.DNSNameTestServer).RepeatableID
+	updateCtx, done := s.startSpinWickUpdate(checkKey, pr.Sha, logger)
+	defer done()
+	ctx, finish, ok := s.beginSpinWickOperation(updateCtx, checkKey, spinWickOperationUpdate, logger)
+	if !ok {
+		return
+	}
+	defer func() { finish(request) }()
+
+	s.startSpinWickDeployment(pr, "", logger)
+	s.startProvisioningCheck(pr, checkKey, spinWickCheckName(""), logger)
+
 	if pr.RepoName == cwsRepoName {
-		request = s.updateKubeSpinWick(pr, logger)
+		request = s.updateKubeSpinWick(ctx, pr, logger)
 	} else if s.isPluginRepository(pr.RepoName) {
-		request = s.updatePluginSpinWick(pr, logger)
+		request = s.updatePluginSpinWick(ctx, pr, logger)
 	} else {
-		request = s.updateSpinWick(pr, withLicense, withCloudInfra, noBuildChanges, envVars, logger)
+		request = s.updateSpinWick(ctx, pr, "", withLicense, withCloudInfra, noBuildChanges, envVars, logger)
+	}
+	if supersededSpinWickUpdate(updateCtx, request) {
+		logger.Info("SpinWick update superseded by a newer commit")
+	} else if destroyedSpinWickOperation(ctx, request) {
+		logger.Info("SpinWick update cancelled by a destroy")
 	}
 
 	logger = logger.WithField("installation_id", request.InstallationID)
+	s.finishSpinWickDeployment(pr, "", request, logger)
+	s.finishProvisioningCheck(checkKey, request)
 
 	if request.Error != nil {
 		if request.Aborted {
@@ -713,7 +797,9 @@ func (s *Server) handleUpdateSpinWick(pr *model.PullRequest, withLicense, withCl
 		} else {
 			logger.WithError(request.Error).Error("Failed to update SpinWick")
 		}
-		s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, s.Config.SetupSpinmintFailedMessage)
+		if !request.PRNotified {
+			s.updateStatusComment(pr, statusSectionSpinWick, s.Config.SetupSpinmintFailedMessage)
+		}
 		if request.ReportError {
 			additionalFields := map[string]string{
 				"Installation ID": request.InstallationID,
@@ -723,7 +809,7 @@ func (s *Server) handleUpdateSpinWick(pr *model.PullRequest, withLicense, withCl
 	}
 }
 
-func (s *Server) updateKubeSpinWick(pr *model.PullRequest, logger logrus.FieldLogger) *spinwick.Request {
+func (s *Server) updateKubeSpinWick(ctx context.Context, pr *model.PullRequest, logger logrus.FieldLogger) *spinwick.Request {
 	request := &spinwick.Request{
 		InstallationID: "n/a",
 		Error:          nil,
@@ -751,26 +837,16 @@ func (s *Server) updateKubeSpinWick(pr *model.PullRequest, logger logrus.FieldLo
 
 	request.InstallationID = namespaceName
 
-	// Remove old message to reduce the amount of similar messages and avoid confusion
-	serverNewCommitMessages := []string{
-		"New commit detected.",
-	}
-	comments, errComments := s.getComments(pr.RepoOwner, pr.RepoName, pr.Number)
-	if errComments != nil {
-		logger.WithError(err).Error("pr_error")
-	} else {
-		s.removeCommentsWithSpecificMessages(comments, serverNewCommitMessages, pr, logger)
-	}
-	// Now that we know this namespace exists, notify via comment that we are attempting to upgrade the deployment
-	s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, "New commit detected. SpinWick will upgrade if the updated docker image is available.")
+	// Now that we know this namespace exists, show that we are attempting to upgrade the deployment
+	s.updateStatusComment(pr, statusSectionSpinWick, "New commit detected. SpinWick will upgrade if the updated docker image is available.")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 45*time.Minute)
+	ctx, cancel := context.WithTimeout(ctx, 45*time.Minute)
 	defer cancel()
 
 	version := s.Builds.getInstallationVersion(pr)
 	image := cwsImage
 
-	reg, errDocker := s.Builds.dockerRegistryClient(s)
+	reg, errDocker := s.Builds.dockerRegistryClient(s, image)
 	if errDocker != nil {
 		return request.WithError(errors.Wrap(errDocker, "unable to get docker registry client")).ShouldReportError()
 	}
@@ -800,27 +876,20 @@ func (s *Server) updateKubeSpinWick(pr *model.PullRequest, logger logrus.FieldLo
 		return request.WithError(errors.Wrap(err, "failed while updating deployment with latest image")).ShouldReportError()
 	}
 
-	// Remove old message to reduce the amount of similar messages and avoid confusion
-	if errComments == nil {
-		serverUpdateMessage := []string{
-			"CWS test server updated",
-		}
-		s.removeCommentsWithSpecificMessages(comments, serverUpdateMessage, pr, logger)
-	}
-
 	lbURL, _ := waitForIPAssignment(kc, namespaceName, logger)
 	spinwickURL := fmt.Sprintf("http://%s", lbURL)
 	msg := fmt.Sprintf("CWS test server updated with git commit `%s`.\n\nAccess here: %s", pr.Sha, spinwickURL)
-	s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, msg)
+	s.updateStatusComment(pr, statusSectionSpinWick, msg)
 
-	return request
+	return request.WithURL(spinwickURL)
 }
 
-// updateSpinWick updates a SpinWick with the following behavior:
+// updateSpinWick updates the PR's SpinWick, or the named variant of it when
+// variant is not empty, with the following behavior:
 // - no cloud installation found = error is returned
 // - cloud installation found and updated = actual ID string and no error
 // - any errors = error is returned
-func (s *Server) updateSpinWick(pr *model.PullRequest, withLicense, withCloudInfra, noBuildChanges bool, envVars cloudModel.EnvVarMap, logger logrus.FieldLogger) *spinwick.Request {
+func (s *Server) updateSpinWick(ctx context.Context, pr *model.PullRequest, variant string, withLicense, withCloudInfra, noBuildChanges bool, envVars cloudModel.EnvVarMap, logger logrus.FieldLogger) *spinwick.Request {
 	request := &spinwick.Request{
 		InstallationID: "n/a",
 		Error:          nil,
@@ -828,7 +897,7 @@ func (s *Server) updateSpinWick(pr *model.PullRequest, withLicense, withCloudInf
 		Aborted:        false,
 	}
 
-	spinwick := model.NewSpinwick(pr.RepoName, pr.Number, s.Config.DNSNameTestServer)
+	spinwick := model.NewSpinwickVariant(pr.RepoName, pr.Number, variant, s.Config.DNSNameTestServer)
 
 	var ownerID string
 	var err error
@@ -852,38 +921,42 @@ func (s *Server) updateSpinWick(pr *model.PullRequest, withLicense, withCloudInf
 
 	logger = logger.WithField("sha", pr.Sha)
 
-	var errComments error
-	var comments []*github.IssueComment
 	if !noBuildChanges {
-		logger.Info("Sleeping a bit to wait for the build process to start")
-		time.Sleep(60 * time.Second)
-
-		// Remove old message to reduce the amount of similar messages and avoid confusion
-		serverNewCommitMessages := []string{
-			"New commit detected.",
-		}
-		comments, errComments = s.getComments(pr.RepoOwner, pr.RepoName, pr.Number)
-		if errComments != nil {
-			logger.WithError(err).Error("pr_error")
+		if _, ok := s.Config.SpinWickBuildWorkflows[pr.RepoName]; ok {
+			s.setSpinWickStatus(pr, variant, "New commit detected. SpinWick will upgrade once the build succeeds."+variantSuffix(variant))
+			s.setProvisioningStage(spinwick.RepeatableID, provisioningStageWaitingForBuild)
+			if request = s.waitForSpinWickBuild(ctx, pr, variant, request, logger); request.Error != nil {
+				return request
+			}
 		} else {
-			s.removeCommentsWithSpecificMessages(comments, serverNewCommitMessages, pr, logger)
+			// Without push notifications the registry is polled, so give the
+			// build some time to start first.
+			if s.Config.RegistryWebhookSecret == "" {
+				logger.Info("Sleeping a bit to wait for the build process to start")
+				select {
+				case <-ctx.Done():
+					return request.WithError(errors.New("update cancelled")).IntentionalAbort()
+				case <-time.After(60 * time.Second):
+				}
+			}
+
+			s.setSpinWickStatus(pr, variant, "New commit detected. SpinWick will upgrade if the updated docker image is available."+variantSuffix(variant))
 		}
-		s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, "New commit detected. SpinWick will upgrade if the updated docker image is available.")
 	}
 
-	reg, err := s.Builds.dockerRegistryClient(s)
+	image := prImageOf(installation.Image)
+	version := s.Builds.getInstallationVersion(pr)
+	reg, err := s.Builds.dockerRegistryClient(s, image)
 	if err != nil {
 		return request.WithError(errors.Wrap(err, "unable to get docker registry client")).ShouldReportError()
 	}
 
 	logger.Info("Waiting for docker image to update SpinWick")
+	s.setProvisioningStage(spinwick.RepeatableID, provisioningStageWaitingForImage)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 45*time.Minute)
+	ctx, cancel := context.WithTimeout(ctx, 45*time.Minute)
 	defer cancel()
 
-	image := installation.Image
-	version := s.Builds.getInstallationVersion(pr)
-
 	err = s.Builds.waitForImage(ctx, reg, version, image, logger)
 	if err != nil {
 		return request.WithError(errors.Wrap(err, "error waiting for the docker image. Aborting")).IntentionalAbort()
@@ -894,8 +967,17 @@ func (s *Server) updateSpinWick(pr *model.PullRequest, withLicense, withCloudInf
 		Image:       &image,
 		PriorityEnv: envVars,
 	}
-	if withLicense && !withCloudInfra {
-		upgradeRequest.License = &s.Config.SpinWickHALicense
+	// CWS SpinWicks get their license from CWS. An explicit "none" profile
+	// removes the license of the installation.
+	opts := s.getSpinWickOptions(pr.RepoName, spinwick.RepeatableID)
+	license := s.spinWickLicense(opts, withLicense)
+	if !withCloudInfra && (license != "" || opts.license == spinWickLicenseNone) {
+		upgradeRequest.License = &license
+	}
+
+	// A newer commit may have superseded this update while it waited.
+	if ctx.Err() != nil {
+		return request.WithError(errors.New("update cancelled")).IntentionalAbort()
 	}
 
 	// Final upgrade check
@@ -910,58 +992,68 @@ func (s *Server) updateSpinWick(pr *model.PullRequest, withLicense, withCloudInf
 		return request.WithError(errors.New("another process already updated the installation version. Aborting")).IntentionalAbort()
 	}
 
-	logger.Info("Provisioning Server - Upgrade request")
+	if noBuildChanges {
+		s.setSpinWickStatus(pr, variant, "Your Spinwick is updating..."+variantSuffix(variant))
+	}
 
-	updatedInstallation, err := cloudClient.UpdateInstallation(request.InstallationID, upgradeRequest)
+	s.setProvisioningStage(spinwick.RepeatableID, provisioningStageUpdating)
+	updatedInstallation, err := s.updateInstallationAndWait(pr, request, upgradeRequest, 600, logger)
 	if err != nil {
-		return request.WithError(errors.Wrap(err, "unable to make upgrade request to provisioning server")).ShouldReportError()
+		return request
 	}
 
-	if noBuildChanges {
-		s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, "Your Spinwick is updating...")
+	mmURL := fmt.Sprintf("https://%s", cloudtools.GetInstallationDNSFromDNSRecords(updatedInstallation))
+	msg := fmt.Sprintf("Mattermost test server updated with git commit `%s`.%s\n\nAccess here: %s", pr.Sha, variantSuffix(variant), mmURL)
+	s.setSpinWickStatus(pr, variant, msg)
+
+	return request.WithURL(mmURL)
+}
+
+// updateInstallationAndWait sends the patch request to the provisioner and waits
+// up to wait seconds for the installation to become stable again. Any error is
+// also recorded on the request.
+func (s *Server) updateInstallationAndWait(pr *model.PullRequest, request *spinwick.Request, upgradeRequest *cloudModel.PatchInstallationRequest, wait int, logger logrus.FieldLogger) (*cloudModel.InstallationDTO, error) {
+	logger.Info("Provisioning Server - Upgrade request")
+
+	updatedInstallation, err := s.CloudClient.UpdateInstallation(request.InstallationID, upgradeRequest)
+	if err != nil {
+		request.WithError(errors.Wrap(err, "unable to make upgrade request to provisioning server")).ShouldReportError()
+		return nil, request.Error
 	}
 
-	wait := 600
 	logger.Infof("Waiting %d seconds for mattermost installation to become stable", wait)
-	ctx, cancel = context.WithTimeout(context.Background(), time.Duration(wait)*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(wait)*time.Second)
 	defer cancel()
 
 	if os.Getenv("MATTERWICK_LOCAL_TESTING") == "true" {
 		s.waitForInstallationStablePoll(ctx, pr, request, logger)
-		if request.Error != nil {
-			return request.WithError(errors.Wrap(request.Error, "error waiting for installation to become stable"))
-		}
 	} else {
 		s.waitForInstallationStable(ctx, pr, request, logger)
-		if request.Error != nil {
-			return request.WithError(errors.Wrap(request.Error, "error waiting for installation to become stable"))
-		}
 	}
-
-	// Remove old message to reduce the amount of similar messages and avoid confusion
-	if errComments == nil {
-		serverUpdateMessage := []string{
-			"Mattermost test server updated",
-		}
-		s.removeCommentsWithSpecificMessages(comments, serverUpdateMessage, pr, logger)
+	if request.Error != nil {
+		request.WithError(errors.Wrap(request.Error, "error waiting for installation to become stable"))
+		return nil, request.Error
 	}
 
-	mmURL := fmt.Sprintf("https://%s", cloudtools.GetInstallationDNSFromDNSRecords(updatedInstallation))
-	msg := fmt.Sprintf("Mattermost test server updated with git commit `%s`.\n\nAccess here: %s", pr.Sha, mmURL)
-	s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, msg)
-
-	return request
+	return updatedInstallation, nil
 }
 
 func (s *Server) handleDestroySpinWick(pr *model.PullRequest, withCloud bool) {
 	logger := s.Logger.WithFields(logrus.Fields{"repo_name": pr.RepoName, "pr": pr.Number})
 
+	ownerID := model.NewSpinwick(pr.RepoName, pr.Number, s.Config.DNSNameTestServer).RepeatableID
+	_, finish, ok := s.beginSpinWickOperation(context.Background(), ownerID, spinWickOperationDestroy, logger)
+	if !ok {
+		return
+	}
+
 	request := &spinwick.Request{
 		InstallationID: "n/a",
 		Error:          nil,
 		ReportError:    false,
 		Aborted:        false,
 	}
+	defer func() { finish(request) }()
 
 	if pr.RepoName == cwsRepoName {
 		request = s.destroyKubeSpinWick(pr, logger)
@@ -970,10 +1062,14 @@ func (s *Server) handleDestroySpinWick(pr *model.PullRequest, withCloud bool) {
 	} else if withCloud {
 		request = s.destroyCloudSpinWickWithCWS(pr, logger)
 	} else {
-		request = s.destroySpinWick(pr, logger)
+		request = s.destroySpinWick(pr, "", logger)
 	}
 
 	logger = logger.WithField("installation_id", request.InstallationID)
+	// Aborted destroys found nothing left to destroy.
+	if request.Error == nil || request.Aborted {
+		s.releaseProvisioningCapacity(ownerID)
+	}
 
 	if request.Error != nil {
 		if request.Aborted {
@@ -988,10 +1084,15 @@ func (s *Server) handleDestroySpinWick(pr *model.PullRequest, withCloud bool) {
 			s.logPrettyErrorToMattermost("[ SpinWick ] Destroy Failed", pr, request.Error, additionalFields, logger)
 		}
 	} else {
+		s.setSpinWickDeploymentStatus(pr, "", deploymentStateInactive, "", logger)
 		s.envMapsLock.Lock()
 		spinwick := model.NewSpinwick(pr.RepoName, pr.Number, s.Config.DNSNameTestServer)
 		delete(s.envMaps, spinwick.RepeatableID)
 		s.envMapsLock.Unlock()
+		s.deleteSpinWickOptions(spinwick.RepeatableID)
+		s.deleteSpinWickCredentials(spinwick.RepeatableID)
+		s.cleanupSpinWickSnapshots(pr, "")
+		s.unlinkCompanions(pr, "")
 	}
 }
 
@@ -1058,15 +1159,7 @@ func (s *Server) destroyKubeSpinWick(pr *model.PullRequest, logger logrus.FieldL
 		return request
 	}
 
-	// Old comments created by MatterWick user will be deleted here.
-	s.commentLock.Lock()
-	defer s.commentLock.Unlock()
-	comments, _, err := newGithubClient(s.Config.GithubAccessToken).Issues.ListComments(context.Background(), pr.RepoOwner, pr.RepoName, pr.Number, nil)
-	if err != nil {
-		return request.WithError(errors.Wrap(err, "unable to get list of old comments")).ShouldReportError()
-	}
-	s.removeOldComments(comments, pr, logger)
-	s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, "Spinwick CWS test server has been destroyed.")
+	s.updateStatusComment(pr, statusSectionSpinWick, "Spinwick CWS test server has been destroyed.")
 	return request
 }
 
@@ -1115,23 +1208,16 @@ func (s *Server) destroyCloudSpinWickWithCWS(pr *model.PullRequest, logger logru
 	defer cancel()
 	s.waitForInstallationIsDeleted(ctx, pr, request, logger)
 
-	// Old comments created by MatterWick user will be deleted here.
-	s.commentLock.Lock()
-	defer s.commentLock.Unlock()
-	comments, _, err := newGithubClient(s.Config.GithubAccessToken).Issues.ListComments(context.Background(), pr.RepoOwner, pr.RepoName, pr.Number, nil)
-	if err != nil {
-		return request.WithError(errors.Wrap(err, "unable to get list of old comments")).ShouldReportError()
-	}
-	s.removeOldComments(comments, pr, logger)
-	s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, s.Config.DestroyedSpinmintMessage)
+	s.updateStatusComment(pr, statusSectionSpinWick, s.Config.DestroyedSpinmintMessage)
 	return request
 }
 
-// destroySpinwick destroys a SpinWick with the following behavior:
+// destroySpinWick destroys the PR's SpinWick, or the named variant of it when
+// variant is not empty, with the following behavior:
 // - no cloud installation found = empty ID string and no error
 // - cloud installation found and deleted = actual ID string and no error
 // - any errors = error is returned
-func (s *Server) destroySpinWick(pr *model.PullRequest, logger logrus.FieldLogger) *spinwick.Request {
+func (s *Server) destroySpinWick(pr *model.PullRequest, variant string, logger logrus.FieldLogger) *spinwick.Request {
 	request := &spinwick.Request{
 		InstallationID: "n/a",
 		Error:          nil,
@@ -1139,7 +1225,7 @@ func (s *Server) destroySpinWick(pr *model.PullRequest, logger logrus.FieldLogge
 		Aborted:        false,
 	}
 
-	spinwick := model.NewSpinwick(pr.RepoName, pr.Number, s.Config.DNSNameTestServer)
+	spinwick := model.NewSpinwickVariant(pr.RepoName, pr.Number, variant, s.Config.DNSNameTestServer)
 
 	ownerID := spinwick.RepeatableID
 	installation, err := cloudtools.GetInstallationIDFromOwnerID(s.CloudClient, s.Config.ProvisionerServer, ownerID)
@@ -1159,17 +1245,13 @@ func (s *Server) destroySpinWick(pr *model.PullRequest, logger logrus.FieldLogge
 		return request.WithError(errors.Wrap(err, "unable to make installation delete request to provisioning server")).ShouldReportError()
 	}
 
-	// Old comments created by MatterWick user will be deleted here.
-	s.commentLock.Lock()
-	defer s.commentLock.Unlock()
-
-	comments, _, err := newGithubClient(s.Config.GithubAccessToken).Issues.ListComments(context.Background(), pr.RepoOwner, pr.RepoName, pr.Number, nil)
-	if err != nil {
-		return request.WithError(errors.Wrap(err, "unable to get list of old comments")).ShouldReportError()
+	// The status of the default SpinWick is left alone when a variant is destroyed.
+	if variant != "" {
+		s.setSpinWickStatus(pr, variant, fmt.Sprintf("SpinWick variant `%s` destroyed.", variant))
+		return request
 	}
-	s.removeOldComments(comments, pr, logger)
 
-	s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, s.Config.DestroyedSpinmintMessage)
+	s.updateStatusComment(pr, statusSectionSpinWick, s.Config.DestroyedSpinmintMessage)
 
 	return request
 }
@@ -1181,6 +1263,9 @@ func (s *Server) handleInstallationState(pr *model.PullRequest, request *spinwic
 	case cloudModel.InstallationStateCreationFailed:
 		request.WithError(errors.New("the installation creation failed")).ShouldReportError()
 		return false
+	case cloudModel.InstallationStateUpdateFailed:
+		request.WithError(errors.New("the installation update failed")).ShouldReportError()
+		return false
 	case cloudModel.InstallationStateDeletionRequested,
 		cloudModel.InstallationStateDeletionInProgress,
 		cloudModel.InstallationStateDeleted:
@@ -1195,11 +1280,7 @@ func (s *Server) handleInstallationState(pr *model.PullRequest, request *spinwic
 			return false
 		}
 	case cloudModel.InstallationStateCreationNoCompatibleClusters:
-		s.sendGitHubComment(
-			pr.RepoOwner,
-			pr.RepoName,
-			pr.Number,
-			"No Kubernetes clusters available at the moment, please contact the Mattermost Cloud Team or wait a bit.")
+		s.addSpinWickWarning(pr, "", "No Kubernetes clusters available at the moment, please contact the Mattermost Cloud Team or wait a bit.")
 		request.WithError(errors.New("no k8s clusters available")).IntentionalAbort()
 		return false
 	}
@@ -1300,57 +1381,51 @@ func (s *Server) waitForInstallationIsDeleted(ctx context.Context, pr *model.Pul
 	}
 }
 
-func (s *Server) initializeMattermostTestServer(mmURL string, prNumber int, logger logrus.FieldLogger) (string, string, error) {
+// initializeMattermostTestServer creates the sysadmin and user-1 accounts, the PR
+// team and the given roster on a new Mattermost installation and returns the
+// credentials of every account it created. Roster users that cannot be created
+// are reported in the warnings of the PR's status comment.
+func (s *Server) initializeMattermostTestServer(mmURL string, pr *model.PullRequest, roster []SpinWickUser, logger logrus.FieldLogger) (spinWickCredentials, error) {
 	logger.Info("Initializing Mattermost installation")
 
 	// Generate unique passwords for this installation
 	sysadminPassword, err := generateSecurePassword()
 	if err != nil {
-		return "", "", errors.Wrap(err, "failed to generate sysadmin password")
+		return nil, errors.Wrap(err, "failed to generate sysadmin password")
 	}
 	userPassword, err := generateSecurePassword()
 	if err != nil {
-		return "", "", errors.Wrap(err, "failed to generate user password")
+		return nil, errors.Wrap(err, "failed to generate user password")
 	}
 
 	wait := 600
-	logger.Infof("Waiting up to %d seconds for DNS to propagate", wait)
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(wait)*time.Second)
-	defer cancel()
-
-	mmHost, _ := url.Parse(mmURL)
-	err = checkDNS(ctx, fmt.Sprintf("%s:443", mmHost.Host))
-	if err != nil {
-		return "", "", errors.Wrap(err, "timed out waiting for DNS to propagate for installation")
-	}
-
 	client := mattermostModel.NewAPIv4Client(mmURL)
 
 	// check if Mattermost is available
-	ctx, cancel = context.WithTimeout(context.Background(), time.Duration(wait)*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(wait)*time.Second)
 	defer cancel()
 	err = checkMMPing(ctx, client, logger)
 	if err != nil {
-		return "", "", errors.Wrap(err, "failed to get mattermost ping response")
+		return nil, errors.Wrap(err, "failed to get mattermost ping response")
 	}
 
 	user := &mattermostModel.User{
-		Username: "sysadmin",
+		Username: spinWickSysadminUsername,
 		Email:    "sysadmin@example.mattermost.com",
 		Password: sysadminPassword,
 	}
 	_, _, err = client.CreateUser(user)
 	if err != nil {
-		return "", "", errors.Wrap(err, "failed to create initial mattermost user")
+		return nil, errors.Wrap(err, "failed to create initial mattermost user")
 	}
 	client.Logout()
 
-	userLogged, _, err := client.Login("sysadmin", sysadminPassword)
+	userLogged, _, err := client.Login(spinWickSysadminUsername, sysadminPassword)
 	if err != nil {
-		return "", "", errors.Wrap(err, "failed to log in with initial mattermost user")
+		return nil, errors.Wrap(err, "failed to log in with initial mattermost user")
 	}
 
-	teamName := fmt.Sprintf("pr%d", prNumber)
+	teamName := fmt.Sprintf("pr%d", pr.Number)
 	team := &mattermostModel.Team{
 		Name:        teamName,
 		DisplayName: teamName,
@@ -1358,31 +1433,41 @@ func (s *Server) initializeMattermostTestServer(mmURL string, prNumber int, logg
 	}
 	firstTeam, _, err := client.CreateTeam(team)
 	if err != nil {
-		return "", "", errors.Wrap(err, "failed to log in with initial team")
+		return nil, errors.Wrap(err, "failed to log in with initial team")
 	}
 
 	_, _, err = client.AddTeamMember(firstTeam.Id, userLogged.Id)
 	if err != nil {
-		return "", "", errors.Wrap(err, "failed adding admin user to initial team")
+		return nil, errors.Wrap(err, "failed adding admin user to initial team")
 	}
 
 	testUser := &mattermostModel.User{
-		Username: "user-1",
+		Username: spinWickUserUsername,
 		Email:    "user-1@example.mattermost.com",
 		Password: userPassword,
 	}
 	testUser, _, err = client.CreateUser(testUser)
 	if err != nil {
-		return "", "", errors.Wrap(err, "failed to create standard test user")
+		return nil, errors.Wrap(err, "failed to create standard test user")
 	}
 	_, _, err = client.AddTeamMember(firstTeam.Id, testUser.Id)
 	if err != nil {
-		return "", "", errors.Wrap(err, "failed adding standard test user to initial team")
+		return nil, errors.Wrap(err, "failed adding standard test user to initial team")
+	}
+
+	credentials := spinWickCredentials{
+		{AccountType: "Admin", Username: spinWickSysadminUsername, Password: sysadminPassword},
+		{AccountType: "User", Username: spinWickUserUsername, Password: userPassword},
+	}
+	rosterCredentials, rosterFailures := createSpinWickRoster(client, firstTeam.Id, roster, logger)
+	credentials = append(credentials, rosterCredentials...)
+	for _, failure := range rosterFailures {
+		s.addSpinWickWarning(pr, "", "Failed to create "+failure.Error())
 	}
 
 	logger.Info("Mattermost configuration complete")
 
-	return sysadminPassword, userPassword, nil
+	return credentials, nil
 }
 
 func checkDNS(ctx context.Context, url string) error {
@@ -1472,24 +1557,6 @@ func (s *Server) isSpinWickCloudWithCWSLabel(labels []string) bool {
 	return false
 }
 
-func (s *Server) removeCommentsWithSpecificMessages(comments []*github.IssueComment, serverMessages []string, pr *model.PullRequest, logger logrus.FieldLogger) {
-	logger.Info("Removing old spinwick MatterWick comments")
-	for _, comment := range comments {
-		if *comment.User.Login == s.Config.Username {
-			for _, message := range serverMessages {
-				if strings.Contains(*comment.Body, message) {
-					logger.WithField("comment_id", *comment.ID).Info("Removing old spinwick comment with ID")
-					_, err := newGithubClient(s.Config.GithubAccessToken).Issues.DeleteComment(context.Background(), pr.RepoOwner, pr.RepoName, *comment.ID)
-					if err != nil {
-						logger.WithError(err).Error("Unable to remove old spinwick MatterWick comment")
-					}
-					break
-				}
-			}
-		}
-	}
-}
-
 func (s *Server) getActiveInstallationUsingCWS(client *cws.Client) (*cws.Installation, error) {
 	installations, err := client.GetInstallations()
 	if err != nil {
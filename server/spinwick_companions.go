@@ -0,0 +1,425 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	cloudModel "github.com/mattermost/mattermost-cloud/model"
+	"github.com/mattermost/matterwick/internal/spinwick"
+	"github.com/mattermost/matterwick/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	companionPRRegex = regexp.MustCompile(`^([A-Za-z0-9_.-]+)/([A-Za-z0-9_.-]+)#([0-9]+)$`)
+	// companionDirectiveRegex matches "SpinWick-With: org/repo#N" lines in a PR body.
+	companionDirectiveRegex = regexp.MustCompile(`(?im)^\s*spinwick-with:\s*(.+?)\s*$`)
+)
+
+// companionPR is a PR in another repository whose build is combined with a
+// SpinWick, e.g. a plugin PR installed on a server PR SpinWick.
+type companionPR struct {
+	Owner  string
+	Repo   string
+	Number int
+}
+
+func (c companionPR) String() string {
+	return fmt.Sprintf("%s/%s#%d", c.Owner, c.Repo, c.Number)
+}
+
+// key identifies the companion PR in the companion index.
+func (c companionPR) key() string {
+	return strings.ToLower(c.String())
+}
+
+func companionFromPR(pr *model.PullRequest) companionPR {
+	return companionPR{Owner: pr.RepoOwner, Repo: pr.RepoName, Number: pr.Number}
+}
+
+// companionHost is a SpinWick that includes companion PRs.
+type companionHost struct {
+	pr      companionPR
+	variant string
+}
+
+// parseCompanionsArg parses a comma separated list of PRs in the format "org/repo#N".
+func parseCompanionsArg(arg string) ([]companionPR, error) {
+	entries := splitCommaSeparated(arg)
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no companion PRs found")
+	}
+
+	companions := make([]companionPR, 0, len(entries))
+	for _, entry := range entries {
+		matches := companionPRRegex.FindStringSubmatch(strings.TrimSpace(entry))
+		if matches == nil {
+			return nil, fmt.Errorf("invalid companion PR %q, expected org/repo#N", entry)
+		}
+		number, err := strconv.Atoi(matches[3])
+		if err != nil || number <= 0 {
+			return nil, fmt.Errorf("invalid companion PR %q, expected org/repo#N", entry)
+		}
+		companions = append(companions, companionPR{Owner: matches[1], Repo: matches[2], Number: number})
+	}
+
+	return companions, nil
+}
+
+// parseCompanionDirectives returns the companion PRs declared in a PR body with
+// "SpinWick-With: org/repo#N" lines. Invalid directives are returned as errors
+// so they can be reported without blocking the SpinWick.
+func parseCompanionDirectives(body string) ([]companionPR, []error) {
+	var companions []companionPR
+	var errs []error
+	for _, matches := range companionDirectiveRegex.FindAllStringSubmatch(body, -1) {
+		parsed, err := parseCompanionsArg(matches[1])
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		companions = append(companions, parsed...)
+	}
+	return companions, errs
+}
+
+// validateCompanion returns an error if the companion PR cannot be combined with
+// a SpinWick of the host PR. Companion PRs must be in the organization of the
+// host PR, so PR authors cannot install builds of other organizations. Plugin
+// PRs can be added to any SpinWick, server PRs only to plugin SpinWicks.
+func (s *Server) validateCompanion(host *model.PullRequest, companion companionPR) error {
+	hostRepo := host.RepoName
+	switch {
+	case !strings.EqualFold(companion.Owner, host.RepoOwner):
+		return fmt.Errorf("companion PR %s is not in the %s organization", companion, host.RepoOwner)
+	case companion.Repo == hostRepo:
+		return fmt.Errorf("companion PR %s is in the same repository as the SpinWick", companion)
+	case s.isPluginRepository(companion.Repo):
+		return nil
+	case companion.Repo == mattermostServerRepo && s.isPluginRepository(hostRepo):
+		return nil
+	}
+	return fmt.Errorf("companion PR %s is not supported, use plugin PRs or a %s PR for plugin SpinWicks", companion, mattermostServerRepo)
+}
+
+// spinWickCompanions returns the valid companion PRs of a SpinWick from the
+// /spinwick create options and the PR body, along with report lines about the
+// ones that were ignored.
+func (s *Server) spinWickCompanions(pr *model.PullRequest, opts spinWickOptions) ([]companionPR, []string) {
+	directives, errs := parseCompanionDirectives(pr.Body)
+
+	var report []string
+	for _, err := range errs {
+		report = append(report, companionWarning(err.Error()))
+	}
+
+	var companions []companionPR
+	seen := make(map[string]bool)
+	var hasServer bool
+	for _, companion := range append(append([]companionPR{}, opts.companions...), directives...) {
+		if seen[companion.key()] {
+			continue
+		}
+		seen[companion.key()] = true
+
+		if err := s.validateCompanion(pr, companion); err != nil {
+			report = append(report, companionWarning(err.Error()))
+			continue
+		}
+		if companion.Repo == mattermostServerRepo {
+			if hasServer {
+				report = append(report, companionWarning(fmt.Sprintf("companion PR %s ignored, only one server PR can be used", companion)))
+				continue
+			}
+			hasServer = true
+		}
+		companions = append(companions, companion)
+	}
+
+	return companions, report
+}
+
+func companionWarning(msg string) string {
+	return fmt.Sprintf("- :warning: %s", msg)
+}
+
+// linkCompanions records that the host PR's SpinWick, or its named variant,
+// includes the companion PRs so that pushes to them refresh the host SpinWick.
+func (s *Server) linkCompanions(host *model.PullRequest, variant string, companions []companionPR) {
+	s.companionHostsLock.Lock()
+	defer s.companionHostsLock.Unlock()
+	hostSpinWick := companionHost{pr: companionFromPR(host), variant: variant}
+	for _, companion := range companions {
+		hosts := s.companionHosts[companion.key()]
+		found := false
+		for _, existing := range hosts {
+			if existing.pr.key() == hostSpinWick.pr.key() && existing.variant == variant {
+				found = true
+				break
+			}
+		}
+		if !found {
+			s.companionHosts[companion.key()] = append(hosts, hostSpinWick)
+		}
+	}
+}
+
+// unlinkCompanions removes the host PR's SpinWick, or its named variant, from
+// the companion index.
+func (s *Server) unlinkCompanions(host *model.PullRequest, variant string) {
+	s.companionHostsLock.Lock()
+	defer s.companionHostsLock.Unlock()
+	hostKey := companionFromPR(host).key()
+	for key, hosts := range s.companionHosts {
+		remaining := hosts[:0]
+		for _, existing := range hosts {
+			if existing.pr.key() != hostKey || existing.variant != variant {
+				remaining = append(remaining, existing)
+			}
+		}
+		if len(remaining) == 0 {
+			delete(s.companionHosts, key)
+		} else {
+			s.companionHosts[key] = remaining
+		}
+	}
+}
+
+// loadCompanionHosts rebuilds the companion index from the "SpinWick-With"
+// directives of the PRs of the SpinWicks found on the provisioner, so pushes to
+// companion PRs keep refreshing them after a restart. Companions added with
+// /spinwick create --companions are not known after a restart.
+func (s *Server) loadCompanionHosts() {
+	logger := s.Logger.WithField("type", "companion_hosts")
+
+	installations, err := s.getProvisionedInstallations()
+	if err != nil {
+		logger.WithError(err).Error("Failed to load the companion PRs, SpinWicks created before the restart are not refreshed with them")
+		return
+	}
+
+	hosts := make(map[string]*model.PullRequest)
+	var loaded int
+	for _, installation := range installations {
+		_, claim, number, ok := provisionedClaim(installation.OwnerID, installation.ID)
+		if !ok || claim.kind != installationKindSpinWick {
+			continue
+		}
+		variant := spinWickOwnerIDRegex.FindStringSubmatch(installation.OwnerID)[3]
+
+		key := fmt.Sprintf("%s#%d", claim.repo, number)
+		host, fetched := hosts[key]
+		if !fetched {
+			prGitHub, _, err := s.githubClient().PullRequests.Get(context.Background(), s.Config.Org, claim.repo, number)
+			if err != nil {
+				logger.WithError(err).WithField("pr", key).Warn("Failed to get the PR of the SpinWick")
+			} else {
+				host = &model.PullRequest{
+					RepoOwner: s.Config.Org,
+					RepoName:  prGitHub.GetBase().GetRepo().GetName(),
+					Number:    number,
+					State:     prGitHub.GetState(),
+					Body:      prGitHub.GetBody(),
+				}
+			}
+			hosts[key] = host
+		}
+		if host == nil || host.State == "closed" {
+			continue
+		}
+
+		companions, _ := s.spinWickCompanions(host, spinWickOptions{})
+		if len(companions) > 0 {
+			s.linkCompanions(host, variant, companions)
+			loaded++
+		}
+	}
+	logger.WithField("spinwicks", loaded).Info("Loaded the companion PRs")
+}
+
+func (s *Server) getCompanionHosts(companion companionPR) []companionHost {
+	s.companionHostsLock.Lock()
+	defer s.companionHostsLock.Unlock()
+	return append([]companionHost{}, s.companionHosts[companion.key()]...)
+}
+
+// resolveCompanionPR fetches a companion PR from GitHub.
+func (s *Server) resolveCompanionPR(companion companionPR) (*model.PullRequest, error) {
+	pr, err := s.GetUpdateChecks(companion.Owner, companion.Repo, companion.Number)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get companion PR %s", companion)
+	}
+	if pr.State == "closed" {
+		return nil, errors.Errorf("companion PR %s is closed", companion)
+	}
+	return pr, nil
+}
+
+// companionServerImage returns the image and version of a server companion PR
+// build, waiting for the image to be published.
+func (s *Server) companionServerImage(companionPR *model.PullRequest, logger logrus.FieldLogger) (string, string, error) {
+	image := mattermostEEImage
+	version := s.Builds.getInstallationVersion(companionPR)
+
+	reg, err := s.Builds.dockerRegistryClient(s, image)
+	if err != nil {
+		return "", "", errors.Wrap(err, "unable to get docker registry client")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+	if err = s.Builds.waitForImage(ctx, reg, version, image, logger); err != nil {
+		return "", "", errors.Wrapf(err, "image for companion PR %s#%d not available", companionPR.RepoName, companionPR.Number)
+	}
+
+	return image, version, nil
+}
+
+// installCompanionPlugin installs the plugin build of a companion PR on the installation.
+func (s *Server) installCompanionPlugin(installationID string, companionPR *model.PullRequest, logger logrus.FieldLogger) error {
+	clusterInstallationID, err := s.getClusterInstallationID(installationID)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 45*time.Minute)
+	defer cancel()
+
+	result := s.waitForAndInstallPlugin(ctx, companionPR, clusterInstallationID, logger)
+	if result.InstallError != nil {
+		return result.InstallError
+	}
+	return result.EnableError
+}
+
+// installCompanionPlugins installs the plugin companion PRs on a new SpinWick and
+// returns the report for the success comment, starting with the given lines.
+// Failures are reported but do not fail the SpinWick.
+func (s *Server) installCompanionPlugins(installationID string, companions []companionPR, report []string, logger logrus.FieldLogger) string {
+	lines := report
+
+	for _, companion := range companions {
+		if !s.isPluginRepository(companion.Repo) {
+			continue
+		}
+		companionLogger := logger.WithField("companion", companion.String())
+
+		companionPR, err := s.resolveCompanionPR(companion)
+		if err == nil {
+			err = s.installCompanionPlugin(installationID, companionPR, companionLogger)
+		}
+		if err != nil {
+			companionLogger.WithError(err).Warn("Failed to install companion plugin")
+			lines = append(lines, fmt.Sprintf("- %s: :x: %s", companion, err.Error()))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("- %s: :white_check_mark: plugin installed from `%s`", companion, companionPR.Sha[0:7]))
+	}
+
+	if len(lines) == 0 {
+		return ""
+	}
+	return "**Companion PRs:**\n" + strings.Join(lines, "\n")
+}
+
+// refreshCompanionHosts updates the SpinWicks that include the given PR as a
+// companion after a new commit was pushed to it.
+func (s *Server) refreshCompanionHosts(companionPR *model.PullRequest) {
+	companion := companionFromPR(companionPR)
+	for _, host := range s.getCompanionHosts(companion) {
+		logger := s.Logger.WithFields(logrus.Fields{
+			"repo_name": host.pr.Repo,
+			"pr":        host.pr.Number,
+			"variant":   host.variant,
+			"companion": companion.String(),
+		})
+
+		hostPR, err := s.GetUpdateChecks(host.pr.Owner, host.pr.Repo, host.pr.Number)
+		if err != nil {
+			logger.WithError(err).Error("Failed to get companion host PR")
+			continue
+		}
+		// Named variants exist without a SpinWick label.
+		if hostPR.State == "closed" || (host.variant == "" && !s.isSpinWickLabelInLabels(hostPR.Labels)) {
+			continue
+		}
+
+		request := s.refreshCompanionHost(hostPR, host.variant, companionPR, logger)
+		if request.Error != nil && request.PRNotified {
+			logger.WithError(request.Error).Info("Skipped the companion refresh of the SpinWick")
+			continue
+		}
+		if request.Error != nil {
+			logger.WithError(request.Error).Error("Failed to refresh SpinWick with companion PR")
+			s.appendStatusComment(hostPR, statusSectionCompanions,
+				fmt.Sprintf(":x: Failed to update the SpinWick with companion PR %s: %s%s", companion, request.Error.Error(), variantSuffix(host.variant)))
+			if request.ReportError {
+				s.logPrettyErrorToMattermost("[ SpinWick ] Companion Update Failed", hostPR, request.Error, map[string]string{
+					"Installation ID": request.InstallationID,
+					"Companion PR":    companion.String(),
+				}, logger)
+			}
+			continue
+		}
+
+		s.appendStatusComment(hostPR, statusSectionCompanions,
+			fmt.Sprintf("SpinWick updated with companion PR %s at git commit `%s`.%s", companion, companionPR.Sha, variantSuffix(host.variant)))
+	}
+}
+
+func (s *Server) refreshCompanionHost(hostPR *model.PullRequest, variant string, companionPR *model.PullRequest, logger logrus.FieldLogger) *spinwick.Request {
+	request := &spinwick.Request{
+		InstallationID: "n/a",
+		Error:          nil,
+		ReportError:    false,
+		Aborted:        false,
+	}
+
+	ownerID := model.NewSpinwickVariant(hostPR.RepoName, hostPR.Number, variant, s.Config.DNSNameTestServer).RepeatableID
+	ctx, finish, ok := s.beginSpinWickOperation(context.Background(), ownerID, spinWickOperationUpdate, logger)
+	if !ok {
+		return request.WithError(errors.New("the SpinWick can no longer be updated")).IntentionalAbort().AlreadyNotifiedPR()
+	}
+	defer func() {
+		destroyedSpinWickOperation(ctx, request)
+		finish(request)
+	}()
+
+	installation, err := s.checkExistingInstallation(ownerID, logger)
+	if err != nil {
+		return request.WithError(err).ShouldReportError()
+	}
+	if installation == nil {
+		return request.WithError(errors.Errorf("no installation found with owner %s", ownerID)).IntentionalAbort()
+	}
+	request.InstallationID = installation.ID
+	logger = logger.WithField("installation_id", installation.ID)
+
+	if s.isPluginRepository(companionPR.RepoName) {
+		if err = s.installCompanionPlugin(installation.ID, companionPR, logger); err != nil {
+			return request.WithError(err)
+		}
+		return request
+	}
+
+	image, version, err := s.companionServerImage(companionPR, logger)
+	if err != nil {
+		return request.WithError(err)
+	}
+	upgradeRequest := &cloudModel.PatchInstallationRequest{
+		Version: &version,
+		Image:   &image,
+	}
+	// Errors are recorded on the request.
+	_, _ = s.updateInstallationAndWait(hostPR, request, upgradeRequest, 1200, logger)
+
+	return request
+}
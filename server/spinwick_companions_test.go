@@ -0,0 +1,188 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mattermost/matterwick/model"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCompanionsArg(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		input       string
+		expected    []companionPR
+		expectedErr string
+	}{
+		{
+			name:     "single PR",
+			input:    "mattermost/mattermost-plugin-jira#12",
+			expected: []companionPR{{Owner: "mattermost", Repo: "mattermost-plugin-jira", Number: 12}},
+		},
+		{
+			name:  "multiple PRs",
+			input: "mattermost/mattermost-plugin-jira#12, mattermost/mattermost#3",
+			expected: []companionPR{
+				{Owner: "mattermost", Repo: "mattermost-plugin-jira", Number: 12},
+				{Owner: "mattermost", Repo: "mattermost", Number: 3},
+			},
+		},
+		{
+			name:        "missing number",
+			input:       "mattermost/mattermost-plugin-jira",
+			expectedErr: `invalid companion PR "mattermost/mattermost-plugin-jira", expected org/repo#N`,
+		},
+		{
+			name:        "zero number",
+			input:       "mattermost/mattermost#0",
+			expectedErr: `invalid companion PR "mattermost/mattermost#0", expected org/repo#N`,
+		},
+		{
+			name:        "empty",
+			input:       "",
+			expectedErr: "no companion PRs found",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			companions, err := parseCompanionsArg(tc.input)
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, companions)
+		})
+	}
+}
+
+func TestSpinWickCompanions(t *testing.T) {
+	s := &Server{Logger: logrus.New()}
+
+	t.Run("server SpinWick", func(t *testing.T) {
+		pr := &model.PullRequest{
+			RepoOwner: "mattermost",
+			RepoName:  mattermostServerRepo,
+			Body:      "Adds a new API.\r\n\r\nSpinWick-With: mattermost/mattermost-plugin-jira#12\r\nspinwick-with: not-a-pr\nSpinWick-With: evil-org/mattermost-plugin-jira#1\n",
+		}
+		opts := spinWickOptions{companions: []companionPR{
+			{Owner: "mattermost", Repo: "mattermost-plugin-jira", Number: 12},
+			{Owner: "mattermost", Repo: "enterprise", Number: 4},
+		}}
+
+		companions, report := s.spinWickCompanions(pr, opts)
+		assert.Equal(t, []companionPR{{Owner: "mattermost", Repo: "mattermost-plugin-jira", Number: 12}}, companions)
+		assert.Equal(t, []string{
+			`- :warning: invalid companion PR "not-a-pr", expected org/repo#N`,
+			"- :warning: companion PR mattermost/enterprise#4 is not supported, use plugin PRs or a mattermost PR for plugin SpinWicks",
+			"- :warning: companion PR evil-org/mattermost-plugin-jira#1 is not in the mattermost organization",
+		}, report)
+	})
+
+	t.Run("plugin SpinWick", func(t *testing.T) {
+		pr := &model.PullRequest{RepoOwner: "mattermost", RepoName: "mattermost-plugin-playbooks"}
+		opts := spinWickOptions{companions: []companionPR{
+			{Owner: "mattermost", Repo: mattermostServerRepo, Number: 1},
+			{Owner: "mattermost", Repo: mattermostServerRepo, Number: 2},
+			{Owner: "mattermost", Repo: "mattermost-plugin-playbooks", Number: 3},
+		}}
+
+		companions, report := s.spinWickCompanions(pr, opts)
+		assert.Equal(t, []companionPR{{Owner: "mattermost", Repo: mattermostServerRepo, Number: 1}}, companions)
+		assert.Len(t, report, 2)
+		assert.Contains(t, report[0], "only one server PR can be used")
+		assert.Contains(t, report[1], "same repository as the SpinWick")
+	})
+}
+
+func TestCompanionHosts(t *testing.T) {
+	s := &Server{companionHosts: make(map[string][]companionHost)}
+
+	plugin := companionPR{Owner: "mattermost", Repo: "mattermost-plugin-jira", Number: 12}
+	host1 := &model.PullRequest{RepoOwner: "mattermost", RepoName: mattermostServerRepo, Number: 1}
+	host2 := &model.PullRequest{RepoOwner: "mattermost", RepoName: mattermostServerRepo, Number: 2}
+
+	s.linkCompanions(host1, "", []companionPR{plugin})
+	s.linkCompanions(host1, "", []companionPR{plugin})
+	s.linkCompanions(host1, "ha", []companionPR{plugin})
+	s.linkCompanions(host2, "", []companionPR{plugin})
+	assert.Equal(t, []companionHost{
+		{pr: companionFromPR(host1)},
+		{pr: companionFromPR(host1), variant: "ha"},
+		{pr: companionFromPR(host2)},
+	}, s.getCompanionHosts(plugin))
+
+	// Lookups are case-insensitive like GitHub repository names.
+	assert.Len(t, s.getCompanionHosts(companionPR{Owner: "Mattermost", Repo: "mattermost-plugin-jira", Number: 12}), 3)
+
+	s.unlinkCompanions(host1, "")
+	assert.Equal(t, []companionHost{
+		{pr: companionFromPR(host1), variant: "ha"},
+		{pr: companionFromPR(host2)},
+	}, s.getCompanionHosts(plugin))
+
+	s.unlinkCompanions(host1, "ha")
+	s.unlinkCompanions(host2, "")
+	assert.Empty(t, s.getCompanionHosts(plugin))
+	assert.Empty(t, s.companionHosts)
+}
+
+func TestLoadCompanionHosts(t *testing.T) {
+	cloud := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/installations" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`[
+			{"ID":"inst-1","OwnerID":"mattermost-pr-1","State":"stable"},
+			{"ID":"inst-2","OwnerID":"mattermost-pr-1-ha","State":"stable"},
+			{"ID":"inst-3","OwnerID":"mattermost-pr-2","State":"stable"},
+			{"ID":"inst-4","OwnerID":"mattermost-pr-3","State":"stable"},
+			{"ID":"inst-5","OwnerID":"desktop-pr-9-linux-0a1b2c3d","State":"stable"}
+		]`))
+	}))
+	defer cloud.Close()
+
+	var lookups []string
+	github := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lookups = append(lookups, r.URL.Path)
+		switch r.URL.Path {
+		case "/repos/mattermost/mattermost/pulls/1":
+			w.Write([]byte(`{"number":1,"state":"open","body":"SpinWick-With: mattermost/mattermost-plugin-jira#12","base":{"repo":{"name":"mattermost"}}}`))
+		case "/repos/mattermost/mattermost/pulls/2":
+			w.Write([]byte(`{"number":2,"state":"closed","body":"SpinWick-With: mattermost/mattermost-plugin-jira#12","base":{"repo":{"name":"mattermost"}}}`))
+		case "/repos/mattermost/mattermost/pulls/3":
+			w.Write([]byte(`{"number":3,"state":"open","body":"No companions.","base":{"repo":{"name":"mattermost"}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer github.Close()
+
+	s := &Server{
+		Logger:         logrus.New(),
+		Config:         &MatterwickConfig{Org: "mattermost", CloudGroupID: "spinwicks"},
+		CloudClient:    model.NewCloudClient(cloud.URL, "", "", "", ""),
+		githubAPIBase:  github.URL + "/",
+		companionHosts: make(map[string][]companionHost),
+	}
+	s.loadCompanionHosts()
+
+	assert.Equal(t, []string{
+		"/repos/mattermost/mattermost/pulls/1",
+		"/repos/mattermost/mattermost/pulls/2",
+		"/repos/mattermost/mattermost/pulls/3",
+	}, lookups, "each PR is fetched once")
+
+	host := &model.PullRequest{RepoOwner: "mattermost", RepoName: "mattermost", Number: 1}
+	assert.Equal(t, []companionHost{
+		{pr: companionFromPR(host)},
+		{pr: companionFromPR(host), variant: "ha"},
+	}, s.getCompanionHosts(companionPR{Owner: "mattermost", Repo: "mattermost-plugin-jira", Number: 12}))
+}
@@ -0,0 +1,298 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	cloudModel "github.com/mattermost/mattermost-cloud/model"
+	mattermostModel "github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/matterwick/internal/cloudtools"
+	"github.com/mattermost/matterwick/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var configSettingPathRegex = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*(\.[A-Za-z][A-Za-z0-9]*)+$`)
+
+// configSetting is a server config setting changed by /spinwick config set.
+type configSetting struct {
+	// Path is the dotted config path e.g. "ServiceSettings.EnableDeveloper".
+	Path  string
+	Value string
+}
+
+// envName returns the environment variable that overrides the setting.
+func (c configSetting) envName() string {
+	return "MM_" + strings.ToUpper(strings.ReplaceAll(c.Path, ".", "_"))
+}
+
+// parseConfigSettings parses settings in the format "Section.Key=value". Values
+// may contain commas, so every setting is a separate argument.
+func parseConfigSettings(args []string) ([]configSetting, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("no config settings found")
+	}
+
+	settings := make([]configSetting, 0, len(args))
+	seen := make(map[string]bool)
+	for _, arg := range args {
+		path, value, ok := strings.Cut(arg, "=")
+		if !ok || !configSettingPathRegex.MatchString(path) {
+			return nil, fmt.Errorf("invalid config setting %q, expected Section.Key=value", arg)
+		}
+		if seen[path] {
+			return nil, fmt.Errorf("config setting %q is listed more than once", path)
+		}
+		seen[path] = true
+		settings = append(settings, configSetting{Path: path, Value: value})
+	}
+	return settings, nil
+}
+
+// spinWickConfigArgs are the parsed arguments of /spinwick config.
+type spinWickConfigArgs struct {
+	// name is the SpinWick variant, empty for the default SpinWick.
+	name     string
+	settings []configSetting
+}
+
+// parseSpinwickConfigArgs parses the arguments of /spinwick config.
+func parseSpinwickConfigArgs(args []string) (spinWickConfigArgs, string, error) {
+	var parsedArgs spinWickConfigArgs
+	if len(args) == 0 || args[0] != "set" {
+		return parsedArgs, spinwickConfigUsageString, fmt.Errorf("expected config set")
+	}
+
+	var outBuf bytes.Buffer
+	flagset := flag.NewFlagSet("spinwick config set", flag.ContinueOnError)
+	flagset.SetOutput(&outBuf)
+	flagset.StringVar(&parsedArgs.name, "name", "", "An optional name of the SpinWick variant")
+
+	err := flagset.Parse(args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return parsedArgs, outBuf.String(), err
+	} else if err != nil {
+		return parsedArgs, outBuf.String(), fmt.Errorf("failed to parse args: %w", err)
+	}
+
+	if err = validateVariantName(parsedArgs.name); err != nil {
+		return parsedArgs, err.Error(), fmt.Errorf("failed to parse name: %w", err)
+	}
+
+	parsedArgs.settings, err = parseConfigSettings(flagset.Args())
+	if err != nil {
+		return parsedArgs, err.Error(), fmt.Errorf("failed to parse config settings: %w", err)
+	}
+
+	return parsedArgs, "", nil
+}
+
+var spinwickConfigUsageString = `Usage: /spinwick config set [--name <variant>] Section.Key=value [Section.Key=value...]
+
+Examples:
+  /spinwick config set ServiceSettings.EnableDeveloper=true
+  /spinwick config set --name ha TeamSettings.MaxUsersPerTeam=500 LogSettings.ConsoleLevel=DEBUG
+`
+
+// configValue converts the setting value to the type of its current value in
+// the config decoded as a JSON object, so only existing settings can be changed.
+func configValue(config map[string]interface{}, setting configSetting) (interface{}, error) {
+	keys := strings.Split(setting.Path, ".")
+	section := config
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := section[key].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unknown config setting %q", setting.Path)
+		}
+		section = next
+	}
+
+	current, ok := section[keys[len(keys)-1]]
+	if !ok {
+		return nil, fmt.Errorf("unknown config setting %q", setting.Path)
+	}
+
+	switch current.(type) {
+	case bool:
+		value, err := strconv.ParseBool(setting.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q for %s, expected true or false", setting.Value, setting.Path)
+		}
+		return value, nil
+	case float64:
+		value, err := strconv.ParseFloat(setting.Value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q for %s, expected a number", setting.Value, setting.Path)
+		}
+		return value, nil
+	case string:
+		return setting.Value, nil
+	case map[string]interface{}:
+		return nil, fmt.Errorf("%s is a config section, not a setting", setting.Path)
+	default:
+		// Lists and unset values are given as JSON, or as a plain string.
+		var value interface{}
+		if err := json.Unmarshal([]byte(setting.Value), &value); err != nil {
+			return setting.Value, nil
+		}
+		return value, nil
+	}
+}
+
+// setConfigPatchValue sets a value at the dotted path of a config patch,
+// creating the sections on the way.
+func setConfigPatchValue(patch map[string]interface{}, path string, value interface{}) {
+	keys := strings.Split(path, ".")
+	section := patch
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := section[key].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			section[key] = next
+		}
+		section = next
+	}
+	section[keys[len(keys)-1]] = value
+}
+
+// isEnvLocked reports whether the setting is overridden by an environment
+// variable, according to the server's environment config.
+func isEnvLocked(envConfig map[string]interface{}, path string) bool {
+	var current interface{} = envConfig
+	for _, key := range strings.Split(path, ".") {
+		section, ok := current.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		current = section[key]
+	}
+	locked, _ := current.(bool)
+	return locked
+}
+
+// patchSpinWickConfig logs in to a SpinWick as sysadmin and patches its config
+// through the API, which applies without restarting the server. Settings locked
+// by environment variables cannot be changed that way and are returned as the
+// env patch to apply instead.
+func patchSpinWickConfig(mmURL, sysadminPassword string, settings []configSetting, logger logrus.FieldLogger) (cloudModel.EnvVarMap, error) {
+	client := mattermostModel.NewAPIv4Client(mmURL)
+	if _, _, err := client.Login(spinWickSysadminUsername, sysadminPassword); err != nil {
+		return nil, errors.Wrap(err, "failed to log in as sysadmin")
+	}
+
+	config, _, err := client.GetConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get the server config")
+	}
+	envConfig, _, err := client.GetEnvironmentConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get the environment config")
+	}
+
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode the server config")
+	}
+	var configMap map[string]interface{}
+	if err = json.Unmarshal(configJSON, &configMap); err != nil {
+		return nil, errors.Wrap(err, "failed to decode the server config")
+	}
+
+	// Only the changed settings are sent, so the sanitized secrets of the
+	// fetched config are never written back.
+	envMap := make(cloudModel.EnvVarMap)
+	patchMap := make(map[string]interface{})
+	for _, setting := range settings {
+		value, valueErr := configValue(configMap, setting)
+		if valueErr != nil {
+			return nil, valueErr
+		}
+		if isEnvLocked(envConfig, setting.Path) {
+			logger.WithField("setting", setting.Path).Info("Config setting is locked by the environment")
+			envMap[setting.envName()] = cloudModel.EnvVar{Value: setting.Value}
+			continue
+		}
+		setConfigPatchValue(patchMap, setting.Path, value)
+	}
+	if len(patchMap) == 0 {
+		return envMap, nil
+	}
+
+	configJSON, err = json.Marshal(patchMap)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode the patched config")
+	}
+	var patch mattermostModel.Config
+	if err = json.Unmarshal(configJSON, &patch); err != nil {
+		return nil, errors.Wrap(err, "failed to decode the patched config")
+	}
+	if _, _, err = client.PatchConfig(&patch); err != nil {
+		return nil, errors.Wrap(err, "failed to patch the server config")
+	}
+
+	return envMap, nil
+}
+
+// formatConfigSettings renders the changed settings and how each was applied
+// as a markdown table.
+func formatConfigSettings(settings []configSetting, envMap cloudModel.EnvVarMap) string {
+	var sb strings.Builder
+	sb.WriteString("| Setting | Value | Applied |\n|---|---|---|")
+	for _, setting := range settings {
+		applied := "Live"
+		if _, ok := envMap[setting.envName()]; ok {
+			applied = fmt.Sprintf("Environment (`%s`, restarts the server)", setting.envName())
+		}
+		sb.WriteString(fmt.Sprintf("\n| %s | `%s` | %s |", setting.Path, setting.Value, applied))
+	}
+	return sb.String()
+}
+
+// handleSetSpinWickConfig changes server config settings on the PR's SpinWick.
+// Env-locked settings are passed to applyEnv, which updates the installation env.
+func (s *Server) handleSetSpinWickConfig(pr *model.PullRequest, args spinWickConfigArgs, applyEnv func(envMap cloudModel.EnvVarMap)) {
+	logger := s.Logger.WithFields(logrus.Fields{"repo_name": pr.RepoName, "pr": pr.Number, "variant": args.name})
+
+	spinwick := model.NewSpinwickVariant(pr.RepoName, pr.Number, args.name, s.Config.DNSNameTestServer)
+	installation, err := cloudtools.GetInstallationIDFromOwnerID(s.CloudClient, s.Config.ProvisionerServer, spinwick.RepeatableID)
+	if err != nil {
+		logger.WithError(err).Error("Failed to get SpinWick installation")
+		s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, "Failed to get the SpinWick installation."+variantSuffix(args.name))
+		return
+	}
+	if installation == nil {
+		s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, "No SpinWick found for this PR."+variantSuffix(args.name))
+		return
+	}
+
+	password := s.getSpinWickCredentials(spinwick.RepeatableID).password(spinWickSysadminUsername)
+	if password == "" {
+		s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number,
+			"The sysadmin credentials of the SpinWick are not available, use `/spinwick update --env` to change its config instead."+variantSuffix(args.name))
+		return
+	}
+
+	mmURL := fmt.Sprintf("https://%s", cloudtools.GetInstallationDNSFromDNSRecords(installation))
+	envMap, err := patchSpinWickConfig(mmURL, password, args.settings, logger)
+	if err != nil {
+		logger.WithError(err).Error("Failed to patch SpinWick config")
+		s.updateStatusComment(pr, statusSectionSettings,
+			fmt.Sprintf(":x: Failed to change the SpinWick config%s: %s", variantSuffix(args.name), err.Error()))
+		return
+	}
+
+	s.updateStatusComment(pr, statusSectionSettings,
+		fmt.Sprintf("Changed the SpinWick config%s:\n\n%s", variantSuffix(args.name), formatConfigSettings(args.settings, envMap)))
+
+	if len(envMap) > 0 {
+		applyEnv(envMap)
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	cloudModel "github.com/mattermost/mattermost-cloud/model"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSpinwickConfigArgs(t *testing.T) {
+	t.Run("settings", func(t *testing.T) {
+		parsedArgs, _, err := parseSpinwickConfigArgs([]string{"set", "--name", "ha", "ServiceSettings.EnableDeveloper=true", "SupportSettings.HelpLink=https://example.com/?a=b,c"})
+		require.NoError(t, err)
+		assert.Equal(t, "ha", parsedArgs.name)
+		assert.Equal(t, []configSetting{
+			{Path: "ServiceSettings.EnableDeveloper", Value: "true"},
+			{Path: "SupportSettings.HelpLink", Value: "https://example.com/?a=b,c"},
+		}, parsedArgs.settings)
+	})
+
+	for name, args := range map[string][]string{
+		"no subcommand":     {},
+		"unknown command":   {"get", "ServiceSettings.SiteURL"},
+		"no settings":       {"set"},
+		"missing value":     {"set", "ServiceSettings.EnableDeveloper"},
+		"missing section":   {"set", "EnableDeveloper=true"},
+		"duplicate setting": {"set", "LogSettings.ConsoleLevel=DEBUG", "LogSettings.ConsoleLevel=INFO"},
+		"invalid name":      {"set", "--name", "Not_Valid", "LogSettings.ConsoleLevel=DEBUG"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, output, err := parseSpinwickConfigArgs(args)
+			assert.Error(t, err)
+			assert.NotEmpty(t, output)
+		})
+	}
+}
+
+func TestConfigValue(t *testing.T) {
+	config := map[string]interface{}{
+		"ServiceSettings": map[string]interface{}{
+			"EnableDeveloper":      false,
+			"MaximumLoginAttempts": float64(10),
+			"SiteURL":              "",
+			"AllowCorsFrom":        nil,
+		},
+		"PluginSettings": map[string]interface{}{
+			"SignaturePublicKeyFiles": []interface{}{},
+		},
+	}
+
+	for setting, expected := range map[configSetting]interface{}{
+		{Path: "ServiceSettings.EnableDeveloper", Value: "true"}:             true,
+		{Path: "ServiceSettings.MaximumLoginAttempts", Value: "50"}:          float64(50),
+		{Path: "ServiceSettings.SiteURL", Value: "https://example.com"}:      "https://example.com",
+		{Path: "ServiceSettings.AllowCorsFrom", Value: "*"}:                  "*",
+		{Path: "PluginSettings.SignaturePublicKeyFiles", Value: `["a.key"]`}: []interface{}{"a.key"},
+	} {
+		value, err := configValue(config, setting)
+		require.NoError(t, err, setting.Path)
+		assert.Equal(t, expected, value, setting.Path)
+	}
+
+	for setting, expectedErr := range map[configSetting]string{
+		{Path: "ServiceSettings.Unknown", Value: "1"}:                    `unknown config setting "ServiceSettings.Unknown"`,
+		{Path: "UnknownSettings.Key", Value: "1"}:                        `unknown config setting "UnknownSettings.Key"`,
+		{Path: "PluginSettings.SignaturePublicKeyFiles.Key", Value: "1"}: `unknown config setting "PluginSettings.SignaturePublicKeyFiles.Key"`,
+		{Path: "ServiceSettings.EnableDeveloper", Value: "no"}:           `invalid value "no" for ServiceSettings.EnableDeveloper, expected true or false`,
+		{Path: "ServiceSettings.MaximumLoginAttempts", Value: "many"}:    `invalid value "many" for ServiceSettings.MaximumLoginAttempts, expected a number`,
+	} {
+		_, err := configValue(config, setting)
+		assert.EqualError(t, err, expectedErr)
+	}
+}
+
+func TestIsEnvLocked(t *testing.T) {
+	envConfig := map[string]interface{}{
+		"ServiceSettings": map[string]interface{}{"SiteURL": true},
+	}
+
+	assert.True(t, isEnvLocked(envConfig, "ServiceSettings.SiteURL"))
+	assert.False(t, isEnvLocked(envConfig, "ServiceSettings.EnableDeveloper"))
+	assert.False(t, isEnvLocked(envConfig, "LogSettings.ConsoleLevel"))
+	assert.False(t, isEnvLocked(envConfig, "ServiceSettings.SiteURL.Nested"))
+}
+
+// configAPIMock is a minimal Mattermost API serving a config and recording the
+// patches it receives.
+type configAPIMock struct {
+	envConfig string
+	patches   []map[string]interface{}
+}
+
+func (m *configAPIMock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/api/v4/users/login":
+		w.Header().Set("Token", "token")
+		w.Write([]byte(`{"id":"sysadmin-id","username":"sysadmin"}`))
+	case "/api/v4/config":
+		w.Write([]byte(`{"ServiceSettings":{"SiteURL":"https://spinwick.example.com","EnableDeveloper":false},"LogSettings":{"ConsoleLevel":"INFO"}}`))
+	case "/api/v4/config/environment":
+		w.Write([]byte(m.envConfig))
+	case "/api/v4/config/patch":
+		body, _ := io.ReadAll(r.Body)
+		var patch map[string]interface{}
+		json.Unmarshal(body, &patch)
+		m.patches = append(m.patches, patch)
+		w.Write(body)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestPatchSpinWickConfig(t *testing.T) {
+	t.Run("live and env-locked settings", func(t *testing.T) {
+		mock := &configAPIMock{envConfig: `{"ServiceSettings":{"SiteURL":true}}`}
+		ts := httptest.NewServer(mock)
+		defer ts.Close()
+
+		settings := []configSetting{
+			{Path: "ServiceSettings.EnableDeveloper", Value: "true"},
+			{Path: "ServiceSettings.SiteURL", Value: "https://other.example.com"},
+		}
+		envMap, err := patchSpinWickConfig(ts.URL, "password", settings, logrus.New())
+		require.NoError(t, err)
+		assert.Equal(t, cloudModel.EnvVarMap{"MM_SERVICESETTINGS_SITEURL": {Value: "https://other.example.com"}}, envMap)
+
+		require.Len(t, mock.patches, 1)
+		serviceSettings := mock.patches[0]["ServiceSettings"].(map[string]interface{})
+		assert.Equal(t, true, serviceSettings["EnableDeveloper"])
+		assert.Nil(t, serviceSettings["SiteURL"], "env-locked settings are not patched")
+		logSettings := mock.patches[0]["LogSettings"].(map[string]interface{})
+		assert.Nil(t, logSettings["ConsoleLevel"], "unchanged settings are not patched")
+
+		assert.Equal(t, "| Setting | Value | Applied |\n|---|---|---|\n"+
+			"| ServiceSettings.EnableDeveloper | `true` | Live |\n"+
+			"| ServiceSettings.SiteURL | `https://other.example.com` | Environment (`MM_SERVICESETTINGS_SITEURL`, restarts the server) |",
+			formatConfigSettings(settings, envMap))
+	})
+
+	t.Run("only env-locked settings", func(t *testing.T) {
+		mock := &configAPIMock{envConfig: `{"ServiceSettings":{"SiteURL":true}}`}
+		ts := httptest.NewServer(mock)
+		defer ts.Close()
+
+		envMap, err := patchSpinWickConfig(ts.URL, "password", []configSetting{{Path: "ServiceSettings.SiteURL", Value: "https://other.example.com"}}, logrus.New())
+		require.NoError(t, err)
+		assert.Len(t, envMap, 1)
+		assert.Empty(t, mock.patches)
+	})
+
+	t.Run("unknown setting", func(t *testing.T) {
+		mock := &configAPIMock{envConfig: `{}`}
+		ts := httptest.NewServer(mock)
+		defer ts.Close()
+
+		_, err := patchSpinWickConfig(ts.URL, "password", []configSetting{{Path: "LogSettings.Unknown", Value: "1"}}, logrus.New())
+		assert.EqualError(t, err, `unknown config setting "LogSettings.Unknown"`)
+		assert.Empty(t, mock.patches)
+	})
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+
+	"github.com/mattermost/matterwick/internal/cloudtools"
+	"github.com/mattermost/matterwick/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// parseSpinwickCredentialsArgs parses the arguments of /spinwick credentials
+// and returns the SpinWick variant name.
+func parseSpinwickCredentialsArgs(args []string) (string, string, error) {
+	if len(args) == 0 || args[0] != "reset" {
+		return "", spinwickCredentialsUsageString, fmt.Errorf("expected credentials reset")
+	}
+
+	var outBuf bytes.Buffer
+	flagset := flag.NewFlagSet("spinwick credentials reset", flag.ContinueOnError)
+	flagset.SetOutput(&outBuf)
+
+	var name string
+	flagset.StringVar(&name, "name", "", "An optional name of the SpinWick variant")
+
+	err := flagset.Parse(args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return "", outBuf.String(), err
+	} else if err != nil {
+		return "", outBuf.String(), fmt.Errorf("failed to parse args: %w", err)
+	}
+	if flagset.NArg() > 0 {
+		return "", spinwickCredentialsUsageString, fmt.Errorf("unexpected arguments for credentials reset")
+	}
+
+	if err = validateVariantName(name); err != nil {
+		return "", err.Error(), fmt.Errorf("failed to parse name: %w", err)
+	}
+
+	return name, "", nil
+}
+
+var spinwickCredentialsUsageString = `Usage: /spinwick credentials reset [--name <variant>]
+
+Rotates the passwords of the SpinWick accounts and posts them in the credentials channel again.
+`
+
+// defaultSpinWickCredentials are the accounts every SpinWick has, used when
+// matterwick did not store the accounts of a SpinWick, e.g. after a restart.
+func defaultSpinWickCredentials() spinWickCredentials {
+	return spinWickCredentials{
+		{AccountType: "Admin", Username: spinWickSysadminUsername},
+		{AccountType: "User", Username: spinWickUserUsername},
+	}
+}
+
+// resetSpinWickCredentials sets new passwords for the accounts through mmctl,
+// which needs no current password. Bot access tokens are kept. A failed reset
+// is shown in the returned credentials, and an error is only returned if the
+// sysadmin password could not be reset.
+func (s *Server) resetSpinWickCredentials(installationID string, credentials spinWickCredentials, logger logrus.FieldLogger) (spinWickCredentials, error) {
+	ciID, err := s.getClusterInstallationID(installationID)
+	if err != nil {
+		return nil, err
+	}
+
+	reset := make(spinWickCredentials, 0, len(credentials))
+	for _, credential := range credentials {
+		if credential.AccountType == spinWickRoleAccountTypes[spinWickRoleBot] {
+			reset = append(reset, credential)
+			continue
+		}
+
+		password, err := generateSecurePassword()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to generate password")
+		}
+		_, err = s.execMmctl(ciID, "user", "change-password", credential.Username, "--password", password)
+		if err != nil {
+			if credential.Username == spinWickSysadminUsername {
+				return nil, errors.Wrap(err, "failed to reset the sysadmin password")
+			}
+			logger.WithError(err).WithField("username", credential.Username).Warn("Failed to reset password")
+			password = "_reset failed_"
+		}
+		credential.Password = password
+		reset = append(reset, credential)
+	}
+
+	return reset, nil
+}
+
+// handleResetSpinWickCredentials rotates the passwords of the PR's SpinWick and
+// posts them in the credentials channel again.
+func (s *Server) handleResetSpinWickCredentials(pr *model.PullRequest, name string) {
+	logger := s.Logger.WithFields(logrus.Fields{"repo_name": pr.RepoName, "pr": pr.Number, "variant": name})
+
+	// Without the webhook the new passwords could not be delivered.
+	if s.Config.MattermostCredentialsWebhookURL == "" {
+		logger.Warn("No Mattermost credentials webhook URL set: unable to reset credentials")
+		s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, "SpinWick credentials cannot be reset because no credentials channel is configured.")
+		return
+	}
+
+	spinwick := model.NewSpinwickVariant(pr.RepoName, pr.Number, name, s.Config.DNSNameTestServer)
+	installation, err := cloudtools.GetInstallationIDFromOwnerID(s.CloudClient, s.Config.ProvisionerServer, spinwick.RepeatableID)
+	if err != nil {
+		logger.WithError(err).Error("Failed to get SpinWick installation")
+		s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, "Failed to get the SpinWick installation."+variantSuffix(name))
+		return
+	}
+	if installation == nil {
+		s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, "No SpinWick found for this PR."+variantSuffix(name))
+		return
+	}
+	logger = logger.WithField("installation_id", installation.ID)
+
+	credentials := s.getSpinWickCredentials(spinwick.RepeatableID)
+	if len(credentials) == 0 {
+		credentials = defaultSpinWickCredentials()
+	}
+
+	credentials, err = s.resetSpinWickCredentials(installation.ID, credentials, logger)
+	if err != nil {
+		logger.WithError(err).Error("Failed to reset SpinWick credentials")
+		s.updateStatusComment(pr, statusSectionSettings, ":x: Failed to reset the SpinWick credentials."+variantSuffix(name))
+		return
+	}
+	s.setSpinWickCredentials(spinwick.RepeatableID, credentials)
+
+	spinwickURL := fmt.Sprintf("https://%s", cloudtools.GetInstallationDNSFromDNSRecords(installation))
+	mmMsg := fmt.Sprintf("## Spinwick credentials reset for PR #%d%s\n---\n**Repository:** %s/%s\n**Pull Request:** [#%d](%s)\n\n**Test Server:** %s\n\n### Credentials\n%s",
+		pr.Number, variantSuffix(name), pr.RepoOwner, pr.RepoName, pr.Number, pr.URL, spinwickURL, credentials.table())
+	if s.Config.MattermostWebhookFooter != "" {
+		mmMsg += "\n---\n" + s.Config.MattermostWebhookFooter
+	}
+	if err = s.postToCredentialsWebhook(mmMsg); err != nil {
+		logger.WithError(err).Error("Unable to post credentials to Mattermost webhook")
+		s.updateStatusComment(pr, statusSectionSettings,
+			":warning: The SpinWick credentials were reset but could not be posted to the credentials channel, reset them again to retry."+variantSuffix(name))
+		return
+	}
+
+	githubMsg := "**Credentials reset:** The new credentials are posted securely in the internal Mattermost channel."
+	if s.Config.MattermostCredentialsChannelURL != "" {
+		githubMsg = fmt.Sprintf("**Credentials reset:** The new credentials are posted securely in [this Mattermost channel](%s) - Look for PR #%d", s.Config.MattermostCredentialsChannelURL, pr.Number)
+	}
+	s.updateStatusComment(pr, statusSectionSettings, githubMsg+variantSuffix(name))
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/matterwick/model"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSpinwickCredentialsArgs(t *testing.T) {
+	name, _, err := parseSpinwickCredentialsArgs([]string{"reset"})
+	require.NoError(t, err)
+	assert.Empty(t, name)
+
+	name, _, err = parseSpinwickCredentialsArgs([]string{"reset", "--name", "ha"})
+	require.NoError(t, err)
+	assert.Equal(t, "ha", name)
+
+	for _, args := range [][]string{{}, {"show"}, {"reset", "sysadmin"}, {"reset", "--name", "Not_Valid"}} {
+		_, output, err := parseSpinwickCredentialsArgs(args)
+		assert.Error(t, err, args)
+		assert.NotEmpty(t, output, args)
+	}
+}
+
+func newCredentialsTestServer(t *testing.T, failFor string) (*Server, *[]string) {
+	var commands []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/cluster_installations":
+			w.Write([]byte(`[{"ID":"ci-1"}]`))
+		case "/api/cluster_installation/ci-1/exec/mmctl":
+			var args []string
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&args))
+			commands = append(commands, strings.Join(args[:5], " "))
+			if args[4] == failFor {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	return &Server{
+		Config:      &MatterwickConfig{},
+		Logger:      logrus.New(),
+		CloudClient: model.NewCloudClient(ts.URL, "", "", "", ""),
+	}, &commands
+}
+
+func TestResetSpinWickCredentials(t *testing.T) {
+	credentials := spinWickCredentials{
+		{AccountType: "Admin", Username: "sysadmin", Password: "old-admin"},
+		{AccountType: "User", Username: "user-1", Password: "old-user"},
+		{AccountType: "Bot (access token)", Username: "bot1", Password: "token"},
+		{AccountType: "Guest", Username: "guest1", Password: "old-guest"},
+	}
+
+	t.Run("rotates passwords and keeps bot tokens", func(t *testing.T) {
+		s, commands := newCredentialsTestServer(t, "guest1")
+
+		reset, err := s.resetSpinWickCredentials("inst-1", credentials, s.Logger)
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			"--local --json user change-password sysadmin",
+			"--local --json user change-password user-1",
+			"--local --json user change-password guest1",
+		}, *commands)
+
+		require.Len(t, reset, 4)
+		assert.NotEqual(t, "old-admin", reset.password("sysadmin"))
+		assert.Len(t, reset.password("sysadmin"), 32)
+		assert.NotEqual(t, "old-user", reset.password("user-1"))
+		assert.Equal(t, "token", reset.password("bot1"))
+		assert.Equal(t, "_reset failed_", reset.password("guest1"))
+		assert.Equal(t, "old-admin", credentials.password("sysadmin"), "the stored credentials are not modified")
+	})
+
+	t.Run("sysadmin reset failure", func(t *testing.T) {
+		s, _ := newCredentialsTestServer(t, "sysadmin")
+
+		_, err := s.resetSpinWickCredentials("inst-1", credentials, s.Logger)
+		assert.ErrorContains(t, err, "failed to reset the sysadmin password")
+		assert.ErrorContains(t, err, "mmctl user change-password sysadmin --password <redacted>", "the new password is kept out of the error")
+	})
+}
+
+func TestRedactMmctlArgs(t *testing.T) {
+	assert.Equal(t,
+		[]string{"user", "change-password", "sysadmin", "--password", "<redacted>"},
+		redactMmctlArgs([]string{"user", "change-password", "sysadmin", "--password", "secret"}))
+	assert.Equal(t,
+		[]string{"user", "change-password", "sysadmin", "--password=<redacted>"},
+		redactMmctlArgs([]string{"user", "change-password", "sysadmin", "--password=secret"}))
+	assert.Equal(t, []string{"export", "list"}, redactMmctlArgs([]string{"export", "list"}))
+}
+
+func TestPostToCredentialsWebhook(t *testing.T) {
+	var received WebhookRequest
+	status := http.StatusOK
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	s := &Server{Config: &MatterwickConfig{MattermostCredentialsWebhookURL: ts.URL}}
+
+	require.NoError(t, s.postToCredentialsWebhook("new credentials"))
+	assert.Equal(t, WebhookRequest{Username: "MatterWick", Text: "new credentials"}, received)
+
+	status = http.StatusBadRequest
+	assert.EqualError(t, s.postToCredentialsWebhook("new credentials"), "webhook returned status code 400")
+}
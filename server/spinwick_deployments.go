@@ -0,0 +1,112 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-github/v32/github"
+	"github.com/mattermost/matterwick/internal/spinwick"
+	"github.com/mattermost/matterwick/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// GitHub deployment states used for SpinWicks.
+const (
+	deploymentStateInProgress = "in_progress"
+	deploymentStateSuccess    = "success"
+	deploymentStateFailure    = "failure"
+	deploymentStateInactive   = "inactive"
+)
+
+// spinWickEnvironment returns the GitHub deployment environment of a SpinWick.
+func spinWickEnvironment(prNumber int, variant string) string {
+	environment := fmt.Sprintf("spinwick-pr-%d", prNumber)
+	if variant != "" {
+		environment += "-" + variant
+	}
+	return environment
+}
+
+// startSpinWickDeployment creates a GitHub deployment of the PR's head commit
+// for the SpinWick and marks it in progress, so the PR timeline links to the
+// SpinWick once it is ready.
+func (s *Server) startSpinWickDeployment(pr *model.PullRequest, variant string, logger logrus.FieldLogger) {
+	ctx := context.Background()
+	client := s.githubClient()
+	environment := spinWickEnvironment(pr.Number, variant)
+
+	// Required contexts are skipped as the SpinWick is built before the
+	// PR checks finish.
+	deployment, _, err := client.Repositories.CreateDeployment(ctx, pr.RepoOwner, pr.RepoName, &github.DeploymentRequest{
+		Ref:                   github.String(pr.Sha),
+		Environment:           github.String(environment),
+		Description:           github.String("SpinWick test server"),
+		AutoMerge:             github.Bool(false),
+		RequiredContexts:      &[]string{},
+		TransientEnvironment:  github.Bool(true),
+		ProductionEnvironment: github.Bool(false),
+	})
+	if err != nil {
+		logger.WithError(err).WithField("environment", environment).Warn("Failed to create the SpinWick deployment")
+		return
+	}
+
+	if err = s.createDeploymentStatus(ctx, client, pr, deployment.GetID(), deploymentStateInProgress, ""); err != nil {
+		logger.WithError(err).WithField("environment", environment).Warn("Failed to set the SpinWick deployment status")
+	}
+}
+
+// finishSpinWickDeployment sets the status of the SpinWick deployment from the
+// result of the request. Aborted requests leave the SpinWick as it was, so
+// their deployment is marked inactive instead of failed.
+func (s *Server) finishSpinWickDeployment(pr *model.PullRequest, variant string, request *spinwick.Request, logger logrus.FieldLogger) {
+	switch {
+	case request.Error == nil:
+		s.setSpinWickDeploymentStatus(pr, variant, deploymentStateSuccess, request.URL, logger)
+	case request.Aborted:
+		s.setSpinWickDeploymentStatus(pr, variant, deploymentStateInactive, "", logger)
+	default:
+		s.setSpinWickDeploymentStatus(pr, variant, deploymentStateFailure, "", logger)
+	}
+}
+
+// setSpinWickDeploymentStatus sets the status of the latest deployment of the
+// SpinWick. The deployment is looked up on GitHub, so statuses can also be
+// set for deployments created before a restart.
+func (s *Server) setSpinWickDeploymentStatus(pr *model.PullRequest, variant, state, environmentURL string, logger logrus.FieldLogger) {
+	ctx := context.Background()
+	client := s.githubClient()
+	environment := spinWickEnvironment(pr.Number, variant)
+	logger = logger.WithFields(logrus.Fields{"environment": environment, "state": state})
+
+	deployments, _, err := client.Repositories.ListDeployments(ctx, pr.RepoOwner, pr.RepoName, &github.DeploymentsListOptions{
+		Environment: environment,
+		ListOptions: github.ListOptions{PerPage: 1},
+	})
+	if err != nil {
+		logger.WithError(err).Warn("Failed to get the SpinWick deployment")
+		return
+	}
+	if len(deployments) == 0 {
+		logger.Debug("No SpinWick deployment found")
+		return
+	}
+
+	if err = s.createDeploymentStatus(ctx, client, pr, deployments[0].GetID(), state, environmentURL); err != nil {
+		logger.WithError(err).Warn("Failed to set the SpinWick deployment status")
+	}
+}
+
+func (s *Server) createDeploymentStatus(ctx context.Context, client *github.Client, pr *model.PullRequest, deploymentID int64, state, environmentURL string) error {
+	status := &github.DeploymentStatusRequest{State: github.String(state)}
+	if environmentURL != "" {
+		status.EnvironmentURL = github.String(environmentURL)
+	}
+
+	_, _, err := client.Repositories.CreateDeploymentStatus(ctx, pr.RepoOwner, pr.RepoName, deploymentID, status)
+	return errors.Wrapf(err, "failed to create %s deployment status", state)
+}
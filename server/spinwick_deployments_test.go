@@ -0,0 +1,122 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mattermost/matterwick/internal/spinwick"
+	"github.com/mattermost/matterwick/model"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpinWickEnvironment(t *testing.T) {
+	assert.Equal(t, "spinwick-pr-42", spinWickEnvironment(42, ""))
+	assert.Equal(t, "spinwick-pr-42-ha", spinWickEnvironment(42, "ha"))
+}
+
+// deploymentsGitHubMock is a minimal GitHub API recording deployments and
+// their statuses.
+type deploymentsGitHubMock struct {
+	deployments []map[string]interface{}
+	statuses    map[int64][]map[string]interface{}
+}
+
+func (m *deploymentsGitHubMock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/repos/mattermost/mattermost-server/deployments" && r.Method == http.MethodPost:
+		var deployment map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&deployment)
+		deployment["id"] = len(m.deployments) + 1
+		m.deployments = append(m.deployments, deployment)
+		json.NewEncoder(w).Encode(deployment)
+	case r.URL.Path == "/repos/mattermost/mattermost-server/deployments":
+		// Newest first, like GitHub.
+		var deployments []map[string]interface{}
+		for i := len(m.deployments) - 1; i >= 0; i-- {
+			if m.deployments[i]["environment"] == r.URL.Query().Get("environment") {
+				deployments = append(deployments, m.deployments[i])
+			}
+		}
+		json.NewEncoder(w).Encode(deployments)
+	case r.Method == http.MethodPost:
+		var id int64
+		fmt.Sscanf(r.URL.Path, "/repos/mattermost/mattermost-server/deployments/%d/statuses", &id)
+		if id < 1 || id > int64(len(m.deployments)) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var status map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&status)
+		m.statuses[id] = append(m.statuses[id], status)
+		json.NewEncoder(w).Encode(status)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestSpinWickDeployments(t *testing.T) {
+	mock := &deploymentsGitHubMock{statuses: make(map[int64][]map[string]interface{})}
+	ts := httptest.NewServer(mock)
+	defer ts.Close()
+
+	s := &Server{
+		Logger:        logrus.New(),
+		Config:        &MatterwickConfig{},
+		githubAPIBase: ts.URL + "/",
+	}
+	pr := &model.PullRequest{RepoOwner: "mattermost", RepoName: "mattermost-server", Number: 42, Sha: "abc123"}
+
+	t.Run("no deployment yet", func(t *testing.T) {
+		s.setSpinWickDeploymentStatus(pr, "", deploymentStateInactive, "", s.Logger)
+		assert.Empty(t, mock.statuses)
+	})
+
+	t.Run("create", func(t *testing.T) {
+		s.startSpinWickDeployment(pr, "", s.Logger)
+		require.Len(t, mock.deployments, 1)
+		assert.Equal(t, "abc123", mock.deployments[0]["ref"])
+		assert.Equal(t, "spinwick-pr-42", mock.deployments[0]["environment"])
+		assert.Equal(t, []interface{}{}, mock.deployments[0]["required_contexts"])
+		assert.Equal(t, true, mock.deployments[0]["transient_environment"])
+
+		s.finishSpinWickDeployment(pr, "", (&spinwick.Request{}).WithURL("https://spinwick.example.com"), s.Logger)
+		require.Len(t, mock.statuses[1], 2)
+		assert.Equal(t, deploymentStateInProgress, mock.statuses[1][0]["state"])
+		assert.Equal(t, deploymentStateSuccess, mock.statuses[1][1]["state"])
+		assert.Equal(t, "https://spinwick.example.com", mock.statuses[1][1]["environment_url"])
+	})
+
+	t.Run("variant update fails", func(t *testing.T) {
+		s.startSpinWickDeployment(pr, "ha", s.Logger)
+		require.Len(t, mock.deployments, 2)
+		assert.Equal(t, "spinwick-pr-42-ha", mock.deployments[1]["environment"])
+
+		s.finishSpinWickDeployment(pr, "ha", (&spinwick.Request{}).WithError(errors.New("failed")), s.Logger)
+		require.Len(t, mock.statuses[2], 2)
+		assert.Equal(t, deploymentStateFailure, mock.statuses[2][1]["state"])
+		assert.Nil(t, mock.statuses[2][1]["environment_url"])
+	})
+
+	t.Run("aborted update", func(t *testing.T) {
+		s.startSpinWickDeployment(pr, "", s.Logger)
+		s.finishSpinWickDeployment(pr, "", (&spinwick.Request{}).WithError(errors.New("aborted")).IntentionalAbort(), s.Logger)
+		require.Len(t, mock.statuses[3], 2)
+		assert.Equal(t, deploymentStateInactive, mock.statuses[3][1]["state"])
+	})
+
+	t.Run("destroy", func(t *testing.T) {
+		s.setSpinWickDeploymentStatus(pr, "", deploymentStateInactive, "", s.Logger)
+		require.Len(t, mock.statuses[3], 3, "the latest deployment of the environment is updated")
+		assert.Equal(t, deploymentStateInactive, mock.statuses[3][2]["state"])
+		assert.Len(t, mock.statuses[1], 2)
+	})
+}
@@ -0,0 +1,186 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/blang/semver"
+	mattermostModel "github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/matterwick/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// pluginEnableDelay is how long to wait after installing a plugin before
+// enabling it, to let the server settle.
+var pluginEnableDelay = 20 * time.Second
+
+// extraPlugin is a plugin installed on a SpinWick in addition to the PR build,
+// either from the marketplace or from a release URL.
+type extraPlugin struct {
+	ID string
+	// Version is the marketplace version, empty for the latest one.
+	Version string
+	// URL is the release bundle to install instead of the marketplace.
+	URL string
+}
+
+// String returns the plugin in the --plugins format.
+func (p extraPlugin) String() string {
+	switch {
+	case p.URL != "":
+		return p.ID + "@" + p.URL
+	case p.Version != "":
+		return p.ID + "@" + p.Version
+	default:
+		return p.ID
+	}
+}
+
+// parseExtraPluginsArg parses plugins in the comma separated format
+// "id,id@version,id@https://release/bundle.tar.gz".
+func parseExtraPluginsArg(arg string) ([]extraPlugin, error) {
+	entries := splitCommaSeparated(arg)
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no plugins found")
+	}
+
+	plugins := make([]extraPlugin, 0, len(entries))
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		id, source, _ := strings.Cut(entry, "@")
+		if !mattermostModel.IsValidPluginId(id) {
+			return nil, fmt.Errorf("invalid plugin %q, expected id, id@version or id@url", entry)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("plugin %q is listed more than once", id)
+		}
+		seen[id] = true
+
+		plugin := extraPlugin{ID: id}
+		switch {
+		case source == "":
+		case strings.HasPrefix(source, "https://") || strings.HasPrefix(source, "http://"):
+			if _, err := url.ParseRequestURI(source); err != nil {
+				return nil, fmt.Errorf("invalid URL for plugin %q", id)
+			}
+			plugin.URL = source
+		default:
+			if _, err := semver.ParseTolerant(source); err != nil {
+				return nil, fmt.Errorf("invalid version %q for plugin %q", source, id)
+			}
+			plugin.Version = strings.TrimPrefix(source, "v")
+		}
+		plugins = append(plugins, plugin)
+	}
+
+	return plugins, nil
+}
+
+// installExtraPlugin installs and enables a plugin on the cluster installation.
+func (s *Server) installExtraPlugin(clusterInstallationID string, plugin extraPlugin, logger logrus.FieldLogger) *model.PluginInstallResult {
+	result := &model.PluginInstallResult{PluginURL: plugin.URL}
+
+	args := []string{"plugin", "install-url", "-f", plugin.URL}
+	if plugin.URL == "" {
+		args = []string{"plugin", "marketplace", "install", plugin.ID}
+		if plugin.Version != "" {
+			args = append(args, plugin.Version)
+		}
+	}
+
+	output, err := s.execMmctl(clusterInstallationID, args...)
+	if err != nil {
+		result.InstallError = errors.Wrap(err, "failed to install plugin")
+		return result
+	}
+	result.ArtifactFound = true
+	logger.WithField("output", string(output)).Info("Plugin installed successfully")
+
+	time.Sleep(pluginEnableDelay)
+
+	output, err = s.execMmctl(clusterInstallationID, "plugin", "enable", plugin.ID)
+	if err != nil {
+		result.EnableError = errors.Wrap(err, "failed to enable plugin")
+		return result
+	}
+	logger.WithField("output", string(output)).Info("Plugin enabled successfully")
+
+	result.Success = true
+	return result
+}
+
+// installExtraPlugins installs the extra plugins on a new SpinWick and returns
+// their rows for the plugin table. Failures are reported but do not fail the SpinWick.
+func (s *Server) installExtraPlugins(installationID string, plugins []extraPlugin, logger logrus.FieldLogger) []string {
+	if len(plugins) == 0 {
+		return nil
+	}
+
+	clusterInstallationID, err := s.getClusterInstallationID(installationID)
+
+	rows := make([]string, 0, len(plugins))
+	for _, plugin := range plugins {
+		pluginLogger := logger.WithField("plugin", plugin.String())
+
+		result := &model.PluginInstallResult{PluginURL: plugin.URL, InstallError: err}
+		if err == nil {
+			result = s.installExtraPlugin(clusterInstallationID, plugin, pluginLogger)
+		}
+		if !result.Success {
+			pluginLogger.WithFields(logrus.Fields{
+				"install_error": result.InstallError,
+				"enable_error":  result.EnableError,
+			}).Warn("Failed to install extra plugin")
+		}
+		rows = append(rows, extraPluginRow(plugin, result))
+	}
+
+	return rows
+}
+
+// extraPluginRow renders an extra plugin as a row of the plugin table.
+func extraPluginRow(plugin extraPlugin, result *model.PluginInstallResult) string {
+	version := plugin.Version
+	source := "Marketplace"
+	if plugin.URL != "" {
+		version = "n/a"
+		source = fmt.Sprintf("[Download](%s)", plugin.URL)
+	} else if version == "" {
+		version = "latest"
+	}
+	return fmt.Sprintf("| %s | %s | %s | %s |", plugin.ID, version, source, pluginInstallStatus(result))
+}
+
+// pluginInstallStatus summarizes a plugin installation for the plugin table.
+func pluginInstallStatus(result *model.PluginInstallResult) string {
+	switch {
+	case result.Success:
+		return ":white_check_mark: Enabled"
+	case result.InstallError != nil:
+		return ":x: Install failed: " + pluginTableCell(result.InstallError.Error())
+	case result.EnableError != nil:
+		return ":warning: Installed, enable failed: " + pluginTableCell(result.EnableError.Error())
+	default:
+		return ":x: Not installed"
+	}
+}
+
+// pluginTableCell makes text safe to use in a markdown table cell.
+func pluginTableCell(text string) string {
+	text = strings.ReplaceAll(text, "|", "\\|")
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// extraPluginsTable renders the plugin table for SpinWicks without a PR plugin.
+func extraPluginsTable(rows []string) string {
+	if len(rows) == 0 {
+		return ""
+	}
+	return "| Plugin | Version | Source | Status |\n|---|---|---|---|\n" + strings.Join(rows, "\n")
+}
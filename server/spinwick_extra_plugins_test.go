@@ -0,0 +1,125 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/matterwick/model"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExtraPluginsArg(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		input       string
+		expected    []extraPlugin
+		expectedErr string
+	}{
+		{
+			name:  "marketplace and release URL",
+			input: "focalboard@v7.10.0, com.mattermost.calls,playbooks@https://example.com/playbooks.tar.gz",
+			expected: []extraPlugin{
+				{ID: "focalboard", Version: "7.10.0"},
+				{ID: "com.mattermost.calls"},
+				{ID: "playbooks", URL: "https://example.com/playbooks.tar.gz"},
+			},
+		},
+		{
+			name:        "invalid id",
+			input:       "a b@1.0.0",
+			expectedErr: `invalid plugin "a b@1.0.0", expected id, id@version or id@url`,
+		},
+		{
+			name:        "invalid version",
+			input:       "focalboard@latest",
+			expectedErr: `invalid version "latest" for plugin "focalboard"`,
+		},
+		{
+			name:        "duplicate",
+			input:       "focalboard,focalboard@7.10.0",
+			expectedErr: `plugin "focalboard" is listed more than once`,
+		},
+		{
+			name:        "empty",
+			input:       " , ",
+			expectedErr: "no plugins found",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			plugins, err := parseExtraPluginsArg(tc.input)
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, plugins)
+		})
+	}
+}
+
+func TestInstallExtraPlugins(t *testing.T) {
+	var commands []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/cluster_installations":
+			w.Write([]byte(`[{"ID":"ci-1"}]`))
+		case "/api/cluster_installation/ci-1/exec/mmctl":
+			var args []string
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&args))
+			command := strings.Join(args, " ")
+			commands = append(commands, command)
+			switch command {
+			case "--local --json plugin marketplace install missing":
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("plugin not found"))
+			case "--local --json plugin enable playbooks":
+				w.WriteHeader(http.StatusInternalServerError)
+			default:
+				w.Write([]byte(`{}`))
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	enableDelay := pluginEnableDelay
+	pluginEnableDelay = time.Millisecond
+	defer func() { pluginEnableDelay = enableDelay }()
+
+	s := &Server{
+		Config:      &MatterwickConfig{},
+		Logger:      logrus.New(),
+		CloudClient: model.NewCloudClient(ts.URL, "", "", "", ""),
+	}
+
+	rows := s.installExtraPlugins("inst-1", []extraPlugin{
+		{ID: "focalboard", Version: "7.10.0"},
+		{ID: "missing"},
+		{ID: "playbooks", URL: "https://example.com/playbooks.tar.gz"},
+	}, s.Logger)
+
+	assert.Equal(t, []string{
+		"--local --json plugin marketplace install focalboard 7.10.0",
+		"--local --json plugin enable focalboard",
+		"--local --json plugin marketplace install missing",
+		"--local --json plugin install-url -f https://example.com/playbooks.tar.gz",
+		"--local --json plugin enable playbooks",
+	}, commands)
+
+	require.Len(t, rows, 3)
+	assert.Equal(t, "| focalboard | 7.10.0 | Marketplace | :white_check_mark: Enabled |", rows[0])
+	assert.True(t, strings.HasPrefix(rows[1], "| missing | latest | Marketplace | :x: Install failed: "), rows[1])
+	assert.True(t, strings.HasPrefix(rows[2], "| playbooks | n/a | [Download](https://example.com/playbooks.tar.gz) | :warning: Installed, enable failed: "), rows[2])
+
+	assert.Empty(t, s.installExtraPlugins("inst-1", nil, s.Logger))
+}
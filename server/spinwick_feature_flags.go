@@ -0,0 +1,217 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	cloudModel "github.com/mattermost/mattermost-cloud/model"
+	"github.com/mattermost/matterwick/internal/cloudtools"
+	"github.com/mattermost/matterwick/model"
+	"github.com/sirupsen/logrus"
+)
+
+// featureFlagEnvPrefix is the prefix of the environment variables that set
+// Mattermost feature flags.
+const featureFlagEnvPrefix = "MM_FEATUREFLAGS_"
+
+var featureFlagNameRegex = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// featureFlagEnvName returns the environment variable for a feature flag. Both
+// the flag name, e.g. "ChannelBookmarks", and the variable name are accepted.
+func featureFlagEnvName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if upper := strings.ToUpper(name); strings.HasPrefix(upper, featureFlagEnvPrefix) {
+		name = name[len(featureFlagEnvPrefix):]
+	}
+	if !featureFlagNameRegex.MatchString(name) {
+		return "", fmt.Errorf("invalid feature flag %q", name)
+	}
+	return featureFlagEnvPrefix + strings.ToUpper(name), nil
+}
+
+// parseFeatureFlagsArg parses feature flags in the comma separated format
+// "Flag1=value,Flag2". Flags without a value are set to "true". When unset is
+// true the flags have no values and are returned as empty variables, which
+// removes them from the installation.
+func parseFeatureFlagsArg(arg string, unset bool) (cloudModel.EnvVarMap, error) {
+	entries := splitCommaSeparated(arg)
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no feature flags found")
+	}
+
+	envMap := make(cloudModel.EnvVarMap)
+	for _, entry := range entries {
+		name, value, hasValue := strings.Cut(entry, "=")
+		if unset && hasValue {
+			return nil, fmt.Errorf("unexpected value for feature flag %q", name)
+		}
+		envName, err := featureFlagEnvName(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := envMap[envName]; ok {
+			return nil, fmt.Errorf("duplicate feature flag %q", name)
+		}
+
+		switch {
+		case unset:
+			envMap[envName] = cloudModel.EnvVar{}
+		case !hasValue:
+			envMap[envName] = cloudModel.EnvVar{Value: "true"}
+		default:
+			envMap[envName] = cloudModel.EnvVar{Value: strings.TrimSpace(value)}
+		}
+	}
+	return envMap, nil
+}
+
+// featureFlagPresetEnv returns the environment variables of a configured
+// feature flag preset.
+func (s *Server) featureFlagPresetEnv(preset string) (cloudModel.EnvVarMap, error) {
+	flags, ok := s.Config.FeatureFlagPresets[preset]
+	if !ok {
+		return nil, fmt.Errorf("unknown feature flag preset %q", preset)
+	}
+
+	envMap := make(cloudModel.EnvVarMap, len(flags))
+	for name, value := range flags {
+		envName, err := featureFlagEnvName(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid feature flag preset %q: %w", preset, err)
+		}
+		envMap[envName] = cloudModel.EnvVar{Value: value}
+	}
+	return envMap, nil
+}
+
+// spinWickFlagsArgs are the parsed arguments of /spinwick flags.
+type spinWickFlagsArgs struct {
+	// command is one of "list", "set" or "unset".
+	command string
+	// name is the SpinWick variant, empty for the default SpinWick.
+	name string
+	// envMap holds the flag changes of set and unset.
+	envMap cloudModel.EnvVarMap
+}
+
+// parseSpinwickFlagsArgs parses the arguments of /spinwick flags.
+func (s *Server) parseSpinwickFlagsArgs(args []string) (spinWickFlagsArgs, string, error) {
+	var parsedArgs spinWickFlagsArgs
+	if len(args) == 0 {
+		return parsedArgs, spinwickFlagsUsageString, fmt.Errorf("missing flags command")
+	}
+	parsedArgs.command = args[0]
+	if parsedArgs.command != "list" && parsedArgs.command != "set" && parsedArgs.command != "unset" {
+		return parsedArgs, spinwickFlagsUsageString, fmt.Errorf("invalid flags command %q", parsedArgs.command)
+	}
+
+	var outBuf bytes.Buffer
+	flagset := flag.NewFlagSet("spinwick flags", flag.ContinueOnError)
+	flagset.SetOutput(&outBuf)
+
+	var preset string
+	flagset.StringVar(&parsedArgs.name, "name", "", "An optional name of the SpinWick variant")
+	if parsedArgs.command == "set" {
+		flagset.StringVar(&preset, "preset", "", "An optional feature flag preset from the matterwick config")
+	}
+
+	err := flagset.Parse(args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return parsedArgs, outBuf.String(), err
+	} else if err != nil {
+		return parsedArgs, outBuf.String(), fmt.Errorf("failed to parse args: %w", err)
+	}
+
+	if err = validateVariantName(parsedArgs.name); err != nil {
+		return parsedArgs, err.Error(), fmt.Errorf("failed to parse name: %w", err)
+	}
+
+	if parsedArgs.command == "list" {
+		if flagset.NArg() > 0 {
+			return parsedArgs, spinwickFlagsUsageString, fmt.Errorf("unexpected arguments for flags list")
+		}
+		return parsedArgs, "", nil
+	}
+
+	parsedArgs.envMap = make(cloudModel.EnvVarMap)
+	if preset != "" {
+		parsedArgs.envMap, err = s.featureFlagPresetEnv(preset)
+		if err != nil {
+			return parsedArgs, err.Error(), fmt.Errorf("failed to parse preset: %w", err)
+		}
+	}
+	if flagset.NArg() == 0 && preset == "" {
+		return parsedArgs, spinwickFlagsUsageString, fmt.Errorf("no feature flags given")
+	}
+	if flagset.NArg() > 0 {
+		flags, err := parseFeatureFlagsArg(strings.Join(flagset.Args(), ","), parsedArgs.command == "unset")
+		if err != nil {
+			return parsedArgs, err.Error(), fmt.Errorf("failed to parse feature flags: %w", err)
+		}
+		for k, v := range flags {
+			parsedArgs.envMap[k] = v
+		}
+	}
+
+	return parsedArgs, "", nil
+}
+
+var spinwickFlagsUsageString = `Usage: /spinwick flags <list|set|unset> [--name <variant>] [flags]
+
+Examples:
+  /spinwick flags list
+  /spinwick flags set ChannelBookmarks,CustomProfileAttributes=false
+  /spinwick flags set --preset mobile
+  /spinwick flags unset ChannelBookmarks
+`
+
+// formatFeatureFlags renders the feature flags set in the environment variables
+// as a markdown table.
+func formatFeatureFlags(env cloudModel.EnvVarMap) string {
+	names := make([]string, 0)
+	for name, value := range env {
+		if strings.HasPrefix(name, featureFlagEnvPrefix) && value.Value != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "No feature flags are set."
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	sb.WriteString("| Feature Flag | Value |\n|---|---|")
+	for _, name := range names {
+		sb.WriteString(fmt.Sprintf("\n| %s | `%s` |", strings.TrimPrefix(name, featureFlagEnvPrefix), env[name].Value))
+	}
+	return sb.String()
+}
+
+// handleListSpinWickFlags shows the feature flags applied to the PR's SpinWick
+// in the status comment.
+func (s *Server) handleListSpinWickFlags(pr *model.PullRequest, name string) {
+	logger := s.Logger.WithFields(logrus.Fields{"repo_name": pr.RepoName, "pr": pr.Number, "variant": name})
+
+	spinwick := model.NewSpinwickVariant(pr.RepoName, pr.Number, name, s.Config.DNSNameTestServer)
+	installation, err := cloudtools.GetInstallationIDFromOwnerID(s.CloudClient, s.Config.ProvisionerServer, spinwick.RepeatableID)
+	if err != nil {
+		logger.WithError(err).Error("Failed to get SpinWick installation")
+		s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, "Failed to get the SpinWick installation."+variantSuffix(name))
+		return
+	}
+	if installation == nil {
+		s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, "No SpinWick found for this PR."+variantSuffix(name))
+		return
+	}
+
+	s.updateStatusComment(pr, statusSectionSettings,
+		fmt.Sprintf("Feature flags on the SpinWick%s:\n\n%s", variantSuffix(name), formatFeatureFlags(installation.PriorityEnv)))
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"testing"
+
+	cloudModel "github.com/mattermost/mattermost-cloud/model"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newFeatureFlagsTestServer() *Server {
+	return &Server{
+		Logger: logrus.New(),
+		Config: &MatterwickConfig{
+			FeatureFlagPresets: map[string]map[string]string{
+				"mobile": {"ChannelBookmarks": "true", "MM_FEATUREFLAGS_MMBLOCKSENABLED": "true"},
+				"broken": {"not a flag": "true"},
+			},
+		},
+	}
+}
+
+func TestFeatureFlagEnvName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"ChannelBookmarks":                 "MM_FEATUREFLAGS_CHANNELBOOKMARKS",
+		" ChannelBookmarks ":               "MM_FEATUREFLAGS_CHANNELBOOKMARKS",
+		"MM_FEATUREFLAGS_CHANNELBOOKMARKS": "MM_FEATUREFLAGS_CHANNELBOOKMARKS",
+		"mm_featureflags_ChannelBookmarks": "MM_FEATUREFLAGS_CHANNELBOOKMARKS",
+	} {
+		envName, err := featureFlagEnvName(name)
+		require.NoError(t, err, name)
+		assert.Equal(t, expected, envName, name)
+	}
+
+	for _, name := range []string{"", "1Flag", "Channel-Bookmarks", "MM_FEATUREFLAGS_"} {
+		_, err := featureFlagEnvName(name)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestParseFeatureFlagsArg(t *testing.T) {
+	t.Run("set", func(t *testing.T) {
+		envMap, err := parseFeatureFlagsArg("ChannelBookmarks, CustomProfileAttributes=false", false)
+		require.NoError(t, err)
+		assert.Equal(t, cloudModel.EnvVarMap{
+			"MM_FEATUREFLAGS_CHANNELBOOKMARKS":        {Value: "true"},
+			"MM_FEATUREFLAGS_CUSTOMPROFILEATTRIBUTES": {Value: "false"},
+		}, envMap)
+	})
+
+	t.Run("unset", func(t *testing.T) {
+		envMap, err := parseFeatureFlagsArg("ChannelBookmarks", true)
+		require.NoError(t, err)
+		assert.Equal(t, cloudModel.EnvVarMap{"MM_FEATUREFLAGS_CHANNELBOOKMARKS": {}}, envMap)
+
+		_, err = parseFeatureFlagsArg("ChannelBookmarks=true", true)
+		assert.EqualError(t, err, `unexpected value for feature flag "ChannelBookmarks"`)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := parseFeatureFlagsArg("", false)
+		assert.EqualError(t, err, "no feature flags found")
+
+		_, err = parseFeatureFlagsArg("ChannelBookmarks,MM_FEATUREFLAGS_CHANNELBOOKMARKS=false", false)
+		assert.EqualError(t, err, `duplicate feature flag "MM_FEATUREFLAGS_CHANNELBOOKMARKS"`)
+	})
+}
+
+func TestParseSpinwickFlagsArgs(t *testing.T) {
+	s := newFeatureFlagsTestServer()
+
+	t.Run("list", func(t *testing.T) {
+		parsedArgs, _, err := s.parseSpinwickFlagsArgs([]string{"list", "--name", "ha"})
+		require.NoError(t, err)
+		assert.Equal(t, spinWickFlagsArgs{command: "list", name: "ha"}, parsedArgs)
+
+		_, _, err = s.parseSpinwickFlagsArgs([]string{"list", "ChannelBookmarks"})
+		assert.Error(t, err)
+	})
+
+	t.Run("set with preset", func(t *testing.T) {
+		parsedArgs, _, err := s.parseSpinwickFlagsArgs([]string{"set", "--preset", "mobile", "ChannelBookmarks=false", "Other"})
+		require.NoError(t, err)
+		assert.Equal(t, cloudModel.EnvVarMap{
+			"MM_FEATUREFLAGS_CHANNELBOOKMARKS": {Value: "false"},
+			"MM_FEATUREFLAGS_MMBLOCKSENABLED":  {Value: "true"},
+			"MM_FEATUREFLAGS_OTHER":            {Value: "true"},
+		}, parsedArgs.envMap)
+	})
+
+	t.Run("unset", func(t *testing.T) {
+		parsedArgs, _, err := s.parseSpinwickFlagsArgs([]string{"unset", "ChannelBookmarks"})
+		require.NoError(t, err)
+		assert.Equal(t, cloudModel.EnvVarMap{"MM_FEATUREFLAGS_CHANNELBOOKMARKS": {}}, parsedArgs.envMap)
+
+		_, _, err = s.parseSpinwickFlagsArgs([]string{"unset", "--preset", "mobile"})
+		assert.Error(t, err, "presets can only be set")
+	})
+
+	t.Run("invalid commands", func(t *testing.T) {
+		_, output, err := s.parseSpinwickFlagsArgs([]string{})
+		assert.Error(t, err)
+		assert.Equal(t, spinwickFlagsUsageString, output)
+
+		_, _, err = s.parseSpinwickFlagsArgs([]string{"toggle", "ChannelBookmarks"})
+		assert.EqualError(t, err, `invalid flags command "toggle"`)
+
+		_, output, err = s.parseSpinwickFlagsArgs([]string{"set"})
+		assert.Error(t, err)
+		assert.Equal(t, spinwickFlagsUsageString, output)
+	})
+}
+
+func TestFeatureFlagPresetEnv(t *testing.T) {
+	s := newFeatureFlagsTestServer()
+
+	_, err := s.featureFlagPresetEnv("unknown")
+	assert.EqualError(t, err, `unknown feature flag preset "unknown"`)
+
+	_, err = s.featureFlagPresetEnv("broken")
+	assert.Error(t, err)
+}
+
+func TestFormatFeatureFlags(t *testing.T) {
+	assert.Equal(t, "No feature flags are set.", formatFeatureFlags(cloudModel.EnvVarMap{"MM_SERVICEENVIRONMENT": {Value: "test"}}))
+
+	assert.Equal(t, "| Feature Flag | Value |\n|---|---|\n| CHANNELBOOKMARKS | `true` |\n| OTHER | `false` |", formatFeatureFlags(cloudModel.EnvVarMap{
+		"MM_FEATUREFLAGS_OTHER":            {Value: "false"},
+		"MM_FEATUREFLAGS_CHANNELBOOKMARKS": {Value: "true"},
+		"MM_FEATUREFLAGS_REMOVED":          {},
+		"MM_SERVICEENVIRONMENT":            {Value: "test"},
+	}))
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// spinWickLicenseNone is the license profile for SpinWicks without a license.
+const spinWickLicenseNone = "none"
+
+// validateLicenseProfile returns an error if profile is neither a configured
+// license profile nor "none". An empty value is valid and means the label default.
+func (s *Server) validateLicenseProfile(profile string) error {
+	if profile == "" || profile == spinWickLicenseNone {
+		return nil
+	}
+	if _, ok := s.Config.LicenseProfiles[profile]; ok {
+		return nil
+	}
+
+	profiles := make([]string, 0, len(s.Config.LicenseProfiles)+1)
+	for name := range s.Config.LicenseProfiles {
+		profiles = append(profiles, name)
+	}
+	sort.Strings(profiles)
+	profiles = append(profiles, spinWickLicenseNone)
+	return fmt.Errorf("unknown license profile %q, use one of %s", profile, strings.Join(profiles, ", "))
+}
+
+// licenseFromProfile returns the license of a profile, empty for "none".
+func (s *Server) licenseFromProfile(profile string) string {
+	if profile == spinWickLicenseNone {
+		return ""
+	}
+	return s.Config.LicenseProfiles[profile]
+}
+
+// spinWickLicense returns the license for a SpinWick: the --license profile, or
+// the default profile of its label. SpinWicks that need a license and have no
+// profile keep using SpinWickHALicense.
+func (s *Server) spinWickLicense(opts spinWickOptions, withLicense bool) string {
+	if opts.license != "" {
+		return s.licenseFromProfile(opts.license)
+	}
+
+	label := s.Config.SetupSpinWick
+	if withLicense {
+		label = s.Config.SetupSpinWickHA
+	}
+	if profile, ok := s.Config.SpinWickLabelLicenses[label]; ok {
+		if err := s.validateLicenseProfile(profile); err != nil {
+			s.Logger.WithError(err).WithField("label", label).Warn("Ignoring invalid SpinWick label license profile")
+		} else {
+			return s.licenseFromProfile(profile)
+		}
+	}
+
+	if withLicense {
+		return s.Config.SpinWickHALicense
+	}
+	return ""
+}
+
+// setSpinWickLicense changes the license profile stored for a SpinWick by
+// /spinwick update, keeping its other options.
+func (s *Server) setSpinWickLicense(spinwickID, profile string) {
+	s.spinWickOptionsLock.Lock()
+	defer s.spinWickOptionsLock.Unlock()
+	opts := s.spinWickOptions[spinwickID]
+	opts.license = profile
+	s.spinWickOptions[spinwickID] = opts
+}
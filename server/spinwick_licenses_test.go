@@ -0,0 +1,94 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"testing"
+
+	cloudModel "github.com/mattermost/mattermost-cloud/model"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func newLicenseTestServer() *Server {
+	return &Server{
+		Logger: logrus.New(),
+		Config: &MatterwickConfig{
+			SetupSpinWick:     "Setup Cloud Test Server",
+			SetupSpinWickHA:   "Setup HA Cloud Test Server",
+			SpinWickHALicense: "ha-license",
+			LicenseProfiles: map[string]string{
+				"professional": "professional-license",
+				"enterprise":   "enterprise-license",
+			},
+		},
+		spinWickOptions: make(map[string]spinWickOptions),
+	}
+}
+
+func TestValidateLicenseProfile(t *testing.T) {
+	s := newLicenseTestServer()
+
+	assert.NoError(t, s.validateLicenseProfile(""))
+	assert.NoError(t, s.validateLicenseProfile("none"))
+	assert.NoError(t, s.validateLicenseProfile("enterprise"))
+	assert.EqualError(t, s.validateLicenseProfile("advanced"), `unknown license profile "advanced", use one of enterprise, professional, none`)
+}
+
+func TestSpinWickLicense(t *testing.T) {
+	t.Run("without label defaults", func(t *testing.T) {
+		s := newLicenseTestServer()
+
+		assert.Empty(t, s.spinWickLicense(spinWickOptions{}, false))
+		assert.Equal(t, "ha-license", s.spinWickLicense(spinWickOptions{}, true))
+		assert.Equal(t, "professional-license", s.spinWickLicense(spinWickOptions{license: "professional"}, false))
+		assert.Empty(t, s.spinWickLicense(spinWickOptions{license: "none"}, true))
+	})
+
+	t.Run("with label defaults", func(t *testing.T) {
+		s := newLicenseTestServer()
+		s.Config.SpinWickLabelLicenses = map[string]string{
+			"Setup Cloud Test Server":    "professional",
+			"Setup HA Cloud Test Server": "bogus",
+		}
+
+		assert.Equal(t, "professional-license", s.spinWickLicense(spinWickOptions{}, false))
+		assert.Equal(t, "ha-license", s.spinWickLicense(spinWickOptions{}, true), "invalid label profiles are ignored")
+		assert.Equal(t, "enterprise-license", s.spinWickLicense(spinWickOptions{license: "enterprise"}, false))
+
+		s.Config.SpinWickLabelLicenses["Setup Cloud Test Server"] = "none"
+		assert.Empty(t, s.spinWickLicense(spinWickOptions{}, false))
+	})
+
+	t.Run("update keeps the other options", func(t *testing.T) {
+		s := newLicenseTestServer()
+		s.setSpinWickOptions("mattermost-pr-1", spinWickOptions{sampleData: "small"})
+		s.setSpinWickLicense("mattermost-pr-1", "enterprise")
+		assert.Equal(t, spinWickOptions{sampleData: "small", license: "enterprise"}, s.spinWickOptions["mattermost-pr-1"])
+	})
+
+	t.Run("installation request", func(t *testing.T) {
+		s := newLicenseTestServer()
+		request := s.createInstallationRequest("owner", "abc1234", mattermostEEImage, "dns", "miniSingleton", "enterprise-license", nil, spinWickOptions{})
+		assert.Equal(t, "enterprise-license", request.License)
+		assert.Equal(t, cloudModel.InstallationAffinityMultiTenant, request.Affinity)
+		assert.Equal(t, []string{defaultMultiTenantAnnotation}, request.Annotations)
+	})
+
+	t.Run("dedicated backends are isolated", func(t *testing.T) {
+		s := newLicenseTestServer()
+		request := s.createInstallationRequest("owner", "abc1234", mattermostEEImage, "dns", "miniSingleton", "", nil, spinWickOptions{
+			database:  cloudModel.InstallationDatabaseSingleTenantRDSPostgres,
+			filestore: defaultSpinWickFilestore,
+		})
+		assert.Equal(t, cloudModel.InstallationAffinityIsolated, request.Affinity)
+		assert.Empty(t, request.Annotations)
+
+		request = s.createInstallationRequest("owner", "abc1234", mattermostEEImage, "dns", "miniSingleton", "", nil, spinWickOptions{
+			database:  defaultSpinWickDatabase,
+			filestore: cloudModel.InstallationFilestoreAwsS3,
+		})
+		assert.Equal(t, cloudModel.InstallationAffinityIsolated, request.Affinity)
+	})
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"context"
+	"sync"
+
+	"github.com/mattermost/matterwick/internal/spinwick"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// spinWickState is the lifecycle state of a SpinWick.
+type spinWickState string
+
+const (
+	// spinWickStateUnknown is the state of SpinWicks without a recorded
+	// operation, e.g. after a restart. Any operation may run on them.
+	spinWickStateUnknown    spinWickState = ""
+	spinWickStateAbsent     spinWickState = "absent"
+	spinWickStateCreating   spinWickState = "creating"
+	spinWickStateReady      spinWickState = "ready"
+	spinWickStateUpdating   spinWickState = "updating"
+	spinWickStateDestroying spinWickState = "destroying"
+	spinWickStateFailed     spinWickState = "failed"
+)
+
+// spinWickOperation is an operation changing the state of a SpinWick.
+type spinWickOperation string
+
+const (
+	spinWickOperationCreate  spinWickOperation = "create"
+	spinWickOperationUpdate  spinWickOperation = "update"
+	spinWickOperationDestroy spinWickOperation = "destroy"
+)
+
+// spinWickTransitions lists the operations allowed from each settled state.
+// Failed SpinWicks allow any operation so they can be retried or cleaned up.
+var spinWickTransitions = map[spinWickState]map[spinWickOperation]bool{
+	spinWickStateAbsent: {spinWickOperationCreate: true},
+	spinWickStateReady:  {spinWickOperationUpdate: true, spinWickOperationDestroy: true},
+	spinWickStateFailed: {spinWickOperationCreate: true, spinWickOperationUpdate: true, spinWickOperationDestroy: true},
+}
+
+// allows reports whether the operation may run on a SpinWick in the state.
+func (st spinWickState) allows(op spinWickOperation) bool {
+	return st == spinWickStateUnknown || spinWickTransitions[st][op]
+}
+
+// runningState returns the state of a SpinWick while the operation runs.
+func (op spinWickOperation) runningState() spinWickState {
+	switch op {
+	case spinWickOperationCreate:
+		return spinWickStateCreating
+	case spinWickOperationUpdate:
+		return spinWickStateUpdating
+	default:
+		return spinWickStateDestroying
+	}
+}
+
+// resultState returns the state of a SpinWick after the operation completed
+// with the request result.
+func (op spinWickOperation) resultState(request *spinwick.Request) spinWickState {
+	switch {
+	case request.Error == nil && op == spinWickOperationDestroy:
+		return spinWickStateAbsent
+	case request.Error == nil:
+		return spinWickStateReady
+	case request.Aborted && op == spinWickOperationUpdate:
+		// Aborted updates leave the previous build running.
+		return spinWickStateReady
+	case request.Aborted && op == spinWickOperationDestroy:
+		// Destroys abort when there is nothing left to destroy.
+		return spinWickStateAbsent
+	default:
+		return spinWickStateFailed
+	}
+}
+
+// spinWickLifecycle serializes the operations of one SpinWick. Operations run
+// in arrival order, and a destroy cancels the running create or update and
+// skips the queued ones, so a closed PR never ends with a live SpinWick.
+type spinWickLifecycle struct {
+	state   spinWickState
+	running spinWickOperation
+	cancel  context.CancelFunc
+
+	// next is the ticket of the next queued operation, serving the ticket of
+	// the operation whose turn it is.
+	next, serving uint64
+	// destroys counts destroy requests, so queued operations can tell that a
+	// destroy arrived after them.
+	destroys uint64
+	turn     *sync.Cond
+}
+
+// beginSpinWickOperation queues an operation of the SpinWick and waits for its
+// turn. It returns false if the operation must be skipped, because parent was
+// cancelled while it waited, e.g. for an update superseded by a newer commit,
+// because a destroy was requested after it was queued or because the state of
+// the SpinWick does not allow it. Otherwise the returned context is cancelled when a destroy is
+// requested, and the returned function must be called with the result of the
+// operation.
+func (s *Server) beginSpinWickOperation(parent context.Context, ownerID string, op spinWickOperation, logger logrus.FieldLogger) (context.Context, func(*spinwick.Request), bool) {
+	logger = logger.WithFields(logrus.Fields{"owner_id": ownerID, "operation": op})
+
+	s.spinWickLifecyclesLock.Lock()
+	defer s.spinWickLifecyclesLock.Unlock()
+
+	lifecycle, ok := s.spinWickLifecycles[ownerID]
+	if !ok {
+		lifecycle = &spinWickLifecycle{turn: sync.NewCond(&s.spinWickLifecyclesLock)}
+		s.spinWickLifecycles[ownerID] = lifecycle
+	}
+
+	ticket := lifecycle.next
+	lifecycle.next++
+	destroys := lifecycle.destroys
+	if op == spinWickOperationDestroy {
+		lifecycle.destroys++
+		if lifecycle.running == spinWickOperationCreate || lifecycle.running == spinWickOperationUpdate {
+			logger.WithField("running", lifecycle.running).Info("Cancelling the running SpinWick operation")
+			lifecycle.cancel()
+		}
+	}
+
+	for lifecycle.serving != ticket {
+		lifecycle.turn.Wait()
+	}
+
+	skip := ""
+	if parent.Err() != nil {
+		skip = "the operation was cancelled while queued"
+	} else if op != spinWickOperationDestroy && lifecycle.destroys != destroys {
+		skip = "the SpinWick was destroyed"
+	} else if !lifecycle.state.allows(op) {
+		skip = "the SpinWick is " + string(lifecycle.state)
+	}
+	if skip != "" {
+		logger.WithField("reason", skip).Info("Skipping SpinWick operation")
+		s.endSpinWickTurn(ownerID, lifecycle)
+		return nil, nil, false
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	lifecycle.running = op
+	lifecycle.cancel = cancel
+	lifecycle.state = op.runningState()
+	logger.WithField("state", lifecycle.state).Debug("SpinWick operation started")
+
+	return ctx, func(request *spinwick.Request) {
+		s.spinWickLifecyclesLock.Lock()
+		defer s.spinWickLifecyclesLock.Unlock()
+
+		cancel()
+		lifecycle.running = ""
+		lifecycle.cancel = nil
+		lifecycle.state = op.resultState(request)
+		logger.WithField("state", lifecycle.state).Debug("SpinWick operation finished")
+		s.endSpinWickTurn(ownerID, lifecycle)
+	}, true
+}
+
+// endSpinWickTurn passes the turn to the next queued operation of the
+// SpinWick, forgetting SpinWicks that are gone and have no queued operation.
+// The lifecycles lock must be held.
+func (s *Server) endSpinWickTurn(ownerID string, lifecycle *spinWickLifecycle) {
+	lifecycle.serving++
+	lifecycle.turn.Broadcast()
+	if lifecycle.serving == lifecycle.next && lifecycle.state == spinWickStateAbsent {
+		delete(s.spinWickLifecycles, ownerID)
+	}
+}
+
+// destroyedSpinWickOperation reports whether the operation failed because a
+// destroy cancelled it. Such requests are marked as aborted without a failure
+// message, as the SpinWick is about to be destroyed anyway.
+func destroyedSpinWickOperation(ctx context.Context, request *spinwick.Request) bool {
+	if request.Error == nil || ctx.Err() == nil {
+		return false
+	}
+	request.WithError(errors.New("cancelled by a destroy of the SpinWick")).IntentionalAbort().AlreadyNotifiedPR()
+	request.ReportError = false
+	return true
+}
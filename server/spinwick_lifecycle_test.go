@@ -0,0 +1,211 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mattermost/matterwick/internal/spinwick"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpinWickOperationResultState(t *testing.T) {
+	failed := func() *spinwick.Request { return (&spinwick.Request{}).WithError(errors.New("failed")) }
+	aborted := func() *spinwick.Request { return failed().IntentionalAbort() }
+
+	for _, tc := range []struct {
+		op       spinWickOperation
+		request  *spinwick.Request
+		expected spinWickState
+	}{
+		{spinWickOperationCreate, &spinwick.Request{}, spinWickStateReady},
+		{spinWickOperationCreate, failed(), spinWickStateFailed},
+		{spinWickOperationCreate, aborted(), spinWickStateFailed},
+		{spinWickOperationUpdate, &spinwick.Request{}, spinWickStateReady},
+		{spinWickOperationUpdate, failed(), spinWickStateFailed},
+		{spinWickOperationUpdate, aborted(), spinWickStateReady},
+		{spinWickOperationDestroy, &spinwick.Request{}, spinWickStateAbsent},
+		{spinWickOperationDestroy, failed(), spinWickStateFailed},
+		{spinWickOperationDestroy, aborted(), spinWickStateAbsent},
+	} {
+		assert.Equal(t, tc.expected, tc.op.resultState(tc.request), "%s %v", tc.op, tc.request.Error)
+	}
+
+	assert.True(t, spinWickStateUnknown.allows(spinWickOperationUpdate))
+	assert.True(t, spinWickStateAbsent.allows(spinWickOperationCreate))
+	assert.False(t, spinWickStateAbsent.allows(spinWickOperationUpdate))
+	assert.False(t, spinWickStateReady.allows(spinWickOperationCreate))
+	assert.True(t, spinWickStateFailed.allows(spinWickOperationDestroy))
+}
+
+// spinWickOperationResult is the outcome of beginSpinWickOperation run in a goroutine.
+type spinWickOperationResult struct {
+	ctx    context.Context
+	finish func(*spinwick.Request)
+	ok     bool
+}
+
+func newLifecycleTestServer() *Server {
+	return &Server{Logger: logrus.New(), spinWickLifecycles: make(map[string]*spinWickLifecycle)}
+}
+
+// queueSpinWickOperation begins the operation in a goroutine once the
+// operations queued before it are registered, so the queue order is known.
+func queueSpinWickOperation(t *testing.T, s *Server, op spinWickOperation, queued uint64) <-chan spinWickOperationResult {
+	return queueSpinWickOperationWithContext(t, context.Background(), s, op, queued)
+}
+
+func queueSpinWickOperationWithContext(t *testing.T, parent context.Context, s *Server, op spinWickOperation, queued uint64) <-chan spinWickOperationResult {
+	results := make(chan spinWickOperationResult, 1)
+	go func() {
+		ctx, finish, ok := s.beginSpinWickOperation(parent, "mattermost-server-pr-1", op, s.Logger)
+		results <- spinWickOperationResult{ctx, finish, ok}
+	}()
+
+	require.Eventually(t, func() bool {
+		s.spinWickLifecyclesLock.Lock()
+		defer s.spinWickLifecyclesLock.Unlock()
+		lifecycle, ok := s.spinWickLifecycles["mattermost-server-pr-1"]
+		return ok && lifecycle.next == queued+1
+	}, 10*time.Second, time.Millisecond)
+	return results
+}
+
+func receiveSpinWickOperation(t *testing.T, results <-chan spinWickOperationResult) spinWickOperationResult {
+	select {
+	case result := <-results:
+		return result
+	case <-time.After(10 * time.Second):
+		t.Fatal("the operation did not get its turn")
+		return spinWickOperationResult{}
+	}
+}
+
+func lifecycleState(s *Server) (spinWickState, bool) {
+	s.spinWickLifecyclesLock.Lock()
+	defer s.spinWickLifecyclesLock.Unlock()
+	lifecycle, ok := s.spinWickLifecycles["mattermost-server-pr-1"]
+	if !ok {
+		return spinWickStateUnknown, false
+	}
+	return lifecycle.state, true
+}
+
+func TestBeginSpinWickOperation(t *testing.T) {
+	t.Run("operations run in arrival order", func(t *testing.T) {
+		s := newLifecycleTestServer()
+
+		create := receiveSpinWickOperation(t, queueSpinWickOperation(t, s, spinWickOperationCreate, 0))
+		require.True(t, create.ok)
+		state, _ := lifecycleState(s)
+		assert.Equal(t, spinWickStateCreating, state)
+
+		updates := queueSpinWickOperation(t, s, spinWickOperationUpdate, 1)
+		select {
+		case <-updates:
+			t.Fatal("the update ran during the creation")
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		create.finish(&spinwick.Request{})
+		update := receiveSpinWickOperation(t, updates)
+		require.True(t, update.ok)
+		state, _ = lifecycleState(s)
+		assert.Equal(t, spinWickStateUpdating, state)
+
+		update.finish(&spinwick.Request{})
+		state, _ = lifecycleState(s)
+		assert.Equal(t, spinWickStateReady, state)
+
+		duplicate := receiveSpinWickOperation(t, queueSpinWickOperation(t, s, spinWickOperationCreate, 2))
+		assert.False(t, duplicate.ok, "ready SpinWicks are not created again")
+	})
+
+	t.Run("destroy cancels the running creation and skips queued operations", func(t *testing.T) {
+		s := newLifecycleTestServer()
+
+		create := receiveSpinWickOperation(t, queueSpinWickOperation(t, s, spinWickOperationCreate, 0))
+		require.True(t, create.ok)
+		updates := queueSpinWickOperation(t, s, spinWickOperationUpdate, 1)
+		destroys := queueSpinWickOperation(t, s, spinWickOperationDestroy, 2)
+		recreates := queueSpinWickOperation(t, s, spinWickOperationCreate, 3)
+
+		select {
+		case <-create.ctx.Done():
+		case <-time.After(10 * time.Second):
+			t.Fatal("the creation was not cancelled")
+		}
+		request := (&spinwick.Request{}).WithError(errors.New("timed out waiting for image to publish"))
+		require.True(t, destroyedSpinWickOperation(create.ctx, request))
+		create.finish(request)
+
+		update := receiveSpinWickOperation(t, updates)
+		assert.False(t, update.ok, "updates queued before the destroy are skipped")
+
+		destroy := receiveSpinWickOperation(t, destroys)
+		require.True(t, destroy.ok)
+		state, _ := lifecycleState(s)
+		assert.Equal(t, spinWickStateDestroying, state)
+		destroy.finish(&spinwick.Request{})
+
+		recreate := receiveSpinWickOperation(t, recreates)
+		require.True(t, recreate.ok, "creations queued after the destroy run")
+		recreate.finish(&spinwick.Request{})
+		state, _ = lifecycleState(s)
+		assert.Equal(t, spinWickStateReady, state)
+	})
+
+	t.Run("operations cancelled while queued are skipped", func(t *testing.T) {
+		s := newLifecycleTestServer()
+
+		create := receiveSpinWickOperation(t, queueSpinWickOperation(t, s, spinWickOperationCreate, 0))
+		require.True(t, create.ok)
+
+		superseded, cancel := context.WithCancel(context.Background())
+		updates := queueSpinWickOperationWithContext(t, superseded, s, spinWickOperationUpdate, 1)
+		newer := queueSpinWickOperation(t, s, spinWickOperationUpdate, 2)
+		cancel()
+
+		create.finish(&spinwick.Request{})
+		update := receiveSpinWickOperation(t, updates)
+		assert.False(t, update.ok, "the superseded update does not run")
+
+		update = receiveSpinWickOperation(t, newer)
+		require.True(t, update.ok)
+		update.finish(&spinwick.Request{})
+		state, _ := lifecycleState(s)
+		assert.Equal(t, spinWickStateReady, state)
+	})
+
+	t.Run("destroyed SpinWicks are forgotten", func(t *testing.T) {
+		s := newLifecycleTestServer()
+
+		destroy := receiveSpinWickOperation(t, queueSpinWickOperation(t, s, spinWickOperationDestroy, 0))
+		require.True(t, destroy.ok)
+		destroy.finish(&spinwick.Request{})
+
+		_, ok := lifecycleState(s)
+		assert.False(t, ok)
+	})
+}
+
+func TestDestroyedSpinWickOperation(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.False(t, destroyedSpinWickOperation(cancelled, &spinwick.Request{}), "succeeded operations are kept")
+	assert.False(t, destroyedSpinWickOperation(context.Background(), (&spinwick.Request{}).WithError(errors.New("failed"))))
+
+	request := (&spinwick.Request{}).WithError(errors.New("failed")).ShouldReportError()
+	require.True(t, destroyedSpinWickOperation(cancelled, request))
+	assert.EqualError(t, request.Error, "cancelled by a destroy of the SpinWick")
+	assert.True(t, request.Aborted)
+	assert.True(t, request.PRNotified)
+	assert.False(t, request.ReportError)
+}
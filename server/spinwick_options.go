@@ -0,0 +1,141 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	cloudModel "github.com/mattermost/mattermost-cloud/model"
+)
+
+const (
+	defaultSpinWickDatabase  = cloudModel.InstallationDatabaseMultiTenantRDSPostgresPGBouncer
+	defaultSpinWickFilestore = cloudModel.InstallationFilestoreBifrost
+)
+
+// spinWickOptions holds the provisioning choices for a SpinWick that are not
+// expressed by its label. They are set by /spinwick create and read when the
+// label event creates the installation.
+type spinWickOptions struct {
+	database  string
+	filestore string
+	// sampleData is the name of the SampleDataProfile seeded after initialization.
+	sampleData string
+	// users is the roster of additional accounts. A nil roster means the configured one.
+	users []SpinWickUser
+	// upgradeFrom is the release the SpinWick is created with before it is
+	// upgraded to the PR build.
+	upgradeFrom string
+	// companions are the PRs from other repositories whose builds are added to the SpinWick.
+	companions []companionPR
+	// plugins are installed in addition to the PR's plugin. A nil list means the repository defaults.
+	plugins []extraPlugin
+	// serverVersion overrides the server version of plugin SpinWicks.
+	serverVersion string
+	// license is the license profile, "none", or empty for the label default.
+	license string
+}
+
+// validateSpinWickDatabase returns an error if database is not a database type
+// supported by the provisioner. An empty value is valid and means "use the default".
+func validateSpinWickDatabase(database string) error {
+	if database != "" && !cloudModel.IsSupportedDatabase(database) {
+		return fmt.Errorf("unsupported database %q", database)
+	}
+	return nil
+}
+
+// validateSpinWickFilestore returns an error if filestore is not a filestore type
+// supported by the provisioner. An empty value is valid and means "use the default".
+func validateSpinWickFilestore(filestore string) error {
+	if filestore != "" && !cloudModel.IsSupportedFilestore(filestore) {
+		return fmt.Errorf("unsupported filestore %q", filestore)
+	}
+	return nil
+}
+
+// sharedBackends reports whether the database and filestore of the options are
+// shared between installations, so the SpinWick can be scheduled on a
+// multi-tenant cluster. Empty values are the shared defaults.
+func (opts spinWickOptions) sharedBackends() bool {
+	sharedDatabase := opts.database == "" || cloudModel.IsMultiTenantRDS(opts.database)
+	sharedFilestore := opts.filestore == "" ||
+		opts.filestore == cloudModel.InstallationFilestoreMultiTenantAwsS3 ||
+		opts.filestore == cloudModel.InstallationFilestoreBifrost
+	return sharedDatabase && sharedFilestore
+}
+
+// affinity returns the installation affinity and annotations matching the
+// backends of the options. Dedicated databases and filestores get an isolated
+// installation.
+func (opts spinWickOptions) affinity() (string, []string) {
+	if opts.sharedBackends() {
+		return cloudModel.InstallationAffinityMultiTenant, []string{defaultMultiTenantAnnotation}
+	}
+	return cloudModel.InstallationAffinityIsolated, nil
+}
+
+func (s *Server) setSpinWickOptions(spinwickID string, opts spinWickOptions) {
+	s.spinWickOptionsLock.Lock()
+	defer s.spinWickOptionsLock.Unlock()
+	s.spinWickOptions[spinwickID] = opts
+}
+
+func (s *Server) deleteSpinWickOptions(spinwickID string) {
+	s.spinWickOptionsLock.Lock()
+	defer s.spinWickOptionsLock.Unlock()
+	delete(s.spinWickOptions, spinwickID)
+}
+
+// getSpinWickOptions returns the options for a SpinWick. Options that were not
+// set through a slash command fall back to the repository defaults in config and
+// then to the provisioner backends SpinWicks have always used.
+func (s *Server) getSpinWickOptions(repoName, spinwickID string) spinWickOptions {
+	s.spinWickOptionsLock.Lock()
+	opts := s.spinWickOptions[spinwickID]
+	s.spinWickOptionsLock.Unlock()
+
+	defaults := s.Config.SpinWickRepoDefaults[repoName]
+	if opts.database == "" {
+		if err := validateSpinWickDatabase(defaults.Database); err != nil {
+			s.Logger.WithError(err).WithField("repo", repoName).Warn("Ignoring invalid SpinWick database default")
+		} else {
+			opts.database = defaults.Database
+		}
+	}
+	if opts.filestore == "" {
+		if err := validateSpinWickFilestore(defaults.Filestore); err != nil {
+			s.Logger.WithError(err).WithField("repo", repoName).Warn("Ignoring invalid SpinWick filestore default")
+		} else {
+			opts.filestore = defaults.Filestore
+		}
+	}
+
+	if opts.plugins == nil && len(defaults.Plugins) > 0 {
+		plugins, err := parseExtraPluginsArg(strings.Join(defaults.Plugins, ","))
+		if err != nil {
+			s.Logger.WithError(err).WithField("repo", repoName).Warn("Ignoring invalid SpinWick plugins default")
+		} else {
+			opts.plugins = plugins
+		}
+	}
+
+	if opts.users == nil {
+		if err := validateSpinWickUsers(s.Config.SpinWickUsers); err != nil {
+			s.Logger.WithError(err).Warn("Ignoring invalid SpinWick user roster")
+		} else {
+			opts.users = s.Config.SpinWickUsers
+		}
+	}
+
+	if opts.database == "" {
+		opts.database = defaultSpinWickDatabase
+	}
+	if opts.filestore == "" {
+		opts.filestore = defaultSpinWickFilestore
+	}
+
+	return opts
+}
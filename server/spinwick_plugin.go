@@ -12,6 +12,7 @@ import (
 
 	"github.com/blang/semver"
 	cloudModel "github.com/mattermost/mattermost-cloud/model"
+	"github.com/mattermost/matterwick/internal/cloudtools"
 	"github.com/mattermost/matterwick/internal/spinwick"
 	"github.com/mattermost/matterwick/model"
 	"github.com/pkg/errors"
@@ -45,7 +46,7 @@ func pluginSpinwickImageTag(version string) string {
 }
 
 // createPluginSpinWick creates a SpinWick for a plugin repository
-func (s *Server) createPluginSpinWick(pr *model.PullRequest, logger logrus.FieldLogger) *spinwick.Request {
+func (s *Server) createPluginSpinWick(ctx context.Context, pr *model.PullRequest, logger logrus.FieldLogger) *spinwick.Request {
 	request := &spinwick.Request{
 		InstallationID: "n/a",
 		Error:          nil,
@@ -77,16 +78,42 @@ func (s *Server) createPluginSpinWick(pr *model.PullRequest, logger logrus.Field
 	// Create the Mattermost installation using the resolved server version.
 	// mattermostdevelopment/ publishes branch tags (release-X.Y), not bare semver.
 	cloudClient := s.CloudClient
-	serverVersion := pluginSpinwickImageTag(s.resolveMattermostServerVersion())
+	opts := s.getSpinWickOptions(pr.RepoName, ownerID)
+	serverImage := defaultPluginImage
+	s.setProvisioningStage(ownerID, provisioningStageWaitingForImage)
+	serverVersion := s.pluginServerImageTag(pr, opts, logger)
+
+	// A server companion PR replaces the released server build.
+	companions, companionReport := s.spinWickCompanions(pr, opts)
+	for _, companion := range companions {
+		if companion.Repo != mattermostServerRepo {
+			continue
+		}
+		companionPR, companionErr := s.resolveCompanionPR(companion)
+		if companionErr == nil {
+			var image, version string
+			image, version, companionErr = s.companionServerImage(companionPR, logger)
+			if companionErr == nil {
+				serverImage, serverVersion = image, version
+				companionReport = append(companionReport, fmt.Sprintf("- %s: :white_check_mark: server image `%s`", companion, version))
+			}
+		}
+		if companionErr != nil {
+			logger.WithError(companionErr).Warn("Failed to use the companion server build")
+			companionReport = append(companionReport, fmt.Sprintf("- %s: :x: %s, using `%s` instead", companion, companionErr.Error(), serverVersion))
+		}
+	}
+
 	logger.WithField("server_version", serverVersion).Info("Resolved Mattermost server version for plugin SpinWick")
 	installationRequest := s.createInstallationRequest(
 		ownerID,
 		serverVersion,
-		defaultPluginImage,
+		serverImage,
 		spinwick.DNS(s.Config.DNSNameTestServer),
 		"miniSingleton",
-		false, // no license needed for plugins
-		nil,   // no env vars for plugins
+		s.spinWickLicense(opts, false), // plugins need no license unless one is requested
+		nil,                            // no env vars for plugins
+		opts,
 	)
 
 	installation, err = cloudClient.CreateInstallation(installationRequest)
@@ -101,13 +128,14 @@ func (s *Server) createPluginSpinWick(pr *model.PullRequest, logger logrus.Field
 	// Wait for installation to become stable and initialize
 	wait := 1200
 	logger.Infof("Waiting %d seconds for mattermost installation to become stable", wait)
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(wait)*time.Second)
+	initCtx, cancel := context.WithTimeout(ctx, time.Duration(wait)*time.Second)
 	defer cancel()
 
-	sysadminPassword, userPassword, err := s.waitAndInitializeInstallation(ctx, pr, request, installation, logger)
+	credentials, err := s.waitAndInitializeInstallation(initCtx, pr, request, installation, opts, logger)
 	if err != nil {
 		return request.WithError(err).ShouldReportError()
 	}
+	s.setSpinWickCredentials(spinwick.RepeatableID, credentials)
 
 	// Get ClusterInstallation ID
 	clusterInstallations, err := cloudClient.GetClusterInstallations(&cloudModel.GetClusterInstallationsRequest{
@@ -124,16 +152,20 @@ func (s *Server) createPluginSpinWick(pr *model.PullRequest, logger logrus.Field
 
 	// Wait for and install the plugin artifact
 	logger.Info("Waiting for plugin artifact and installing")
+	s.setProvisioningStage(ownerID, provisioningStagePlugins)
 	// Create a new context for plugin artifact wait (45 minutes)
-	pluginCtx, pluginCancel := context.WithTimeout(context.Background(), 45*time.Minute)
+	pluginCtx, pluginCancel := context.WithTimeout(ctx, 45*time.Minute)
 	defer pluginCancel()
 	pluginResult := s.waitForAndInstallPlugin(pluginCtx, pr, clusterInstallationID, logger)
 
 	shortSHA := pr.Sha[0:7]
 
 	// Build plugin table and warning message
-	pluginTable := fmt.Sprintf("| Plugin | Version | Artifact |\n|---|---|---|\n| %s | %s | [Download](%s) |",
-		pluginID, shortSHA, pluginResult.PluginURL)
+	pluginTable := fmt.Sprintf("| Plugin | Version | Artifact | Status |\n|---|---|---|---|\n| %s | %s | [Download](%s) | %s |",
+		pluginID, shortSHA, pluginResult.PluginURL, pluginInstallStatus(pluginResult))
+	for _, row := range s.installExtraPlugins(installation.ID, opts.plugins, logger) {
+		pluginTable += "\n" + row
+	}
 
 	var extraInfo string
 	if !pluginResult.Success {
@@ -160,33 +192,47 @@ func (s *Server) createPluginSpinWick(pr *model.PullRequest, logger logrus.Field
 		extraInfo = pluginTable
 	}
 
-	s.sendSpinwickSuccessToMattermost(pr, installation, sysadminPassword, userPassword, extraInfo, logger)
+	// Plugin settings are applied once the plugin is installed so they reach it
+	// through its configuration change hook.
+	if pluginResult.InstallError == nil {
+		mmURL := fmt.Sprintf("https://%s", cloudtools.GetInstallationDNSFromDNSRecords(installation))
+		if settingsInfo := s.configurePlugin(pr, mmURL, credentials.password(spinWickSysadminUsername), logger); settingsInfo != "" {
+			extraInfo += "\n\n" + settingsInfo
+		}
+	}
 
+	if companionInfo := s.installCompanionPlugins(installation.ID, companions, companionReport, logger); companionInfo != "" {
+		extraInfo += "\n\n" + companionInfo
+	}
+	s.linkCompanions(pr, "", companions)
+
+	mmURL := fmt.Sprintf("https://%s", cloudtools.GetInstallationDNSFromDNSRecords(installation))
+	smokeInfo, smokeErr := s.smokeTestSpinWick(pr, ownerID, mmURL, installation.ID, credentials, logger)
+	extraInfo += "\n\n" + smokeInfo
+
+	s.sendSpinwickSuccessToMattermost(pr, "", installation, credentials, extraInfo, logger)
+
+	request.WithURL(mmURL)
+	if smokeErr != nil {
+		// The success comment already shows the failed checks.
+		return request.WithError(smokeErr).AlreadyNotifiedPR()
+	}
 	return request
 }
 
-// waitForAndInstallPlugin waits for the plugin artifact to be available on S3 and installs it
+// waitForAndInstallPlugin waits for the plugin artifact to be available from
+// the repository's artifact source and installs it
 func (s *Server) waitForAndInstallPlugin(ctx context.Context, pr *model.PullRequest, clusterInstallationID string, logger logrus.FieldLogger) *model.PluginInstallResult {
-	// Build the S3 URL for the plugin artifact
-	shortSHA := pr.Sha[0:7]
-	filename := fmt.Sprintf("%s-%s.tar.gz", pr.RepoName, shortSHA)
-	s3URL := fmt.Sprintf("https://%s.s3.amazonaws.com/%s/%s", pluginS3Bucket, pr.RepoName, filename)
-
-	logger.WithFields(logrus.Fields{
-		"url": s3URL,
-		"sha": shortSHA,
-	}).Info("Waiting for plugin artifact on S3")
-
 	result := &model.PluginInstallResult{
-		PluginURL:     s3URL,
 		Success:       false,
 		ArtifactFound: false,
 	}
 
-	// Wait for the artifact to be available on S3
-	err := s.waitForS3Artifact(ctx, s3URL, logger)
+	// Wait for the artifact to be available
+	artifact, err := s.pluginArtifactSource(pr.RepoName).waitForArtifact(ctx, pr, logger)
+	result.PluginURL = artifact.DownloadURL
 	if err != nil {
-		result.InstallError = errors.Wrap(err, "failed to wait for S3 artifact")
+		result.InstallError = err
 		return result
 	}
 	result.ArtifactFound = true
@@ -194,18 +240,14 @@ func (s *Server) waitForAndInstallPlugin(ctx context.Context, pr *model.PullRequ
 	// Install the plugin using mmctl
 	cloudClient := s.CloudClient
 
-	// Determine the plugin ID - check config mapping first, then fall back to trimming prefix
-	pluginID, exists := s.Config.PluginRepoToIDMapping[pr.RepoName]
-	if !exists {
-		pluginID = strings.TrimPrefix(pr.RepoName, pluginRepoPrefix)
-		logger.WithField("pluginID", pluginID).Debug("Using calculated plugin ID (no mapping found)")
-	} else {
-		logger.WithField("pluginID", pluginID).Debug("Using mapped plugin ID from config")
-	}
+	pluginID := s.pluginIDForRepo(pr.RepoName)
+	logger.WithField("pluginID", pluginID).Debug("Resolved plugin ID")
 
-	// Install the plugin using the S3 URL
-	subcommand := []string{"--local", "plugin", "install-url", "-f", s3URL}
+	// Install the plugin from the artifact URL
+	subcommand := []string{"--local", "plugin", "install-url", "-f", artifact.InstallURL}
 	output, err := cloudClient.ExecClusterInstallationCLI(clusterInstallationID, "mmctl", subcommand)
+	// The bundle has been downloaded by the installation, or failed to.
+	s.dropPluginBundle(artifact.bundleToken)
 	if err != nil {
 		logger.WithError(err).WithField("output", string(output)).Error("Failed to install plugin")
 		result.InstallError = errors.Wrap(err, "failed to install plugin via mmctl")
@@ -214,7 +256,7 @@ func (s *Server) waitForAndInstallPlugin(ctx context.Context, pr *model.PullRequ
 	logger.WithField("output", string(output)).Info("Plugin installed successfully")
 
 	// Wait for 20 seconds to allow the system to stabilize after plugin installation
-	time.Sleep(20 * time.Second)
+	time.Sleep(pluginEnableDelay)
 
 	// Enable the plugin
 	subcommand = []string{"--local", "plugin", "enable", pluginID}
@@ -230,6 +272,15 @@ func (s *Server) waitForAndInstallPlugin(ctx context.Context, pr *model.PullRequ
 	return result
 }
 
+// pluginIDForRepo returns the plugin ID of a plugin repository. The config
+// mapping takes precedence over the ID derived from the repository name.
+func (s *Server) pluginIDForRepo(repoName string) string {
+	if pluginID, ok := s.Config.PluginRepoToIDMapping[repoName]; ok {
+		return pluginID
+	}
+	return strings.TrimPrefix(repoName, pluginRepoPrefix)
+}
+
 // waitForS3Artifact waits for the artifact to be available on S3
 func (s *Server) waitForS3Artifact(ctx context.Context, url string, logger logrus.FieldLogger) error {
 	for {
@@ -259,7 +310,7 @@ func (s *Server) waitForS3Artifact(ctx context.Context, url string, logger logru
 }
 
 // updatePluginSpinWick updates a SpinWick for a plugin repository
-func (s *Server) updatePluginSpinWick(pr *model.PullRequest, logger logrus.FieldLogger) *spinwick.Request {
+func (s *Server) updatePluginSpinWick(ctx context.Context, pr *model.PullRequest, logger logrus.FieldLogger) *spinwick.Request {
 	request := &spinwick.Request{
 		InstallationID: "n/a",
 		Error:          nil,
@@ -283,17 +334,7 @@ func (s *Server) updatePluginSpinWick(pr *model.PullRequest, logger logrus.Field
 	logger = logger.WithField("installation_id", request.InstallationID)
 	logger.Info("Updating plugin SpinWick")
 
-	// Remove old messages
-	serverNewCommitMessages := []string{
-		"New commit detected.",
-	}
-	comments, errComments := s.getComments(pr.RepoOwner, pr.RepoName, pr.Number)
-	if errComments != nil {
-		logger.WithError(err).Error("pr_error")
-	} else {
-		s.removeCommentsWithSpecificMessages(comments, serverNewCommitMessages, pr, logger)
-	}
-	s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, "New commit detected. SpinWick will update the plugin if a new artifact is available.")
+	s.updateStatusComment(pr, statusSectionSpinWick, "New commit detected. SpinWick will update the plugin if a new artifact is available.")
 
 	// Get ClusterInstallation ID
 	cloudClient := s.CloudClient
@@ -310,17 +351,13 @@ func (s *Server) updatePluginSpinWick(pr *model.PullRequest, logger logrus.Field
 	clusterInstallationID := clusterInstallations[0].ID
 
 	// Wait for and reinstall the plugin artifact
-	ctx, cancel := context.WithTimeout(context.Background(), 45*time.Minute)
+	s.setProvisioningStage(ownerID, provisioningStagePlugins)
+	ctx, cancel := context.WithTimeout(ctx, 45*time.Minute)
 	defer cancel()
 
 	pluginResult := s.waitForAndInstallPlugin(ctx, pr, clusterInstallationID, logger)
-
-	// Remove old update messages
-	if errComments == nil {
-		serverUpdateMessage := []string{
-			"Plugin test server updated",
-		}
-		s.removeCommentsWithSpecificMessages(comments, serverUpdateMessage, pr, logger)
+	if !pluginResult.Success && errors.Is(ctx.Err(), context.Canceled) {
+		return request.WithError(errors.New("update cancelled")).IntentionalAbort()
 	}
 
 	// Extract plugin info from repo name and commit
@@ -354,13 +391,23 @@ func (s *Server) updatePluginSpinWick(pr *model.PullRequest, logger logrus.Field
 		updateMessage = fmt.Sprintf("Plugin test server updated!\n\n%s", pluginTable)
 	}
 
-	s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, updateMessage)
+	// Reinstalling the plugin keeps its settings, but they may have changed in
+	// the PR description since the last commit.
+	if pluginResult.InstallError == nil {
+		mmURL := fmt.Sprintf("https://%s", cloudtools.GetInstallationDNSFromDNSRecords(installation))
+		password := s.getSpinWickCredentials(ownerID).password(spinWickSysadminUsername)
+		if settingsInfo := s.configurePlugin(pr, mmURL, password, logger); settingsInfo != "" {
+			updateMessage += "\n\n" + settingsInfo
+		}
+	}
 
-	return request
+	s.updateStatusComment(pr, statusSectionSpinWick, updateMessage)
+
+	return request.WithURL(fmt.Sprintf("https://%s", cloudtools.GetInstallationDNSFromDNSRecords(installation)))
 }
 
 // destroyPluginSpinWick destroys a SpinWick for a plugin repository
 func (s *Server) destroyPluginSpinWick(pr *model.PullRequest, logger logrus.FieldLogger) *spinwick.Request {
 	// This can use the same logic as destroySpinWick since the installation is the same
-	return s.destroySpinWick(pr, logger)
+	return s.destroySpinWick(pr, "", logger)
 }
@@ -0,0 +1,304 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+	"github.com/gorilla/mux"
+	cloudModel "github.com/mattermost/mattermost-cloud/model"
+	"github.com/mattermost/matterwick/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	pluginArtifactSourceS3            = "s3"
+	pluginArtifactSourceGitHubActions = "github-actions"
+
+	// pluginBundleTTL is how long a bundle taken from a GitHub Actions artifact
+	// is served for installations to download it.
+	pluginBundleTTL = time.Hour
+	// maxPluginArtifactSize bounds the GitHub Actions artifacts downloaded.
+	maxPluginArtifactSize = 200 * 1024 * 1024
+	// maxPluginBundlesSize bounds the bundles held in memory at once.
+	maxPluginBundlesSize = 500 * 1024 * 1024
+)
+
+// pluginArtifactPollInterval is how often artifact sources look for the PR build.
+var pluginArtifactPollInterval = 30 * time.Second
+
+// pluginArtifact is the plugin bundle built for a PR commit.
+type pluginArtifact struct {
+	// InstallURL is where the installation downloads the bundle from.
+	InstallURL string
+	// DownloadURL is the link shown on the PR.
+	DownloadURL string
+	// bundleToken identifies the bundle served by matterwick, if any, so it
+	// can be dropped once it was installed.
+	bundleToken string
+}
+
+// pluginArtifactSource provides the plugin bundles built for PR commits.
+type pluginArtifactSource interface {
+	// waitForArtifact waits until the bundle of the PR head commit is available.
+	// The DownloadURL of the returned artifact is set on error when it is known.
+	waitForArtifact(ctx context.Context, pr *model.PullRequest, logger logrus.FieldLogger) (pluginArtifact, error)
+}
+
+// validatePluginArtifactSource returns an error if source is not a supported
+// plugin artifact source. An empty value is valid and means S3.
+func validatePluginArtifactSource(source string) error {
+	switch source {
+	case "", pluginArtifactSourceS3, pluginArtifactSourceGitHubActions:
+		return nil
+	}
+	return fmt.Errorf("unsupported plugin artifact source %q", source)
+}
+
+// pluginArtifactSource returns the artifact source configured for the repository.
+func (s *Server) pluginArtifactSource(repoName string) pluginArtifactSource {
+	defaults := s.Config.SpinWickRepoDefaults[repoName]
+	if err := validatePluginArtifactSource(defaults.PluginArtifactSource); err != nil {
+		s.Logger.WithError(err).WithField("repo", repoName).Warn("Ignoring invalid plugin artifact source, using S3")
+	}
+
+	if defaults.PluginArtifactSource == pluginArtifactSourceGitHubActions {
+		return &githubActionsPluginArtifactSource{
+			server:       s,
+			client:       newGithubClient(s.Config.GithubAccessToken),
+			artifactName: defaults.PluginArtifactName,
+		}
+	}
+	return &s3PluginArtifactSource{server: s}
+}
+
+// s3PluginArtifactSource provides the bundles the plugin CI publishes to the
+// PR builds bucket.
+type s3PluginArtifactSource struct {
+	server *Server
+}
+
+func (a *s3PluginArtifactSource) waitForArtifact(ctx context.Context, pr *model.PullRequest, logger logrus.FieldLogger) (pluginArtifact, error) {
+	shortSHA := pr.Sha[0:7]
+	filename := fmt.Sprintf("%s-%s.tar.gz", pr.RepoName, shortSHA)
+	s3URL := fmt.Sprintf("https://%s.s3.amazonaws.com/%s/%s", pluginS3Bucket, pr.RepoName, filename)
+	artifact := pluginArtifact{InstallURL: s3URL, DownloadURL: s3URL}
+
+	logger.WithFields(logrus.Fields{
+		"url": s3URL,
+		"sha": shortSHA,
+	}).Info("Waiting for plugin artifact on S3")
+
+	if err := a.server.waitForS3Artifact(ctx, s3URL, logger); err != nil {
+		return artifact, errors.Wrap(err, "failed to wait for S3 artifact")
+	}
+	return artifact, nil
+}
+
+// githubActionsPluginArtifactSource provides the bundles uploaded as artifacts
+// of the PR's successful workflow runs. GitHub artifact downloads need a token
+// and are zipped, so the bundle is extracted and served by matterwick.
+type githubActionsPluginArtifactSource struct {
+	server *Server
+	client *github.Client
+	// artifactName is the artifact holding the bundle, empty for the first one.
+	artifactName string
+}
+
+func (a *githubActionsPluginArtifactSource) waitForArtifact(ctx context.Context, pr *model.PullRequest, logger logrus.FieldLogger) (pluginArtifact, error) {
+	logger = logger.WithField("sha", pr.Sha)
+	logger.Info("Waiting for plugin artifact on GitHub Actions")
+
+	for {
+		run, artifact, err := a.findArtifact(ctx, pr)
+		if err != nil {
+			logger.WithError(err).Warn("Failed to look up plugin artifact on GitHub Actions")
+		}
+		if artifact != nil {
+			logger.WithField("artifact", artifact.GetName()).Info("Plugin artifact found on GitHub Actions")
+			return a.serveArtifact(ctx, pr, run, artifact)
+		}
+
+		select {
+		case <-ctx.Done():
+			return pluginArtifact{}, errors.New("timed out waiting for GitHub Actions artifact")
+		case <-time.After(pluginArtifactPollInterval):
+			logger.Debug("GitHub Actions artifact not found yet. Waiting...")
+		}
+	}
+}
+
+// findArtifact returns the latest successful workflow run of the PR head commit
+// with a matching artifact, or nils if there is none yet.
+func (a *githubActionsPluginArtifactSource) findArtifact(ctx context.Context, pr *model.PullRequest) (*github.WorkflowRun, *github.Artifact, error) {
+	runs, _, err := a.client.Actions.ListRepositoryWorkflowRuns(ctx, pr.RepoOwner, pr.RepoName, &github.ListWorkflowRunsOptions{
+		Branch:      pr.Ref,
+		Event:       "pull_request",
+		Status:      "success",
+		ListOptions: github.ListOptions{PerPage: 50},
+	})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to list workflow runs")
+	}
+
+	// Runs are listed newest first.
+	for _, run := range runs.WorkflowRuns {
+		if run.GetHeadSHA() != pr.Sha {
+			continue
+		}
+		artifacts, _, err := a.client.Actions.ListWorkflowRunArtifacts(ctx, pr.RepoOwner, pr.RepoName, run.GetID(), &github.ListOptions{PerPage: 100})
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to list artifacts of workflow run %d", run.GetID())
+		}
+		for _, artifact := range artifacts.Artifacts {
+			if artifact.GetExpired() || (a.artifactName != "" && artifact.GetName() != a.artifactName) {
+				continue
+			}
+			return run, artifact, nil
+		}
+	}
+
+	return nil, nil, nil
+}
+
+// serveArtifact downloads the artifact, extracts the plugin bundle and serves it
+// from matterwick for the installation to download.
+func (a *githubActionsPluginArtifactSource) serveArtifact(ctx context.Context, pr *model.PullRequest, run *github.WorkflowRun, artifact *github.Artifact) (pluginArtifact, error) {
+	result := pluginArtifact{DownloadURL: run.GetHTMLURL()}
+	if a.server.Config.MatterWickURL == "" {
+		return result, errors.New("MatterWickURL must be configured to serve GitHub Actions artifacts")
+	}
+
+	archiveURL, _, err := a.client.Actions.DownloadArtifact(ctx, pr.RepoOwner, pr.RepoName, artifact.GetID(), true)
+	if err != nil {
+		return result, errors.Wrapf(err, "failed to get download URL of artifact %s", artifact.GetName())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, archiveURL.String(), nil)
+	if err != nil {
+		return result, errors.Wrap(err, "failed to create artifact download request")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return result, errors.Wrapf(err, "failed to download artifact %s", artifact.GetName())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return result, errors.Errorf("failed to download artifact %s: status %d", artifact.GetName(), resp.StatusCode)
+	}
+
+	archive, err := io.ReadAll(io.LimitReader(resp.Body, maxPluginArtifactSize+1))
+	if err != nil {
+		return result, errors.Wrapf(err, "failed to download artifact %s", artifact.GetName())
+	}
+	if len(archive) > maxPluginArtifactSize {
+		return result, errors.Errorf("artifact %s is larger than %d bytes", artifact.GetName(), maxPluginArtifactSize)
+	}
+
+	filename, bundle, err := extractPluginBundle(archive)
+	if err != nil {
+		return result, errors.Wrapf(err, "failed to extract plugin bundle from artifact %s", artifact.GetName())
+	}
+
+	result.bundleToken, result.InstallURL, err = a.server.servePluginBundle(filename, bundle)
+	return result, err
+}
+
+// extractPluginBundle returns the name and content of the first .tar.gz file in
+// a zip archive.
+func extractPluginBundle(archive []byte) (string, []byte, error) {
+	reader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return "", nil, errors.Wrap(err, "invalid zip archive")
+	}
+
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() || !strings.HasSuffix(file.Name, ".tar.gz") {
+			continue
+		}
+		rc, err := file.Open()
+		if err != nil {
+			return "", nil, errors.Wrapf(err, "failed to open %s", file.Name)
+		}
+		bundle, err := io.ReadAll(io.LimitReader(rc, maxPluginArtifactSize))
+		rc.Close()
+		if err != nil {
+			return "", nil, errors.Wrapf(err, "failed to read %s", file.Name)
+		}
+		return path.Base(file.Name), bundle, nil
+	}
+
+	return "", nil, errors.New("no .tar.gz bundle found")
+}
+
+// pluginBundle is a plugin bundle served by matterwick until it expires.
+type pluginBundle struct {
+	filename  string
+	content   []byte
+	expiresAt time.Time
+}
+
+// servePluginBundle serves the bundle for pluginBundleTTL, or until it is
+// dropped, and returns its token and URL. Bundles are held in memory, so an
+// error is returned when the bundles served would exceed maxPluginBundlesSize.
+func (s *Server) servePluginBundle(filename string, content []byte) (string, string, error) {
+	token := cloudModel.NewID()
+
+	s.pluginBundlesLock.Lock()
+	defer s.pluginBundlesLock.Unlock()
+	now := time.Now()
+	size := len(content)
+	for key, bundle := range s.pluginBundles {
+		if now.After(bundle.expiresAt) {
+			delete(s.pluginBundles, key)
+			continue
+		}
+		size += len(bundle.content)
+	}
+	if size > maxPluginBundlesSize {
+		return "", "", errors.Errorf("too many plugin bundles are being served, %d bytes would exceed the limit of %d bytes", size, maxPluginBundlesSize)
+	}
+	s.pluginBundles[token] = pluginBundle{filename: filename, content: content, expiresAt: now.Add(pluginBundleTTL)}
+
+	return token, fmt.Sprintf("%s/plugin_bundles/%s/%s", strings.TrimSuffix(s.Config.MatterWickURL, "/"), token, filename), nil
+}
+
+// dropPluginBundle stops serving a bundle registered with servePluginBundle.
+func (s *Server) dropPluginBundle(token string) {
+	if token == "" {
+		return
+	}
+	s.pluginBundlesLock.Lock()
+	defer s.pluginBundlesLock.Unlock()
+	delete(s.pluginBundles, token)
+}
+
+// servePluginBundleHandler serves a bundle registered with servePluginBundle.
+func (s *Server) servePluginBundleHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	s.pluginBundlesLock.Lock()
+	bundle, ok := s.pluginBundles[vars["token"]]
+	s.pluginBundlesLock.Unlock()
+
+	if !ok || bundle.filename != vars["filename"] || time.Now().After(bundle.expiresAt) {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	if _, err := w.Write(bundle.content); err != nil {
+		s.Logger.WithError(err).WithField("filename", bundle.filename).Warn("Failed to serve plugin bundle")
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mattermost/matterwick/model"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeZipArchive(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := w.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestExtractPluginBundle(t *testing.T) {
+	filename, bundle, err := extractPluginBundle(makeZipArchive(t, map[string]string{
+		"dist/jira-4.0.0.tar.gz": "bundle",
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, "jira-4.0.0.tar.gz", filename)
+	assert.Equal(t, []byte("bundle"), bundle)
+
+	_, _, err = extractPluginBundle(makeZipArchive(t, map[string]string{"coverage.txt": "100%"}))
+	assert.EqualError(t, err, "no .tar.gz bundle found")
+
+	_, _, err = extractPluginBundle([]byte("not a zip"))
+	assert.Error(t, err)
+}
+
+func TestPluginArtifactSource(t *testing.T) {
+	s := &Server{
+		Config: &MatterwickConfig{
+			SpinWickRepoDefaults: map[string]SpinWickRepoDefaults{
+				"mattermost-plugin-jira":  {PluginArtifactSource: pluginArtifactSourceGitHubActions, PluginArtifactName: "bundle"},
+				"mattermost-plugin-typo":  {PluginArtifactSource: "artifactory"},
+				"mattermost-plugin-calls": {PluginArtifactSource: pluginArtifactSourceS3},
+			},
+		},
+		Logger: logrus.New(),
+	}
+
+	source, ok := s.pluginArtifactSource("mattermost-plugin-jira").(*githubActionsPluginArtifactSource)
+	require.True(t, ok)
+	assert.Equal(t, "bundle", source.artifactName)
+
+	assert.IsType(t, &s3PluginArtifactSource{}, s.pluginArtifactSource("mattermost-plugin-typo"))
+	assert.IsType(t, &s3PluginArtifactSource{}, s.pluginArtifactSource("mattermost-plugin-calls"))
+	assert.IsType(t, &s3PluginArtifactSource{}, s.pluginArtifactSource("mattermost-plugin-playbooks"))
+}
+
+func TestGitHubActionsPluginArtifactSource(t *testing.T) {
+	const sha = "abcdef1234567890"
+	archive := makeZipArchive(t, map[string]string{"jira-4.0.0.tar.gz": "bundle"})
+
+	var runsRequests int
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/mattermost/mattermost-plugin-jira/actions/runs":
+			runsRequests++
+			assert.Equal(t, "feature", r.URL.Query().Get("branch"))
+			assert.Equal(t, "success", r.URL.Query().Get("status"))
+			// The build of the head commit shows up on the second poll.
+			if runsRequests == 1 {
+				fmt.Fprint(w, `{"total_count":1,"workflow_runs":[{"id":1,"head_sha":"older"}]}`)
+				return
+			}
+			fmt.Fprintf(w, `{"total_count":2,"workflow_runs":[{"id":2,"head_sha":"%s","html_url":"https://github.com/mattermost/mattermost-plugin-jira/actions/runs/2"},{"id":1,"head_sha":"older"}]}`, sha)
+		case "/repos/mattermost/mattermost-plugin-jira/actions/runs/2/artifacts":
+			fmt.Fprint(w, `{"total_count":3,"artifacts":[{"id":10,"name":"coverage"},{"id":11,"name":"bundle","expired":true},{"id":12,"name":"bundle"}]}`)
+		case "/repos/mattermost/mattermost-plugin-jira/actions/artifacts/12/zip":
+			w.Header().Set("Location", ts.URL+"/download/12")
+			w.WriteHeader(http.StatusFound)
+		case "/download/12":
+			w.Write(archive)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	pollInterval := pluginArtifactPollInterval
+	pluginArtifactPollInterval = time.Millisecond
+	defer func() { pluginArtifactPollInterval = pollInterval }()
+
+	s := &Server{
+		Config:        &MatterwickConfig{MatterWickURL: "https://matterwick.example.com/"},
+		Logger:        logrus.New(),
+		pluginBundles: make(map[string]pluginBundle),
+	}
+	source := &githubActionsPluginArtifactSource{
+		server:       s,
+		client:       newTestGitHubClient(t, ts),
+		artifactName: "bundle",
+	}
+
+	pr := &model.PullRequest{RepoOwner: "mattermost", RepoName: "mattermost-plugin-jira", Ref: "feature", Sha: sha}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	artifact, err := source.waitForArtifact(ctx, pr, s.Logger)
+	require.NoError(t, err)
+	assert.Equal(t, "https://github.com/mattermost/mattermost-plugin-jira/actions/runs/2", artifact.DownloadURL)
+	require.True(t, strings.HasPrefix(artifact.InstallURL, "https://matterwick.example.com/plugin_bundles/"), artifact.InstallURL)
+	assert.True(t, strings.HasSuffix(artifact.InstallURL, "/jira-4.0.0.tar.gz"), artifact.InstallURL)
+
+	// The bundle is served from matterwick for the installation to download.
+	router := mux.NewRouter()
+	router.HandleFunc("/plugin_bundles/{token}/{filename}", s.servePluginBundleHandler)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, strings.TrimPrefix(artifact.InstallURL, "https://matterwick.example.com"), nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "bundle", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/plugin_bundles/unknown/jira-4.0.0.tar.gz", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// Once installed, the bundle is no longer served.
+	s.dropPluginBundle(artifact.bundleToken)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, strings.TrimPrefix(artifact.InstallURL, "https://matterwick.example.com"), nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestServePluginBundleLimit(t *testing.T) {
+	s := &Server{
+		Config:        &MatterwickConfig{MatterWickURL: "https://matterwick.example.com"},
+		Logger:        logrus.New(),
+		pluginBundles: make(map[string]pluginBundle),
+	}
+
+	large := make([]byte, maxPluginBundlesSize/2)
+	first, _, err := s.servePluginBundle("a.tar.gz", large)
+	require.NoError(t, err)
+	_, _, err = s.servePluginBundle("b.tar.gz", large)
+	require.NoError(t, err)
+
+	// The bundles held in memory are capped.
+	_, _, err = s.servePluginBundle("c.tar.gz", []byte("bundle"))
+	require.Error(t, err)
+
+	// Dropping an installed bundle frees room for new ones.
+	s.dropPluginBundle(first)
+	_, url, err := s.servePluginBundle("c.tar.gz", []byte("bundle"))
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(url, "/c.tar.gz"), url)
+
+	// Expired bundles do not count against the limit.
+	s.pluginBundlesLock.Lock()
+	for token, bundle := range s.pluginBundles {
+		bundle.expiresAt = time.Now().Add(-time.Minute)
+		s.pluginBundles[token] = bundle
+	}
+	s.pluginBundlesLock.Unlock()
+	_, _, err = s.servePluginBundle("d.tar.gz", large)
+	require.NoError(t, err)
+	assert.Len(t, s.pluginBundles, 1)
+}
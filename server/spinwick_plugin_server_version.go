@@ -0,0 +1,149 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/google/go-github/v32/github"
+	"github.com/mattermost/matterwick/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// pluginManifest is the part of a plugin's plugin.json matterwick uses.
+type pluginManifest struct {
+	MinServerVersion string `json:"min_server_version"`
+}
+
+// validatePluginServerVersion returns an error if version cannot be used as
+// the server of a plugin SpinWick. An empty value is valid and means the
+// version is chosen from the plugin manifest.
+func validatePluginServerVersion(version string) error {
+	if version == "" || version == "master" || strings.HasPrefix(version, "release-") {
+		return nil
+	}
+	if _, err := semver.ParseTolerant(version); err != nil {
+		return fmt.Errorf("invalid server version %q, expected e.g. 10.11.0, release-10.11 or master", version)
+	}
+	return nil
+}
+
+// pluginServerTagSatisfies reports whether the server image tag satisfies the
+// minimum server version. master is ahead of every release and tags that are
+// not release branches cannot be compared, so they are assumed to satisfy it.
+func pluginServerTagSatisfies(tag string, minVersion semver.Version) bool {
+	if !strings.HasPrefix(tag, "release-") {
+		return true
+	}
+	v, err := semver.ParseTolerant(strings.TrimPrefix(tag, "release-"))
+	if err != nil {
+		return true
+	}
+	// A release branch image is built from the newest patch of the branch.
+	return v.Major > minVersion.Major || (v.Major == minVersion.Major && v.Minor >= minVersion.Minor)
+}
+
+// choosePluginServerTag returns the server image tag for a plugin SpinWick. It
+// prefers the default tag and falls back to the release branch of the minimum
+// server version and then master. The warning is set when no published image
+// satisfies the minimum server version, in which case the default tag is used.
+func choosePluginServerTag(defaultTag, minServerVersion string, tagExists func(tag string) bool) (string, string) {
+	if minServerVersion == "" {
+		return defaultTag, ""
+	}
+	minVersion, err := semver.ParseTolerant(minServerVersion)
+	if err != nil {
+		return defaultTag, fmt.Sprintf("The plugin's min_server_version `%s` is not a valid version, using server `%s`.", minServerVersion, defaultTag)
+	}
+
+	candidates := []string{defaultTag, fmt.Sprintf("release-%d.%d", minVersion.Major, minVersion.Minor), "master"}
+	for _, tag := range candidates {
+		if pluginServerTagSatisfies(tag, minVersion) && tagExists(tag) {
+			return tag, ""
+		}
+	}
+
+	return defaultTag, fmt.Sprintf("No server image satisfies the plugin's min_server_version `%s`, using server `%s`. The plugin may fail to enable.", minServerVersion, defaultTag)
+}
+
+// getPluginManifest returns the plugin.json of the PR head commit, or nil if the
+// repository has none.
+func (s *Server) getPluginManifest(pr *model.PullRequest) (*pluginManifest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	file, _, resp, err := s.githubClient().Repositories.GetContents(ctx, pr.RepoOwner, pr.RepoName, "plugin.json", &github.RepositoryContentGetOptions{Ref: pr.Sha})
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get plugin.json")
+	}
+
+	content, err := file.GetContent()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode plugin.json")
+	}
+
+	var manifest pluginManifest
+	if err = json.Unmarshal([]byte(content), &manifest); err != nil {
+		return nil, errors.Wrap(err, "failed to parse plugin.json")
+	}
+	return &manifest, nil
+}
+
+// pluginServerImageTag returns the server image tag for a plugin SpinWick: the
+// /spinwick create --server-version override, or a published image that
+// satisfies the plugin's min_server_version. Compatibility problems are
+// reported on the PR but do not prevent the SpinWick from being created.
+func (s *Server) pluginServerImageTag(pr *model.PullRequest, opts spinWickOptions, logger logrus.FieldLogger) string {
+	defaultTag := pluginSpinwickImageTag(s.resolveMattermostServerVersion())
+	if opts.serverVersion != "" {
+		defaultTag = pluginSpinwickImageTag(opts.serverVersion)
+	}
+
+	manifest, err := s.getPluginManifest(pr)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to read the plugin manifest, using the default server version")
+		return defaultTag
+	}
+	if manifest == nil || manifest.MinServerVersion == "" {
+		return defaultTag
+	}
+	logger = logger.WithField("min_server_version", manifest.MinServerVersion)
+
+	if opts.serverVersion != "" {
+		if minVersion, parseErr := semver.ParseTolerant(manifest.MinServerVersion); parseErr == nil && !pluginServerTagSatisfies(defaultTag, minVersion) {
+			s.addSpinWickWarning(pr, "",
+				fmt.Sprintf("The requested server `%s` does not satisfy the plugin's min_server_version `%s`. The plugin may fail to enable.", defaultTag, manifest.MinServerVersion))
+		}
+		return defaultTag
+	}
+
+	reg, err := s.Builds.dockerRegistryClient(s, defaultPluginImage)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to get docker registry client, using the default server version")
+		return defaultTag
+	}
+	tagExists := func(tag string) bool {
+		// A short timeout makes waitForImage check the tag once.
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return s.Builds.waitForImage(ctx, reg, tag, defaultPluginImage, logger) == nil
+	}
+
+	tag, warning := choosePluginServerTag(defaultTag, manifest.MinServerVersion, tagExists)
+	if warning != "" {
+		logger.Warn("No compatible server image found for the plugin")
+		s.addSpinWickWarning(pr, "", warning)
+	}
+	return tag
+}
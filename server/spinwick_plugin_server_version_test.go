@@ -0,0 +1,90 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mattermost/matterwick/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChoosePluginServerTag(t *testing.T) {
+	published := map[string]bool{"release-10.11": true, "release-9.11": true, "master": true}
+	tagExists := func(tag string) bool { return published[tag] }
+
+	for _, tc := range []struct {
+		name             string
+		defaultTag       string
+		minServerVersion string
+		expectedTag      string
+		expectWarning    bool
+	}{
+		{name: "no minimum", defaultTag: "release-10.11", expectedTag: "release-10.11"},
+		{name: "default satisfies minimum", defaultTag: "release-10.11", minServerVersion: "9.11.0", expectedTag: "release-10.11"},
+		{name: "same minor with a patch", defaultTag: "release-10.11", minServerVersion: "10.11.3", expectedTag: "release-10.11"},
+		{name: "newer minimum falls back to master", defaultTag: "release-10.11", minServerVersion: "11.0.0", expectedTag: "master"},
+		{name: "default image missing", defaultTag: "release-10.12", minServerVersion: "9.11.0", expectedTag: "release-9.11"},
+		{name: "invalid minimum", defaultTag: "release-10.11", minServerVersion: "latest", expectedTag: "release-10.11", expectWarning: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tag, warning := choosePluginServerTag(tc.defaultTag, tc.minServerVersion, tagExists)
+			assert.Equal(t, tc.expectedTag, tag)
+			assert.Equal(t, tc.expectWarning, warning != "", warning)
+		})
+	}
+
+	t.Run("no compatible image", func(t *testing.T) {
+		tag, warning := choosePluginServerTag("release-10.11", "11.0.0", func(string) bool { return false })
+		assert.Equal(t, "release-10.11", tag)
+		assert.Contains(t, warning, "No server image satisfies the plugin's min_server_version `11.0.0`")
+	})
+}
+
+func TestValidatePluginServerVersion(t *testing.T) {
+	for version, valid := range map[string]bool{
+		"":              true,
+		"10.11.0":       true,
+		"release-10.11": true,
+		"master":        true,
+		"main":          false,
+	} {
+		err := validatePluginServerVersion(version)
+		if valid {
+			assert.NoError(t, err, version)
+		} else {
+			assert.Error(t, err, version)
+		}
+	}
+}
+
+func TestGetPluginManifest(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/mattermost/mattermost-plugin-jira/contents/plugin.json":
+			assert.Equal(t, "abcdef1", r.URL.Query().Get("ref"))
+			content := base64.StdEncoding.EncodeToString([]byte(`{"id": "jira", "min_server_version": "9.11.0"}`))
+			fmt.Fprintf(w, `{"type":"file","encoding":"base64","content":"%s"}`, content)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	s := &Server{Config: &MatterwickConfig{}, githubAPIBase: ts.URL + "/"}
+
+	manifest, err := s.getPluginManifest(&model.PullRequest{RepoOwner: "mattermost", RepoName: "mattermost-plugin-jira", Sha: "abcdef1"})
+	require.NoError(t, err)
+	require.NotNil(t, manifest)
+	assert.Equal(t, "9.11.0", manifest.MinServerVersion)
+
+	manifest, err = s.getPluginManifest(&model.PullRequest{RepoOwner: "mattermost", RepoName: "mattermost-plugin-calls", Sha: "abcdef1"})
+	require.NoError(t, err)
+	assert.Nil(t, manifest)
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	mattermostModel "github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/matterwick/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// pluginSettingsBlockRegex matches a fenced spinwick-plugin-settings block in a
+// PR description, e.g.
+//
+//	```spinwick-plugin-settings
+//	{"webhooksecret": "secret", "enablefeature": true}
+//	```
+var pluginSettingsBlockRegex = regexp.MustCompile("(?s)```spinwick-plugin-settings[ \t]*\r?\n(.*?)```")
+
+// parsePluginSettingsBlock returns the settings of the spinwick-plugin-settings
+// block in the PR description, or nil if there is none.
+func parsePluginSettingsBlock(body string) (map[string]interface{}, error) {
+	match := pluginSettingsBlockRegex.FindStringSubmatch(body)
+	if match == nil {
+		return nil, nil
+	}
+
+	var settings map[string]interface{}
+	if err := json.Unmarshal([]byte(match[1]), &settings); err != nil {
+		return nil, errors.Wrap(err, "invalid spinwick-plugin-settings block in the PR description")
+	}
+	return settings, nil
+}
+
+// pluginSettings returns the settings for the PR's plugin: the repository
+// settings from config, overridden by the PR description block. Keys are kept
+// as given, and a PR setting replaces the config setting whose key only
+// differs in case.
+func (s *Server) pluginSettings(pr *model.PullRequest) (map[string]interface{}, error) {
+	settings := make(map[string]interface{})
+	for key, value := range s.Config.SpinWickRepoDefaults[pr.RepoName].PluginSettings {
+		settings[key] = value
+	}
+
+	prSettings, err := parsePluginSettingsBlock(pr.Body)
+	for key, value := range prSettings {
+		for existing := range settings {
+			if strings.EqualFold(existing, key) {
+				delete(settings, existing)
+			}
+		}
+		settings[key] = value
+	}
+
+	return settings, err
+}
+
+// applyPluginSettings logs in to a SpinWick as sysadmin and patches the plugin
+// settings into its config through the API, and returns the keys that were
+// applied, in order. The settings are sent as a map keyed by plugin ID, as
+// config paths cannot address plugin IDs containing dots.
+func applyPluginSettings(mmURL, sysadminPassword, pluginID string, settings map[string]interface{}) ([]string, error) {
+	client := mattermostModel.NewAPIv4Client(mmURL)
+	if _, _, err := client.Login(spinWickSysadminUsername, sysadminPassword); err != nil {
+		return nil, errors.Wrap(err, "failed to log in as sysadmin")
+	}
+
+	patch := &mattermostModel.Config{}
+	patch.PluginSettings.Plugins = map[string]map[string]interface{}{pluginID: settings}
+	if _, _, err := client.PatchConfig(patch); err != nil {
+		return nil, errors.Wrap(err, "failed to patch the plugin settings")
+	}
+
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// configurePlugin applies the plugin settings of the PR and returns the report
+// for the GitHub comment, or an empty string if there are no settings. Failures
+// are reported but do not fail the SpinWick.
+func (s *Server) configurePlugin(pr *model.PullRequest, mmURL, sysadminPassword string, logger logrus.FieldLogger) string {
+	var lines []string
+
+	settings, err := s.pluginSettings(pr)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to parse plugin settings")
+		lines = append(lines, fmt.Sprintf("- :warning: %s", err.Error()))
+	}
+
+	if len(settings) > 0 && sysadminPassword == "" {
+		lines = append(lines, "- :warning: the sysadmin credentials of the SpinWick are not available, the plugin settings were not applied")
+	} else if len(settings) > 0 {
+		applied, applyErr := applyPluginSettings(mmURL, sysadminPassword, s.pluginIDForRepo(pr.RepoName), settings)
+		if len(applied) > 0 {
+			lines = append(lines, fmt.Sprintf("- :white_check_mark: applied `%s`", strings.Join(applied, "`, `")))
+		}
+		if applyErr != nil {
+			logger.WithError(applyErr).Warn("Failed to apply plugin settings")
+			lines = append(lines, fmt.Sprintf("- :x: %s", applyErr.Error()))
+		}
+	}
+
+	if len(lines) == 0 {
+		return ""
+	}
+	return "**Plugin settings:**\n" + strings.Join(lines, "\n")
+}
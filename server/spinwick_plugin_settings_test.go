@@ -0,0 +1,118 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mattermost/matterwick/model"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPluginSettings(t *testing.T) {
+	s := &Server{
+		Config: &MatterwickConfig{
+			SpinWickRepoDefaults: map[string]SpinWickRepoDefaults{
+				"mattermost-plugin-github": {PluginSettings: map[string]interface{}{
+					"WebhookSecret":     "from-config",
+					"EnableLeftSidebar": true,
+				}},
+			},
+		},
+	}
+
+	t.Run("config only", func(t *testing.T) {
+		settings, err := s.pluginSettings(&model.PullRequest{RepoName: "mattermost-plugin-github", Body: "No settings here."})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"WebhookSecret": "from-config", "EnableLeftSidebar": true}, settings)
+	})
+
+	t.Run("PR description overrides config", func(t *testing.T) {
+		body := "Adds a setting.\r\n\r\n```spinwick-plugin-settings\r\n{\"webhookSecret\": \"from-pr\", \"MaxItems\": 5}\r\n```\r\n"
+		settings, err := s.pluginSettings(&model.PullRequest{RepoName: "mattermost-plugin-github", Body: body})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"webhookSecret": "from-pr", "EnableLeftSidebar": true, "MaxItems": float64(5)}, settings)
+	})
+
+	t.Run("invalid block", func(t *testing.T) {
+		body := "```spinwick-plugin-settings\n{not json}\n```"
+		settings, err := s.pluginSettings(&model.PullRequest{RepoName: "mattermost-plugin-jira", Body: body})
+		require.Error(t, err)
+		assert.Empty(t, settings)
+	})
+}
+
+func TestConfigurePlugin(t *testing.T) {
+	var patches []map[string]map[string]interface{}
+	failPatch := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v4/users/login":
+			w.Header().Set("Token", "token")
+			w.Write([]byte(`{"id":"sysadmin-id"}`))
+		case "/api/v4/config/patch":
+			var patch struct {
+				PluginSettings struct {
+					Plugins map[string]map[string]interface{}
+				}
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&patch))
+			patches = append(patches, patch.PluginSettings.Plugins)
+			if failPatch {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	s := &Server{
+		Config: &MatterwickConfig{
+			PluginRepoToIDMapping: map[string]string{"mattermost-plugin-calls": "com.mattermost.calls"},
+		},
+		Logger: logrus.New(),
+	}
+	body := "```spinwick-plugin-settings\n{\"WebhookSecret\": \"secret\", \"EnableCodePreview\": true}\n```"
+
+	t.Run("applied", func(t *testing.T) {
+		patches, failPatch = nil, false
+		report := s.configurePlugin(&model.PullRequest{RepoName: "mattermost-plugin-calls", Body: body}, ts.URL, "password", s.Logger)
+
+		assert.Equal(t, []map[string]map[string]interface{}{
+			{"com.mattermost.calls": {"WebhookSecret": "secret", "EnableCodePreview": true}},
+		}, patches, "dotted plugin IDs and the case of keys are kept")
+		assert.Equal(t, "**Plugin settings:**\n- :white_check_mark: applied `EnableCodePreview`, `WebhookSecret`", report)
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		patches, failPatch = nil, true
+		report := s.configurePlugin(&model.PullRequest{RepoName: "mattermost-plugin-calls", Body: body}, ts.URL, "password", s.Logger)
+
+		assert.Len(t, patches, 1)
+		assert.NotContains(t, report, ":white_check_mark:")
+		assert.Contains(t, report, "- :x: failed to patch the plugin settings")
+	})
+
+	t.Run("no credentials", func(t *testing.T) {
+		patches, failPatch = nil, false
+		report := s.configurePlugin(&model.PullRequest{RepoName: "mattermost-plugin-calls", Body: body}, ts.URL, "", s.Logger)
+
+		assert.Empty(t, patches)
+		assert.Contains(t, report, "the sysadmin credentials of the SpinWick are not available")
+	})
+
+	t.Run("no settings", func(t *testing.T) {
+		patches, failPatch = nil, false
+		assert.Empty(t, s.configurePlugin(&model.PullRequest{RepoName: "mattermost-plugin-jira"}, ts.URL, "password", s.Logger))
+		assert.Empty(t, patches)
+	})
+}
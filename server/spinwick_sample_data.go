@@ -0,0 +1,181 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"fmt"
+
+	mattermostModel "github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// seedSampleDataForSpinWick logs in to a freshly initialized SpinWick as sysadmin
+// and seeds it with the data described by the named profile.
+func (s *Server) seedSampleDataForSpinWick(mmURL, sysadminPassword string, prNumber int, profileName string, logger logrus.FieldLogger) error {
+	profile, ok := s.Config.SampleDataProfiles[profileName]
+	if !ok {
+		return errors.Errorf("sample data profile %q is not configured", profileName)
+	}
+	logger = logger.WithField("sample_data", profileName)
+	logger.Info("Seeding sample data")
+
+	client := mattermostModel.NewAPIv4Client(mmURL)
+	admin, _, err := client.Login("sysadmin", sysadminPassword)
+	if err != nil {
+		return errors.Wrap(err, "failed to log in as sysadmin")
+	}
+	team, _, err := client.GetTeamByName(fmt.Sprintf("pr%d", prNumber), "")
+	if err != nil {
+		return errors.Wrap(err, "failed to get PR team")
+	}
+	user, _, err := client.GetUserByUsername("user-1", "")
+	if err != nil {
+		return errors.Wrap(err, "failed to get standard test user")
+	}
+
+	return seedSampleData(client, team, admin.Id, user.Id, profile, logger)
+}
+
+// seedSampleData creates the teams, channels, posts, threads, reactions, file
+// posts and direct messages described by profile. The client must be logged in
+// as adminID; userID is added to everything that is created so both accounts
+// see the same data.
+// Separated so it can be unit-tested without DNS/ping dependencies.
+func seedSampleData(client *mattermostModel.Client4, prTeam *mattermostModel.Team, adminID, userID string, profile SampleDataProfile, logger logrus.FieldLogger) error {
+	teams := []*mattermostModel.Team{prTeam}
+	for i := 1; i <= profile.Teams; i++ {
+		name := fmt.Sprintf("%s-team-%d", prTeam.Name, i)
+		team, _, err := client.CreateTeam(&mattermostModel.Team{
+			Name:        name,
+			DisplayName: fmt.Sprintf("Sample Team %d", i),
+			Type:        mattermostModel.TeamOpen,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "failed to create team %s", name)
+		}
+		if _, _, err = client.AddTeamMember(team.Id, userID); err != nil {
+			return errors.Wrapf(err, "failed to add standard test user to team %s", name)
+		}
+		teams = append(teams, team)
+	}
+
+	for _, team := range teams {
+		var channels []*mattermostModel.Channel
+		for i := 1; i <= profile.PublicChannels+profile.PrivateChannels; i++ {
+			channelType := mattermostModel.ChannelTypeOpen
+			name := fmt.Sprintf("public-%d", i)
+			if i > profile.PublicChannels {
+				channelType = mattermostModel.ChannelTypePrivate
+				name = fmt.Sprintf("private-%d", i-profile.PublicChannels)
+			}
+			channel, _, err := client.CreateChannel(&mattermostModel.Channel{
+				TeamId:      team.Id,
+				Name:        name,
+				DisplayName: name,
+				Type:        channelType,
+			})
+			if err != nil {
+				return errors.Wrapf(err, "failed to create channel %s in team %s", name, team.Name)
+			}
+			if _, _, err = client.AddChannelMember(channel.Id, userID); err != nil {
+				return errors.Wrapf(err, "failed to add standard test user to channel %s", name)
+			}
+			channels = append(channels, channel)
+		}
+
+		for _, channel := range channels {
+			if err := seedSampleChannelPosts(client, channel, adminID, profile); err != nil {
+				return err
+			}
+		}
+
+		if len(channels) > 0 {
+			for i := 1; i <= profile.FilePosts; i++ {
+				if err := createSampleFilePost(client, channels[0].Id, i); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if profile.DirectMessages > 0 {
+		dm, _, err := client.CreateDirectChannel(adminID, userID)
+		if err != nil {
+			return errors.Wrap(err, "failed to create direct message channel")
+		}
+		for i := 1; i <= profile.DirectMessages; i++ {
+			if _, _, err = client.CreatePost(&mattermostModel.Post{
+				ChannelId: dm.Id,
+				Message:   fmt.Sprintf("Sample direct message %d", i),
+			}); err != nil {
+				return errors.Wrap(err, "failed to create direct message")
+			}
+		}
+	}
+
+	logger.WithField("teams", len(teams)).Info("Sample data seeded")
+	return nil
+}
+
+// seedSampleChannelPosts creates the root posts of a channel, turns the first one
+// into a thread and reacts to every root post. Mattermost only accepts reactions
+// of the session user, so sessionUserID must be the user the client is logged in
+// as.
+func seedSampleChannelPosts(client *mattermostModel.Client4, channel *mattermostModel.Channel, sessionUserID string, profile SampleDataProfile) error {
+	for i := 1; i <= profile.PostsPerChannel; i++ {
+		post, _, err := client.CreatePost(&mattermostModel.Post{
+			ChannelId: channel.Id,
+			Message:   fmt.Sprintf("Sample post %d in ~%s", i, channel.Name),
+		})
+		if err != nil {
+			return errors.Wrapf(err, "failed to create post in channel %s", channel.Name)
+		}
+
+		if i == 1 {
+			for j := 1; j <= profile.RepliesPerThread; j++ {
+				if _, _, err = client.CreatePost(&mattermostModel.Post{
+					ChannelId: channel.Id,
+					RootId:    post.Id,
+					Message:   fmt.Sprintf("Sample reply %d", j),
+				}); err != nil {
+					return errors.Wrapf(err, "failed to create reply in channel %s", channel.Name)
+				}
+			}
+		}
+
+		if profile.Reactions {
+			if _, _, err = client.SaveReaction(&mattermostModel.Reaction{
+				UserId:    sessionUserID,
+				PostId:    post.Id,
+				EmojiName: "+1",
+			}); err != nil {
+				return errors.Wrapf(err, "failed to add reaction in channel %s", channel.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+func createSampleFilePost(client *mattermostModel.Client4, channelID string, n int) error {
+	filename := fmt.Sprintf("sample-file-%d.txt", n)
+	upload, _, err := client.UploadFile([]byte(fmt.Sprintf("Sample file %d uploaded by MatterWick.\n", n)), channelID, filename)
+	if err != nil {
+		return errors.Wrapf(err, "failed to upload %s", filename)
+	}
+	if len(upload.FileInfos) == 0 {
+		return errors.Errorf("no file info returned for %s", filename)
+	}
+
+	if _, _, err = client.CreatePost(&mattermostModel.Post{
+		ChannelId: channelID,
+		Message:   fmt.Sprintf("Sample file post %d", n),
+		FileIds:   mattermostModel.StringArray{upload.FileInfos[0].Id},
+	}); err != nil {
+		return errors.Wrapf(err, "failed to create post for %s", filename)
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	mattermostModel "github.com/mattermost/mattermost-server/v6/model"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sampleDataMock is a minimal Mattermost API that records how many objects of
+// each kind were created. Like Mattermost, it rejects reactions of users other
+// than the session user.
+type sampleDataMock struct {
+	lock          sync.Mutex
+	counts        map[string]int
+	failOn        string
+	sessionUserID string
+}
+
+func (m *sampleDataMock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var kind string
+	switch {
+	case r.URL.Path == "/api/v4/teams":
+		kind = "team"
+	case strings.HasPrefix(r.URL.Path, "/api/v4/teams/") && strings.HasSuffix(r.URL.Path, "/members"):
+		kind = "team_member"
+	case r.URL.Path == "/api/v4/channels/direct":
+		kind = "dm"
+	case r.URL.Path == "/api/v4/channels":
+		kind = "channel"
+	case strings.HasPrefix(r.URL.Path, "/api/v4/channels/") && strings.HasSuffix(r.URL.Path, "/members"):
+		kind = "channel_member"
+	case r.URL.Path == "/api/v4/posts":
+		kind = "post"
+	case r.URL.Path == "/api/v4/reactions":
+		kind = "reaction"
+	case r.URL.Path == "/api/v4/files":
+		kind = "file"
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if kind == "reaction" {
+		var reaction mattermostModel.Reaction
+		if err := json.NewDecoder(r.Body).Decode(&reaction); err != nil || reaction.UserId != m.sessionUserID {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"message":"You do not have the appropriate permissions."}`))
+			return
+		}
+	}
+
+	if kind == m.failOn {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message":"Internal server error"}`))
+		return
+	}
+
+	m.counts[kind]++
+	w.WriteHeader(http.StatusCreated)
+	switch kind {
+	case "file":
+		w.Write([]byte(`{"file_infos":[{"id":"file-1"}]}`))
+	case "team":
+		w.Write([]byte(`{"id":"team-new","name":"pr1-team-1"}`))
+	default:
+		w.Write([]byte(`{"id":"` + kind + `-id"}`))
+	}
+}
+
+func TestSeedSampleData(t *testing.T) {
+	profile := SampleDataProfile{
+		Teams:            1,
+		PublicChannels:   2,
+		PrivateChannels:  1,
+		PostsPerChannel:  5,
+		RepliesPerThread: 3,
+		Reactions:        true,
+		DirectMessages:   3,
+		FilePosts:        1,
+	}
+	prTeam := &mattermostModel.Team{Id: "team-pr", Name: "pr1"}
+
+	t.Run("creates the profile's data", func(t *testing.T) {
+		mock := &sampleDataMock{counts: make(map[string]int), sessionUserID: "admin-id"}
+		server := httptest.NewServer(mock)
+		defer server.Close()
+
+		client := mattermostModel.NewAPIv4Client(server.URL)
+		err := seedSampleData(client, prTeam, "admin-id", "user-id", profile, logrus.New())
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, mock.counts["team"])
+		assert.Equal(t, 1, mock.counts["team_member"])
+		assert.Equal(t, 6, mock.counts["channel"], "3 channels in each of the 2 teams")
+		assert.Equal(t, 6, mock.counts["channel_member"])
+		assert.Equal(t, 30, mock.counts["reaction"], "one reaction per root post")
+		assert.Equal(t, 2, mock.counts["file"], "one file post per team")
+		assert.Equal(t, 1, mock.counts["dm"])
+		// 6 channels * (5 roots + 3 replies) + 2 file posts + 3 direct messages
+		assert.Equal(t, 53, mock.counts["post"])
+	})
+
+	t.Run("empty profile creates nothing", func(t *testing.T) {
+		mock := &sampleDataMock{counts: make(map[string]int), sessionUserID: "admin-id"}
+		server := httptest.NewServer(mock)
+		defer server.Close()
+
+		client := mattermostModel.NewAPIv4Client(server.URL)
+		err := seedSampleData(client, prTeam, "admin-id", "user-id", SampleDataProfile{}, logrus.New())
+		require.NoError(t, err)
+		assert.Empty(t, mock.counts)
+	})
+
+	t.Run("reacts as the session user", func(t *testing.T) {
+		mock := &sampleDataMock{counts: make(map[string]int), sessionUserID: "other-id"}
+		server := httptest.NewServer(mock)
+		defer server.Close()
+
+		client := mattermostModel.NewAPIv4Client(server.URL)
+		err := seedSampleData(client, prTeam, "admin-id", "user-id", profile, logrus.New())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to add reaction")
+	})
+
+	t.Run("fails when a channel cannot be created", func(t *testing.T) {
+		mock := &sampleDataMock{counts: make(map[string]int), failOn: "channel", sessionUserID: "admin-id"}
+		server := httptest.NewServer(mock)
+		defer server.Close()
+
+		client := mattermostModel.NewAPIv4Client(server.URL)
+		err := seedSampleData(client, prTeam, "admin-id", "user-id", profile, logrus.New())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to create channel public-1")
+	})
+}
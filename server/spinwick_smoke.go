@@ -0,0 +1,229 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	mattermostModel "github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/matterwick/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// smokeTestWebSocketTimeout is how long the smoke test waits for the
+	// websocket event of its post.
+	smokeTestWebSocketTimeout = 15 * time.Second
+
+	// provisioningStageSmokeTest is the check run stage of the smoke test.
+	provisioningStageSmokeTest = "Running the smoke test"
+)
+
+// smokeCheck is the result of one check of the SpinWick smoke test.
+type smokeCheck struct {
+	Name string
+	// Err is nil for passed checks.
+	Err error
+	// Skipped checks could not run because an earlier check failed.
+	Skipped bool
+}
+
+// smokeReport is the result of the SpinWick smoke test.
+type smokeReport []smokeCheck
+
+// failed reports whether any check failed.
+func (r smokeReport) failed() bool {
+	for _, check := range r {
+		if check.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// err returns the failures of the report as one error, or nil if it passed.
+func (r smokeReport) err() error {
+	var failures []string
+	for _, check := range r {
+		if check.Err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", check.Name, check.Err.Error()))
+		}
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(failures, "; "))
+}
+
+// markdown renders the report for the success comment.
+func (r smokeReport) markdown() string {
+	var sb strings.Builder
+	if r.failed() {
+		sb.WriteString(":x: **Smoke test failed:** the SpinWick is up but may be broken.")
+	} else {
+		sb.WriteString(":white_check_mark: **Smoke test passed**")
+	}
+	sb.WriteString("\n\n| Check | Result |\n|---|---|")
+	for _, check := range r {
+		result := ":white_check_mark:"
+		if check.Skipped {
+			result = ":heavy_minus_sign: skipped"
+		} else if check.Err != nil {
+			result = ":x: " + check.Err.Error()
+		}
+		sb.WriteString(fmt.Sprintf("\n| %s | %s |", check.Name, result))
+	}
+	return sb.String()
+}
+
+// runSpinWickSmokeTest checks that a new SpinWick is usable beyond answering
+// pings: it logs in, uploads a file, posts it in the PR team, waits for the post
+// on a websocket and checks that no plugin failed to run.
+func runSpinWickSmokeTest(mmURL string, prNumber int, sysadminPassword string, logger logrus.FieldLogger) smokeReport {
+	client := mattermostModel.NewAPIv4Client(mmURL)
+	if _, _, err := client.Login(spinWickSysadminUsername, sysadminPassword); err != nil {
+		return smokeReport{
+			{Name: "Log in", Err: errors.Wrap(err, "failed to log in as sysadmin")},
+			{Name: "Upload a file", Skipped: true},
+			{Name: "Create a post", Skipped: true},
+			{Name: "WebSocket", Skipped: true},
+			{Name: "Plugins", Skipped: true},
+		}
+	}
+	report := smokeReport{{Name: "Log in"}}
+
+	// The websocket is opened first so it receives the event of the new post.
+	ws, wsErr := mattermostModel.NewWebSocketClient4("ws"+strings.TrimPrefix(mmURL, "http"), client.AuthToken)
+	if wsErr == nil {
+		ws.Listen()
+		defer ws.Close()
+	}
+
+	post, postChecks := smokeTestPost(client, prNumber)
+	report = append(report, postChecks...)
+
+	wsCheck := smokeCheck{Name: "WebSocket"}
+	switch {
+	case wsErr != nil:
+		wsCheck.Err = errors.Wrap(wsErr, "failed to connect")
+	case post == nil:
+		wsCheck.Skipped = true
+	default:
+		wsCheck.Err = waitForPostedEvent(ws, post.Id, smokeTestWebSocketTimeout)
+	}
+	report = append(report, wsCheck)
+
+	if post != nil {
+		if _, err := client.DeletePost(post.Id); err != nil {
+			logger.WithError(err).Warn("Failed to delete the smoke test post")
+		}
+	}
+
+	statuses, _, err := client.GetPluginStatuses()
+	if err != nil {
+		err = errors.Wrap(err, "failed to get plugin statuses")
+	} else {
+		err = failedPlugins(statuses)
+	}
+	report = append(report, smokeCheck{Name: "Plugins", Err: err})
+
+	return report
+}
+
+// smokeTestPost uploads a file and posts it in the town square of the PR team.
+// The post is nil if it could not be created.
+func smokeTestPost(client *mattermostModel.Client4, prNumber int) (*mattermostModel.Post, smokeReport) {
+	uploadCheck := smokeCheck{Name: "Upload a file"}
+	postCheck := smokeCheck{Name: "Create a post"}
+
+	channel, _, err := client.GetChannelByNameForTeamName("town-square", fmt.Sprintf("pr%d", prNumber), "")
+	if err != nil {
+		uploadCheck.Skipped = true
+		postCheck.Err = errors.Wrap(err, "failed to get the town-square channel")
+		return nil, smokeReport{uploadCheck, postCheck}
+	}
+
+	var fileIDs mattermostModel.StringArray
+	uploaded, _, err := client.UploadFile([]byte("SpinWick smoke test"), channel.Id, "smoke-test.txt")
+	if err != nil {
+		uploadCheck.Err = errors.Wrap(err, "failed to upload")
+	} else if len(uploaded.FileInfos) == 0 {
+		uploadCheck.Err = errors.New("no file was uploaded")
+	} else {
+		fileIDs = mattermostModel.StringArray{uploaded.FileInfos[0].Id}
+	}
+
+	post, _, err := client.CreatePost(&mattermostModel.Post{
+		ChannelId: channel.Id,
+		Message:   "SpinWick smoke test",
+		FileIds:   fileIDs,
+	})
+	if err != nil {
+		postCheck.Err = errors.Wrap(err, "failed to create")
+		return nil, smokeReport{uploadCheck, postCheck}
+	}
+
+	return post, smokeReport{uploadCheck, postCheck}
+}
+
+// waitForPostedEvent waits for the websocket event of the post.
+func waitForPostedEvent(ws *mattermostModel.WebSocketClient, postID string, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case event, ok := <-ws.EventChannel:
+			if !ok {
+				if ws.ListenError != nil {
+					return errors.Wrap(ws.ListenError, "connection closed")
+				}
+				return errors.New("connection closed")
+			}
+			if event.EventType() != mattermostModel.WebsocketEventPosted {
+				continue
+			}
+			if postJSON, _ := event.GetData()["post"].(string); strings.Contains(postJSON, postID) {
+				return nil
+			}
+		case <-timer.C:
+			return errors.Errorf("no event received for the new post within %s", timeout)
+		}
+	}
+}
+
+// failedPlugins returns an error listing the plugins that failed to run.
+func failedPlugins(statuses mattermostModel.PluginStatuses) error {
+	var failed []string
+	for _, status := range statuses {
+		if status.State == mattermostModel.PluginStateFailedToStart || status.State == mattermostModel.PluginStateFailedToStayRunning {
+			failed = append(failed, status.PluginId)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	sort.Strings(failed)
+	return errors.Errorf("not running: %s", strings.Join(failed, ", "))
+}
+
+// smokeTestSpinWick runs the smoke test on a new SpinWick and returns the report
+// for its success comment, along with the failures of the report. Failures are
+// also reported to the Mattermost error channel. The SpinWick may still be
+// usable, so the success comment is posted either way.
+func (s *Server) smokeTestSpinWick(pr *model.PullRequest, ownerID, mmURL, installationID string, credentials spinWickCredentials, logger logrus.FieldLogger) (string, error) {
+	s.setProvisioningStage(ownerID, provisioningStageSmokeTest)
+
+	report := runSpinWickSmokeTest(mmURL, pr.Number, credentials.password(spinWickSysadminUsername), logger)
+	err := report.err()
+	if err != nil {
+		logger.WithError(err).Warn("SpinWick smoke test failed")
+		s.logPrettyErrorToMattermost("[ SpinWick ] Smoke Test Failed", pr, err, map[string]string{"Installation ID": installationID}, logger)
+	}
+	return report.markdown(), err
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	mattermostModel "github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/matterwick/model"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSmokeReport(t *testing.T) {
+	passed := smokeReport{{Name: "Log in"}, {Name: "Plugins"}}
+	assert.False(t, passed.failed())
+	assert.NoError(t, passed.err())
+	assert.Equal(t, ":white_check_mark: **Smoke test passed**\n\n| Check | Result |\n|---|---|\n| Log in | :white_check_mark: |\n| Plugins | :white_check_mark: |", passed.markdown())
+
+	failed := smokeReport{
+		{Name: "Log in"},
+		{Name: "Create a post", Err: errors.New("failed to create")},
+		{Name: "WebSocket", Skipped: true},
+	}
+	assert.True(t, failed.failed())
+	assert.EqualError(t, failed.err(), "Create a post: failed to create")
+	assert.Equal(t, ":x: **Smoke test failed:** the SpinWick is up but may be broken.\n\n| Check | Result |\n|---|---|\n"+
+		"| Log in | :white_check_mark: |\n| Create a post | :x: failed to create |\n| WebSocket | :heavy_minus_sign: skipped |", failed.markdown())
+}
+
+func TestFailedPlugins(t *testing.T) {
+	assert.NoError(t, failedPlugins(mattermostModel.PluginStatuses{
+		{PluginId: "playbooks", State: mattermostModel.PluginStateRunning},
+		{PluginId: "disabled", State: mattermostModel.PluginStateNotRunning},
+	}))
+	assert.EqualError(t, failedPlugins(mattermostModel.PluginStatuses{
+		{PluginId: "playbooks", State: mattermostModel.PluginStateRunning},
+		{PluginId: "jira", State: mattermostModel.PluginStateFailedToStayRunning},
+		{PluginId: "boards", State: mattermostModel.PluginStateFailedToStart},
+	}), "not running: boards, jira")
+}
+
+func TestWaitForPostedEvent(t *testing.T) {
+	postedEvent := func(postID string) *mattermostModel.WebSocketEvent {
+		event := mattermostModel.NewWebSocketEvent(mattermostModel.WebsocketEventPosted, "", "channel-id", "", nil)
+		event.Add("post", `{"id":"`+postID+`"}`)
+		return event
+	}
+
+	t.Run("posted", func(t *testing.T) {
+		ws := &mattermostModel.WebSocketClient{EventChannel: make(chan *mattermostModel.WebSocketEvent, 3)}
+		ws.EventChannel <- mattermostModel.NewWebSocketEvent(mattermostModel.WebsocketEventHello, "", "", "", nil)
+		ws.EventChannel <- postedEvent("other-post")
+		ws.EventChannel <- postedEvent("post-id")
+		assert.NoError(t, waitForPostedEvent(ws, "post-id", time.Second))
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		ws := &mattermostModel.WebSocketClient{EventChannel: make(chan *mattermostModel.WebSocketEvent, 1)}
+		ws.EventChannel <- postedEvent("other-post")
+		assert.EqualError(t, waitForPostedEvent(ws, "post-id", 10*time.Millisecond), "no event received for the new post within 10ms")
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		ws := &mattermostModel.WebSocketClient{EventChannel: make(chan *mattermostModel.WebSocketEvent)}
+		close(ws.EventChannel)
+		assert.EqualError(t, waitForPostedEvent(ws, "post-id", time.Second), "connection closed")
+	})
+}
+
+func TestRunSpinWickSmokeTest(t *testing.T) {
+	t.Run("login failure", func(t *testing.T) {
+		ts := httptest.NewServer(http.NotFoundHandler())
+		defer ts.Close()
+
+		report := runSpinWickSmokeTest(ts.URL, 42, "password", logrus.New())
+		require.Len(t, report, 5)
+		assert.Error(t, report[0].Err)
+		for _, check := range report[1:] {
+			assert.True(t, check.Skipped, check.Name)
+		}
+	})
+
+	t.Run("no websocket", func(t *testing.T) {
+		var deleted bool
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/api/v4/users/login":
+				w.Header().Set("Token", "token")
+				w.Write([]byte(`{"id":"sysadmin-id"}`))
+			case "/api/v4/teams/name/pr42/channels/name/town-square":
+				w.Write([]byte(`{"id":"channel-id"}`))
+			case "/api/v4/files":
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"file_infos":[{"id":"file-id"}]}`))
+			case "/api/v4/posts":
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"id":"post-id","channel_id":"channel-id"}`))
+			case "/api/v4/posts/post-id":
+				deleted = r.Method == http.MethodDelete
+				w.Write([]byte(`{"status":"OK"}`))
+			case "/api/v4/plugins/statuses":
+				w.Write([]byte(`[{"plugin_id":"playbooks","state":2}]`))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer ts.Close()
+
+		report := runSpinWickSmokeTest(ts.URL, 42, "password", logrus.New())
+		require.Len(t, report, 5)
+		assert.Equal(t, []string{"Log in", "Upload a file", "Create a post", "WebSocket", "Plugins"},
+			[]string{report[0].Name, report[1].Name, report[2].Name, report[3].Name, report[4].Name})
+		assert.NoError(t, report[0].Err)
+		assert.NoError(t, report[1].Err)
+		assert.NoError(t, report[2].Err)
+		assert.Error(t, report[3].Err, "the mock serves no websocket")
+		assert.NoError(t, report[4].Err)
+		assert.True(t, deleted, "the smoke test post is deleted")
+	})
+}
+
+func TestSmokeTestSpinWick(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	defer ts.Close()
+
+	s := &Server{Logger: logrus.New(), Config: &MatterwickConfig{}}
+	pr := &model.PullRequest{RepoOwner: "mattermost", RepoName: "mattermost-server", Number: 42}
+
+	info, err := s.smokeTestSpinWick(pr, "mattermost-server-pr-42", ts.URL, "installation-id", spinWickCredentials{}, s.Logger)
+	require.Error(t, err, "the failure is returned so the SpinWick is not reported as ready")
+	assert.Contains(t, err.Error(), "Log in: failed to log in as sysadmin")
+	assert.Contains(t, info, ":x: **Smoke test failed:**")
+}
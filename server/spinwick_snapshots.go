@@ -0,0 +1,704 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	cloudModel "github.com/mattermost/mattermost-cloud/model"
+	"github.com/mattermost/matterwick/internal/spinwick"
+	"github.com/mattermost/matterwick/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// spinWickSnapshotMethodBackup snapshots are provisioner database backups.
+	spinWickSnapshotMethodBackup = "provisioner backup"
+	// spinWickSnapshotMethodExport snapshots are mmctl bulk exports, used when
+	// the installation database does not support provisioner backups.
+	spinWickSnapshotMethodExport = "mmctl export"
+
+	snapshotHibernateTimeout = 10 * time.Minute
+	snapshotOperationTimeout = 30 * time.Minute
+	snapshotWakeUpTimeout    = 20 * time.Minute
+)
+
+// snapshotPollInterval is how often snapshot and restore operations are polled.
+var snapshotPollInterval = 10 * time.Second
+
+var spinWickSnapshotNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// spinWickSnapshot is a named copy of a SpinWick's data that can be restored
+// while the PR is open.
+type spinWickSnapshot struct {
+	name           string
+	method         string
+	installationID string
+	// backupID is the provisioner backup ID of backup snapshots.
+	backupID string
+	// exportFile is the export file name in the installation filestore of export snapshots.
+	exportFile string
+	createdAt  time.Time
+}
+
+// mmctlJob is the subset of a Mattermost job printed by mmctl --json.
+type mmctlJob struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+// validateSnapshotName returns an error if name cannot be used as a snapshot name.
+func validateSnapshotName(name string) error {
+	if !spinWickSnapshotNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid snapshot name %q, use up to 32 lowercase letters, digits, '-' or '_'", name)
+	}
+	return nil
+}
+
+// parseSnapshotArgs parses the arguments of /spinwick snapshot and /spinwick
+// restore, a snapshot name and an optional --name of the SpinWick variant.
+func parseSnapshotArgs(args []string) (string, string, error) {
+	flagset := flag.NewFlagSet("spinwick snapshot", flag.ContinueOnError)
+	flagset.SetOutput(io.Discard)
+
+	var variant string
+	flagset.StringVar(&variant, "name", "", "An optional name of the SpinWick variant")
+
+	// The snapshot name may come before or after the flags.
+	var names []string
+	for {
+		if err := flagset.Parse(args); err != nil {
+			return "", "", fmt.Errorf("failed to parse args: %w", err)
+		}
+		if flagset.NArg() == 0 {
+			break
+		}
+		names = append(names, flagset.Arg(0))
+		args = flagset.Args()[1:]
+	}
+
+	if len(names) != 1 {
+		return "", "", fmt.Errorf("expected exactly one snapshot name")
+	}
+	if err := validateSnapshotName(names[0]); err != nil {
+		return "", "", err
+	}
+	if err := validateVariantName(variant); err != nil {
+		return "", "", err
+	}
+	return names[0], variant, nil
+}
+
+// snapshotCommandArgs returns the /spinwick restore arguments of a snapshot.
+func snapshotCommandArgs(name, variant string) string {
+	if variant == "" {
+		return name
+	}
+	return name + " --name " + variant
+}
+
+// startSpinWickSnapshotOperation marks a snapshot or restore as running for the
+// SpinWick. It returns false if another one is already running.
+func (s *Server) startSpinWickSnapshotOperation(spinwickID string) bool {
+	s.spinWickSnapshotsLock.Lock()
+	defer s.spinWickSnapshotsLock.Unlock()
+	if s.spinWickSnapshotsBusy[spinwickID] {
+		return false
+	}
+	s.spinWickSnapshotsBusy[spinwickID] = true
+	return true
+}
+
+func (s *Server) finishSpinWickSnapshotOperation(spinwickID string) {
+	s.spinWickSnapshotsLock.Lock()
+	defer s.spinWickSnapshotsLock.Unlock()
+	delete(s.spinWickSnapshotsBusy, spinwickID)
+}
+
+func (s *Server) getSpinWickSnapshot(spinwickID, name string) (spinWickSnapshot, bool) {
+	s.spinWickSnapshotsLock.Lock()
+	defer s.spinWickSnapshotsLock.Unlock()
+	snapshot, ok := s.spinWickSnapshots[spinwickID][name]
+	return snapshot, ok
+}
+
+func (s *Server) setSpinWickSnapshot(spinwickID string, snapshot spinWickSnapshot) {
+	s.spinWickSnapshotsLock.Lock()
+	defer s.spinWickSnapshotsLock.Unlock()
+	if s.spinWickSnapshots[spinwickID] == nil {
+		s.spinWickSnapshots[spinwickID] = make(map[string]spinWickSnapshot)
+	}
+	s.spinWickSnapshots[spinwickID][snapshot.name] = snapshot
+}
+
+// spinWickSnapshotNames returns the sorted snapshot names of a SpinWick.
+func (s *Server) spinWickSnapshotNames(spinwickID string) []string {
+	s.spinWickSnapshotsLock.Lock()
+	defer s.spinWickSnapshotsLock.Unlock()
+	names := make([]string, 0, len(s.spinWickSnapshots[spinwickID]))
+	for name := range s.spinWickSnapshots[spinwickID] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// takeSpinWickSnapshots removes and returns all snapshots of a SpinWick.
+func (s *Server) takeSpinWickSnapshots(spinwickID string) []spinWickSnapshot {
+	s.spinWickSnapshotsLock.Lock()
+	defer s.spinWickSnapshotsLock.Unlock()
+	snapshots := make([]spinWickSnapshot, 0, len(s.spinWickSnapshots[spinwickID]))
+	for _, snapshot := range s.spinWickSnapshots[spinwickID] {
+		snapshots = append(snapshots, snapshot)
+	}
+	delete(s.spinWickSnapshots, spinwickID)
+	return snapshots
+}
+
+// handleSnapshotSpinWick creates a named snapshot of the PR's SpinWick and
+// reports the progress in the status comment.
+func (s *Server) handleSnapshotSpinWick(pr *model.PullRequest, name, variant string) {
+	spinwickID := model.NewSpinwickVariant(pr.RepoName, pr.Number, variant, s.Config.DNSNameTestServer).RepeatableID
+	logger := s.Logger.WithFields(logrus.Fields{"repo_name": pr.RepoName, "pr": pr.Number, "variant": variant, "snapshot": name})
+
+	if _, ok := s.getSpinWickSnapshot(spinwickID, name); ok {
+		s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, fmt.Sprintf("A snapshot named `%s` already exists for this SpinWick%s. Please choose another name.", name, variantSuffix(variant)))
+		return
+	}
+	if !s.startSpinWickSnapshotOperation(spinwickID) {
+		s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, "A snapshot or restore is already running for this SpinWick. Please wait for it to finish.")
+		return
+	}
+	defer s.finishSpinWickSnapshotOperation(spinwickID)
+
+	// Snapshots hibernate the installation, so they must not run next to a
+	// create, update or destroy of the SpinWick.
+	_, finish, ok := s.beginSpinWickOperation(context.Background(), spinwickID, spinWickOperationUpdate, logger)
+	if !ok {
+		s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, "No SpinWick found for this PR. Create one before taking a snapshot."+variantSuffix(variant))
+		return
+	}
+	request := &spinwick.Request{InstallationID: "n/a"}
+	defer func() { finish(request) }()
+
+	installation, err := s.checkExistingInstallation(spinwickID, logger)
+	if err != nil {
+		request.WithError(err)
+		logger.WithError(err).Error("Failed to get SpinWick installation")
+		s.updateStatusComment(pr, statusSectionSnapshots, fmt.Sprintf(":x: Failed to create snapshot `%s`: unable to find the SpinWick installation.%s", name, variantSuffix(variant)))
+		return
+	}
+	if installation == nil {
+		request.WithError(errors.New("no SpinWick installation found")).IntentionalAbort()
+		s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, "No SpinWick found for this PR. Create one before taking a snapshot."+variantSuffix(variant))
+		return
+	}
+	request.InstallationID = installation.ID
+	logger = logger.WithField("installation_id", installation.ID)
+
+	s.updateStatusComment(pr, statusSectionSnapshots, fmt.Sprintf("Creating snapshot `%s` of the SpinWick%s database. The server may be unavailable for a few minutes.", name, variantSuffix(variant)))
+
+	snapshot, err := s.snapshotSpinWick(installation, name, logger)
+	if err != nil {
+		request.WithError(err)
+		logger.WithError(err).Error("Failed to create SpinWick snapshot")
+		s.logPrettyErrorToMattermost("[ SpinWick ] Snapshot Failed", pr, err, map[string]string{
+			"Installation ID": installation.ID,
+			"Snapshot":        name,
+		}, logger)
+		s.updateStatusComment(pr, statusSectionSnapshots, fmt.Sprintf(":x: Failed to create snapshot `%s`%s: %s", name, variantSuffix(variant), err.Error()))
+		return
+	}
+
+	s.setSpinWickSnapshot(spinwickID, snapshot)
+	logger.WithField("method", snapshot.method).Info("SpinWick snapshot created")
+	s.updateStatusComment(pr, statusSectionSnapshots,
+		fmt.Sprintf("Snapshot `%s` created using %s. Restore it with `/spinwick restore %s`.", name, snapshot.method, snapshotCommandArgs(name, variant)))
+}
+
+// handleRestoreSpinWick restores the PR's SpinWick from a named snapshot and
+// reports the progress in the status comment.
+func (s *Server) handleRestoreSpinWick(pr *model.PullRequest, name, variant string) {
+	spinwickID := model.NewSpinwickVariant(pr.RepoName, pr.Number, variant, s.Config.DNSNameTestServer).RepeatableID
+	logger := s.Logger.WithFields(logrus.Fields{"repo_name": pr.RepoName, "pr": pr.Number, "variant": variant, "snapshot": name})
+
+	snapshot, ok := s.getSpinWickSnapshot(spinwickID, name)
+	if !ok {
+		msg := fmt.Sprintf("No snapshot named `%s` found for this SpinWick%s.", name, variantSuffix(variant))
+		if names := s.spinWickSnapshotNames(spinwickID); len(names) > 0 {
+			msg += fmt.Sprintf(" Available snapshots: `%s`.", strings.Join(names, "`, `"))
+		}
+		s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, msg)
+		return
+	}
+	if !s.startSpinWickSnapshotOperation(spinwickID) {
+		s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, "A snapshot or restore is already running for this SpinWick. Please wait for it to finish.")
+		return
+	}
+	defer s.finishSpinWickSnapshotOperation(spinwickID)
+
+	_, finish, ok := s.beginSpinWickOperation(context.Background(), spinwickID, spinWickOperationUpdate, logger)
+	if !ok {
+		s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, fmt.Sprintf("Snapshot `%s` belongs to a SpinWick that no longer exists.", name))
+		return
+	}
+	request := &spinwick.Request{InstallationID: "n/a"}
+	defer func() { finish(request) }()
+
+	installation, err := s.checkExistingInstallation(spinwickID, logger)
+	if err != nil {
+		request.WithError(err)
+		logger.WithError(err).Error("Failed to get SpinWick installation")
+		s.updateStatusComment(pr, statusSectionSnapshots, fmt.Sprintf(":x: Failed to restore snapshot `%s`: unable to find the SpinWick installation.%s", name, variantSuffix(variant)))
+		return
+	}
+	if installation == nil || installation.ID != snapshot.installationID {
+		request.WithError(errors.New("the SpinWick installation of the snapshot no longer exists")).IntentionalAbort()
+		s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, fmt.Sprintf("Snapshot `%s` belongs to a SpinWick that no longer exists.", name))
+		return
+	}
+	request.InstallationID = installation.ID
+	logger = logger.WithField("installation_id", installation.ID)
+
+	s.updateStatusComment(pr, statusSectionSnapshots, fmt.Sprintf("Restoring snapshot `%s`%s. The server may be unavailable for a few minutes.", name, variantSuffix(variant)))
+
+	if err = s.restoreSpinWick(installation, snapshot, logger); err != nil {
+		request.WithError(err)
+		logger.WithError(err).Error("Failed to restore SpinWick snapshot")
+		s.logPrettyErrorToMattermost("[ SpinWick ] Restore Failed", pr, err, map[string]string{
+			"Installation ID": installation.ID,
+			"Snapshot":        name,
+		}, logger)
+		s.updateStatusComment(pr, statusSectionSnapshots, fmt.Sprintf(":x: Failed to restore snapshot `%s`%s: %s", name, variantSuffix(variant), err.Error()))
+		return
+	}
+
+	logger.Info("SpinWick snapshot restored")
+	msg := fmt.Sprintf("Snapshot `%s` restored.%s", name, variantSuffix(variant))
+	if snapshot.method == spinWickSnapshotMethodExport {
+		msg += " The export was imported on top of the current data, so objects created after the snapshot were kept."
+	}
+	s.updateStatusComment(pr, statusSectionSnapshots, msg)
+}
+
+// snapshotSpinWick snapshots the installation with a provisioner database backup
+// when the installation supports it, and with an mmctl bulk export otherwise.
+func (s *Server) snapshotSpinWick(installation *cloudModel.InstallationDTO, name string, logger logrus.FieldLogger) (spinWickSnapshot, error) {
+	snapshot := spinWickSnapshot{
+		name:           name,
+		installationID: installation.ID,
+		createdAt:      time.Now(),
+	}
+
+	if err := cloudModel.EnsureBackupRestoreCompatible(installation.Installation); err != nil {
+		logger.WithError(err).Info("Provisioner backups are not available, snapshotting with mmctl export")
+		clusterInstallationID, err := s.getClusterInstallationID(installation.ID)
+		if err != nil {
+			return snapshot, err
+		}
+		snapshot.method = spinWickSnapshotMethodExport
+		snapshot.exportFile, err = s.exportSpinWickSnapshot(clusterInstallationID, logger)
+		return snapshot, err
+	}
+
+	snapshot.method = spinWickSnapshotMethodBackup
+	err := s.withHibernatedInstallation(installation.ID, logger, func() error {
+		backup, err := s.CloudClient.CreateInstallationBackup(installation.ID)
+		if err != nil {
+			return errors.Wrap(err, "failed to request installation backup")
+		}
+		snapshot.backupID = backup.ID
+		return s.waitForInstallationBackup(backup.ID, logger)
+	})
+	if err != nil && snapshot.backupID != "" {
+		if deleteErr := s.CloudClient.DeleteInstallationBackup(snapshot.backupID); deleteErr != nil {
+			logger.WithError(deleteErr).Warn("Failed to delete the backup of a failed snapshot")
+		}
+	}
+
+	return snapshot, err
+}
+
+// restoreSpinWick restores the installation from the snapshot with the same
+// method the snapshot was created with.
+func (s *Server) restoreSpinWick(installation *cloudModel.InstallationDTO, snapshot spinWickSnapshot, logger logrus.FieldLogger) error {
+	if snapshot.method == spinWickSnapshotMethodExport {
+		clusterInstallationID, err := s.getClusterInstallationID(installation.ID)
+		if err != nil {
+			return err
+		}
+		return s.importSpinWickSnapshot(clusterInstallationID, snapshot.exportFile, logger)
+	}
+
+	return s.withHibernatedInstallation(installation.ID, logger, func() error {
+		restoration, err := s.CloudClient.RestoreInstallationDatabase(installation.ID, snapshot.backupID)
+		if err != nil {
+			return errors.Wrap(err, "failed to request database restoration")
+		}
+		return s.waitForInstallationDBRestoration(restoration.ID, logger)
+	})
+}
+
+// withHibernatedInstallation hibernates the installation, runs fn and wakes the
+// installation up again, also when fn fails.
+func (s *Server) withHibernatedInstallation(installationID string, logger logrus.FieldLogger, fn func() error) error {
+	logger.Info("Hibernating installation")
+	if _, err := s.CloudClient.HibernateInstallation(installationID); err != nil {
+		return errors.Wrap(err, "failed to hibernate installation")
+	}
+	if err := s.waitForInstallationState(installationID, cloudModel.InstallationStateHibernating, snapshotHibernateTimeout); err != nil {
+		return err
+	}
+
+	fnErr := fn()
+
+	var wakeErr error
+	state, err := s.getInstallationState(installationID)
+	if err != nil {
+		wakeErr = err
+	} else if state == cloudModel.InstallationStateHibernating {
+		// A restoration may already leave the installation awake.
+		logger.Info("Waking up installation")
+		if _, err = s.CloudClient.WakeupInstallation(installationID, &cloudModel.PatchInstallationRequest{}); err != nil {
+			wakeErr = errors.Wrap(err, "failed to wake up installation")
+		}
+	}
+	if wakeErr == nil {
+		wakeErr = s.waitForInstallationState(installationID, cloudModel.InstallationStateStable, snapshotWakeUpTimeout)
+	}
+
+	if fnErr != nil {
+		if wakeErr != nil {
+			logger.WithError(wakeErr).Error("Failed to wake up installation")
+		}
+		return fnErr
+	}
+	return wakeErr
+}
+
+func (s *Server) getInstallationState(installationID string) (string, error) {
+	installation, err := s.CloudClient.GetInstallation(installationID, &cloudModel.GetInstallationRequest{})
+	if err != nil {
+		return "", errors.Wrap(err, "unable to get installation")
+	}
+	if installation == nil {
+		return "", errors.New("installation not found")
+	}
+	return installation.State, nil
+}
+
+// waitForInstallationState polls the installation until it reaches state.
+func (s *Server) waitForInstallationState(installationID, state string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for {
+		current, err := s.getInstallationState(installationID)
+		if err != nil {
+			return err
+		}
+		switch current {
+		case state:
+			return nil
+		case cloudModel.InstallationStateUpdateFailed,
+			cloudModel.InstallationStateDBRestorationFailed,
+			cloudModel.InstallationStateDeletionRequested,
+			cloudModel.InstallationStateDeletionInProgress,
+			cloudModel.InstallationStateDeleted:
+			return errors.Errorf("installation is in state %s, expected %s", current, state)
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.Errorf("timed out waiting for installation to be %s, state is %s", state, current)
+		case <-time.After(snapshotPollInterval):
+		}
+	}
+}
+
+func (s *Server) waitForInstallationBackup(backupID string, logger logrus.FieldLogger) error {
+	ctx, cancel := context.WithTimeout(context.Background(), snapshotOperationTimeout)
+	defer cancel()
+
+	for {
+		backup, err := s.CloudClient.GetInstallationBackup(backupID)
+		if err != nil {
+			return errors.Wrap(err, "unable to get installation backup")
+		}
+		logger.WithField("backup_state", backup.State).Debug("Waiting for installation backup")
+		switch backup.State {
+		case cloudModel.InstallationBackupStateBackupSucceeded:
+			return nil
+		case cloudModel.InstallationBackupStateBackupFailed:
+			return errors.New("the installation backup failed")
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.New("timed out waiting for the installation backup")
+		case <-time.After(snapshotPollInterval):
+		}
+	}
+}
+
+func (s *Server) waitForInstallationDBRestoration(restorationID string, logger logrus.FieldLogger) error {
+	ctx, cancel := context.WithTimeout(context.Background(), snapshotOperationTimeout)
+	defer cancel()
+
+	for {
+		restoration, err := s.CloudClient.GetInstallationDBRestoration(restorationID)
+		if err != nil {
+			return errors.Wrap(err, "unable to get database restoration")
+		}
+		logger.WithField("restoration_state", restoration.State).Debug("Waiting for database restoration")
+		switch restoration.State {
+		case cloudModel.InstallationDBRestorationStateSucceeded:
+			return nil
+		case cloudModel.InstallationDBRestorationStateFailed, cloudModel.InstallationDBRestorationStateInvalid:
+			return errors.New("the database restoration failed")
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.New("timed out waiting for the database restoration")
+		case <-time.After(snapshotPollInterval):
+		}
+	}
+}
+
+// getClusterInstallationID returns the ID of the first cluster installation of an installation.
+func (s *Server) getClusterInstallationID(installationID string) (string, error) {
+	clusterInstallations, err := s.CloudClient.GetClusterInstallations(&cloudModel.GetClusterInstallationsRequest{
+		InstallationID: installationID,
+		Paging:         cloudModel.Paging{Page: 0, PerPage: 100},
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "unable to get cluster installations")
+	}
+	if len(clusterInstallations) == 0 {
+		return "", errors.New("no cluster installations found")
+	}
+	return clusterInstallations[0].ID, nil
+}
+
+// mmctlSecretFlags are the mmctl flags whose values are kept out of errors.
+var mmctlSecretFlags = map[string]bool{"--password": true}
+
+// execMmctl runs mmctl in local mode with JSON output on the cluster installation.
+func (s *Server) execMmctl(clusterInstallationID string, args ...string) ([]byte, error) {
+	subcommand := append([]string{"--local", "--json"}, args...)
+	output, err := s.CloudClient.ExecClusterInstallationCLI(clusterInstallationID, "mmctl", subcommand)
+	if err != nil {
+		return output, errors.Wrapf(err, "failed to run mmctl %s: %s", strings.Join(redactMmctlArgs(args), " "), strings.TrimSpace(string(output)))
+	}
+	return output, nil
+}
+
+// redactMmctlArgs returns the mmctl arguments with the values of secret flags
+// replaced, so they can be logged.
+func redactMmctlArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		flag, _, hasValue := strings.Cut(arg, "=")
+		switch {
+		case hasValue && mmctlSecretFlags[flag]:
+			redacted[i] = flag + "=<redacted>"
+		case i > 0 && mmctlSecretFlags[args[i-1]]:
+			redacted[i] = "<redacted>"
+		default:
+			redacted[i] = arg
+		}
+	}
+	return redacted
+}
+
+// listExportFiles returns the export files of the installation. mmctl prints a
+// single file as a JSON string and several as a JSON array.
+func (s *Server) listExportFiles(clusterInstallationID string) (map[string]bool, error) {
+	output, err := s.execMmctl(clusterInstallationID, "export", "list")
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	if err = json.Unmarshal(output, &files); err != nil {
+		var file string
+		if err = json.Unmarshal(output, &file); err != nil {
+			return nil, errors.Wrap(err, "failed to parse export list")
+		}
+		files = []string{file}
+	}
+
+	exports := make(map[string]bool)
+	for _, file := range files {
+		if strings.HasSuffix(file, ".zip") {
+			exports[file] = true
+		}
+	}
+	return exports, nil
+}
+
+// exportSpinWickSnapshot runs an mmctl bulk export and returns the name of the
+// export file it created.
+func (s *Server) exportSpinWickSnapshot(clusterInstallationID string, logger logrus.FieldLogger) (string, error) {
+	existing, err := s.listExportFiles(clusterInstallationID)
+	if err != nil {
+		return "", err
+	}
+
+	output, err := s.execMmctl(clusterInstallationID, "export", "create")
+	if err != nil {
+		return "", err
+	}
+	if err = s.waitForMmctlJob(clusterInstallationID, "export", output, logger); err != nil {
+		return "", err
+	}
+
+	exports, err := s.listExportFiles(clusterInstallationID)
+	if err != nil {
+		return "", err
+	}
+	for file := range exports {
+		if !existing[file] {
+			return file, nil
+		}
+	}
+
+	return "", errors.New("the export job succeeded but no new export file was found")
+}
+
+// importSpinWickSnapshot imports an export file created by exportSpinWickSnapshot.
+// The file is downloaded from the filestore into the pod because imports are
+// read from a different directory than exports are written to.
+func (s *Server) importSpinWickSnapshot(clusterInstallationID, exportFile string, logger logrus.FieldLogger) error {
+	localPath := "/tmp/" + exportFile
+	if _, err := s.execMmctl(clusterInstallationID, "export", "download", exportFile, localPath); err != nil {
+		return err
+	}
+
+	output, err := s.execMmctl(clusterInstallationID, "import", "process", "--bypass-upload", localPath)
+	if err != nil {
+		return err
+	}
+
+	return s.waitForMmctlJob(clusterInstallationID, "import", output, logger)
+}
+
+// waitForMmctlJob polls the export or import job printed in jobOutput until it finishes.
+func (s *Server) waitForMmctlJob(clusterInstallationID, jobType string, jobOutput []byte, logger logrus.FieldLogger) error {
+	var job mmctlJob
+	if err := json.Unmarshal(jobOutput, &job); err != nil || job.ID == "" {
+		return errors.Errorf("failed to parse %s job: %s", jobType, strings.TrimSpace(string(jobOutput)))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), snapshotOperationTimeout)
+	defer cancel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return errors.Errorf("timed out waiting for %s job %s", jobType, job.ID)
+		case <-time.After(snapshotPollInterval):
+		}
+
+		output, err := s.execMmctl(clusterInstallationID, jobType, "job", "show", job.ID)
+		if err != nil {
+			return err
+		}
+		if err = json.Unmarshal(output, &job); err != nil {
+			return errors.Wrapf(err, "failed to parse %s job", jobType)
+		}
+		logger.WithFields(logrus.Fields{"job_id": job.ID, "status": job.Status}).Debugf("Waiting for %s job", jobType)
+
+		switch job.Status {
+		case "success":
+			return nil
+		case "error", "canceled":
+			return errors.Errorf("the %s job %s finished with status %s", jobType, job.ID, job.Status)
+		}
+	}
+}
+
+// cleanupSpinWickSnapshots forgets the snapshots of the PR's SpinWick, or of the
+// named variant of it when variant is not empty, and deletes its provisioner
+// backups. Export snapshots live in the installation filestore and are removed
+// with the installation.
+func (s *Server) cleanupSpinWickSnapshots(pr *model.PullRequest, variant string) {
+	spinwick := model.NewSpinwickVariant(pr.RepoName, pr.Number, variant, s.Config.DNSNameTestServer)
+	logger := s.Logger.WithFields(logrus.Fields{"repo_name": pr.RepoName, "pr": pr.Number, "variant": variant})
+
+	for _, snapshot := range s.takeSpinWickSnapshots(spinwick.RepeatableID) {
+		if snapshot.backupID == "" {
+			continue
+		}
+		if err := s.CloudClient.DeleteInstallationBackup(snapshot.backupID); err != nil {
+			logger.WithError(err).WithFields(logrus.Fields{
+				"snapshot":  snapshot.name,
+				"backup_id": snapshot.backupID,
+			}).Warn("Failed to delete SpinWick snapshot backup")
+		}
+	}
+}
+
+// loadSpinWickSnapshots adds the provisioner backups of the SpinWicks to the
+// snapshots, so the backups taken before a restart can still be restored and
+// are deleted with the SpinWick. The provisioner does not keep snapshot names,
+// so these snapshots are named after the time they were taken. Export snapshots
+// cannot be found again, they are removed with their installation.
+func (s *Server) loadSpinWickSnapshots() {
+	logger := s.Logger.WithField("type", "spinwick_snapshots")
+
+	installations, err := s.getProvisionedInstallations()
+	if err != nil {
+		logger.WithError(err).Error("Failed to load the SpinWick snapshots, backups taken before the restart are not deleted")
+		return
+	}
+
+	var loaded int
+	for _, installation := range installations {
+		if _, claim, _, ok := provisionedClaim(installation.OwnerID, installation.ID); !ok || claim.kind != installationKindSpinWick {
+			continue
+		}
+
+		backups, err := s.CloudClient.GetInstallationBackups(&cloudModel.GetInstallationBackupsRequest{
+			InstallationID: installation.ID,
+			State:          string(cloudModel.InstallationBackupStateBackupSucceeded),
+			Paging:         cloudModel.AllPagesNotDeleted(),
+		})
+		if err != nil {
+			logger.WithError(err).WithField("installation_id", installation.ID).Warn("Failed to get the backups of the SpinWick")
+			continue
+		}
+		for _, backup := range backups {
+			snapshot := recoveredSpinWickSnapshot(installation.ID, backup)
+			if _, exists := s.getSpinWickSnapshot(installation.OwnerID, snapshot.name); exists {
+				snapshot.name += "-" + strings.ToLower(backup.ID[:4])
+			}
+			s.setSpinWickSnapshot(installation.OwnerID, snapshot)
+			loaded++
+		}
+	}
+	logger.WithField("snapshots", loaded).Info("Loaded the SpinWick snapshots")
+}
+
+// recoveredSpinWickSnapshot returns the snapshot of a backup taken before a restart.
+func recoveredSpinWickSnapshot(installationID string, backup *cloudModel.InstallationBackup) spinWickSnapshot {
+	createdAt := time.Unix(0, backup.RequestAt*int64(time.Millisecond)).UTC()
+	return spinWickSnapshot{
+		name:           "backup-" + createdAt.Format("20060102-150405"),
+		method:         spinWickSnapshotMethodBackup,
+		installationID: installationID,
+		backupID:       backup.ID,
+		createdAt:      createdAt,
+	}
+}
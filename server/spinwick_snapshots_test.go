@@ -0,0 +1,262 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	cloudModel "github.com/mattermost/mattermost-cloud/model"
+	"github.com/mattermost/matterwick/model"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// snapshotProvisionerMock is a minimal provisioner API that tracks the
+// installation state and the mmctl commands run on it.
+type snapshotProvisionerMock struct {
+	lock           sync.Mutex
+	state          string
+	calls          []string
+	mmctlCommands  []string
+	exportFiles    []string
+	deletedBackups []string
+}
+
+func (m *snapshotProvisionerMock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	switch {
+	case r.URL.Path == "/api/installation/inst-1":
+		w.Write([]byte(`{"ID":"inst-1","State":"` + m.state + `"}`))
+	case r.URL.Path == "/api/installation/inst-1/hibernate":
+		m.calls = append(m.calls, "hibernate")
+		m.state = cloudModel.InstallationStateHibernating
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"ID":"inst-1"}`))
+	case r.URL.Path == "/api/installation/inst-1/wakeup":
+		m.calls = append(m.calls, "wakeup")
+		m.state = cloudModel.InstallationStateStable
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"ID":"inst-1"}`))
+	case r.URL.Path == "/api/installations/backups":
+		m.calls = append(m.calls, "backup")
+		w.Write([]byte(`{"ID":"backup-1","State":"backup-requested"}`))
+	case r.URL.Path == "/api/installations/backup/backup-1" && r.Method == http.MethodDelete:
+		m.deletedBackups = append(m.deletedBackups, "backup-1")
+		w.WriteHeader(http.StatusAccepted)
+	case r.URL.Path == "/api/installations/backup/backup-1":
+		w.Write([]byte(`{"ID":"backup-1","State":"backup-succeeded"}`))
+	case r.URL.Path == "/api/cluster_installations":
+		w.Write([]byte(`[{"ID":"ci-1"}]`))
+	case r.URL.Path == "/api/cluster_installation/ci-1/exec/mmctl":
+		var args []string
+		if err := json.NewDecoder(r.Body).Decode(&args); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		command := strings.Join(args, " ")
+		m.mmctlCommands = append(m.mmctlCommands, command)
+		m.execMmctl(w, command)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (m *snapshotProvisionerMock) execMmctl(w http.ResponseWriter, command string) {
+	switch command {
+	case "--local --json export list":
+		// mmctl prints a single item without wrapping it in an array.
+		if len(m.exportFiles) == 1 {
+			json.NewEncoder(w).Encode(m.exportFiles[0])
+			return
+		}
+		json.NewEncoder(w).Encode(m.exportFiles)
+	case "--local --json export create":
+		m.exportFiles = append(m.exportFiles, "new_export.zip")
+		w.Write([]byte(`{"id":"job-1","status":"pending"}`))
+	case "--local --json import process --bypass-upload /tmp/new_export.zip":
+		w.Write([]byte(`{"id":"job-2","status":"pending"}`))
+	case "--local --json export job show job-1":
+		w.Write([]byte(`{"id":"job-1","status":"success"}`))
+	case "--local --json import job show job-2":
+		w.Write([]byte(`{"id":"job-2","status":"success"}`))
+	case "--local --json export download new_export.zip /tmp/new_export.zip":
+		w.Write([]byte(`{}`))
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func newSnapshotTestServer(t *testing.T, mock *snapshotProvisionerMock) *Server {
+	ts := httptest.NewServer(mock)
+	t.Cleanup(ts.Close)
+
+	pollInterval := snapshotPollInterval
+	snapshotPollInterval = time.Millisecond
+	t.Cleanup(func() { snapshotPollInterval = pollInterval })
+
+	return &Server{
+		Config:                &MatterwickConfig{},
+		Logger:                logrus.New(),
+		CloudClient:           model.NewCloudClient(ts.URL, "", "", "", ""),
+		spinWickSnapshots:     make(map[string]map[string]spinWickSnapshot),
+		spinWickSnapshotsBusy: make(map[string]bool),
+	}
+}
+
+func TestParseSnapshotArgs(t *testing.T) {
+	for _, tc := range []struct {
+		name            string
+		args            []string
+		expectedName    string
+		expectedVariant string
+		expectedErr     string
+	}{
+		{name: "valid", args: []string{"before-migration_1"}, expectedName: "before-migration_1"},
+		{name: "variant after name", args: []string{"before", "--name", "ha"}, expectedName: "before", expectedVariant: "ha"},
+		{name: "variant before name", args: []string{"--name", "ha", "before"}, expectedName: "before", expectedVariant: "ha"},
+		{name: "missing", args: []string{}, expectedErr: "expected exactly one snapshot name"},
+		{name: "too many", args: []string{"a", "b"}, expectedErr: "expected exactly one snapshot name"},
+		{name: "uppercase", args: []string{"Before"}, expectedErr: `invalid snapshot name "Before"`},
+		{name: "too long", args: []string{strings.Repeat("a", 33)}, expectedErr: "invalid snapshot name"},
+		{name: "invalid variant", args: []string{"before", "--name", "Not_Valid"}, expectedErr: `invalid variant name "Not_Valid"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			name, variant, err := parseSnapshotArgs(tc.args)
+			if tc.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedName, name)
+			assert.Equal(t, tc.expectedVariant, variant)
+		})
+	}
+}
+
+func TestSnapshotSpinWick(t *testing.T) {
+	t.Run("provisioner backup", func(t *testing.T) {
+		mock := &snapshotProvisionerMock{state: cloudModel.InstallationStateStable}
+		s := newSnapshotTestServer(t, mock)
+
+		installation := &cloudModel.InstallationDTO{Installation: &cloudModel.Installation{
+			ID:        "inst-1",
+			Database:  cloudModel.InstallationDatabaseSingleTenantRDSPostgres,
+			Filestore: cloudModel.InstallationFilestoreBifrost,
+		}}
+		snapshot, err := s.snapshotSpinWick(installation, "before", s.Logger)
+		require.NoError(t, err)
+
+		assert.Equal(t, spinWickSnapshotMethodBackup, snapshot.method)
+		assert.Equal(t, "backup-1", snapshot.backupID)
+		assert.Equal(t, []string{"hibernate", "backup", "wakeup"}, mock.calls)
+		assert.Equal(t, cloudModel.InstallationStateStable, mock.state)
+		assert.Empty(t, mock.mmctlCommands)
+	})
+
+	t.Run("mmctl export fallback", func(t *testing.T) {
+		mock := &snapshotProvisionerMock{
+			state:       cloudModel.InstallationStateStable,
+			exportFiles: []string{"old_export.zip"},
+		}
+		s := newSnapshotTestServer(t, mock)
+
+		installation := &cloudModel.InstallationDTO{Installation: &cloudModel.Installation{
+			ID:        "inst-1",
+			Database:  defaultSpinWickDatabase,
+			Filestore: defaultSpinWickFilestore,
+		}}
+		snapshot, err := s.snapshotSpinWick(installation, "before", s.Logger)
+		require.NoError(t, err)
+
+		assert.Equal(t, spinWickSnapshotMethodExport, snapshot.method)
+		assert.Equal(t, "new_export.zip", snapshot.exportFile)
+		assert.Empty(t, mock.calls, "the installation should not be hibernated")
+
+		err = s.restoreSpinWick(installation, snapshot, s.Logger)
+		require.NoError(t, err)
+		assert.Contains(t, mock.mmctlCommands, "--local --json export download new_export.zip /tmp/new_export.zip")
+		assert.Contains(t, mock.mmctlCommands, "--local --json import job show job-2")
+	})
+}
+
+func TestCleanupSpinWickSnapshots(t *testing.T) {
+	mock := &snapshotProvisionerMock{}
+	s := newSnapshotTestServer(t, mock)
+
+	pr := &model.PullRequest{RepoName: "mattermost", Number: 42}
+	spinwickID := model.NewSpinwick(pr.RepoName, pr.Number, "").RepeatableID
+	s.setSpinWickSnapshot(spinwickID, spinWickSnapshot{name: "backup", method: spinWickSnapshotMethodBackup, backupID: "backup-1"})
+	s.setSpinWickSnapshot(spinwickID, spinWickSnapshot{name: "export", method: spinWickSnapshotMethodExport, exportFile: "new_export.zip"})
+	assert.Equal(t, []string{"backup", "export"}, s.spinWickSnapshotNames(spinwickID))
+
+	variantID := model.NewSpinwickVariant(pr.RepoName, pr.Number, "ha", "").RepeatableID
+	s.setSpinWickSnapshot(variantID, spinWickSnapshot{name: "backup", method: spinWickSnapshotMethodBackup, backupID: "backup-1"})
+
+	s.cleanupSpinWickSnapshots(pr, "")
+
+	assert.Equal(t, []string{"backup-1"}, mock.deletedBackups)
+	assert.Empty(t, s.spinWickSnapshotNames(spinwickID))
+	assert.Equal(t, []string{"backup"}, s.spinWickSnapshotNames(variantID), "the snapshots of variants are kept")
+
+	s.cleanupSpinWickSnapshots(pr, "ha")
+	assert.Empty(t, s.spinWickSnapshotNames(variantID))
+}
+
+func TestLoadSpinWickSnapshots(t *testing.T) {
+	var backupQueries []string
+	cloud := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/installations":
+			w.Write([]byte(`[
+				{"ID":"inst-1","OwnerID":"mattermost-pr-1","State":"stable"},
+				{"ID":"inst-2","OwnerID":"mattermost-pr-1-ha","State":"stable"},
+				{"ID":"inst-3","OwnerID":"desktop-pr-9-linux-0a1b2c3d","State":"stable"}
+			]`))
+		case "/api/installations/backups":
+			backupQueries = append(backupQueries, r.URL.Query().Get("installation")+" "+r.URL.Query().Get("state"))
+			if r.URL.Query().Get("installation") != "inst-1" {
+				w.Write([]byte(`[]`))
+				return
+			}
+			w.Write([]byte(`[
+				{"ID":"abcdbackup1","InstallationID":"inst-1","State":"backup-succeeded","RequestAt":1790000000000},
+				{"ID":"efghbackup2","InstallationID":"inst-1","State":"backup-succeeded","RequestAt":1790000000000}
+			]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer cloud.Close()
+
+	s := &Server{
+		Logger:                logrus.New(),
+		Config:                &MatterwickConfig{CloudGroupID: "spinwicks"},
+		CloudClient:           model.NewCloudClient(cloud.URL, "", "", "", ""),
+		spinWickSnapshots:     make(map[string]map[string]spinWickSnapshot),
+		spinWickSnapshotsBusy: make(map[string]bool),
+	}
+	s.loadSpinWickSnapshots()
+
+	assert.Equal(t, []string{"inst-1 backup-succeeded", "inst-2 backup-succeeded"}, backupQueries, "only SpinWick backups are loaded")
+	assert.Equal(t, []string{"backup-20260921-141320", "backup-20260921-141320-efgh"}, s.spinWickSnapshotNames("mattermost-pr-1"))
+	snapshot, ok := s.getSpinWickSnapshot("mattermost-pr-1", "backup-20260921-141320")
+	require.True(t, ok)
+	assert.Equal(t, spinWickSnapshot{
+		name:           "backup-20260921-141320",
+		method:         spinWickSnapshotMethodBackup,
+		installationID: "inst-1",
+		backupID:       "abcdbackup1",
+		createdAt:      time.Unix(1790000000, 0).UTC(),
+	}, snapshot)
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"context"
+
+	"github.com/mattermost/matterwick/internal/spinwick"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// spinWickUpdate is an in-flight update of a SpinWick to a commit.
+type spinWickUpdate struct {
+	sha    string
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// startSpinWickUpdate registers an update of the SpinWick to the commit. The
+// in-flight updates of the SpinWick to other commits are cancelled and waited
+// for, so rapid pushes only deploy the latest commit. The returned context is
+// cancelled when a newer commit supersedes the update, and the returned
+// function must be called once the update is done.
+func (s *Server) startSpinWickUpdate(ownerID, sha string, logger logrus.FieldLogger) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	update := &spinWickUpdate{sha: sha, cancel: cancel, done: make(chan struct{})}
+
+	s.spinWickUpdatesLock.Lock()
+	var superseded []*spinWickUpdate
+	for _, previous := range s.spinWickUpdates[ownerID] {
+		if previous.sha != sha {
+			logger.WithField("superseded_sha", previous.sha).Info("Cancelling the SpinWick update of an older commit")
+			previous.cancel()
+			superseded = append(superseded, previous)
+		}
+	}
+	s.spinWickUpdates[ownerID] = append(s.spinWickUpdates[ownerID], update)
+	s.spinWickUpdatesLock.Unlock()
+
+	for _, previous := range superseded {
+		<-previous.done
+	}
+
+	return ctx, func() {
+		s.spinWickUpdatesLock.Lock()
+		defer s.spinWickUpdatesLock.Unlock()
+
+		updates := s.spinWickUpdates[ownerID]
+		for i, u := range updates {
+			if u == update {
+				updates = append(updates[:i], updates[i+1:]...)
+				break
+			}
+		}
+		if len(updates) == 0 {
+			delete(s.spinWickUpdates, ownerID)
+		} else {
+			s.spinWickUpdates[ownerID] = updates
+		}
+		cancel()
+		close(update.done)
+	}
+}
+
+// supersededSpinWickUpdate reports whether the update failed because a newer
+// commit cancelled it. Such requests are marked as aborted without a failure
+// message, as the newer update reports its own status on the PR.
+func supersededSpinWickUpdate(ctx context.Context, request *spinwick.Request) bool {
+	if request.Error == nil || ctx.Err() == nil {
+		return false
+	}
+	request.WithError(errors.New("superseded by a newer commit")).IntentionalAbort().AlreadyNotifiedPR()
+	request.ReportError = false
+	return true
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mattermost/matterwick/internal/spinwick"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartSpinWickUpdate(t *testing.T) {
+	newServer := func() *Server {
+		return &Server{Logger: logrus.New(), spinWickUpdates: make(map[string][]*spinWickUpdate)}
+	}
+
+	t.Run("newer commit supersedes older", func(t *testing.T) {
+		s := newServer()
+		oldCtx, oldDone := s.startSpinWickUpdate("mattermost-server-pr-1", "sha1", s.Logger)
+
+		// The newer update reports whether its context is live once it starts.
+		started := make(chan error)
+		go func() {
+			ctx, done := s.startSpinWickUpdate("mattermost-server-pr-1", "sha2", s.Logger)
+			defer done()
+			started <- ctx.Err()
+		}()
+
+		select {
+		case <-oldCtx.Done():
+		case <-time.After(10 * time.Second):
+			t.Fatal("the older update was not cancelled")
+		}
+		select {
+		case <-started:
+			t.Fatal("the newer update started before the older one was done")
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		oldDone()
+		select {
+		case err := <-started:
+			assert.NoError(t, err)
+		case <-time.After(10 * time.Second):
+			t.Fatal("the newer update did not start")
+		}
+	})
+
+	t.Run("same commit and other SpinWicks are not cancelled", func(t *testing.T) {
+		s := newServer()
+		ctx, done := s.startSpinWickUpdate("mattermost-server-pr-1", "sha1", s.Logger)
+		defer done()
+
+		_, sameDone := s.startSpinWickUpdate("mattermost-server-pr-1", "sha1", s.Logger)
+		defer sameDone()
+		_, otherDone := s.startSpinWickUpdate("mattermost-server-pr-2", "sha2", s.Logger)
+		defer otherDone()
+
+		assert.NoError(t, ctx.Err())
+		assert.Len(t, s.spinWickUpdates["mattermost-server-pr-1"], 2)
+	})
+
+	t.Run("done unregisters the update", func(t *testing.T) {
+		s := newServer()
+		ctx, done := s.startSpinWickUpdate("mattermost-server-pr-1", "sha1", s.Logger)
+		done()
+		assert.Error(t, ctx.Err())
+		assert.Empty(t, s.spinWickUpdates)
+	})
+}
+
+func TestSupersededSpinWickUpdate(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.False(t, supersededSpinWickUpdate(cancelled, &spinwick.Request{}), "succeeded updates are kept")
+	assert.False(t, supersededSpinWickUpdate(context.Background(), (&spinwick.Request{}).WithError(errors.New("failed"))))
+
+	request := (&spinwick.Request{}).WithError(errors.New("timed out waiting for image to publish")).ShouldReportError()
+	require.True(t, supersededSpinWickUpdate(cancelled, request))
+	assert.EqualError(t, request.Error, "superseded by a newer commit")
+	assert.True(t, request.Aborted)
+	assert.True(t, request.PRNotified)
+	assert.False(t, request.ReportError)
+}
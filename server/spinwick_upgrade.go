@@ -0,0 +1,102 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/blang/semver"
+	cloudModel "github.com/mattermost/mattermost-cloud/model"
+	"github.com/mattermost/matterwick/internal/spinwick"
+	"github.com/mattermost/matterwick/model"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	mattermostEEReleaseImage   = "mattermost/mattermost-enterprise-edition"
+	mattermostTeamReleaseImage = "mattermost/mattermost-team-edition"
+
+	// defaultUpgradeSampleData is the sample data profile seeded before the upgrade
+	// when /spinwick create --upgrade-from is used without --sample-data.
+	defaultUpgradeSampleData = "small"
+)
+
+// validateUpgradeFrom returns an error if version is neither a release version
+// such as "10.11.0" nor a development branch tag such as "release-10.11".
+func validateUpgradeFrom(version string) error {
+	if version == "" || strings.HasPrefix(version, "release-") {
+		return nil
+	}
+	if _, err := semver.ParseTolerant(version); err != nil {
+		return fmt.Errorf("invalid upgrade version %q, expected e.g. 10.11.0 or release-10.11", version)
+	}
+	return nil
+}
+
+// upgradeFromImageAndTag returns the image and tag of the release a SpinWick is
+// upgraded from. Release versions are published on the official images, branch
+// tags only on the development image the PR build uses.
+func upgradeFromImageAndTag(prImage, version string) (string, string) {
+	if strings.HasPrefix(version, "release-") {
+		return prImage, version
+	}
+
+	tag := strings.TrimPrefix(version, "v")
+	if prImage == mattermostTeamImage {
+		return mattermostTeamReleaseImage, tag
+	}
+	return mattermostEEReleaseImage, tag
+}
+
+// prImageOf returns the development image of the PR builds for the image of a
+// SpinWick installation. An installation is left on a release image when its
+// upgrade to the PR build failed.
+func prImageOf(image string) string {
+	switch image {
+	case mattermostEEReleaseImage:
+		return mattermostEEImage
+	case mattermostTeamReleaseImage:
+		return mattermostTeamImage
+	}
+	return image
+}
+
+// upgradeSpinWickToPR patches an upgrade-path SpinWick from the release it was
+// created with to the PR build, the same way updateSpinWick does, and returns a
+// report for the success comment. A failed upgrade is reported but leaves the
+// installation in place so the failure can be investigated.
+func (s *Server) upgradeSpinWickToPR(pr *model.PullRequest, installationID, fromVersion, version, image, license string, envVars cloudModel.EnvVarMap, logger logrus.FieldLogger) string {
+	logger = logger.WithFields(logrus.Fields{"upgrade_from": fromVersion, "upgrade_to": version})
+	logger.Info("Upgrading SpinWick to the PR build")
+
+	upgradeRequest := &cloudModel.PatchInstallationRequest{
+		Version:     &version,
+		Image:       &image,
+		PriorityEnv: envVars,
+	}
+	if license != "" {
+		upgradeRequest.License = &license
+	}
+
+	// A separate request keeps a failed upgrade from failing the SpinWick itself.
+	upgradeTracker := &spinwick.Request{InstallationID: installationID}
+	start := time.Now()
+	_, err := s.updateInstallationAndWait(pr, upgradeTracker, upgradeRequest, 1200, logger)
+	duration := time.Since(start).Round(time.Second)
+
+	report := fmt.Sprintf("**Upgrade path:** `%s` → `%s`\n", fromVersion, version)
+	if err != nil {
+		logger.WithError(err).Warn("SpinWick upgrade did not reach stable")
+		s.logPrettyErrorToMattermost("[ SpinWick ] Upgrade Path Failed", pr, err, map[string]string{
+			"Installation ID": installationID,
+			"Upgrade From":    fromVersion,
+		}, logger)
+		return report + fmt.Sprintf(":x: The upgrade did not reach stable after %s: %s", duration, err.Error())
+	}
+
+	logger.WithField("duration", duration.String()).Info("SpinWick upgrade reached stable")
+	return report + fmt.Sprintf(":white_check_mark: The upgrade reached stable in %s (image rollout and migrations).", duration)
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateUpgradeFrom(t *testing.T) {
+	for _, tc := range []struct {
+		version string
+		valid   bool
+	}{
+		{"", true},
+		{"10.11.0", true},
+		{"v10.11.2", true},
+		{"10.11", true},
+		{"release-10.11", true},
+		{"latest", false},
+		{"abc1234", false},
+	} {
+		t.Run(tc.version, func(t *testing.T) {
+			err := validateUpgradeFrom(tc.version)
+			if tc.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestUpgradeFromImageAndTag(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		prImage       string
+		version       string
+		expectedImage string
+		expectedTag   string
+	}{
+		{
+			name:          "enterprise release",
+			prImage:       mattermostEEImage,
+			version:       "10.11.0",
+			expectedImage: mattermostEEReleaseImage,
+			expectedTag:   "10.11.0",
+		},
+		{
+			name:          "v prefix is stripped",
+			prImage:       mattermostEEImage,
+			version:       "v10.11.0",
+			expectedImage: mattermostEEReleaseImage,
+			expectedTag:   "10.11.0",
+		},
+		{
+			name:          "team edition fallback",
+			prImage:       mattermostTeamImage,
+			version:       "10.11.0",
+			expectedImage: mattermostTeamReleaseImage,
+			expectedTag:   "10.11.0",
+		},
+		{
+			name:          "branch tag uses the development image",
+			prImage:       mattermostEEImage,
+			version:       "release-10.11",
+			expectedImage: mattermostEEImage,
+			expectedTag:   "release-10.11",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			image, tag := upgradeFromImageAndTag(tc.prImage, tc.version)
+			assert.Equal(t, tc.expectedImage, image)
+			assert.Equal(t, tc.expectedTag, tag)
+		})
+	}
+}
+
+func TestPRImageOf(t *testing.T) {
+	assert.Equal(t, mattermostEEImage, prImageOf(mattermostEEImage))
+	assert.Equal(t, mattermostTeamImage, prImageOf(mattermostTeamImage))
+	assert.Equal(t, mattermostEEImage, prImageOf(mattermostEEReleaseImage), "a failed upgrade path is updated with the PR build")
+	assert.Equal(t, mattermostTeamImage, prImageOf(mattermostTeamReleaseImage))
+	assert.Equal(t, cwsImage, prImageOf(cwsImage))
+}
@@ -0,0 +1,270 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	mattermostModel "github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	spinWickRoleSystemAdmin = "system_admin"
+	spinWickRoleTeamAdmin   = "team_admin"
+	spinWickRoleMember      = "member"
+	spinWickRoleGuest       = "guest"
+	spinWickRoleBot         = "bot"
+	spinWickRoleDeactivated = "deactivated"
+
+	spinWickSysadminUsername = "sysadmin"
+	spinWickUserUsername     = "user-1"
+)
+
+// spinWickRoleAccountTypes maps the supported roster roles to the account type
+// shown in the credentials table.
+var spinWickRoleAccountTypes = map[string]string{
+	spinWickRoleSystemAdmin: "System Admin",
+	spinWickRoleTeamAdmin:   "Team Admin",
+	spinWickRoleMember:      "Member",
+	spinWickRoleGuest:       "Guest",
+	spinWickRoleBot:         "Bot (access token)",
+	spinWickRoleDeactivated: "Deactivated",
+}
+
+// spinWickCredential is an account created on a SpinWick.
+type spinWickCredential struct {
+	AccountType string
+	Username    string
+	// Password is the account password, or the access token for bots.
+	Password string
+}
+
+// spinWickCredentials are the accounts created on a SpinWick, in creation order.
+type spinWickCredentials []spinWickCredential
+
+// password returns the password of the given username, or an empty string.
+func (c spinWickCredentials) password(username string) string {
+	for _, credential := range c {
+		if credential.Username == username {
+			return credential.Password
+		}
+	}
+	return ""
+}
+
+// table renders the credentials as a markdown table.
+func (c spinWickCredentials) table() string {
+	var sb strings.Builder
+	sb.WriteString("| Account Type | Username | Password |\n|---|---|---|")
+	for _, credential := range c {
+		sb.WriteString(fmt.Sprintf("\n| %s | %s | %s |", credential.AccountType, credential.Username, credential.Password))
+	}
+	return sb.String()
+}
+
+// setSpinWickCredentials stores the accounts created on a SpinWick so later
+// commands can log in to it.
+func (s *Server) setSpinWickCredentials(spinwickID string, credentials spinWickCredentials) {
+	s.credentialsLock.Lock()
+	defer s.credentialsLock.Unlock()
+	s.credentials[spinwickID] = credentials
+}
+
+// getSpinWickCredentials returns the accounts created on a SpinWick, or nil if
+// matterwick did not create it since it was started.
+func (s *Server) getSpinWickCredentials(spinwickID string) spinWickCredentials {
+	s.credentialsLock.Lock()
+	defer s.credentialsLock.Unlock()
+	return s.credentials[spinwickID]
+}
+
+func (s *Server) deleteSpinWickCredentials(spinwickID string) {
+	s.credentialsLock.Lock()
+	defer s.credentialsLock.Unlock()
+	delete(s.credentials, spinwickID)
+}
+
+// parseUsersArg parses a roster in the comma separated format "name1:role1,name2:role2".
+func parseUsersArg(arg string) ([]SpinWickUser, error) {
+	entries := splitCommaSeparated(arg)
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no users found")
+	}
+
+	users := make([]SpinWickUser, 0, len(entries))
+	for _, entry := range entries {
+		fields := strings.SplitN(entry, ":", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid user %q, expected username:role", entry)
+		}
+		users = append(users, SpinWickUser{
+			Username: strings.TrimSpace(fields[0]),
+			Role:     strings.TrimSpace(fields[1]),
+		})
+	}
+
+	if err := validateSpinWickUsers(users); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// validateSpinWickUsers checks that every user in a roster has a valid, unique
+// username and a supported role.
+func validateSpinWickUsers(users []SpinWickUser) error {
+	seen := map[string]bool{
+		spinWickSysadminUsername: true,
+		spinWickUserUsername:     true,
+	}
+	for _, user := range users {
+		if !mattermostModel.IsValidUsername(user.Username) {
+			return fmt.Errorf("invalid username %q", user.Username)
+		}
+		if seen[user.Username] {
+			return fmt.Errorf("duplicate username %q", user.Username)
+		}
+		seen[user.Username] = true
+
+		if _, ok := spinWickRoleAccountTypes[user.Role]; !ok {
+			return fmt.Errorf("unsupported role %q for user %q", user.Role, user.Username)
+		}
+	}
+
+	return nil
+}
+
+// enableRosterSettings turns on the server settings that the roster depends on.
+func enableRosterSettings(client *mattermostModel.Client4, users []SpinWickUser) error {
+	var needsGuests, needsBots bool
+	for _, user := range users {
+		switch user.Role {
+		case spinWickRoleGuest:
+			needsGuests = true
+		case spinWickRoleBot:
+			needsBots = true
+		}
+	}
+	if !needsGuests && !needsBots {
+		return nil
+	}
+
+	patch := &mattermostModel.Config{}
+	if needsGuests {
+		patch.GuestAccountsSettings.Enable = mattermostModel.NewBool(true)
+	}
+	if needsBots {
+		patch.ServiceSettings.EnableBotAccountCreation = mattermostModel.NewBool(true)
+		patch.ServiceSettings.EnableUserAccessTokens = mattermostModel.NewBool(true)
+	}
+	if _, _, err := client.PatchConfig(patch); err != nil {
+		return errors.Wrap(err, "failed to enable guest and bot accounts")
+	}
+
+	return nil
+}
+
+// createSpinWickRoster creates the roster users in the given team. The client
+// must be logged in as a system admin. Users that cannot be created, e.g. guests
+// on an unlicensed server, are left out of the returned credentials so the rest
+// of the SpinWick remains usable, and their errors are returned.
+func createSpinWickRoster(client *mattermostModel.Client4, teamID string, users []SpinWickUser, logger logrus.FieldLogger) (spinWickCredentials, []error) {
+	if len(users) == 0 {
+		return nil, nil
+	}
+
+	if err := enableRosterSettings(client, users); err != nil {
+		logger.WithError(err).Warn("Failed to update settings for the SpinWick user roster")
+	}
+
+	var credentials spinWickCredentials
+	var failures []error
+	for _, user := range users {
+		credential, err := createSpinWickRosterUser(client, teamID, user)
+		if err != nil {
+			logger.WithError(err).WithFields(logrus.Fields{
+				"username": user.Username,
+				"role":     user.Role,
+			}).Warn("Failed to create SpinWick roster user")
+			failures = append(failures, errors.Wrapf(err, "roster user %s (%s)", user.Username, user.Role))
+			continue
+		}
+		credentials = append(credentials, credential)
+	}
+
+	return credentials, failures
+}
+
+func createSpinWickRosterUser(client *mattermostModel.Client4, teamID string, user SpinWickUser) (spinWickCredential, error) {
+	credential := spinWickCredential{
+		AccountType: spinWickRoleAccountTypes[user.Role],
+		Username:    user.Username,
+	}
+
+	if user.Role == spinWickRoleBot {
+		bot, _, err := client.CreateBot(&mattermostModel.Bot{
+			Username:    user.Username,
+			DisplayName: user.Username,
+		})
+		if err != nil {
+			return credential, errors.Wrap(err, "failed to create bot")
+		}
+		if _, _, err = client.AddTeamMember(teamID, bot.UserId); err != nil {
+			return credential, disableRosterUser(client, bot.UserId, errors.Wrap(err, "failed to add bot to team"))
+		}
+		token, _, err := client.CreateUserAccessToken(bot.UserId, "SpinWick")
+		if err != nil {
+			return credential, disableRosterUser(client, bot.UserId, errors.Wrap(err, "failed to create bot access token"))
+		}
+		credential.Password = token.Token
+		return credential, nil
+	}
+
+	password, err := generateSecurePassword()
+	if err != nil {
+		return credential, errors.Wrap(err, "failed to generate password")
+	}
+	created, _, err := client.CreateUser(&mattermostModel.User{
+		Username: user.Username,
+		Email:    fmt.Sprintf("%s@example.mattermost.com", user.Username),
+		Password: password,
+	})
+	if err != nil {
+		return credential, errors.Wrap(err, "failed to create user")
+	}
+	credential.Password = password
+
+	if _, _, err = client.AddTeamMember(teamID, created.Id); err != nil {
+		return credential, disableRosterUser(client, created.Id, errors.Wrap(err, "failed to add user to team"))
+	}
+
+	switch user.Role {
+	case spinWickRoleSystemAdmin:
+		_, err = client.UpdateUserRoles(created.Id, "system_user system_admin")
+	case spinWickRoleTeamAdmin:
+		_, err = client.UpdateTeamMemberRoles(teamID, created.Id, "team_user team_admin")
+	case spinWickRoleGuest:
+		_, err = client.DemoteUserToGuest(created.Id)
+	case spinWickRoleDeactivated:
+		_, err = client.UpdateUserActive(created.Id, false)
+	}
+	if err != nil {
+		return credential, disableRosterUser(client, created.Id, errors.Wrapf(err, "failed to apply role %s", user.Role))
+	}
+
+	return credential, nil
+}
+
+// disableRosterUser deactivates a roster user that was created but could not be
+// set up, so that no account with the wrong role is left usable on the SpinWick.
+// It returns setupErr, annotated when the user could not be deactivated either.
+func disableRosterUser(client *mattermostModel.Client4, userID string, setupErr error) error {
+	if _, err := client.UpdateUserActive(userID, false); err != nil {
+		return errors.Wrapf(setupErr, "failed to deactivate the user (%s)", err.Error())
+	}
+	return setupErr
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	mattermostModel "github.com/mattermost/mattermost-server/v6/model"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUsersArg(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		input       string
+		expected    []SpinWickUser
+		expectedErr string
+	}{
+		{
+			name:  "single user",
+			input: "guest1:guest",
+			expected: []SpinWickUser{
+				{Username: "guest1", Role: spinWickRoleGuest},
+			},
+		},
+		{
+			name:  "multiple users with spaces",
+			input: "admin2:system_admin, lead:team_admin ,bot1:bot",
+			expected: []SpinWickUser{
+				{Username: "admin2", Role: spinWickRoleSystemAdmin},
+				{Username: "lead", Role: spinWickRoleTeamAdmin},
+				{Username: "bot1", Role: spinWickRoleBot},
+			},
+		},
+		{
+			name:        "empty",
+			input:       "",
+			expectedErr: "no users found",
+		},
+		{
+			name:        "missing role",
+			input:       "guest1",
+			expectedErr: `invalid user "guest1", expected username:role`,
+		},
+		{
+			name:        "unsupported role",
+			input:       "guest1:owner",
+			expectedErr: `unsupported role "owner" for user "guest1"`,
+		},
+		{
+			name:        "invalid username",
+			input:       "Not Valid:member",
+			expectedErr: `invalid username "Not Valid"`,
+		},
+		{
+			name:        "duplicate username",
+			input:       "a1:member,a1:guest",
+			expectedErr: `duplicate username "a1"`,
+		},
+		{
+			name:        "reserved username",
+			input:       "sysadmin:member",
+			expectedErr: `duplicate username "sysadmin"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			users, err := parseUsersArg(tc.input)
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, users)
+		})
+	}
+}
+
+func TestSpinWickCredentials(t *testing.T) {
+	credentials := spinWickCredentials{
+		{AccountType: "Admin", Username: "sysadmin", Password: "pw1"},
+		{AccountType: "Guest", Username: "guest1", Password: "pw2"},
+	}
+
+	assert.Equal(t, "pw2", credentials.password("guest1"))
+	assert.Empty(t, credentials.password("missing"))
+	assert.Equal(t, "| Account Type | Username | Password |\n|---|---|---|\n| Admin | sysadmin | pw1 |\n| Guest | guest1 | pw2 |", credentials.table())
+}
+
+func TestCreateSpinWickRoster(t *testing.T) {
+	var patchedConfig bool
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v4/config/patch", func(w http.ResponseWriter, r *http.Request) {
+		patchedConfig = true
+		w.Write([]byte(`{}`))
+	})
+	mux.HandleFunc("/api/v4/users", func(w http.ResponseWriter, r *http.Request) {
+		user := mattermostModel.User{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&user))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":"id-` + user.Username + `","username":"` + user.Username + `"}`))
+	})
+	mux.HandleFunc("/api/v4/bots", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"user_id":"id-bot1","username":"bot1"}`))
+	})
+	var deactivated []string
+	mux.HandleFunc("/api/v4/users/", func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/active"):
+			deactivated = append(deactivated, strings.Split(r.URL.Path, "/")[4])
+			w.Write([]byte(`{"status":"OK"}`))
+		case strings.HasSuffix(r.URL.Path, "/demote"):
+			// Guest accounts need a license, which this server doesn't have.
+			w.WriteHeader(http.StatusNotImplemented)
+			w.Write([]byte(`{"message":"license required"}`))
+		case strings.HasSuffix(r.URL.Path, "/tokens"):
+			w.Write([]byte(`{"id":"token-id","token":"bot-token"}`))
+		default:
+			w.Write([]byte(`{"status":"OK"}`))
+		}
+	})
+	mux.HandleFunc("/api/v4/teams/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"team_id":"team-id"}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := mattermostModel.NewAPIv4Client(server.URL)
+	credentials, failures := createSpinWickRoster(client, "team-id", []SpinWickUser{
+		{Username: "admin2", Role: spinWickRoleSystemAdmin},
+		{Username: "guest1", Role: spinWickRoleGuest},
+		{Username: "bot1", Role: spinWickRoleBot},
+		{Username: "gone", Role: spinWickRoleDeactivated},
+	}, logrus.New())
+
+	assert.True(t, patchedConfig)
+	require.Len(t, credentials, 3, "the guest should be skipped")
+	assert.Equal(t, "admin2", credentials[0].Username)
+	assert.Equal(t, "System Admin", credentials[0].AccountType)
+	assert.Len(t, credentials[0].Password, 32)
+	assert.Equal(t, spinWickCredential{AccountType: "Bot (access token)", Username: "bot1", Password: "bot-token"}, credentials[1])
+	assert.Equal(t, "gone", credentials[2].Username)
+
+	require.Len(t, failures, 1)
+	assert.Contains(t, failures[0].Error(), "roster user guest1 (guest): failed to apply role guest")
+	assert.Equal(t, []string{"id-guest1", "id-gone"}, deactivated, "the guest that could not be demoted should be deactivated")
+}
@@ -0,0 +1,334 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	cloudModel "github.com/mattermost/mattermost-cloud/model"
+	"github.com/mattermost/matterwick/internal/cloudtools"
+	"github.com/mattermost/matterwick/internal/spinwick"
+	"github.com/mattermost/matterwick/model"
+	"github.com/sirupsen/logrus"
+)
+
+// spinWickVariantNameRegex keeps variant names short enough to fit in the
+// installation DNS name next to the repository name and PR number.
+var spinWickVariantNameRegex = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,10}[a-z0-9])?$`)
+
+// spinWickVariant is a named SpinWick created next to the default SpinWick of
+// a PR with /spinwick create --name. Variants are not backed by a label.
+type spinWickVariant struct {
+	size        string
+	withLicense bool
+}
+
+// validateVariantName returns an error if name cannot be used as a variant name.
+// An empty name is valid and means the default SpinWick.
+func validateVariantName(name string) error {
+	if name != "" && !spinWickVariantNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid variant name %q, use up to 12 lowercase letters, digits or '-'", name)
+	}
+	return nil
+}
+
+// variantSuffix returns the suffix that identifies a variant in GitHub comments.
+func variantSuffix(variant string) string {
+	if variant == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (variant `%s`)", variant)
+}
+
+// addSpinWickVariant starts tracking a variant of the SpinWick. It returns false
+// if a variant with the same name is already tracked.
+func (s *Server) addSpinWickVariant(spinwickID, name string, variant spinWickVariant) bool {
+	s.spinWickVariantsLock.Lock()
+	defer s.spinWickVariantsLock.Unlock()
+	if _, ok := s.spinWickVariants[spinwickID][name]; ok {
+		return false
+	}
+	if s.spinWickVariants[spinwickID] == nil {
+		s.spinWickVariants[spinwickID] = make(map[string]spinWickVariant)
+	}
+	s.spinWickVariants[spinwickID][name] = variant
+	return true
+}
+
+func (s *Server) deleteSpinWickVariant(spinwickID, name string) {
+	s.spinWickVariantsLock.Lock()
+	defer s.spinWickVariantsLock.Unlock()
+	delete(s.spinWickVariants[spinwickID], name)
+	if len(s.spinWickVariants[spinwickID]) == 0 {
+		delete(s.spinWickVariants, spinwickID)
+	}
+}
+
+func (s *Server) getSpinWickVariant(spinwickID, name string) (spinWickVariant, bool) {
+	s.spinWickVariantsLock.Lock()
+	defer s.spinWickVariantsLock.Unlock()
+	variant, ok := s.spinWickVariants[spinwickID][name]
+	return variant, ok
+}
+
+// spinWickVariantNames returns the sorted variant names of a SpinWick.
+func (s *Server) spinWickVariantNames(spinwickID string) []string {
+	s.spinWickVariantsLock.Lock()
+	defer s.spinWickVariantsLock.Unlock()
+	names := make([]string, 0, len(s.spinWickVariants[spinwickID]))
+	for name := range s.spinWickVariants[spinwickID] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// setVariantEnvAndOptions stores the /spinwick create settings of a variant
+// under the variant's RepeatableID, the same way they are stored for the
+// default SpinWick.
+func (s *Server) setVariantEnvAndOptions(variantID string, envMap cloudModel.EnvVarMap, opts spinWickOptions) {
+	s.envMapsLock.Lock()
+	s.envMaps[variantID] = envMap
+	s.envMapsLock.Unlock()
+	s.setSpinWickOptions(variantID, opts)
+}
+
+// handleCreateSpinWickVariant creates a named variant of the PR's SpinWick.
+func (s *Server) handleCreateSpinWickVariant(pr *model.PullRequest, name, size string, envVars cloudModel.EnvVarMap) {
+	logger := s.Logger.WithFields(logrus.Fields{"repo_name": pr.RepoName, "pr": pr.Number, "variant": name})
+	if pr.State == "closed" {
+		logger.Info("PR is closed/merged, will not create a test server")
+		s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, "PR is closed/merged not creating a SpinWick Test server")
+		return
+	}
+	if pr.RepoName != mattermostServerRepo {
+		s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, fmt.Sprintf("Named SpinWick variants are only supported for the %s repository.", mattermostServerRepo))
+		return
+	}
+
+	spinwickID := model.NewSpinwick(pr.RepoName, pr.Number, s.Config.DNSNameTestServer).RepeatableID
+	withLicense := size == "miniHA"
+	if !s.addSpinWickVariant(spinwickID, name, spinWickVariant{size: size, withLicense: withLicense}) {
+		s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, fmt.Sprintf("A SpinWick variant named `%s` already exists for this PR.", name))
+		return
+	}
+
+	variantID := model.NewSpinwickVariant(pr.RepoName, pr.Number, name, s.Config.DNSNameTestServer).RepeatableID
+	ctx, finish, ok := s.beginSpinWickOperation(context.Background(), variantID, spinWickOperationCreate, logger)
+	if !ok {
+		s.deleteSpinWickVariant(spinwickID, name)
+		return
+	}
+	request := &spinwick.Request{InstallationID: "n/a"}
+	defer func() { finish(request) }()
+
+	s.setSpinWickStatus(pr, name, fmt.Sprintf("Creating SpinWick variant `%s` using Mattermost Cloud.", name))
+	s.startSpinWickDeployment(pr, name, logger)
+	s.startProvisioningCheck(pr, variantID, spinWickCheckName(name), logger)
+	if lease, err := s.waitForSpinWickCapacity(ctx, pr, name, variantID, logger); err != nil {
+		request.WithError(err)
+	} else {
+		request = s.createSpinWick(ctx, pr, name, size, withLicense, envVars, logger)
+		if request.InstallationID != "n/a" {
+			lease.keep(variantID)
+		}
+		lease.done()
+	}
+	if destroyedSpinWickOperation(ctx, request) {
+		logger.Info("SpinWick variant creation cancelled by a destroy")
+	}
+
+	logger = logger.WithField("installation_id", request.InstallationID)
+	s.finishSpinWickDeployment(pr, name, request, logger)
+	s.finishProvisioningCheck(variantID, request)
+
+	if request.Error != nil {
+		if request.Aborted {
+			logger.WithError(request.Error).Warn("Aborted creation of SpinWick variant")
+		} else {
+			logger.WithError(request.Error).Error("Failed to create SpinWick variant")
+		}
+		// Keep tracking variants that have an installation so they are still
+		// destroyed with the PR.
+		if request.InstallationID == "n/a" {
+			s.deleteSpinWickVariant(spinwickID, name)
+		}
+		if !request.PRNotified {
+			s.setSpinWickStatus(pr, name, s.Config.SetupSpinmintFailedMessage+variantSuffix(name))
+		}
+
+		if request.ReportError {
+			additionalFields := map[string]string{
+				"Installation ID": request.InstallationID,
+				"Variant":         name,
+			}
+			s.logPrettyErrorToMattermost("[ SpinWick ] Creation Failed", pr, request.Error, additionalFields, logger)
+		}
+	}
+}
+
+// handleUpdateSpinWickVariant updates a named variant of the PR's SpinWick with
+// the PR's build and the variant's environment variables.
+func (s *Server) handleUpdateSpinWickVariant(pr *model.PullRequest, name string, noBuildChanges bool) {
+	logger := s.Logger.WithFields(logrus.Fields{"repo_name": pr.RepoName, "pr": pr.Number, "variant": name})
+
+	spinwickID := model.NewSpinwick(pr.RepoName, pr.Number, s.Config.DNSNameTestServer).RepeatableID
+	variant, ok := s.getSpinWickVariant(spinwickID, name)
+	if !ok {
+		s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, fmt.Sprintf("No SpinWick variant named `%s` found for this PR.", name))
+		return
+	}
+
+	variantID := model.NewSpinwickVariant(pr.RepoName, pr.Number, name, s.Config.DNSNameTestServer).RepeatableID
+	updateCtx, done := s.startSpinWickUpdate(variantID, pr.Sha, logger)
+	defer done()
+	ctx, finish, ok := s.beginSpinWickOperation(updateCtx, variantID, spinWickOperationUpdate, logger)
+	if !ok {
+		return
+	}
+	request := &spinwick.Request{InstallationID: "n/a"}
+	defer func() { finish(request) }()
+
+	s.startSpinWickDeployment(pr, name, logger)
+	s.startProvisioningCheck(pr, variantID, spinWickCheckName(name), logger)
+	request = s.updateSpinWick(ctx, pr, name, variant.withLicense, false, noBuildChanges, s.getEnvMap(variantID), logger)
+	if supersededSpinWickUpdate(updateCtx, request) {
+		logger.Info("SpinWick variant update superseded by a newer commit")
+	} else if destroyedSpinWickOperation(ctx, request) {
+		logger.Info("SpinWick variant update cancelled by a destroy")
+	}
+
+	logger = logger.WithField("installation_id", request.InstallationID)
+	s.finishSpinWickDeployment(pr, name, request, logger)
+	s.finishProvisioningCheck(variantID, request)
+
+	if request.Error != nil {
+		if request.Aborted {
+			logger.WithError(request.Error).Warn("Aborted update of SpinWick variant")
+		} else {
+			logger.WithError(request.Error).Error("Failed to update SpinWick variant")
+		}
+		if !request.PRNotified {
+			s.setSpinWickStatus(pr, name, s.Config.SetupSpinmintFailedMessage+variantSuffix(name))
+		}
+		if request.ReportError {
+			additionalFields := map[string]string{
+				"Installation ID": request.InstallationID,
+				"Variant":         name,
+			}
+			s.logPrettyErrorToMattermost("[ SpinWick ] Update Failed", pr, request.Error, additionalFields, logger)
+		}
+	}
+}
+
+// handleUpdateSpinWickVariants updates all named variants of the PR's SpinWick
+// in parallel after a new commit.
+func (s *Server) handleUpdateSpinWickVariants(pr *model.PullRequest, noBuildChanges bool) {
+	spinwickID := model.NewSpinwick(pr.RepoName, pr.Number, s.Config.DNSNameTestServer).RepeatableID
+
+	var wg sync.WaitGroup
+	for _, name := range s.spinWickVariantNames(spinwickID) {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			s.handleUpdateSpinWickVariant(pr, name, noBuildChanges)
+		}(name)
+	}
+	wg.Wait()
+}
+
+// handleDestroySpinWickVariant destroys a named variant of the PR's SpinWick.
+func (s *Server) handleDestroySpinWickVariant(pr *model.PullRequest, name string) {
+	logger := s.Logger.WithFields(logrus.Fields{"repo_name": pr.RepoName, "pr": pr.Number, "variant": name})
+
+	spinwickID := model.NewSpinwick(pr.RepoName, pr.Number, s.Config.DNSNameTestServer).RepeatableID
+	if _, ok := s.getSpinWickVariant(spinwickID, name); !ok {
+		s.sendGitHubComment(pr.RepoOwner, pr.RepoName, pr.Number, fmt.Sprintf("No SpinWick variant named `%s` found for this PR.", name))
+		return
+	}
+
+	variantID := model.NewSpinwickVariant(pr.RepoName, pr.Number, name, s.Config.DNSNameTestServer).RepeatableID
+	_, finish, ok := s.beginSpinWickOperation(context.Background(), variantID, spinWickOperationDestroy, logger)
+	if !ok {
+		return
+	}
+	request := &spinwick.Request{InstallationID: "n/a"}
+	defer func() { finish(request) }()
+
+	// The variant may be gone if its creation failed while this destroy waited.
+	if _, ok := s.getSpinWickVariant(spinwickID, name); !ok {
+		return
+	}
+
+	request = s.destroySpinWick(pr, name, logger)
+
+	logger = logger.WithField("installation_id", request.InstallationID)
+
+	if request.Error != nil && !request.Aborted {
+		logger.WithError(request.Error).Error("Failed to delete SpinWick variant")
+		if request.ReportError {
+			additionalFields := map[string]string{
+				"Installation ID": request.InstallationID,
+				"Variant":         name,
+			}
+			s.logPrettyErrorToMattermost("[ SpinWick ] Destroy Failed", pr, request.Error, additionalFields, logger)
+		}
+		return
+	}
+	if request.Error != nil {
+		logger.WithError(request.Error).Warn("Aborted deletion of SpinWick variant")
+	}
+	s.releaseProvisioningCapacity(variantID)
+
+	s.setSpinWickDeploymentStatus(pr, name, deploymentStateInactive, "", logger)
+
+	s.envMapsLock.Lock()
+	delete(s.envMaps, variantID)
+	s.envMapsLock.Unlock()
+	s.deleteSpinWickOptions(variantID)
+	s.deleteSpinWickCredentials(variantID)
+	s.cleanupSpinWickSnapshots(pr, name)
+	s.unlinkCompanions(pr, name)
+	s.deleteSpinWickVariant(spinwickID, name)
+}
+
+// handleDestroySpinWickVariants destroys all named variants of the PR's SpinWick,
+// including the ones created before a restart.
+func (s *Server) handleDestroySpinWickVariants(pr *model.PullRequest) {
+	logger := s.Logger.WithFields(logrus.Fields{"repo_name": pr.RepoName, "pr": pr.Number})
+	spinwickID := model.NewSpinwick(pr.RepoName, pr.Number, s.Config.DNSNameTestServer).RepeatableID
+	if err := s.trackProvisionedSpinWickVariants(spinwickID, logger); err != nil {
+		logger.WithError(err).Warn("Failed to find the SpinWick variants on the provisioner, only destroying the tracked ones")
+	}
+	for _, name := range s.spinWickVariantNames(spinwickID) {
+		s.handleDestroySpinWickVariant(pr, name)
+	}
+}
+
+// trackProvisionedSpinWickVariants starts tracking the variants of the SpinWick
+// that have an installation on the provisioner. Variants are only tracked in
+// memory, so this finds the ones created before a restart.
+func (s *Server) trackProvisionedSpinWickVariants(spinwickID string, logger logrus.FieldLogger) error {
+	prefix := spinwickID + "-"
+	installations, err := cloudtools.GetInstallationsWithOwnerIDPrefix(s.CloudClient, s.Config.CloudGroupID, prefix)
+	if err != nil {
+		return err
+	}
+
+	for _, installation := range installations {
+		name := strings.TrimPrefix(installation.OwnerID, prefix)
+		if name == "" || validateVariantName(name) != nil {
+			continue
+		}
+		if s.addSpinWickVariant(spinwickID, name, spinWickVariant{size: installation.Size}) {
+			logger.WithFields(logrus.Fields{"variant": name, "installation_id": installation.ID}).Info("Found untracked SpinWick variant on the provisioner")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mattermost/matterwick/model"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateVariantName(t *testing.T) {
+	for name, valid := range map[string]bool{
+		"":              true,
+		"ha":            true,
+		"team-edition":  true,
+		"te-1":          true,
+		"-ha":           false,
+		"ha-":           false,
+		"HA":            false,
+		"team_edition":  false,
+		"thirteen-char": false,
+	} {
+		err := validateVariantName(name)
+		if valid {
+			assert.NoError(t, err, name)
+		} else {
+			assert.Error(t, err, name)
+		}
+	}
+}
+
+func TestSpinWickVariantTracking(t *testing.T) {
+	s := &Server{spinWickVariants: make(map[string]map[string]spinWickVariant)}
+
+	assert.True(t, s.addSpinWickVariant("mattermost-pr-1", "ha", spinWickVariant{size: "miniHA", withLicense: true}))
+	assert.True(t, s.addSpinWickVariant("mattermost-pr-1", "te", spinWickVariant{size: "miniSingleton"}))
+	assert.False(t, s.addSpinWickVariant("mattermost-pr-1", "ha", spinWickVariant{}), "duplicate names are rejected")
+	assert.True(t, s.addSpinWickVariant("mattermost-pr-2", "ha", spinWickVariant{}))
+
+	assert.Equal(t, []string{"ha", "te"}, s.spinWickVariantNames("mattermost-pr-1"))
+	variant, ok := s.getSpinWickVariant("mattermost-pr-1", "ha")
+	assert.True(t, ok)
+	assert.Equal(t, spinWickVariant{size: "miniHA", withLicense: true}, variant)
+
+	s.deleteSpinWickVariant("mattermost-pr-1", "ha")
+	s.deleteSpinWickVariant("mattermost-pr-1", "te")
+	assert.Empty(t, s.spinWickVariantNames("mattermost-pr-1"))
+	assert.Len(t, s.spinWickVariants, 1)
+
+	assert.Empty(t, variantSuffix(""))
+	assert.Equal(t, " (variant `ha`)", variantSuffix("ha"))
+}
+
+func TestTrackProvisionedSpinWickVariants(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/installations" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`[
+			{"ID":"inst-1","OwnerID":"mattermost-pr-1","State":"stable"},
+			{"ID":"inst-2","OwnerID":"mattermost-pr-1-ha","State":"stable","Size":"miniHA"},
+			{"ID":"inst-3","OwnerID":"mattermost-pr-1-te","State":"stable"},
+			{"ID":"inst-4","OwnerID":"mattermost-pr-1-old","State":"deletion-requested"},
+			{"ID":"inst-5","OwnerID":"mattermost-pr-12-ha","State":"stable"}
+		]`))
+	}))
+	defer ts.Close()
+
+	s := &Server{
+		Config:           &MatterwickConfig{},
+		Logger:           logrus.New(),
+		CloudClient:      model.NewCloudClient(ts.URL, "", "", "", ""),
+		spinWickVariants: make(map[string]map[string]spinWickVariant),
+	}
+	s.addSpinWickVariant("mattermost-pr-1", "te", spinWickVariant{size: "miniSingleton"})
+
+	require.NoError(t, s.trackProvisionedSpinWickVariants("mattermost-pr-1", s.Logger))
+	assert.Equal(t, []string{"ha", "te"}, s.spinWickVariantNames("mattermost-pr-1"))
+	variant, _ := s.getSpinWickVariant("mattermost-pr-1", "ha")
+	assert.Equal(t, "miniHA", variant.size)
+	variant, _ = s.getSpinWickVariant("mattermost-pr-1", "te")
+	assert.Equal(t, "miniSingleton", variant.size, "tracked variants are kept")
+	assert.Empty(t, s.spinWickVariantNames("mattermost-pr-12"))
+}
@@ -0,0 +1,256 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/go-github/v32/github"
+	"github.com/mattermost/matterwick/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// statusCommentMarker identifies the status comment matterwick keeps on each PR.
+const statusCommentMarker = "<!-- matterwick:status -->"
+
+// statusSection is a part of the status comment owned by one feature.
+type statusSection string
+
+const (
+	statusSectionSpinWick   statusSection = "spinwick"
+	statusSectionSettings   statusSection = "settings"
+	statusSectionSnapshots  statusSection = "snapshots"
+	statusSectionCompanions statusSection = "companions"
+	statusSectionE2E        statusSection = "e2e"
+	statusSectionCMT        statusSection = "cmt"
+	statusSectionWarnings   statusSection = "warnings"
+)
+
+// statusSections are the sections of the status comment in display order.
+var statusSections = []struct {
+	id    statusSection
+	title string
+}{
+	{statusSectionSpinWick, "SpinWick"},
+	{statusSectionSettings, "SpinWick Settings"},
+	{statusSectionSnapshots, "SpinWick Snapshots"},
+	{statusSectionCompanions, "Companion PRs"},
+	{statusSectionE2E, "E2E Tests"},
+	{statusSectionCMT, "Compatibility Matrix Testing"},
+	{statusSectionWarnings, "Warnings"},
+}
+
+// statusSectionMaxLines is the number of lines kept by sections that collect
+// events, like the warnings. Older lines are dropped.
+const statusSectionMaxLines = 10
+
+// spinWickVariantSectionPrefix prefixes the sections of named SpinWick variants,
+// which follow the SpinWick section.
+const spinWickVariantSectionPrefix = "spinwick-variant-"
+
+// spinWickVariantSection returns the status comment section of a named variant.
+func spinWickVariantSection(variant string) statusSection {
+	return statusSection(spinWickVariantSectionPrefix + variant)
+}
+
+var statusSectionRegex = regexp.MustCompile(`(?s)<!-- matterwick:section:([a-z0-9-]+) -->\n(.*?)\n<!-- /matterwick:section -->`)
+
+// parseStatusComment returns the section contents of a status comment body.
+func parseStatusComment(body string) map[statusSection]string {
+	sections := make(map[statusSection]string)
+	for _, match := range statusSectionRegex.FindAllStringSubmatch(body, -1) {
+		sections[statusSection(match[1])] = match[2]
+	}
+	return sections
+}
+
+// renderStatusComment renders the status comment body. Empty sections are left out.
+func renderStatusComment(sections map[statusSection]string) string {
+	var sb strings.Builder
+	sb.WriteString(statusCommentMarker)
+	sb.WriteString("\n## MatterWick Status")
+	writeSection := func(id statusSection, title string) {
+		content := sections[id]
+		if content == "" {
+			return
+		}
+		sb.WriteString(fmt.Sprintf("\n\n### %s\n<!-- matterwick:section:%s -->\n%s\n<!-- /matterwick:section -->", title, id, content))
+	}
+
+	var variants []string
+	for id := range sections {
+		if variant := strings.TrimPrefix(string(id), spinWickVariantSectionPrefix); variant != string(id) {
+			variants = append(variants, variant)
+		}
+	}
+	sort.Strings(variants)
+
+	for _, section := range statusSections {
+		writeSection(section.id, section.title)
+		if section.id == statusSectionSpinWick {
+			for _, variant := range variants {
+				writeSection(spinWickVariantSection(variant), fmt.Sprintf("SpinWick variant `%s`", variant))
+			}
+		}
+	}
+	return sb.String()
+}
+
+// findStatusComment returns the status comment matterwick posted on the PR, or
+// nil if there is none.
+func (s *Server) findStatusComment(ctx context.Context, client *github.Client, pr *model.PullRequest) (*github.IssueComment, error) {
+	opts := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		comments, resp, err := client.Issues.ListComments(ctx, pr.RepoOwner, pr.RepoName, pr.Number, opts)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list comments")
+		}
+		for _, comment := range comments {
+			if comment.GetUser().GetLogin() == s.Config.Username && strings.HasPrefix(comment.GetBody(), statusCommentMarker) {
+				return comment, nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return nil, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// updateStatusComment sets one section of the PR's status comment, creating the
+// comment when the PR has none yet. An empty content removes the section.
+func (s *Server) updateStatusComment(pr *model.PullRequest, section statusSection, content string) {
+	logger := s.Logger.WithFields(logrus.Fields{"repo_name": pr.RepoName, "pr": pr.Number, "section": section})
+	if err := s.setStatusSection(pr, section, content); err != nil {
+		logger.WithError(err).Error("Failed to update the status comment")
+	}
+}
+
+// appendStatusComment adds a line to one section of the PR's status comment,
+// keeping the last statusSectionMaxLines lines of the section.
+func (s *Server) appendStatusComment(pr *model.PullRequest, section statusSection, line string) {
+	logger := s.Logger.WithFields(logrus.Fields{"repo_name": pr.RepoName, "pr": pr.Number, "section": section})
+	err := s.editStatusSection(pr, section, func(content string) string {
+		lines := strings.Split(content, "\n")
+		if content == "" {
+			lines = nil
+		}
+		lines = append(lines, "- "+strings.TrimSpace(line))
+		if len(lines) > statusSectionMaxLines {
+			lines = lines[len(lines)-statusSectionMaxLines:]
+		}
+		return strings.Join(lines, "\n")
+	})
+	if err != nil {
+		logger.WithError(err).Error("Failed to update the status comment")
+	}
+}
+
+// addSpinWickWarning reports a problem of the PR's SpinWick that does not fail
+// it in the warnings of the status comment.
+func (s *Server) addSpinWickWarning(pr *model.PullRequest, variant, warning string) {
+	s.appendStatusComment(pr, statusSectionWarnings, ":warning: "+warning+variantSuffix(variant))
+}
+
+// statusCommentMutex returns the lock of the status comment of the PR.
+func (s *Server) statusCommentMutex(pr *model.PullRequest) *sync.Mutex {
+	key := fmt.Sprintf("%s/%s#%d", pr.RepoOwner, pr.RepoName, pr.Number)
+
+	s.statusCommentLocksMu.Lock()
+	defer s.statusCommentLocksMu.Unlock()
+	if s.statusCommentLocks == nil {
+		s.statusCommentLocks = make(map[string]*sync.Mutex)
+	}
+	if m, ok := s.statusCommentLocks[key]; ok {
+		return m
+	}
+	m := &sync.Mutex{}
+	s.statusCommentLocks[key] = m
+	return m
+}
+
+func (s *Server) setStatusSection(pr *model.PullRequest, section statusSection, content string) error {
+	return s.editStatusSection(pr, section, func(string) string { return content })
+}
+
+// editStatusSection replaces one section of the PR's status comment with the
+// result of edit, which is called with the current content of the section.
+func (s *Server) editStatusSection(pr *model.PullRequest, section statusSection, edit func(content string) string) error {
+	// The comment is read and written back, so concurrent updates of its
+	// sections must not interleave.
+	lock := s.statusCommentMutex(pr)
+	lock.Lock()
+	defer lock.Unlock()
+
+	ctx := context.Background()
+	client := s.githubClient()
+
+	comment, err := s.findStatusComment(ctx, client, pr)
+	if err != nil {
+		return err
+	}
+
+	sections := make(map[statusSection]string)
+	if comment != nil {
+		sections = parseStatusComment(comment.GetBody())
+	}
+	content := strings.TrimSpace(edit(sections[section]))
+	sections[section] = content
+	body := renderStatusComment(sections)
+
+	if comment == nil {
+		if content == "" {
+			return nil
+		}
+		_, _, err = client.Issues.CreateComment(ctx, pr.RepoOwner, pr.RepoName, pr.Number, &github.IssueComment{Body: &body})
+		return errors.Wrap(err, "failed to create the status comment")
+	}
+	if body == comment.GetBody() {
+		return nil
+	}
+
+	_, resp, err := client.Issues.EditComment(ctx, pr.RepoOwner, pr.RepoName, comment.GetID(), &github.IssueComment{Body: &body})
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		// The comment was deleted in the meantime.
+		_, _, err = client.Issues.CreateComment(ctx, pr.RepoOwner, pr.RepoName, pr.Number, &github.IssueComment{Body: &body})
+		return errors.Wrap(err, "failed to create the status comment")
+	}
+	return errors.Wrap(err, "failed to edit the status comment")
+}
+
+// setSpinWickStatus shows the state of the PR's SpinWick in the status comment.
+// Named variants have a section of their own.
+func (s *Server) setSpinWickStatus(pr *model.PullRequest, variant, status string) {
+	if variant != "" {
+		s.updateStatusComment(pr, spinWickVariantSection(variant), status)
+		return
+	}
+	s.updateStatusComment(pr, statusSectionSpinWick, status)
+}
+
+// updateBranchStatusComment sets a section of the status comment of the open PR
+// for the branch. Branches without an open PR, e.g. release branches, are skipped.
+func (s *Server) updateBranchStatusComment(owner, repoName, branch string, section statusSection, content string, logger logrus.FieldLogger) {
+	prs, _, err := s.githubClient().PullRequests.List(context.Background(), owner, repoName, &github.PullRequestListOptions{
+		State: "open",
+		Head:  owner + ":" + branch,
+	})
+	if err != nil {
+		logger.WithError(err).Warn("Failed to find the PR for the branch")
+		return
+	}
+	if len(prs) == 0 {
+		return
+	}
+
+	pr := &model.PullRequest{RepoOwner: owner, RepoName: repoName, Number: prs[0].GetNumber()}
+	s.updateStatusComment(pr, section, content)
+}
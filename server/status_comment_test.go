@@ -0,0 +1,199 @@
+// Copyright (c) 2020-present Mattermost, Inc. All Rights Reserved.
+// See License.txt for license information.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mattermost/matterwick/model"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderStatusComment(t *testing.T) {
+	body := renderStatusComment(map[statusSection]string{
+		statusSectionCMT:      "Dispatched.",
+		statusSectionSpinWick: "Creating a new SpinWick test server.\nThis takes a few minutes.",
+		statusSectionE2E:      "",
+	})
+
+	assert.Equal(t, statusCommentMarker+"\n## MatterWick Status"+
+		"\n\n### SpinWick\n<!-- matterwick:section:spinwick -->\nCreating a new SpinWick test server.\nThis takes a few minutes.\n<!-- /matterwick:section -->"+
+		"\n\n### Compatibility Matrix Testing\n<!-- matterwick:section:cmt -->\nDispatched.\n<!-- /matterwick:section -->", body)
+
+	assert.Equal(t, map[statusSection]string{
+		statusSectionSpinWick: "Creating a new SpinWick test server.\nThis takes a few minutes.",
+		statusSectionCMT:      "Dispatched.",
+	}, parseStatusComment(body))
+}
+
+func TestRenderStatusCommentVariants(t *testing.T) {
+	sections := map[statusSection]string{
+		statusSectionE2E:                    "E2E test servers created.",
+		spinWickVariantSection("perf"):      "SpinWick variant `perf` destroyed.",
+		spinWickVariantSection("ha"):        "Creating SpinWick variant `ha` using Mattermost Cloud.",
+		statusSectionSpinWick:               "SpinWick is ready.",
+		spinWickVariantSection("empty-one"): "",
+	}
+	body := renderStatusComment(sections)
+
+	assert.Equal(t, statusCommentMarker+"\n## MatterWick Status"+
+		"\n\n### SpinWick\n<!-- matterwick:section:spinwick -->\nSpinWick is ready.\n<!-- /matterwick:section -->"+
+		"\n\n### SpinWick variant `ha`\n<!-- matterwick:section:spinwick-variant-ha -->\nCreating SpinWick variant `ha` using Mattermost Cloud.\n<!-- /matterwick:section -->"+
+		"\n\n### SpinWick variant `perf`\n<!-- matterwick:section:spinwick-variant-perf -->\nSpinWick variant `perf` destroyed.\n<!-- /matterwick:section -->"+
+		"\n\n### E2E Tests\n<!-- matterwick:section:e2e -->\nE2E test servers created.\n<!-- /matterwick:section -->", body)
+
+	delete(sections, spinWickVariantSection("empty-one"))
+	assert.Equal(t, sections, parseStatusComment(body))
+}
+
+// statusCommentGitHubMock is a minimal GitHub API serving the comments of one PR.
+type statusCommentGitHubMock struct {
+	lock     sync.Mutex
+	comments map[int64]string
+	nextID   int64
+	creates  int
+	edits    int
+	// deleted makes edits fail as if the comment was deleted.
+	deleted bool
+}
+
+func (m *statusCommentGitHubMock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/repos/mattermost/mattermost-server/issues/1/comments":
+		comments := []map[string]interface{}{
+			{"id": 1000, "body": statusCommentMarker + "\nposted by someone else", "user": map[string]string{"login": "other"}},
+		}
+		for id, body := range m.comments {
+			comments = append(comments, map[string]interface{}{"id": id, "body": body, "user": map[string]string{"login": "matterwick"}})
+		}
+		json.NewEncoder(w).Encode(comments)
+	case r.Method == http.MethodPost && r.URL.Path == "/repos/mattermost/mattermost-server/issues/1/comments":
+		var comment struct{ Body string }
+		json.NewDecoder(r.Body).Decode(&comment)
+		m.nextID++
+		m.comments[m.nextID] = comment.Body
+		m.creates++
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": m.nextID, "body": comment.Body})
+	case r.Method == http.MethodPatch:
+		var id int64
+		fmt.Sscanf(r.URL.Path, "/repos/mattermost/mattermost-server/issues/comments/%d", &id)
+		if _, ok := m.comments[id]; !ok || m.deleted {
+			delete(m.comments, id)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var comment struct{ Body string }
+		json.NewDecoder(r.Body).Decode(&comment)
+		m.comments[id] = comment.Body
+		m.edits++
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "body": comment.Body})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestSetStatusSection(t *testing.T) {
+	mock := &statusCommentGitHubMock{comments: make(map[int64]string)}
+	ts := httptest.NewServer(mock)
+	defer ts.Close()
+
+	s := &Server{
+		Logger:        logrus.New(),
+		Config:        &MatterwickConfig{Username: "matterwick"},
+		githubAPIBase: ts.URL + "/",
+	}
+	pr := &model.PullRequest{RepoOwner: "mattermost", RepoName: "mattermost-server", Number: 1}
+
+	t.Run("nothing to clear", func(t *testing.T) {
+		require.NoError(t, s.setStatusSection(pr, statusSectionE2E, ""))
+		assert.Empty(t, mock.comments)
+	})
+
+	t.Run("create", func(t *testing.T) {
+		require.NoError(t, s.setStatusSection(pr, statusSectionSpinWick, "Creating a new SpinWick test server."))
+		require.Len(t, mock.comments, 1)
+		assert.Equal(t, 1, mock.creates)
+	})
+
+	t.Run("edit keeps other sections", func(t *testing.T) {
+		require.NoError(t, s.setStatusSection(pr, statusSectionE2E, "E2E test servers created."))
+		require.NoError(t, s.setStatusSection(pr, statusSectionSpinWick, "SpinWick is ready."))
+		require.Len(t, mock.comments, 1)
+		assert.Equal(t, 1, mock.creates)
+		assert.Equal(t, 2, mock.edits)
+		assert.Equal(t, map[statusSection]string{
+			statusSectionSpinWick: "SpinWick is ready.",
+			statusSectionE2E:      "E2E test servers created.",
+		}, parseStatusComment(mock.comments[1]))
+	})
+
+	t.Run("unchanged", func(t *testing.T) {
+		require.NoError(t, s.setStatusSection(pr, statusSectionSpinWick, "SpinWick is ready."))
+		assert.Equal(t, 2, mock.edits)
+	})
+
+	t.Run("deleted comment is posted again", func(t *testing.T) {
+		mock.deleted = true
+		defer func() { mock.deleted = false }()
+
+		require.NoError(t, s.setStatusSection(pr, statusSectionSpinWick, "SpinWick has been destroyed."))
+		require.Len(t, mock.comments, 1)
+		assert.Equal(t, 2, mock.creates)
+		assert.Equal(t, map[statusSection]string{
+			statusSectionSpinWick: "SpinWick has been destroyed.",
+			statusSectionE2E:      "E2E test servers created.",
+		}, parseStatusComment(mock.comments[2]))
+	})
+}
+
+func TestAppendStatusComment(t *testing.T) {
+	mock := &statusCommentGitHubMock{comments: make(map[int64]string)}
+	ts := httptest.NewServer(mock)
+	defer ts.Close()
+
+	s := &Server{
+		Logger:        logrus.New(),
+		Config:        &MatterwickConfig{Username: "matterwick"},
+		githubAPIBase: ts.URL + "/",
+	}
+	pr := &model.PullRequest{RepoOwner: "mattermost", RepoName: "mattermost-server", Number: 1}
+
+	require.NoError(t, s.setStatusSection(pr, statusSectionSpinWick, "SpinWick is ready."))
+	s.addSpinWickWarning(pr, "", "Seeding the sample data failed.")
+	s.addSpinWickWarning(pr, "ha", "No Kubernetes clusters available.")
+	require.Len(t, mock.comments, 1)
+	assert.Equal(t, map[statusSection]string{
+		statusSectionSpinWick: "SpinWick is ready.",
+		statusSectionWarnings: "- :warning: Seeding the sample data failed.\n- :warning: No Kubernetes clusters available. (variant `ha`)",
+	}, parseStatusComment(mock.comments[1]))
+
+	for i := 0; i < statusSectionMaxLines; i++ {
+		s.appendStatusComment(pr, statusSectionCompanions, fmt.Sprintf("update %d", i))
+	}
+	s.appendStatusComment(pr, statusSectionCompanions, "latest update")
+	lines := strings.Split(parseStatusComment(mock.comments[1])[statusSectionCompanions], "\n")
+	require.Len(t, lines, statusSectionMaxLines)
+	assert.Equal(t, "- update 1", lines[0])
+	assert.Equal(t, "- latest update", lines[statusSectionMaxLines-1])
+}
+
+func TestStatusCommentMutex(t *testing.T) {
+	s := &Server{}
+	pr := &model.PullRequest{RepoOwner: "mattermost", RepoName: "mattermost-server", Number: 1}
+
+	assert.Same(t, s.statusCommentMutex(pr), s.statusCommentMutex(&model.PullRequest{RepoOwner: "mattermost", RepoName: "mattermost-server", Number: 1}))
+	assert.NotSame(t, s.statusCommentMutex(pr), s.statusCommentMutex(&model.PullRequest{RepoOwner: "mattermost", RepoName: "mattermost-server", Number: 2}))
+	assert.NotSame(t, s.statusCommentMutex(pr), s.statusCommentMutex(&model.PullRequest{RepoOwner: "mattermost", RepoName: "mattermost-webapp", Number: 1}))
+}
@@ -9,6 +9,9 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
 )
 
 // WebhookRequest defines the message to send to MM
@@ -42,3 +45,28 @@ func (s *Server) sendToWebhook(webhookRequest *WebhookRequest) error {
 
 	return nil
 }
+
+// postToCredentialsWebhook posts a message to the Mattermost credentials webhook.
+func (s *Server) postToCredentialsWebhook(text string) error {
+	b, err := json.Marshal(&WebhookRequest{Username: "MatterWick", Text: text})
+	if err != nil {
+		return errors.Wrap(err, "unable to marshal webhook request")
+	}
+
+	client := http.Client{Timeout: 10 * time.Second}
+	request, err := http.NewRequest(http.MethodPost, s.Config.MattermostCredentialsWebhookURL, bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrap(err, "unable to create webhook request")
+	}
+	request.Header.Add("Content-Type", "application/json")
+
+	resp, err := client.Do(request)
+	if err != nil {
+		return errors.Wrap(err, "unable to post to webhook")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return errors.Errorf("webhook returned status code %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -30,6 +30,8 @@ type WorkflowRunWithInputs struct {
 	HeadBranch string            `json:"head_branch"`
 	HeadSHA    string            `json:"head_sha"`
 	Event      string            `json:"event"` // triggering event: "push", "schedule", "workflow_dispatch", etc.
+	Conclusion string            `json:"conclusion"`
+	HTMLURL    string            `json:"html_url"`
 	Inputs     map[string]string `json:"inputs"`
 }
 
@@ -99,6 +101,17 @@ func (s *Server) handleWorkflowRunEventWithInputs(payload *WorkflowRunWebhookPay
 		return
 	}
 
+	// SpinWick build: wake the updates waiting for the head commit's image.
+	if payload.Action == "completed" && s.isSpinWickBuildWorkflow(repoName, workflowName) {
+		logger.WithField("conclusion", payload.WorkflowRun.Conclusion).Info("SpinWick build workflow completed")
+		s.buildWorkflows.complete(repoName, headSHA, buildWorkflowRun{
+			ID:         runID,
+			Conclusion: payload.WorkflowRun.Conclusion,
+			HTMLURL:    payload.WorkflowRun.HTMLURL,
+		})
+		return
+	}
+
 	// On completion: CMT keys on run id, non-CMT flows key on SHA.
 	if payload.Action == "completed" && s.isE2ETestWorkflow(workflowName) {
 		if workflowName == s.cmtTestWorkflowName() {